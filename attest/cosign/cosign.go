@@ -0,0 +1,372 @@
+package cosign
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+
+	"github.com/docker/labs-brown-tape/attest/signer"
+)
+
+const (
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+	// artifact types of signatures, attestations and SBOMs that are stored as referrers
+	SignatureArtifactType   = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	AttestationArtifactType = "application/vnd.dev.cosign.artifact.att.v1+json"
+	SBOMArtifactType        = "application/vnd.dev.cosign.artifact.sbom.v1+json"
+
+	SignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	CertificateAnnotation = "dev.sigstore.cosign/certificate"
+	ChainAnnotation       = "dev.sigstore.cosign/chain"
+	BundleAnnotation      = "dev.sigstore.cosign/bundle"
+
+	simpleSigningType = "cosign container image signature"
+)
+
+var (
+	// Fulcio certificate extensions that hold OIDC issuer, the former one is deprecated
+	// and contains raw string, the latter contains DER-encoded string
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// Signature is a single cosign signature, as stored in a layer of `.sig` image
+type Signature struct {
+	Payload     []byte
+	Signature   []byte
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+	Bundle      *Bundle
+}
+
+type Bundle struct {
+	SignedEntryTimestamp []byte        `json:"SignedEntryTimestamp"`
+	Payload              BundlePayload `json:"Payload"`
+}
+
+// BundlePayload is what transparency log signs, the fields are in the order
+// that is required for canonical JSON encoding
+type BundlePayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+type hashedRekord struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// ParseSignature reads signature from simplesigning payload and annotations of the layer
+func ParseSignature(payload []byte, annotations map[string]string) (*Signature, error) {
+	encodedSignature, ok := annotations[SignatureAnnotation]
+	if !ok {
+		return nil, fmt.Errorf("signature annotation %q is missing", SignatureAnnotation)
+	}
+	sig, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode signature: %w", err)
+	}
+	signature := &Signature{
+		Payload:   payload,
+		Signature: sig,
+	}
+	if certificatePEM, ok := annotations[CertificateAnnotation]; ok && certificatePEM != "" {
+		certificates, err := cryptoutils.UnmarshalCertificatesFromPEM([]byte(certificatePEM))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse certificate: %w", err)
+		}
+		if len(certificates) != 1 {
+			return nil, fmt.Errorf("expected one certificate, found %d", len(certificates))
+		}
+		signature.Certificate = certificates[0]
+	}
+	if chainPEM, ok := annotations[ChainAnnotation]; ok && chainPEM != "" {
+		signature.Chain, err = cryptoutils.UnmarshalCertificatesFromPEM([]byte(chainPEM))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse certificate chain: %w", err)
+		}
+	}
+	if bundleJSON, ok := annotations[BundleAnnotation]; ok && bundleJSON != "" {
+		signature.Bundle = &Bundle{}
+		if err := json.Unmarshal([]byte(bundleJSON), signature.Bundle); err != nil {
+			return nil, fmt.Errorf("unable to parse bundle: %w", err)
+		}
+	}
+	return signature, nil
+}
+
+// SubjectAlternativeNames returns identities of the signing certificate, if there is one
+func (s *Signature) SubjectAlternativeNames() []string {
+	if s.Certificate == nil {
+		return nil
+	}
+	return cryptoutils.GetSubjectAlternateNames(s.Certificate)
+}
+
+// Issuer returns OIDC issuer of the signing certificate, if there is one
+func (s *Signature) Issuer() string {
+	if s.Certificate == nil {
+		return ""
+	}
+	for _, extension := range s.Certificate.Extensions {
+		switch {
+		case extension.Id.Equal(oidIssuerV2):
+			issuer := ""
+			if _, err := asn1.Unmarshal(extension.Value, &issuer); err == nil {
+				return issuer
+			}
+		case extension.Id.Equal(oidIssuerV1):
+			return string(extension.Value)
+		}
+	}
+	return ""
+}
+
+// Verifier checks signatures either with a public key, or with certificates issued
+// to the given identity by one of the authorities in the trusted root
+type Verifier struct {
+	publicKey *signer.Verifier
+
+	identity      string
+	issuer        string
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+	logs          map[string]*signer.Verifier
+}
+
+func NewKeyVerifier(path string) (*Verifier, error) {
+	publicKey, err := signer.LoadPublicKeyFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{publicKey: publicKey}, nil
+}
+
+// NewKeylessVerifier loads trusted root in the format used by sigstore clients,
+// signatures have to be recorded in one of the transparency logs from it
+func NewKeylessVerifier(trustedRootPath, identity, issuer string) (*Verifier, error) {
+	if identity == "" || issuer == "" {
+		return nil, fmt.Errorf("both identity and issuer must be set for keyless verification")
+	}
+	data, err := os.ReadFile(trustedRootPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read trusted root file: %w", err)
+	}
+	verifier := &Verifier{
+		identity:      identity,
+		issuer:        issuer,
+		roots:         x509.NewCertPool(),
+		intermediates: x509.NewCertPool(),
+		logs:          map[string]*signer.Verifier{},
+	}
+	if err := verifier.loadTrustedRoot(data); err != nil {
+		return nil, fmt.Errorf("unable to load trusted root from %q: %w", trustedRootPath, err)
+	}
+	return verifier, nil
+}
+
+func (v *Verifier) loadTrustedRoot(data []byte) error {
+	trustedRoot := struct {
+		TransparencyLogs []struct {
+			PublicKey struct {
+				RawBytes []byte `json:"rawBytes"`
+			} `json:"publicKey"`
+		} `json:"tlogs"`
+		CertificateAuthorities []struct {
+			CertChain struct {
+				Certificates []struct {
+					RawBytes []byte `json:"rawBytes"`
+				} `json:"certificates"`
+			} `json:"certChain"`
+		} `json:"certificateAuthorities"`
+	}{}
+	if err := json.Unmarshal(data, &trustedRoot); err != nil {
+		return err
+	}
+
+	numRoots := 0
+	for _, authority := range trustedRoot.CertificateAuthorities {
+		for _, rawCertificate := range authority.CertChain.Certificates {
+			certificate, err := x509.ParseCertificate(rawCertificate.RawBytes)
+			if err != nil {
+				return fmt.Errorf("invalid certificate authority: %w", err)
+			}
+			if bytes.Equal(certificate.RawIssuer, certificate.RawSubject) && certificate.CheckSignatureFrom(certificate) == nil {
+				v.roots.AddCert(certificate)
+				numRoots++
+			} else {
+				v.intermediates.AddCert(certificate)
+			}
+		}
+	}
+	for _, log := range trustedRoot.TransparencyLogs {
+		publicKey, err := x509.ParsePKIXPublicKey(log.PublicKey.RawBytes)
+		if err != nil {
+			return fmt.Errorf("invalid transparency log key: %w", err)
+		}
+		logVerifier, err := signer.NewVerifier(publicKey)
+		if err != nil {
+			return fmt.Errorf("invalid transparency log key: %w", err)
+		}
+		logID := sha256.Sum256(log.PublicKey.RawBytes)
+		v.logs[hex.EncodeToString(logID[:])] = logVerifier
+	}
+	if numRoots == 0 {
+		return fmt.Errorf("no root certificate authorities found")
+	}
+	if len(v.logs) == 0 {
+		return fmt.Errorf("no transparency logs found")
+	}
+	return nil
+}
+
+// Verify checks that the signature is valid and that it was made for the image with
+// the given digest, it returns key ID or identity of the signer
+func (v *Verifier) Verify(ctx context.Context, signature *Signature, imageDigest string) (string, error) {
+	payload := simpleSigning{}
+	if err := json.Unmarshal(signature.Payload, &payload); err != nil {
+		return "", fmt.Errorf("unable to parse simplesigning payload: %w", err)
+	}
+	if payload.Critical.Type != simpleSigningType {
+		return "", fmt.Errorf("unexpected simplesigning payload type %q", payload.Critical.Type)
+	}
+	if payload.Critical.Image.DockerManifestDigest != imageDigest {
+		return "", fmt.Errorf("signature is for %q instead of %q", payload.Critical.Image.DockerManifestDigest, imageDigest)
+	}
+
+	if v.publicKey != nil {
+		if err := v.publicKey.Verify(ctx, signature.Payload, signature.Signature); err != nil {
+			return "", err
+		}
+		return v.publicKey.KeyID()
+	}
+	return v.verifyKeyless(ctx, signature)
+}
+
+func (v *Verifier) verifyKeyless(ctx context.Context, signature *Signature) (string, error) {
+	if signature.Certificate == nil {
+		return "", fmt.Errorf("signature doesn't have a certificate")
+	}
+	if signature.Bundle == nil {
+		return "", fmt.Errorf("signature doesn't have a transparency log bundle")
+	}
+
+	// certificates are short-lived, so these are checked at the time when the signature
+	// was recorded in the transparency log, which is trusted once the bundle is verified
+	integratedTime, err := v.verifyBundle(ctx, signature)
+	if err != nil {
+		return "", err
+	}
+
+	intermediates := v.intermediates.Clone()
+	for _, certificate := range signature.Chain {
+		if !bytes.Equal(certificate.RawIssuer, certificate.RawSubject) {
+			intermediates.AddCert(certificate)
+		}
+	}
+	if _, err := signature.Certificate.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		CurrentTime:   integratedTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return "", fmt.Errorf("invalid certificate: %w", err)
+	}
+
+	if !slices.Contains(signature.SubjectAlternativeNames(), v.identity) {
+		return "", fmt.Errorf("certificate identities %v don't include %q", signature.SubjectAlternativeNames(), v.identity)
+	}
+	if issuer := signature.Issuer(); issuer != v.issuer {
+		return "", fmt.Errorf("certificate issuer is %q instead of %q", issuer, v.issuer)
+	}
+
+	certificateVerifier, err := signer.NewVerifier(signature.Certificate.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	if err := certificateVerifier.Verify(ctx, signature.Payload, signature.Signature); err != nil {
+		return "", err
+	}
+	return v.identity, nil
+}
+
+// verifyBundle checks that bundle is signed by one of the trusted transparency logs
+// and that the log entry matches the signature
+func (v *Verifier) verifyBundle(ctx context.Context, signature *Signature) (time.Time, error) {
+	bundle := signature.Bundle
+	logVerifier, ok := v.logs[bundle.Payload.LogID]
+	if !ok {
+		return time.Time{}, fmt.Errorf("bundle is from unknown transparency log %q", bundle.Payload.LogID)
+	}
+	canonicalPayload, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := logVerifier.Verify(ctx, canonicalPayload, bundle.SignedEntryTimestamp); err != nil {
+		return time.Time{}, fmt.Errorf("invalid signed entry timestamp: %w", err)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to decode bundle body: %w", err)
+	}
+	entry := hashedRekord{}
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse bundle body: %w", err)
+	}
+	if entry.Kind != "hashedrekord" {
+		return time.Time{}, fmt.Errorf("unexpected transparency log entry kind %q", entry.Kind)
+	}
+	payloadDigest := sha256.Sum256(signature.Payload)
+	if entry.Spec.Data.Hash.Algorithm != "sha256" || entry.Spec.Data.Hash.Value != hex.EncodeToString(payloadDigest[:]) {
+		return time.Time{}, fmt.Errorf("transparency log entry is for a different payload")
+	}
+	if !bytes.Equal(entry.Spec.Signature.Content, signature.Signature) {
+		return time.Time{}, fmt.Errorf("transparency log entry is for a different signature")
+	}
+	certificates, err := cryptoutils.UnmarshalCertificatesFromPEM(entry.Spec.Signature.PublicKey.Content)
+	if err != nil || len(certificates) != 1 || !certificates[0].Equal(signature.Certificate) {
+		return time.Time{}, fmt.Errorf("transparency log entry is for a different certificate")
+	}
+	return time.Unix(bundle.Payload.IntegratedTime, 0), nil
+}
@@ -0,0 +1,242 @@
+package cosign_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+
+	. "github.com/docker/labs-brown-tape/attest/cosign"
+)
+
+const (
+	imageDigest = "sha256:0766f0adc399b7a5855e5d3b9e11bd29d75d3b630c1bfd9f131206562aa0a0d4"
+	identity    = "https://github.com/example/app/.github/workflows/release.yaml@refs/heads/main"
+	issuer      = "https://token.actions.githubusercontent.com"
+)
+
+func makePayload(digest string) []byte {
+	return []byte(`{"critical":{"identity":{"docker-reference":"ghcr.io/example/app"},"image":{"docker-manifest-digest":"` +
+		digest + `"},"type":"cosign container image signature"},"optional":null}`)
+}
+
+func sign(g *WithT, key crypto.Signer, data []byte) []byte {
+	digest := sha256.Sum256(data)
+	sig, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	g.Expect(err).ToNot(HaveOccurred())
+	return sig
+}
+
+func generateKey(g *WithT) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	return key
+}
+
+func TestKeyVerifier(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	key := generateKey(g)
+	publicKeyPEM, err := cryptoutils.MarshalPublicKeyToPEM(key.Public())
+	g.Expect(err).ToNot(HaveOccurred())
+	publicKeyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	g.Expect(os.WriteFile(publicKeyPath, publicKeyPEM, 0o600)).To(Succeed())
+
+	verifier, err := NewKeyVerifier(publicKeyPath)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	payload := makePayload(imageDigest)
+	signature, err := ParseSignature(payload, map[string]string{
+		SignatureAnnotation: base64.StdEncoding.EncodeToString(sign(g, key, payload)),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(signature.SubjectAlternativeNames()).To(BeEmpty())
+
+	keyID, err := verifier.Verify(ctx, signature, imageDigest)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(keyID).To(HavePrefix("SHA256:"))
+
+	_, err = verifier.Verify(ctx, signature, "sha256:"+hex.EncodeToString(make([]byte, 32)))
+	g.Expect(err).To(MatchError(ContainSubstring("signature is for")))
+
+	// signature made with another key
+	signature, err = ParseSignature(payload, map[string]string{
+		SignatureAnnotation: base64.StdEncoding.EncodeToString(sign(g, generateKey(g), payload)),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = verifier.Verify(ctx, signature, imageDigest)
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = ParseSignature(payload, map[string]string{})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestKeylessVerifier(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	// signature was made long ago, certificate has expired since
+	signedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	rootKey := generateKey(g)
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test root"},
+		NotBefore:             signedAt.AddDate(-1, 0, 0),
+		NotAfter:              signedAt.AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, rootKey.Public(), rootKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	root, err := x509.ParseCertificate(rootDER)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	issuerExtension, err := asn1.Marshal(issuer)
+	g.Expect(err).ToNot(HaveOccurred())
+	identityURI, err := url.Parse(identity)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	leafKey := generateKey(g)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    signedAt.Add(-time.Minute),
+		NotAfter:     signedAt.Add(10 * time.Minute),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:         []*url.URL{identityURI},
+		ExtraExtensions: []pkix.Extension{{
+			Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8},
+			Value: issuerExtension,
+		}},
+	}, root, leafKey.Public(), rootKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	leafPEM := cryptoutils.PEMEncode(cryptoutils.CertificatePEMType, leafDER)
+
+	logKey := generateKey(g)
+	logKeyDER, err := x509.MarshalPKIXPublicKey(logKey.Public())
+	g.Expect(err).ToNot(HaveOccurred())
+	logID := sha256.Sum256(logKeyDER)
+
+	trustedRoot, err := json.Marshal(map[string]any{
+		"mediaType": "application/vnd.dev.sigstore.trustedroot+json;version=0.1",
+		"tlogs": []any{map[string]any{
+			"baseUrl":       "https://rekor.example.com",
+			"hashAlgorithm": "SHA2_256",
+			"publicKey":     map[string]any{"rawBytes": logKeyDER},
+			"logId":         map[string]any{"keyId": logID[:]},
+		}},
+		"certificateAuthorities": []any{map[string]any{
+			"certChain": map[string]any{"certificates": []any{map[string]any{"rawBytes": rootDER}}},
+		}},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	trustedRootPath := filepath.Join(t.TempDir(), "trusted_root.json")
+	g.Expect(os.WriteFile(trustedRootPath, trustedRoot, 0o600)).To(Succeed())
+
+	makeAnnotations := func(payload []byte, certificatePEM []byte, integratedTime time.Time) map[string]string {
+		sig := sign(g, leafKey, payload)
+		payloadDigest := sha256.Sum256(payload)
+		body, err := json.Marshal(map[string]any{
+			"apiVersion": "0.0.1",
+			"kind":       "hashedrekord",
+			"spec": map[string]any{
+				"data": map[string]any{"hash": map[string]any{"algorithm": "sha256", "value": hex.EncodeToString(payloadDigest[:])}},
+				"signature": map[string]any{
+					"content":   sig,
+					"publicKey": map[string]any{"content": certificatePEM},
+				},
+			},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		bundlePayload := BundlePayload{
+			Body:           base64.StdEncoding.EncodeToString(body),
+			IntegratedTime: integratedTime.Unix(),
+			LogID:          hex.EncodeToString(logID[:]),
+			LogIndex:       42,
+		}
+		canonicalPayload, err := json.Marshal(bundlePayload)
+		g.Expect(err).ToNot(HaveOccurred())
+		bundle, err := json.Marshal(Bundle{
+			SignedEntryTimestamp: sign(g, logKey, canonicalPayload),
+			Payload:              bundlePayload,
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		return map[string]string{
+			SignatureAnnotation:   base64.StdEncoding.EncodeToString(sig),
+			CertificateAnnotation: string(certificatePEM),
+			ChainAnnotation:       string(cryptoutils.PEMEncode(cryptoutils.CertificatePEMType, rootDER)),
+			BundleAnnotation:      string(bundle),
+		}
+	}
+
+	payload := makePayload(imageDigest)
+	signature, err := ParseSignature(payload, makeAnnotations(payload, leafPEM, signedAt))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(signature.SubjectAlternativeNames()).To(ConsistOf(identity))
+	g.Expect(signature.Issuer()).To(Equal(issuer))
+
+	verifier, err := NewKeylessVerifier(trustedRootPath, identity, issuer)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(verifier.Verify(ctx, signature, imageDigest)).To(Equal(identity))
+
+	for name, verifier := range map[string]func() (*Verifier, error){
+		"identity": func() (*Verifier, error) { return NewKeylessVerifier(trustedRootPath, "someone@example.com", issuer) },
+		"issuer": func() (*Verifier, error) {
+			return NewKeylessVerifier(trustedRootPath, identity, "https://accounts.google.com")
+		},
+	} {
+		verifier, err := verifier()
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = verifier.Verify(ctx, signature, imageDigest)
+		g.Expect(err).To(HaveOccurred(), name)
+	}
+
+	// certificate has to be valid at the time of the log entry
+	signature, err = ParseSignature(payload, makeAnnotations(payload, leafPEM, signedAt.Add(time.Hour)))
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = verifier.Verify(ctx, signature, imageDigest)
+	g.Expect(err).To(MatchError(ContainSubstring("invalid certificate")))
+
+	// entry time can't be changed without invalidating the bundle
+	annotations := makeAnnotations(payload, leafPEM, signedAt)
+	bundle := Bundle{}
+	g.Expect(json.Unmarshal([]byte(annotations[BundleAnnotation]), &bundle)).To(Succeed())
+	bundle.Payload.IntegratedTime++
+	bundleJSON, err := json.Marshal(bundle)
+	g.Expect(err).ToNot(HaveOccurred())
+	annotations[BundleAnnotation] = string(bundleJSON)
+	signature, err = ParseSignature(payload, annotations)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = verifier.Verify(ctx, signature, imageDigest)
+	g.Expect(err).To(MatchError(ContainSubstring("invalid signed entry timestamp")))
+
+	// bundle has to be for the same payload
+	annotations = makeAnnotations(makePayload("sha256:"+hex.EncodeToString(make([]byte, 32))), leafPEM, signedAt)
+	signature, err = ParseSignature(payload, annotations)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = verifier.Verify(ctx, signature, imageDigest)
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = NewKeylessVerifier(trustedRootPath, identity, "")
+	g.Expect(err).To(HaveOccurred())
+}
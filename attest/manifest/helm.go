@@ -0,0 +1,63 @@
+package manifest
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/docker/labs-brown-tape/attest/digest"
+	"github.com/docker/labs-brown-tape/attest/types"
+)
+
+const (
+	HelmChartPredicateType = "docker.com/tape/HelmChart/v0.1"
+)
+
+var (
+	_ types.Statement = (*HelmChart)(nil)
+)
+
+type HelmChart struct {
+	types.GenericStatement[HelmChartRelease]
+}
+
+type HelmChartRelease struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	AppVersion string `json:"appVersion,omitempty"`
+
+	ReleaseName string `json:"releaseName"`
+	Namespace   string `json:"namespace"`
+
+	// ValuesFiles are relative to the chart directory
+	ValuesFiles  []string      `json:"valuesFiles,omitempty"`
+	ValuesDigest digest.SHA256 `json:"valuesDigest"`
+}
+
+func MakeHelmChartStatement(release HelmChartRelease, subjects ...types.Subject) types.Statement {
+	return &HelmChart{
+		types.MakeStatement[HelmChartRelease](
+			HelmChartPredicateType,
+			struct {
+				HelmChartRelease `json:"helmChart"`
+			}{release},
+			subjects...,
+		),
+	}
+}
+
+func (a HelmChartRelease) Compare(b HelmChartRelease) types.Cmp {
+	for _, cmp := range []int{
+		cmp.Compare(a.Name, b.Name),
+		cmp.Compare(a.Version, b.Version),
+		cmp.Compare(a.AppVersion, b.AppVersion),
+		cmp.Compare(a.ReleaseName, b.ReleaseName),
+		cmp.Compare(a.Namespace, b.Namespace),
+		slices.Compare(a.ValuesFiles, b.ValuesFiles),
+		cmp.Compare(a.ValuesDigest, b.ValuesDigest),
+	} {
+		if cmp != 0 {
+			return &cmp
+		}
+	}
+	return types.CmpEqual()
+}
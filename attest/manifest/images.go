@@ -2,6 +2,7 @@ package manifest
 
 import (
 	"cmp"
+	"slices"
 
 	attestTypes "github.com/docker/labs-brown-tape/attest/types"
 	manifestTypes "github.com/docker/labs-brown-tape/manifest/types"
@@ -11,11 +12,13 @@ const (
 	OriginalImageRefPredicateType = "docker.com/tape/OriginalImageRef/v0.1"
 	ResolvedImageRefPredicateType = "docker.com/tape/ResolvedImageRef/v0.1"
 	ReplacedImageRefPredicateType = "docker.com/tape/ReplacedImageRef/v0.1"
+	FilteredImageRefPredicateType = "docker.com/tape/FilteredImageRef/v0.1"
 )
 
 var (
 	_ attestTypes.Statement = (*OriginalImageRef)(nil)
 	_ attestTypes.Statement = (*ResolvedImageRef)(nil)
+	_ attestTypes.Statement = (*FilteredImageRef)(nil)
 )
 
 type OriginalImageRef struct {
@@ -30,6 +33,21 @@ type ReplacedImageRef struct {
 	attestTypes.GenericStatement[ImageRefenceWithLocation]
 }
 
+type FilteredImageRef struct {
+	attestTypes.GenericStatement[FilteredImageIndex]
+}
+
+// FilteredImageIndex records digests of an image index before and after
+// manifests for platforms other than the given ones were removed, along with
+// images related to the original index, which were not copied
+type FilteredImageIndex struct {
+	ImageRefenceWithLocation `json:"filteredImageReference"`
+	OriginalDigest           string   `json:"originalDigest"`
+	FilteredDigest           string   `json:"filteredDigest"`
+	Platforms                []string `json:"platforms"`
+	SkippedRelatedImages     []string `json:"skippedRelatedImages,omitempty"`
+}
+
 type ImageRefenceWithLocation struct {
 	Reference string  `json:"reference"`
 	Line      int     `json:"line"`
@@ -44,7 +62,7 @@ type ImageRefenceWithLocation struct {
 
 func MakeOriginalImageRefStatements(images *manifestTypes.ImageList) attestTypes.Statements {
 	statements := attestTypes.Statements{}
-	forEachImage(images, func(subject attestTypes.Subject, ref ImageRefenceWithLocation) {
+	forEachImage(images, func(subject attestTypes.Subject, ref ImageRefenceWithLocation, _ manifestTypes.Image) {
 		s := &OriginalImageRef{
 			attestTypes.MakeStatement(
 				OriginalImageRefPredicateType,
@@ -61,7 +79,7 @@ func MakeOriginalImageRefStatements(images *manifestTypes.ImageList) attestTypes
 
 func MakeReplacedImageRefStatements(images *manifestTypes.ImageList) attestTypes.Statements {
 	statements := attestTypes.Statements{}
-	forEachImage(images, func(subject attestTypes.Subject, ref ImageRefenceWithLocation) {
+	forEachImage(images, func(subject attestTypes.Subject, ref ImageRefenceWithLocation, _ manifestTypes.Image) {
 		statements = append(statements, &ReplacedImageRef{
 			attestTypes.MakeStatement(
 				ReplacedImageRefPredicateType,
@@ -75,15 +93,52 @@ func MakeReplacedImageRefStatements(images *manifestTypes.ImageList) attestTypes
 	return statements
 }
 
+// MakeFilteredImageRefStatements returns statements for images that were copied
+// with only some of the platforms, images from related lists that refer to the
+// original index are recorded as skipped
+func MakeFilteredImageRefStatements(images *manifestTypes.ImageList, platforms []string, related ...*manifestTypes.ImageList) attestTypes.Statements {
+	statements := attestTypes.Statements{}
+	forEachImage(images, func(subject attestTypes.Subject, ref ImageRefenceWithLocation, image manifestTypes.Image) {
+		if image.NewDigest == "" || image.NewDigest == image.Digest {
+			return
+		}
+		var skipped []string
+		for _, relatedImages := range related {
+			for _, relatedImage := range relatedImages.Items() {
+				if relatedImage.IsRelatedTo(image.Digest) {
+					skipped = append(skipped, relatedImage.Ref(true))
+				}
+			}
+		}
+		slices.Sort(skipped)
+		statements = append(statements, &FilteredImageRef{
+			attestTypes.MakeStatement[FilteredImageIndex](
+				FilteredImageRefPredicateType,
+				FilteredImageIndex{
+					ImageRefenceWithLocation: ref,
+					OriginalDigest:           image.Digest,
+					FilteredDigest:           image.NewDigest,
+					Platforms:                platforms,
+					SkippedRelatedImages:     slices.Compact(skipped),
+				},
+				subject,
+			),
+		})
+	})
+	return statements
+}
+
 func MakeResovedImageRefStatements(images *manifestTypes.ImageList) attestTypes.Statements {
 	statements := attestTypes.Statements{}
-	forEachImage(images, func(subject attestTypes.Subject, ref ImageRefenceWithLocation) {
+	forEachImage(images, func(subject attestTypes.Subject, ref ImageRefenceWithLocation, image manifestTypes.Image) {
 		statements = append(statements, &ResolvedImageRef{
 			attestTypes.MakeStatement(
 				ResolvedImageRefPredicateType,
 				struct {
 					ImageRefenceWithLocation `json:"resolvedImageReference"`
-				}{ref},
+					// DigestSource is only set when the digest was not resolved using the registry
+					DigestSource manifestTypes.DigestSource `json:"digestSource,omitempty"`
+				}{ref, image.DigestSource},
 				subject,
 			),
 		})
@@ -91,7 +146,7 @@ func MakeResovedImageRefStatements(images *manifestTypes.ImageList) attestTypes.
 	return statements
 }
 
-func forEachImage(images *manifestTypes.ImageList, do func(attestTypes.Subject, ImageRefenceWithLocation)) {
+func forEachImage(images *manifestTypes.ImageList, do func(attestTypes.Subject, ImageRefenceWithLocation, manifestTypes.Image)) {
 	for _, image := range images.Items() {
 		for _, source := range image.Sources {
 			do(
@@ -105,6 +160,7 @@ func forEachImage(images *manifestTypes.ImageList, do func(attestTypes.Subject,
 					Column:    source.Column,
 					Alias:     image.Alias,
 				},
+				image,
 			)
 		}
 	}
@@ -122,3 +178,20 @@ func (a ImageRefenceWithLocation) Compare(b ImageRefenceWithLocation) attestType
 	}
 	return attestTypes.CmpEqual()
 }
+
+func (a FilteredImageIndex) Compare(b FilteredImageIndex) attestTypes.Cmp {
+	if cmp := a.ImageRefenceWithLocation.Compare(b.ImageRefenceWithLocation); cmp == nil || *cmp != 0 {
+		return cmp
+	}
+	if cmp := cmp.Compare(a.OriginalDigest, b.OriginalDigest); cmp != 0 {
+		return &cmp
+	}
+	if cmp := cmp.Compare(a.FilteredDigest, b.FilteredDigest); cmp != 0 {
+		return &cmp
+	}
+	if cmp := slices.Compare(a.Platforms, b.Platforms); cmp != 0 {
+		return &cmp
+	}
+	cmp := slices.Compare(a.SkippedRelatedImages, b.SkippedRelatedImages)
+	return &cmp
+}
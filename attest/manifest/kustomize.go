@@ -0,0 +1,51 @@
+package manifest
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/docker/labs-brown-tape/attest/types"
+)
+
+const (
+	KustomizationPredicateType = "docker.com/tape/Kustomization/v0.1"
+)
+
+var (
+	_ types.Statement = (*Kustomization)(nil)
+)
+
+type Kustomization struct {
+	types.GenericStatement[KustomizeBuild]
+}
+
+// KustomizeBuild paths are relative to the manifest directory
+type KustomizeBuild struct {
+	Path     string   `json:"path"`
+	Overlays []string `json:"overlays,omitempty"`
+}
+
+func MakeKustomizationStatement(subject types.Subject, path string, overlays []string) types.Statement {
+	return &Kustomization{
+		types.MakeStatement[KustomizeBuild](
+			KustomizationPredicateType,
+			struct {
+				KustomizeBuild `json:"kustomizeBuild"`
+			}{
+				KustomizeBuild{
+					Path:     path,
+					Overlays: overlays,
+				},
+			},
+			subject,
+		),
+	}
+}
+
+func (a KustomizeBuild) Compare(b KustomizeBuild) types.Cmp {
+	if cmp := cmp.Compare(a.Path, b.Path); cmp != 0 {
+		return &cmp
+	}
+	cmp := slices.Compare(a.Overlays, b.Overlays)
+	return &cmp
+}
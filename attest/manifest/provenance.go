@@ -0,0 +1,113 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v1"
+
+	attestTypes "github.com/docker/labs-brown-tape/attest/types"
+	"github.com/docker/labs-brown-tape/attest/vcs/git"
+	manifestTypes "github.com/docker/labs-brown-tape/manifest/types"
+)
+
+const (
+	ProvenancePredicateType = slsa.PredicateSLSAProvenance
+
+	BuilderID        = "https://github.com/docker/labs-brown-tape"
+	PackageBuildType = "https://github.com/docker/labs-brown-tape/PackageBuild/v0.1"
+)
+
+var (
+	_ attestTypes.Statement         = (*Provenance)(nil)
+	_ attestTypes.VolatileStatement = (*Provenance)(nil)
+)
+
+type Provenance struct {
+	attestTypes.GenericStatement[SLSAProvenance]
+}
+
+type SLSAProvenance struct {
+	slsa.ProvenancePredicate
+}
+
+// ProvenanceInvocation describes how tape was invoked, metadata is optional
+// and should be omitted when attestations must be reproducible
+type ProvenanceInvocation struct {
+	ExternalParameters any
+	BuilderVersion     map[string]string
+	Metadata           slsa.BuildMetadata
+}
+
+// MakeProvenanceStatement returns SLSA provenance for the artefact content, resolved
+// dependencies are all of the images and the commit that manifests came from
+func MakeProvenanceStatement(subject attestTypes.Subject, invocation ProvenanceInvocation, images *manifestTypes.ImageList, source attestTypes.PathCheckSummary) attestTypes.Statement {
+	dependencies := []slsa.ResourceDescriptor{}
+	if source != nil {
+		if summary, ok := source.Full().(*git.Summary); ok && summary.Git != nil && summary.Git.Reference.Hash != "" {
+			dependency := slsa.ResourceDescriptor{
+				Name:   summary.Path,
+				Digest: map[string]string{"gitCommit": summary.Git.Reference.Hash},
+			}
+			if summary.URI != "" {
+				dependency.URI = "git+" + summary.URI + "@" + summary.Git.Reference.Name
+			}
+			dependencies = append(dependencies, dependency)
+		}
+	}
+	for _, image := range images.Items() {
+		algorithm, hex, ok := strings.Cut(image.Digest, ":")
+		if !ok {
+			continue
+		}
+		dependencies = append(dependencies, slsa.ResourceDescriptor{
+			URI:    image.Ref(true),
+			Digest: map[string]string{algorithm: hex},
+		})
+	}
+
+	return &Provenance{
+		attestTypes.MakeStatement[SLSAProvenance](
+			ProvenancePredicateType,
+			SLSAProvenance{
+				slsa.ProvenancePredicate{
+					BuildDefinition: slsa.ProvenanceBuildDefinition{
+						BuildType:            PackageBuildType,
+						ExternalParameters:   invocation.ExternalParameters,
+						ResolvedDependencies: dependencies,
+					},
+					RunDetails: slsa.ProvenanceRunDetails{
+						Builder: slsa.Builder{
+							ID:      BuilderID,
+							Version: invocation.BuilderVersion,
+						},
+						BuildMetadata: invocation.Metadata,
+					},
+				},
+			},
+			subject,
+		),
+	}
+}
+
+// WithoutVolatileValues omits build metadata, as invocation ID and start time are
+// different on every run
+func (p *Provenance) WithoutVolatileValues() attestTypes.Statement {
+	predicate := p.ComparablePredicate.(SLSAProvenance)
+	predicate.RunDetails.BuildMetadata = slsa.BuildMetadata{}
+	return &Provenance{
+		attestTypes.MakeStatement[SLSAProvenance](p.Type, predicate, p.Subjects...),
+	}
+}
+
+// Compare uses JSON encoding, as predicate contains arbitrary values
+func (a SLSAProvenance) Compare(b SLSAProvenance) attestTypes.Cmp {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return nil
+	}
+	cmp := bytes.Compare(dataA, dataB)
+	return &cmp
+}
@@ -0,0 +1,47 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+
+	attestTypes "github.com/docker/labs-brown-tape/attest/types"
+)
+
+var (
+	_ attestTypes.Statement = (*SBOM)(nil)
+)
+
+type SBOM struct {
+	attestTypes.GenericStatement[SBOMDocument]
+}
+
+// SBOMDocument is a bill of materials in any of the supported formats,
+// the document is used as the predicate as is
+type SBOMDocument struct {
+	Document any
+}
+
+// MakeSBOMStatement returns application-level SBOM, predicate type
+// depends on the format of the document
+func MakeSBOMStatement(subject attestTypes.Subject, predicateType string, document any) attestTypes.Statement {
+	return &SBOM{
+		attestTypes.MakeStatement[SBOMDocument](
+			predicateType,
+			SBOMDocument{Document: document},
+			subject,
+		),
+	}
+}
+
+func (d SBOMDocument) MarshalJSON() ([]byte, error) { return json.Marshal(d.Document) }
+
+// Compare uses JSON encoding, as document structure depends on the format
+func (a SBOMDocument) Compare(b SBOMDocument) attestTypes.Cmp {
+	dataA, errA := json.Marshal(a)
+	dataB, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return nil
+	}
+	cmp := bytes.Compare(dataA, dataB)
+	return &cmp
+}
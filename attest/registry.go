@@ -21,6 +21,10 @@ type PathCheckerRegistry struct {
 	mutatedPaths types.Mutations
 	statements   types.Statements
 
+	derivedPaths map[string]struct{}
+	derived      map[types.PathCheckerRegistryKey]struct{}
+	artefacts    map[types.PathCheckerRegistryKey]struct{}
+
 	baseDir
 }
 
@@ -37,6 +41,9 @@ func NewPathCheckerRegistry(dir string, newPathChecker func(string, digest.SHA25
 		newPathChecker: newPathChecker,
 		registry:       map[types.PathCheckerRegistryKey]types.PathChecker{},
 		statements:     types.Statements{},
+		derivedPaths:   map[string]struct{}{},
+		derived:        map[types.PathCheckerRegistryKey]struct{}{},
+		artefacts:      map[types.PathCheckerRegistryKey]struct{}{},
 	}
 }
 
@@ -63,10 +70,28 @@ func (r *PathCheckerRegistry) Register(path string, digest digest.SHA256) error
 	if _, ok := r.registry[key]; ok {
 		return fmt.Errorf("path checker already reigstered for %#v", key)
 	}
+	if _, ok := r.derivedPaths[key.Path]; ok {
+		r.derived[key] = struct{}{}
+		return nil
+	}
 	r.registry[key] = r.newPathChecker(r.pathFromWorkDir(path), digest)
 	return nil
 }
 
+// RegisterDerived needs to be called before path is registered, it's meant for files
+// that are generated (e.g. by kustomize build) and don't exist in VCS, so instead of
+// checking path itself, each of the sources it was generated from gets checked
+func (r *PathCheckerRegistry) RegisterDerived(path string, sources ...string) {
+	r.derivedPaths[r.pathFromRepoRoot(path)] = struct{}{}
+	for _, source := range sources {
+		key := r.makeKey(r.pathFromRepoRoot(source), "")
+		if _, ok := r.registry[key]; ok {
+			continue
+		}
+		r.registry[key] = r.newPathChecker(r.pathFromWorkDir(source), "")
+	}
+}
+
 func (r *PathCheckerRegistry) RegisterMutated(mutatedPaths types.Mutations) {
 	r.mutatedPaths = make(types.Mutations, len(mutatedPaths)) // avoid stale entries
 	for k := range mutatedPaths {
@@ -76,12 +101,21 @@ func (r *PathCheckerRegistry) RegisterMutated(mutatedPaths types.Mutations) {
 	}
 }
 
+// RegisterArtefact is meant for subjects that are not files in the manifest dir, such
+// as contents of the artefact itself, so name is kept as is and it's never checked in VCS
+func (r *PathCheckerRegistry) RegisterArtefact(name string, digest digest.SHA256) {
+	r.artefacts[r.makeKey(name, digest)] = struct{}{}
+}
+
 func (r *PathCheckerRegistry) AssociateStatements(statements ...types.Statement) error {
 	for i := range statements {
 		if err := statements[i].SetSubjects(func(subject *types.Subject) error {
+			if _, ok := r.artefacts[r.makeKey(subject.Name, subject.Digest)]; ok {
+				return nil
+			}
 			path := r.pathFromRepoRoot(subject.Name)
 			key := r.makeKey(path, subject.Digest)
-			if _, ok := r.registry[key]; !ok {
+			if !r.isRegistered(key) {
 				err := fmt.Errorf("statement with subject %#v is not relevant (path resoved to %q)", subject, path)
 				if r.mutatedPaths == nil {
 					return err
@@ -169,6 +203,14 @@ func (r *PathCheckerRegistry) GetStatements() types.Statements {
 	return r.statements
 }
 
+func (r *PathCheckerRegistry) isRegistered(key types.PathCheckerRegistryKey) bool {
+	if _, ok := r.registry[key]; ok {
+		return true
+	}
+	_, ok := r.derived[key]
+	return ok
+}
+
 func (r *PathCheckerRegistry) dir() string {
 	switch {
 	case r.baseDir.fromRepoRoot != "":
@@ -13,6 +13,7 @@ import (
 
 	. "github.com/docker/labs-brown-tape/attest"
 	"github.com/docker/labs-brown-tape/attest/manifest"
+	"github.com/docker/labs-brown-tape/attest/types"
 	"github.com/docker/labs-brown-tape/manifest/imageresolver"
 	"github.com/docker/labs-brown-tape/manifest/imagescanner"
 	"github.com/docker/labs-brown-tape/manifest/loader"
@@ -53,12 +54,20 @@ func makeRegistryTest(tc testdata.TestCase) func(t *testing.T) {
 			g.Expect(pathChecker).ToNot(BeNil())
 			g.Expect(attreg).ToNot(BeNil())
 
-			scanner := imagescanner.NewDefaultImageScanner()
-			scanner.WithProvinanceAttestor(attreg)
-
 			expectedNumPaths := len(tc.Manifests)
 			g.Expect(loader.Paths()).To(HaveLen(expectedNumPaths))
 
+			// base dir and each of the files
+			expectedNumEntries := expectedNumPaths + 1
+			if build := loader.KustomizeBuild(); build != nil {
+				attreg.RegisterDerived(build.Output, build.Sources()...)
+				// output of kustomize build is not in VCS, its sources are checked instead
+				expectedNumEntries = len(build.Sources()) + 1
+			}
+
+			scanner := imagescanner.NewDefaultImageScanner()
+			scanner.WithProvinanceAttestor(attreg)
+
 			for i := range tc.Manifests {
 				g.Expect(loader.ContainsRelPath(tc.Manifests[i])).To(BeTrue())
 			}
@@ -70,7 +79,7 @@ func makeRegistryTest(tc testdata.TestCase) func(t *testing.T) {
 			g.Expect(collection).ToNot(BeNil())
 			g.Expect(collection.Providers).To(ConsistOf("git"))
 			g.Expect(collection.EntryGroups).To(HaveLen(1))
-			g.Expect(collection.EntryGroups[0]).To(HaveLen(expectedNumPaths + 1))
+			g.Expect(collection.EntryGroups[0]).To(HaveLen(expectedNumEntries))
 
 			g.Expect(attreg.AssociateCoreStatements()).To(Succeed())
 
@@ -99,3 +108,93 @@ func makeRegistryTest(tc testdata.TestCase) func(t *testing.T) {
 		}
 	}
 }
+
+func TestRegistryWithKustomizeBuild(t *testing.T) {
+	cases := testdata.KustomizeCases()
+	cases.Run(t, "../", func(tc testdata.TestCase) func(t *testing.T) {
+		return func(t *testing.T) {
+			g := NewWithT(t)
+
+			loader := loader.NewRecursiveManifestDirectoryLoader(tc.Directory)
+			g.Expect(loader.Load()).To(Succeed())
+			defer loader.Cleanup()
+
+			build := loader.KustomizeBuild()
+			g.Expect(build).ToNot(BeNil())
+
+			_, attreg, err := DetectVCS(tc.Directory)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(attreg).ToNot(BeNil())
+
+			attreg.RegisterDerived(build.Output, build.Sources()...)
+
+			scanner := imagescanner.NewDefaultImageScanner()
+			scanner.WithProvinanceAttestor(attreg)
+			g.Expect(scanner.Scan(loader.RelPaths())).To(Succeed())
+
+			collection, err := attreg.MakePathCheckSummarySummaryCollection()
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(collection.EntryGroups).To(HaveLen(1))
+			// base dir, kustomization file and one overlay
+			g.Expect(collection.EntryGroups[0]).To(HaveLen(3))
+
+			g.Expect(attreg.AssociateCoreStatements()).To(Succeed())
+			g.Expect(attreg.AssociateStatements(manifest.MakeOriginalImageRefStatements(scanner.GetImages())...)).To(Succeed())
+			g.Expect(attreg.AssociateStatements(manifest.MakeKustomizationStatement(
+				types.MakeSubject(build.Output, build.OutputDigest), build.Kustomization, build.Overlays))).To(Succeed())
+
+			g.Expect(attreg.AssociateStatements(manifest.MakeKustomizationStatement(
+				types.MakeSubject("kustomization.yaml", build.OutputDigest), build.Kustomization, build.Overlays))).ToNot(Succeed())
+		}
+	})
+}
+
+func TestRegistryWithProvenance(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := "../manifest/testdata/tekton/base"
+	loader := loader.NewRecursiveManifestDirectoryLoader(dir)
+	g.Expect(loader.Load()).To(Succeed())
+
+	_, attreg, err := DetectVCS(dir)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(attreg).ToNot(BeNil())
+
+	scanner := imagescanner.NewDefaultImageScanner()
+	scanner.WithProvinanceAttestor(attreg)
+	g.Expect(scanner.Scan(loader.RelPaths())).To(Succeed())
+	images := scanner.GetImages()
+
+	subject := types.MakeSubject("example.com/app:config.abc", "abc")
+	statement := manifest.MakeProvenanceStatement(subject, manifest.ProvenanceInvocation{
+		ExternalParameters: map[string]any{"command": "package"},
+	}, images, attreg.BaseDirSummary())
+
+	g.Expect(attreg.AssociateStatements(statement)).ToNot(Succeed())
+	attreg.RegisterArtefact(subject.Name, subject.Digest)
+	g.Expect(attreg.AssociateStatements(statement)).To(Succeed())
+	g.Expect(statement.GetSubject()).To(ConsistOf(subject))
+
+	exported := statement.Export()
+	g.Expect(exported.PredicateType).To(Equal("https://slsa.dev/provenance/v1"))
+	predicate, ok := exported.Predicate.(manifest.SLSAProvenance)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(predicate.RunDetails.Builder.ID).To(Equal(manifest.BuilderID))
+	g.Expect(predicate.BuildDefinition.ExternalParameters).To(HaveKeyWithValue("command", "package"))
+
+	dependencies := predicate.BuildDefinition.ResolvedDependencies
+	g.Expect(dependencies).ToNot(BeEmpty())
+	g.Expect(dependencies[0].Digest).To(HaveKey("gitCommit"))
+	numImagesWithDigests := 0
+	for _, image := range images.Items() {
+		if image.Digest != "" {
+			numImagesWithDigests++
+		}
+	}
+	g.Expect(numImagesWithDigests).ToNot(BeZero())
+	g.Expect(dependencies).To(HaveLen(numImagesWithDigests + 1))
+	for _, dependency := range dependencies[1:] {
+		g.Expect(dependency.URI).To(ContainSubstring("@sha256:"))
+		g.Expect(dependency.Digest).To(HaveKey("sha256"))
+	}
+}
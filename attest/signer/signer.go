@@ -0,0 +1,133 @@
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"os"
+
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+)
+
+type SignerVerifier struct {
+	*Verifier
+	private crypto.Signer
+}
+
+// Verifier only holds the public key, it's used for checking signatures
+// made by others
+type Verifier struct {
+	keyID  string
+	public crypto.PublicKey
+}
+
+var (
+	_ dsse.SignerVerifier = (*SignerVerifier)(nil)
+	_ dsse.Verifier       = (*Verifier)(nil)
+)
+
+func LoadPrivateKeyFromFile(path string) (*SignerVerifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read private key file: %w", err)
+	}
+	key, err := cryptoutils.UnmarshalPEMToPrivateKey(data, cryptoutils.SkipPassword)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key from %q: %w", path, err)
+	}
+	return NewSignerVerifier(key)
+}
+
+func NewSignerVerifier(key crypto.PrivateKey) (*SignerVerifier, error) {
+	var private crypto.Signer
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		private = key
+	case ed25519.PrivateKey:
+		private = key
+	case *rsa.PrivateKey:
+		private = key
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	verifier, err := NewVerifier(private.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignerVerifier{
+		Verifier: verifier,
+		private:  private,
+	}, nil
+}
+
+func LoadPublicKeyFromFile(path string) (*Verifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read public key file: %w", err)
+	}
+	key, err := cryptoutils.UnmarshalPEMToPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse public key from %q: %w", path, err)
+	}
+	return NewVerifier(key)
+}
+
+func NewVerifier(public crypto.PublicKey) (*Verifier, error) {
+	switch public.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", public)
+	}
+
+	keyID, err := dsse.SHA256KeyID(public)
+	if err != nil {
+		return nil, fmt.Errorf("unable to make key ID: %w", err)
+	}
+
+	return &Verifier{
+		keyID:  keyID,
+		public: public,
+	}, nil
+}
+
+func (sv *SignerVerifier) Sign(_ context.Context, data []byte) ([]byte, error) {
+	if _, ok := sv.private.(ed25519.PrivateKey); ok {
+		// ed25519 signs the message itself, it has to be passed unhashed
+		return sv.private.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return sv.private.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+func (v *Verifier) Verify(_ context.Context, data, sig []byte) error {
+	digest := sha256.Sum256(data)
+	switch public := v.public.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(public, digest[:], sig) {
+			return fmt.Errorf("invalid ECDSA signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(public, data, sig) {
+			return fmt.Errorf("invalid ED25519 signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("invalid RSA signature: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", public)
+	}
+	return nil
+}
+
+func (v *Verifier) KeyID() (string, error) { return v.keyID, nil }
+
+func (v *Verifier) Public() crypto.PublicKey { return v.public }
@@ -0,0 +1,110 @@
+package signer_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	toto "github.com/in-toto/in-toto-golang/in_toto"
+	. "github.com/onsi/gomega"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"github.com/sigstore/sigstore/pkg/cryptoutils"
+
+	. "github.com/docker/labs-brown-tape/attest/signer"
+)
+
+func TestSignerVerifier(t *testing.T) {
+	keys := map[string]func() (crypto.PrivateKey, error){
+		"ecdsa": func() (crypto.PrivateKey, error) {
+			return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		},
+		"ed25519": func() (crypto.PrivateKey, error) {
+			_, key, err := ed25519.GenerateKey(rand.Reader)
+			return key, err
+		},
+		"rsa": func() (crypto.PrivateKey, error) {
+			return rsa.GenerateKey(rand.Reader, 2048)
+		},
+	}
+
+	for keyType := range keys {
+		generateKey := keys[keyType]
+		t.Run(keyType, func(t *testing.T) {
+			g := NewWithT(t)
+			t.Parallel()
+
+			key, err := generateKey()
+			g.Expect(err).ToNot(HaveOccurred())
+
+			keyPEM, err := cryptoutils.MarshalPrivateKeyToPEM(key)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			keyPath := filepath.Join(t.TempDir(), "key.pem")
+			g.Expect(os.WriteFile(keyPath, keyPEM, 0o600)).To(Succeed())
+
+			sv, err := LoadPrivateKeyFromFile(keyPath)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			keyID, err := sv.KeyID()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(keyID).To(HavePrefix("SHA256:"))
+
+			ctx := context.Background()
+
+			envelopeSigner, err := dsse.NewEnvelopeSigner(sv)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			envelope, err := envelopeSigner.SignPayload(ctx, toto.PayloadType, []byte(`{"foo":"bar"}`))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(envelope.Signatures).To(HaveLen(1))
+			g.Expect(envelope.Signatures[0].KeyID).To(Equal(keyID))
+
+			envelopeVerifier, err := dsse.NewEnvelopeVerifier(sv)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			acceptedKeys, err := envelopeVerifier.Verify(ctx, envelope)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(acceptedKeys).To(HaveLen(1))
+			g.Expect(acceptedKeys[0].KeyID).To(Equal(keyID))
+
+			publicKeyPEM, err := cryptoutils.MarshalPublicKeyToPEM(sv.Public())
+			g.Expect(err).ToNot(HaveOccurred())
+
+			publicKeyPath := filepath.Join(t.TempDir(), "key.pub")
+			g.Expect(os.WriteFile(publicKeyPath, publicKeyPEM, 0o600)).To(Succeed())
+
+			verifier, err := LoadPublicKeyFromFile(publicKeyPath)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(verifier.KeyID()).To(Equal(keyID))
+
+			envelopeVerifier, err = dsse.NewEnvelopeVerifier(verifier)
+			g.Expect(err).ToNot(HaveOccurred())
+			_, err = envelopeVerifier.Verify(ctx, envelope)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			envelope.Payload = "e30K" // {}
+			_, err = envelopeVerifier.Verify(ctx, envelope)
+			g.Expect(err).To(HaveOccurred())
+		})
+	}
+}
+
+func TestLoadPrivateKeyFromFile(t *testing.T) {
+	g := NewWithT(t)
+
+	keyPath := filepath.Join(t.TempDir(), "key.pem")
+	g.Expect(os.WriteFile(keyPath, []byte("not a key"), 0o600)).To(Succeed())
+
+	_, err := LoadPrivateKeyFromFile(keyPath)
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = LoadPrivateKeyFromFile(filepath.Join(t.TempDir(), "missing.pem"))
+	g.Expect(err).To(HaveOccurred())
+}
@@ -3,6 +3,8 @@ package types
 import (
 	"bytes"
 	"cmp"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -10,6 +12,7 @@ import (
 	"slices"
 
 	toto "github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 
 	"github.com/docker/labs-brown-tape/attest/digest"
 )
@@ -77,6 +80,12 @@ type (
 		Compare(Statement) Cmp
 	}
 
+	// VolatileStatement has values that are different every time it's made,
+	// such as invocation IDs, these are left out of the digest of statements
+	VolatileStatement interface {
+		WithoutVolatileValues() Statement
+	}
+
 	Predicate[T any] struct {
 		Type                   string `json:"predicateType"`
 		ComparablePredicate[T] `json:"predicate"`
@@ -140,6 +149,48 @@ func (s Statements) Encode(w io.Writer) error {
 	return s.EncodeWith(json.NewEncoder(w).Encode)
 }
 
+func (s Statements) SignWith(ctx context.Context, signer dsse.SignerVerifier, encoder EncodeFunc) error {
+	envelopeSigner, err := dsse.NewEnvelopeSigner(signer)
+	if err != nil {
+		return err
+	}
+	for i := range s {
+		payload, err := json.Marshal(s[i].Export())
+		if err != nil {
+			return err
+		}
+		envelope, err := envelopeSigner.SignPayload(ctx, toto.PayloadType, payload)
+		if err != nil {
+			return fmt.Errorf("failed to sign statement of type %q: %w", s[i].GetType(), err)
+		}
+		if err := encoder(envelope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Statements) EncodeSigned(ctx context.Context, w io.Writer, signer dsse.SignerVerifier) error {
+	return s.SignWith(ctx, signer, json.NewEncoder(w).Encode)
+}
+
+// Digest is computed from unsigned statements without any volatile values, so it
+// only changes when attested facts change
+func (s Statements) Digest() (digest.SHA256, error) {
+	hash := sha256.New()
+	encoder := json.NewEncoder(hash)
+	for i := range s {
+		statement := s[i]
+		if volatile, ok := statement.(VolatileStatement); ok {
+			statement = volatile.WithoutVolatileValues()
+		}
+		if err := statement.EncodeWith(encoder.Encode); err != nil {
+			return "", fmt.Errorf("encoding statement of type %q failed: %w", statement.GetType(), err)
+		}
+	}
+	return digest.MakeSHA256(hash), nil
+}
+
 func (s Statements) MakeSummaryAnnotation() SummaryAnnotation {
 	types := map[string]struct{}{}
 	subjects := map[Subject]struct{}{}
@@ -162,8 +213,13 @@ func (s Statements) MakeSummaryAnnotation() SummaryAnnotation {
 	for s := range subjects {
 		summary.Subjects = append(summary.Subjects, s)
 	}
+	// the same file can be a subject with different digests, e.g. before and after
+	// it was updated, so digests are compared too for the order to be stable
 	slices.SortFunc(summary.Subjects, func(a, b Subject) int {
-		return cmp.Compare(a.Name, b.Name)
+		if c := cmp.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Digest, b.Digest)
 	})
 	return summary
 }
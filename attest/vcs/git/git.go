@@ -5,6 +5,7 @@ import (
 	"io"
 	"path/filepath"
 	"strings"
+	"time"
 
 	gogit "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
@@ -437,6 +438,31 @@ func findByPath(repo *gogit.Repository, path string) (object.Object, error) {
 	}
 }
 
+// HeadCommitTime returns committer time of HEAD commit in the repository that contains
+// the given path, false is returned when the path is not in a repository
+func HeadCommitTime(path string) (time.Time, bool, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	repo, err := gogit.PlainOpenWithOptions(absPath, &gogit.PlainOpenOptions{DetectDotGit: true})
+	if err == gogit.ErrRepositoryNotExists {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("unable to get HEAD of repository containing %q: %w", path, err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("unable to get HEAD commit of repository containing %q: %w", path, err)
+	}
+	return commit.Committer.When.UTC(), true, nil
+}
+
 func detectRepo(path string) (*gogit.Repository, bool) {
 	dir := filepath.Dir(path)
 	if dir == path { // reached root
@@ -214,3 +214,16 @@ func makeCreateFileSetupFunc(path string) func(*testing.T, *gitTestCases) {
 		}
 	}
 }
+
+func TestHeadCommitTime(t *testing.T) {
+	g := NewWithT(t)
+
+	commitTime, ok, err := HeadCommitTime("../../../manifest/testdata")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(commitTime.IsZero()).To(BeFalse())
+
+	_, ok, err = HeadCommitTime(t.TempDir())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+}
@@ -0,0 +1,68 @@
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/docker/labs-brown-tape/oci"
+)
+
+// Interpreter acts on contents of an artefact, each interpreter is registered
+// under the value of content interpreter annotation that it handles
+type Interpreter interface {
+	Apply(context.Context, *Contents) (*Result, error)
+}
+
+type Contents struct {
+	// Image is the reference the artefact was fetched from
+	Image string
+	// Dir is where the contents were extracted to
+	Dir         string
+	Annotations map[string]string
+}
+
+type Result struct {
+	Applied []ObjectRef
+	Pruned  []ObjectRef
+}
+
+type Registry struct {
+	interpreters map[string]Interpreter
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		interpreters: map[string]Interpreter{},
+	}
+}
+
+func (r *Registry) Register(name string, interpreter Interpreter) error {
+	if _, ok := r.interpreters[name]; ok {
+		return fmt.Errorf("content interpreter %q is already registered", name)
+	}
+	r.interpreters[name] = interpreter
+	return nil
+}
+
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.interpreters))
+	for name := range r.interpreters {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Lookup returns the interpreter for the content interpreter annotation of the artefact
+func (r *Registry) Lookup(annotations map[string]string) (Interpreter, error) {
+	name, ok := annotations[oci.ContentInterpreterAnnotation]
+	if !ok {
+		return nil, fmt.Errorf("artefact doesn't have %q annotation", oci.ContentInterpreterAnnotation)
+	}
+	interpreter, ok := r.interpreters[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content interpreter %q", name)
+	}
+	return interpreter, nil
+}
@@ -0,0 +1,371 @@
+package interpreter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/docker/labs-brown-tape/manifest/types"
+)
+
+const (
+	DefaultFieldManager = "tape"
+
+	InventoryLabel           = "tape.docker.com/inventory"
+	InventoryImageAnnotation = "tape.docker.com/image"
+)
+
+var configMaps = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+type ObjectRef struct {
+	Group     string `json:"group,omitempty"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+func (r ObjectRef) String() string {
+	kind := r.Kind
+	if r.Group != "" {
+		kind += "." + r.Group
+	}
+	if r.Namespace == "" {
+		return kind + "/" + r.Name
+	}
+	return kind + "/" + r.Namespace + "/" + r.Name
+}
+
+// inventoryKey uses the same format as cli-utils inventory, none of the fields
+// can contain an underscore
+func (r ObjectRef) inventoryKey() string {
+	return strings.Join([]string{r.Namespace, r.Name, r.Group, r.Kind}, "_")
+}
+
+func parseInventoryKey(key string) (ObjectRef, error) {
+	parts := strings.Split(key, "_")
+	if len(parts) != 4 {
+		return ObjectRef{}, fmt.Errorf("invalid inventory key %q", key)
+	}
+	return ObjectRef{Namespace: parts[0], Name: parts[1], Group: parts[2], Kind: parts[3]}, nil
+}
+
+type KubectlApplyOptions struct {
+	// FieldManager is used for server-side apply, it defaults to DefaultFieldManager
+	FieldManager   string
+	ForceConflicts bool
+	// Namespace is set on namespaced objects that don't specify one
+	Namespace string
+
+	// Inventory is a config map that keeps track of applied objects, pruning
+	// deletes objects that were in the inventory but are no longer in the artefact
+	InventoryName      string
+	InventoryNamespace string
+	Prune              bool
+}
+
+type KubectlApply struct {
+	client dynamic.Interface
+	mapper meta.RESTMapper
+
+	KubectlApplyOptions
+}
+
+func NewKubectlApply(client dynamic.Interface, mapper meta.RESTMapper, options KubectlApplyOptions) (*KubectlApply, error) {
+	if options.FieldManager == "" {
+		options.FieldManager = DefaultFieldManager
+	}
+	if options.Namespace == "" {
+		options.Namespace = metav1.NamespaceDefault
+	}
+	if options.InventoryNamespace == "" {
+		options.InventoryNamespace = options.Namespace
+	}
+	if options.Prune && options.InventoryName == "" {
+		return nil, fmt.Errorf("inventory name must be set for pruning")
+	}
+	return &KubectlApply{
+		client:              client,
+		mapper:              mapper,
+		KubectlApplyOptions: options,
+	}, nil
+}
+
+// Apply does server-side apply of all objects from the contents, inventory is updated
+// to include new objects before these are applied, and objects that are no longer in
+// the contents are pruned after all objects have been applied; namespaces are applied
+// before the inventory is updated, as it may be stored in one of these
+func (a *KubectlApply) Apply(ctx context.Context, contents *Contents) (*Result, error) {
+	objects, err := loadObjects(contents.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]ObjectRef, 0, len(objects))
+	for _, obj := range objects {
+		mapping, err := a.restMapping(obj.GroupVersionKind())
+		if err != nil {
+			return nil, err
+		}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			if obj.GetNamespace() == "" {
+				obj.SetNamespace(a.Namespace)
+			}
+		} else {
+			obj.SetNamespace("")
+		}
+		refs = append(refs, makeObjectRef(obj))
+	}
+
+	var inventory, union []ObjectRef
+	if a.InventoryName != "" {
+		inventory, err = a.getInventory(ctx)
+		if err != nil {
+			return nil, err
+		}
+		union = slices.Clone(inventory)
+		for _, ref := range refs {
+			if !slices.Contains(union, ref) {
+				union = append(union, ref)
+			}
+		}
+	}
+
+	result := &Result{}
+	for i, obj := range objects {
+		// objects are ordered with namespaces first
+		if union != nil && applyOrder(obj) > 0 {
+			if err := a.setInventory(ctx, contents.Image, union); err != nil {
+				return result, err
+			}
+			union = nil
+		}
+		if err := a.applyObject(ctx, obj); err != nil {
+			return result, fmt.Errorf("failed to apply %s: %w", refs[i], err)
+		}
+		result.Applied = append(result.Applied, refs[i])
+	}
+
+	if a.InventoryName == "" {
+		return result, nil
+	}
+
+	keep := []ObjectRef{}
+	for _, ref := range inventory {
+		if slices.Contains(refs, ref) {
+			continue
+		}
+		if !a.Prune {
+			keep = append(keep, ref)
+			continue
+		}
+		if err := a.deleteObject(ctx, ref); err != nil {
+			return result, fmt.Errorf("failed to prune %s: %w", ref, err)
+		}
+		result.Pruned = append(result.Pruned, ref)
+	}
+	if err := a.setInventory(ctx, contents.Image, append(keep, refs...)); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// restMapping retries with discovery reset, as custom resource definitions may
+// have been applied after the mapper was populated
+func (a *KubectlApply) restMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	versions := []string{}
+	if gvk.Version != "" {
+		versions = append(versions, gvk.Version)
+	}
+	mapping, err := a.mapper.RESTMapping(gvk.GroupKind(), versions...)
+	if meta.IsNoMatchError(err) {
+		if mapper, ok := a.mapper.(meta.ResettableRESTMapper); ok {
+			mapper.Reset()
+			mapping, err = a.mapper.RESTMapping(gvk.GroupKind(), versions...)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to find resource for %s: %w", gvk, err)
+	}
+	return mapping, nil
+}
+
+func (a *KubectlApply) resourceInterface(mapping *meta.RESTMapping, namespace string) dynamic.ResourceInterface {
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return a.client.Resource(mapping.Resource).Namespace(namespace)
+	}
+	return a.client.Resource(mapping.Resource)
+}
+
+func (a *KubectlApply) applyObject(ctx context.Context, obj *unstructured.Unstructured) error {
+	mapping, err := a.restMapping(obj.GroupVersionKind())
+	if err != nil {
+		return err
+	}
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = a.resourceInterface(mapping, obj.GetNamespace()).Patch(ctx, obj.GetName(), apitypes.ApplyPatchType, data,
+		metav1.PatchOptions{FieldManager: a.FieldManager, Force: &a.ForceConflicts})
+	return err
+}
+
+func (a *KubectlApply) deleteObject(ctx context.Context, ref ObjectRef) error {
+	mapping, err := a.restMapping(schema.GroupVersionKind{Group: ref.Group, Kind: ref.Kind})
+	if err != nil {
+		return err
+	}
+	propagationPolicy := metav1.DeletePropagationBackground
+	err = a.resourceInterface(mapping, ref.Namespace).Delete(ctx, ref.Name,
+		metav1.DeleteOptions{PropagationPolicy: &propagationPolicy})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (a *KubectlApply) getInventory(ctx context.Context) ([]ObjectRef, error) {
+	obj, err := a.client.Resource(configMaps).Namespace(a.InventoryNamespace).Get(ctx, a.InventoryName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get inventory: %w", err)
+	}
+	data, _, err := unstructured.NestedStringMap(obj.Object, "data")
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse inventory: %w", err)
+	}
+	refs := make([]ObjectRef, 0, len(data))
+	for key := range data {
+		ref, err := parseInventoryKey(key)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	slices.SortFunc(refs, func(a, b ObjectRef) int {
+		return strings.Compare(a.inventoryKey(), b.inventoryKey())
+	})
+	return refs, nil
+}
+
+func (a *KubectlApply) setInventory(ctx context.Context, image string, refs []ObjectRef) error {
+	data := make(map[string]any, len(refs))
+	for _, ref := range refs {
+		data[ref.inventoryKey()] = ""
+	}
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]any{
+			"name":        a.InventoryName,
+			"namespace":   a.InventoryNamespace,
+			"labels":      map[string]any{InventoryLabel: a.InventoryName},
+			"annotations": map[string]any{InventoryImageAnnotation: image},
+		},
+		"data": data,
+	}}
+	body, err := obj.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	_, err = a.client.Resource(configMaps).Namespace(a.InventoryNamespace).Patch(ctx, a.InventoryName, apitypes.ApplyPatchType, body,
+		metav1.PatchOptions{FieldManager: a.FieldManager, Force: &a.ForceConflicts})
+	if err != nil {
+		return fmt.Errorf("unable to update inventory: %w", err)
+	}
+	return nil
+}
+
+func makeObjectRef(obj *unstructured.Unstructured) ObjectRef {
+	gvk := obj.GroupVersionKind()
+	return ObjectRef{
+		Group:     gvk.Group,
+		Kind:      gvk.Kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}
+}
+
+// loadObjects reads all objects from YAML and JSON files in dir, namespaces and
+// custom resource definitions are ordered first, so that objects that depend on
+// these can be applied
+func loadObjects(dir string) ([]*unstructured.Unstructured, error) {
+	objects := []*unstructured.Unstructured{}
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() || slices.Contains(konfig.RecognizedKustomizationFileNames(), e.Name()) {
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		nodes, err := (&kio.ByteReader{
+			Reader:                bytes.NewReader(data),
+			OmitReaderAnnotations: true,
+		}).Read()
+		if err != nil {
+			return fmt.Errorf("unable to parse %q: %w", path, err)
+		}
+		for _, node := range nodes {
+			if err := types.ForEachResource(node, func(node *yaml.RNode) error {
+				data, err := node.MarshalJSON()
+				if err != nil {
+					return err
+				}
+				obj := &unstructured.Unstructured{}
+				if err := obj.UnmarshalJSON(data); err != nil {
+					return fmt.Errorf("unable to decode object from %q: %w", path, err)
+				}
+				objects = append(objects, obj)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to load objects from %q: %w", dir, err)
+	}
+	slices.SortStableFunc(objects, func(a, b *unstructured.Unstructured) int {
+		return applyOrder(a) - applyOrder(b)
+	})
+	return objects, nil
+}
+
+func applyOrder(obj *unstructured.Unstructured) int {
+	switch obj.GroupVersionKind().GroupKind() {
+	case schema.GroupKind{Kind: "Namespace"}:
+		return 0
+	case schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}:
+		return 1
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,298 @@
+package interpreter_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	. "github.com/docker/labs-brown-tape/interpreter"
+	"github.com/docker/labs-brown-tape/oci"
+)
+
+var (
+	configMaps  = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	deployments = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	namespaces  = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+)
+
+func TestRegistry(t *testing.T) {
+	g := NewWithT(t)
+
+	registry := NewRegistry()
+	kubectlApply, err := NewKubectlApply(nil, nil, KubectlApplyOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(registry.Register(oci.ContentInterpreterKubectlApply, kubectlApply)).To(Succeed())
+	g.Expect(registry.Register(oci.ContentInterpreterKubectlApply, kubectlApply)).ToNot(Succeed())
+	g.Expect(registry.Names()).To(ConsistOf(oci.ContentInterpreterKubectlApply))
+
+	interpreter, err := registry.Lookup(map[string]string{oci.ContentInterpreterAnnotation: oci.ContentInterpreterKubectlApply})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(interpreter).To(Equal(kubectlApply))
+
+	_, err = registry.Lookup(map[string]string{oci.ContentInterpreterAnnotation: "unknown"})
+	g.Expect(err).To(MatchError(ContainSubstring(`unsupported content interpreter "unknown"`)))
+	_, err = registry.Lookup(map[string]string{})
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = NewKubectlApply(nil, nil, KubectlApplyOptions{Prune: true})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestKubectlApply(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	client := newFakeClient()
+	patchOptions := []metav1.PatchOptions{}
+	kubectlApply, err := NewKubectlApply(&patchRecorder{Interface: client, options: &patchOptions}, newRESTMapper(), KubectlApplyOptions{
+		Namespace:          "test",
+		InventoryName:      "app",
+		InventoryNamespace: "tape",
+		Prune:              true,
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"deployment.yaml": deployment("nginx:1.25"),
+		"config.yaml":     configMap("a") + "---\n" + configMap("b"),
+		"namespace.yaml":  "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: test\n",
+		"README.md":       "not a manifest",
+	})
+
+	result, err := kubectlApply.Apply(ctx, &Contents{Image: "example.com/app:config.1", Dir: dir})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Applied).To(HaveLen(4))
+	g.Expect(result.Applied[0].String()).To(Equal("Namespace/test"))
+	g.Expect(result.Pruned).To(BeEmpty())
+
+	obj, err := client.Resource(deployments).Namespace("test").Get(ctx, "app", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+	g.Expect(containers).To(HaveLen(1))
+	g.Expect(containers[0]).To(HaveKeyWithValue("image", "nginx:1.25"))
+
+	inventory, err := client.Resource(configMaps).Namespace("tape").Get(ctx, "app", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(inventory.GetAnnotations()).To(HaveKeyWithValue(InventoryImageAnnotation, "example.com/app:config.1"))
+	data, _, _ := unstructured.NestedStringMap(inventory.Object, "data")
+	g.Expect(data).To(HaveLen(4))
+	g.Expect(data).To(HaveKey("test_app_apps_Deployment"))
+	g.Expect(data).To(HaveKey("_test__Namespace"))
+
+	g.Expect(os.Remove(filepath.Join(dir, "config.yaml"))).To(Succeed())
+	writeFiles(t, dir, map[string]string{
+		"deployment.yaml": deployment("nginx:1.26"),
+		"config.yaml":     configMap("a"),
+	})
+
+	result, err = kubectlApply.Apply(ctx, &Contents{Image: "example.com/app:config.2", Dir: dir})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Applied).To(HaveLen(3))
+	g.Expect(result.Pruned).To(HaveLen(1))
+	g.Expect(result.Pruned[0].String()).To(Equal("ConfigMap/test/b"))
+
+	_, err = client.Resource(configMaps).Namespace("test").Get(ctx, "b", metav1.GetOptions{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+	obj, err = client.Resource(deployments).Namespace("test").Get(ctx, "app", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	containers, _, _ = unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+	g.Expect(containers[0]).To(HaveKeyWithValue("image", "nginx:1.26"))
+
+	inventory, err = client.Resource(configMaps).Namespace("tape").Get(ctx, "app", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	data, _, _ = unstructured.NestedStringMap(inventory.Object, "data")
+	g.Expect(data).To(HaveLen(3))
+	g.Expect(data).ToNot(HaveKey("test_b__ConfigMap"))
+
+	// apply patches are recorded with the field manager
+	for _, action := range client.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok {
+			g.Expect(patch.GetPatchType()).To(Equal(apitypes.ApplyPatchType))
+		}
+	}
+	g.Expect(patchOptions).ToNot(BeEmpty())
+	for _, options := range patchOptions {
+		g.Expect(options.FieldManager).To(Equal(DefaultFieldManager))
+		g.Expect(options.Force).ToNot(BeNil())
+		g.Expect(*options.Force).To(BeFalse())
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"unknown.yaml": "apiVersion: example.com/v1\nkind: Unknown\nmetadata:\n  name: x\n",
+	})
+	_, err = kubectlApply.Apply(ctx, &Contents{Image: "example.com/app:config.3", Dir: dir})
+	g.Expect(err).To(MatchError(ContainSubstring("unable to find resource for example.com/v1, Kind=Unknown")))
+}
+
+func TestKubectlApplyInventoryInNewNamespace(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	client := newFakeClient()
+	// same as the API server, objects cannot be created in a namespace that doesn't exist
+	client.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if _, err := client.Tracker().Get(namespaces, "", action.GetNamespace()); err != nil {
+			return true, nil, err
+		}
+		return false, nil, nil
+	})
+	kubectlApply, err := NewKubectlApply(client, newRESTMapper(), KubectlApplyOptions{
+		FieldManager:       "test",
+		Namespace:          "app",
+		InventoryName:      "app",
+		InventoryNamespace: "app",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml":    configMap("a"),
+		"namespace.yaml": "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: app\n",
+	})
+
+	result, err := kubectlApply.Apply(ctx, &Contents{Image: "example.com/app:config.1", Dir: dir})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Applied).To(HaveLen(2))
+
+	inventory, err := client.Resource(configMaps).Namespace("app").Get(ctx, "app", metav1.GetOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	data, _, _ := unstructured.NestedStringMap(inventory.Object, "data")
+	g.Expect(data).To(HaveKey("_app__Namespace"))
+	g.Expect(data).To(HaveKey("app_a__ConfigMap"))
+
+	// the inventory is recorded before the objects in the namespace are applied
+	patches := []string{}
+	for _, action := range client.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok {
+			patches = append(patches, patch.GetResource().Resource+"/"+patch.GetName())
+		}
+	}
+	g.Expect(patches).To(Equal([]string{"namespaces/app", "configmaps/app", "configmaps/a", "configmaps/app"}))
+}
+
+// patchRecorder keeps options of all patches, as these are not recorded by the fake client
+type patchRecorder struct {
+	dynamic.Interface
+	options *[]metav1.PatchOptions
+}
+
+func (r *patchRecorder) Resource(resource schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return &patchRecorderResource{
+		NamespaceableResourceInterface: r.Interface.Resource(resource),
+		options:                        r.options,
+	}
+}
+
+type patchRecorderResource struct {
+	dynamic.NamespaceableResourceInterface
+	options *[]metav1.PatchOptions
+}
+
+func (r *patchRecorderResource) Namespace(namespace string) dynamic.ResourceInterface {
+	return &patchRecorderNamespacedResource{
+		ResourceInterface: r.NamespaceableResourceInterface.Namespace(namespace),
+		options:           r.options,
+	}
+}
+
+func (r *patchRecorderResource) Patch(ctx context.Context, name string, pt apitypes.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	*r.options = append(*r.options, options)
+	return r.NamespaceableResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+}
+
+type patchRecorderNamespacedResource struct {
+	dynamic.ResourceInterface
+	options *[]metav1.PatchOptions
+}
+
+func (r *patchRecorderNamespacedResource) Patch(ctx context.Context, name string, pt apitypes.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	*r.options = append(*r.options, options)
+	return r.ResourceInterface.Patch(ctx, name, pt, data, options, subresources...)
+}
+
+func newRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{{Version: "v1"}, {Group: "apps", Version: "v1"}})
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	return mapper
+}
+
+// newFakeClient returns a fake client that handles apply patches by creating or replacing
+// objects, which is what server-side apply does when there is only one field manager
+func newFakeClient() *fake.FakeDynamicClient {
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		configMaps:  "ConfigMapList",
+		deployments: "DeploymentList",
+		namespaces:  "NamespaceList",
+	})
+	tracker := client.Tracker()
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != apitypes.ApplyPatchType {
+			return false, nil, nil
+		}
+		gvr, namespace := patch.GetResource(), patch.GetNamespace()
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		_, err := tracker.Get(gvr, namespace, patch.GetName())
+		if apierrors.IsNotFound(err) {
+			return true, obj, tracker.Create(gvr, obj, namespace)
+		}
+		if err != nil {
+			return true, nil, err
+		}
+		return true, obj, tracker.Update(gvr, obj, namespace)
+	})
+	return client
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func deployment(image string) string {
+	return `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+      - image: ` + image + `
+        name: app
+`
+}
+
+func configMap(name string) string {
+	return `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ` + name + `
+data:
+  key: value
+`
+}
@@ -0,0 +1,284 @@
+package diff
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/docker/labs-brown-tape/manifest/types"
+)
+
+const contextLines = 3
+
+type ChangeType string
+
+const (
+	ChangeTypeAdded    ChangeType = "added"
+	ChangeTypeRemoved  ChangeType = "removed"
+	ChangeTypeModified ChangeType = "modified"
+)
+
+// ResourceID identifies a resource, group is taken from apiVersion, so that kinds
+// with the same name in different API groups are told apart, while the version
+// is left out, as changing it doesn't make a different resource
+type ResourceID struct {
+	Group     string `json:"group,omitempty"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+func (id ResourceID) String() string {
+	kind := id.Kind
+	if id.Group != "" {
+		kind += "." + id.Group
+	}
+	if id.Namespace == "" {
+		return kind + "/" + id.Name
+	}
+	return kind + "/" + id.Namespace + "/" + id.Name
+}
+
+func (id ResourceID) Compare(other ResourceID) int {
+	if cmp := cmp.Compare(id.Kind, other.Kind); cmp != 0 {
+		return cmp
+	}
+	if cmp := cmp.Compare(id.Group, other.Group); cmp != 0 {
+		return cmp
+	}
+	if cmp := cmp.Compare(id.Namespace, other.Namespace); cmp != 0 {
+		return cmp
+	}
+	return cmp.Compare(id.Name, other.Name)
+}
+
+type Resource struct {
+	ResourceID
+
+	// Path is relative to the directory resources were loaded from
+	Path string
+	YAML string
+}
+
+type Resources map[ResourceID]*Resource
+
+type ResourceDiff struct {
+	ResourceID `json:",inline"`
+
+	Change  ChangeType `json:"change"`
+	OldPath string     `json:"oldPath,omitempty"`
+	NewPath string     `json:"newPath,omitempty"`
+	Diff    string     `json:"diff"`
+}
+
+// LoadResources reads all resources from YAML and JSON files in dir, items of lists
+// are treated as separate resources
+func LoadResources(dir string) (Resources, error) {
+	resources := Resources{}
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		if e.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return resources.load(path, relPath)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to load resources from %q: %w", dir, err)
+	}
+	return resources, nil
+}
+
+func (r Resources) load(path, relPath string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	nodes, err := (&kio.ByteReader{
+		Reader:                bytes.NewReader(data),
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return fmt.Errorf("unable to parse %q: %w", relPath, err)
+	}
+	for _, node := range nodes {
+		if err := types.ForEachResource(node, func(node *yaml.RNode) error {
+			group, _ := resid.ParseGroupVersion(node.GetApiVersion())
+			id := ResourceID{
+				Group:     group,
+				Kind:      node.GetKind(),
+				Namespace: node.GetNamespace(),
+				Name:      node.GetName(),
+			}
+			if existing, ok := r[id]; ok {
+				return fmt.Errorf("resource %s is defined in both %q and %q", id, existing.Path, relPath)
+			}
+			// resources are normalised, so that only changes in content are reported,
+			// and not e.g. a change of style or order of fields
+			data, err := node.MarshalJSON()
+			if err == nil {
+				data, err = sigsyaml.JSONToYAML(data)
+			}
+			if err != nil {
+				return fmt.Errorf("unable to encode %s from %q: %w", id, relPath, err)
+			}
+			r[id] = &Resource{
+				ResourceID: id,
+				Path:       relPath,
+				YAML:       string(data),
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compare returns differences between old and new resources sorted by kind, namespace
+// and name; resources that are the same are omitted
+func Compare(oldResources, newResources Resources) []ResourceDiff {
+	diffs := []ResourceDiff{}
+	for id, oldResource := range oldResources {
+		newResource, ok := newResources[id]
+		if !ok {
+			diffs = append(diffs, ResourceDiff{
+				ResourceID: id,
+				Change:     ChangeTypeRemoved,
+				OldPath:    oldResource.Path,
+				Diff:       Unified(oldResource.YAML, ""),
+			})
+			continue
+		}
+		if oldResource.YAML == newResource.YAML {
+			continue
+		}
+		diffs = append(diffs, ResourceDiff{
+			ResourceID: id,
+			Change:     ChangeTypeModified,
+			OldPath:    oldResource.Path,
+			NewPath:    newResource.Path,
+			Diff:       Unified(oldResource.YAML, newResource.YAML),
+		})
+	}
+	for id, newResource := range newResources {
+		if _, ok := oldResources[id]; ok {
+			continue
+		}
+		diffs = append(diffs, ResourceDiff{
+			ResourceID: id,
+			Change:     ChangeTypeAdded,
+			NewPath:    newResource.Path,
+			Diff:       Unified("", newResource.YAML),
+		})
+	}
+	slices.SortFunc(diffs, func(a, b ResourceDiff) int {
+		return a.ResourceID.Compare(b.ResourceID)
+	})
+	return diffs
+}
+
+type line struct {
+	op   diffmatchpatch.Operation
+	text string
+}
+
+// Unified returns line-by-line differences between a and b in the unified format,
+// without the file header
+func Unified(a, b string) string {
+	dmp := diffmatchpatch.New()
+	charsA, charsB, lineArray := dmp.DiffLinesToChars(a, b)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(charsA, charsB, false), lineArray)
+
+	lines := []line{}
+	for _, diff := range diffs {
+		for _, text := range strings.SplitAfter(diff.Text, "\n") {
+			if text != "" {
+				lines = append(lines, line{op: diff.Type, text: strings.TrimSuffix(text, "\n")})
+			}
+		}
+	}
+
+	out := &strings.Builder{}
+	for start := 0; start < len(lines); {
+		// find the next change and include lines around it, hunks that are close
+		// to each other are merged
+		first := slices.IndexFunc(lines[start:], func(l line) bool { return l.op != diffmatchpatch.DiffEqual })
+		if first == -1 {
+			break
+		}
+		first += start
+		last := first
+		for i := first; i < len(lines) && i <= last+2*contextLines; i++ {
+			if lines[i].op != diffmatchpatch.DiffEqual {
+				last = i
+			}
+		}
+		hunkStart, hunkEnd := max(first-contextLines, start), min(last+contextLines+1, len(lines))
+		writeHunk(out, lines, hunkStart, hunkEnd)
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, lines []line, start, end int) {
+	// line numbers are 1-based, and count lines in either of the sides
+	oldLine, newLine := 1, 1
+	for _, l := range lines[:start] {
+		if l.op != diffmatchpatch.DiffInsert {
+			oldLine++
+		}
+		if l.op != diffmatchpatch.DiffDelete {
+			newLine++
+		}
+	}
+	oldCount, newCount := 0, 0
+	for _, l := range lines[start:end] {
+		if l.op != diffmatchpatch.DiffInsert {
+			oldCount++
+		}
+		if l.op != diffmatchpatch.DiffDelete {
+			newCount++
+		}
+	}
+	// same as diff, when a side is empty its line number is the one before
+	if oldCount == 0 {
+		oldLine--
+	}
+	if newCount == 0 {
+		newLine--
+	}
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+	for _, l := range lines[start:end] {
+		switch l.op {
+		case diffmatchpatch.DiffDelete:
+			out.WriteString("-")
+		case diffmatchpatch.DiffInsert:
+			out.WriteString("+")
+		default:
+			out.WriteString(" ")
+		}
+		out.WriteString(l.text + "\n")
+	}
+}
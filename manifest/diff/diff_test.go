@@ -0,0 +1,144 @@
+package diff_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	. "github.com/docker/labs-brown-tape/manifest/diff"
+)
+
+func TestUnified(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(Unified("a\nb\n", "a\nb\n")).To(BeEmpty())
+	g.Expect(Unified("", "a\nb\n")).To(Equal("@@ -0,0 +1,2 @@\n+a\n+b\n"))
+	g.Expect(Unified("a\nb\n", "")).To(Equal("@@ -1,2 +0,0 @@\n-a\n-b\n"))
+
+	old := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n18\n19\n20\n"
+	new := "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n17\n18\n19\n20\n21\n"
+	g.Expect(Unified(old, new)).To(Equal(
+		"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+			"@@ -18,3 +18,4 @@\n 18\n 19\n 20\n+21\n"))
+
+	// changes close to each other are in the same hunk
+	new = "1\n2\nthree\n4\n5\n6\n7\n8\nnine\n10\n11\n12\n13\n14\n15\n16\n17\n18\n19\n20\n"
+	g.Expect(Unified(old, new)).To(Equal(
+		"@@ -1,12 +1,12 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n 7\n 8\n-9\n+nine\n 10\n 11\n 12\n"))
+}
+
+func TestCompare(t *testing.T) {
+	g := NewWithT(t)
+
+	oldDir, newDir := t.TempDir(), t.TempDir()
+	writeFiles := func(dir string, files map[string]string) {
+		for name, data := range files {
+			path := filepath.Join(dir, name)
+			g.Expect(os.MkdirAll(filepath.Dir(path), 0o755)).To(Succeed())
+			g.Expect(os.WriteFile(path, []byte(data), 0o644)).To(Succeed())
+		}
+	}
+
+	writeFiles(oldDir, map[string]string{
+		"deployment.yaml": deployment("nginx:1.25"),
+		"service.yaml":    service("app"),
+		"config.yaml":     configMap("a", "1") + "---\n" + configMap("b", "1"),
+		"README.md":       "not a manifest",
+	})
+	writeFiles(newDir, map[string]string{
+		// moving a resource to another file is not a change
+		"app/deployment.yaml": deployment("nginx:1.26"),
+		"list.json": `{"apiVersion": "v1", "kind": "List", "items": [` +
+			`{"apiVersion": "v1", "kind": "List", "items": [` +
+			`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "a", "namespace": "test"}, "data": {"key": "1"}},` +
+			`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c", "namespace": "test"}, "data": {"key": "1"}}` +
+			`]}]}`,
+		"service.yaml": service("app"),
+	})
+
+	oldResources, err := LoadResources(oldDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(oldResources).To(HaveLen(4))
+	newResources, err := LoadResources(newDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(newResources).To(HaveLen(4))
+
+	diffs := Compare(oldResources, newResources)
+	g.Expect(diffs).To(HaveLen(3))
+
+	g.Expect(diffs[0].ResourceID).To(Equal(ResourceID{Kind: "ConfigMap", Namespace: "test", Name: "b"}))
+	g.Expect(diffs[0].Change).To(Equal(ChangeTypeRemoved))
+	g.Expect(diffs[0].OldPath).To(Equal("config.yaml"))
+
+	g.Expect(diffs[1].ResourceID).To(Equal(ResourceID{Kind: "ConfigMap", Namespace: "test", Name: "c"}))
+	g.Expect(diffs[1].Change).To(Equal(ChangeTypeAdded))
+	g.Expect(diffs[1].NewPath).To(Equal("list.json"))
+	g.Expect(diffs[1].Diff).To(ContainSubstring("+  name: c\n"))
+
+	g.Expect(diffs[2].ResourceID.String()).To(Equal("Deployment.apps/test/app"))
+	g.Expect(diffs[2].Change).To(Equal(ChangeTypeModified))
+	g.Expect(diffs[2].OldPath).To(Equal("deployment.yaml"))
+	g.Expect(diffs[2].NewPath).To(Equal(filepath.Join("app", "deployment.yaml")))
+	g.Expect(diffs[2].Diff).To(ContainSubstring("-      - image: nginx:1.25\n+      - image: nginx:1.26\n"))
+
+	// kinds with the same name in different groups are different resources
+	sameKindDir := t.TempDir()
+	writeFiles(sameKindDir, map[string]string{
+		"deployment.yaml": deployment("nginx:1.26"),
+		"other.yaml":      strings.Replace(deployment("nginx:1.26"), "apiVersion: apps/v1", "apiVersion: example.com/v1", 1),
+	})
+	sameKindResources, err := LoadResources(sameKindDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sameKindResources).To(HaveLen(2))
+	diffs = Compare(Resources{}, sameKindResources)
+	g.Expect(diffs).To(HaveLen(2))
+	g.Expect(diffs[0].ResourceID).To(Equal(ResourceID{Group: "apps", Kind: "Deployment", Namespace: "test", Name: "app"}))
+	g.Expect(diffs[1].ResourceID).To(Equal(ResourceID{Group: "example.com", Kind: "Deployment", Namespace: "test", Name: "app"}))
+
+	writeFiles(newDir, map[string]string{
+		"duplicate.yaml": service("app"),
+	})
+	_, err = LoadResources(newDir)
+	g.Expect(err).To(MatchError(ContainSubstring("resource Service/test/app is defined in both")))
+}
+
+func deployment(image string) string {
+	return `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  namespace: test
+spec:
+  template:
+    spec:
+      containers:
+      - image: ` + image + `
+        name: app
+`
+}
+
+func service(name string) string {
+	return `apiVersion: v1
+kind: Service
+metadata:
+  name: ` + name + `
+  namespace: test
+spec:
+  ports:
+  - port: 80
+`
+}
+
+func configMap(name, value string) string {
+	return `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ` + name + `
+  namespace: test
+data:
+  key: "` + value + `"
+`
+}
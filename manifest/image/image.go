@@ -1,6 +1,8 @@
 package image
 
 import (
+	"strings"
+
 	kustomize "sigs.k8s.io/kustomize/api/types"
 
 	"github.com/docker/labs-brown-tape/attest/digest"
@@ -14,9 +16,18 @@ type (
 		OriginalTag  string `json:"originalTag,omitempty"`
 
 		Digest string `json:"digest,omitempty"`
+		// DigestSource is set when the digest was not obtained from the registry
+		DigestSource DigestSource `json:"digestSource,omitempty"`
 
 		NewName string `json:"newName,omitempty"`
 		NewTag  string `json:"newTag,omitempty"`
+		// NewDigest is set when the copy differs from the original, e.g. when platforms are filtered
+		NewDigest string `json:"newDigest,omitempty"`
+
+		// Subject is set for images found with referrers API, these are not tagged
+		// and only referred to by digest
+		Subject      string `json:"subject,omitempty"`
+		ArtifactType string `json:"artifactType,omitempty"`
 
 		Alias *string `json:"alias,omitempty"`
 	}
@@ -38,8 +49,13 @@ type (
 	}
 )
 
+type DigestSource string
+
+// DigestSourceUser means that the digest was given by the user, e.g. for a newly built image
+const DigestSourceUser DigestSource = "user"
+
 func (i Image) Ref(original bool) string {
-	ref := ""
+	ref, digest := "", i.Digest
 	if original {
 		ref = i.OriginalName
 		if i.OriginalTag != "" {
@@ -50,13 +66,32 @@ func (i Image) Ref(original bool) string {
 		if i.NewTag != "" {
 			ref += ":" + i.NewTag
 		}
+		digest = i.CopiedDigest()
 	}
-	if i.Digest != "" {
-		ref += "@" + i.Digest
+	if digest != "" {
+		ref += "@" + digest
 	}
 	return ref
 }
 
+// CopiedDigest returns digest of the image once it's copied
+func (i Image) CopiedDigest() string {
+	if i.NewDigest != "" {
+		return i.NewDigest
+	}
+	return i.Digest
+}
+
+// IsRelatedTo returns true when the image is a referrer of the given digest, or
+// when it's tagged after the digest, as cosign does, e.g. sha256-<hex>.sig
+func (i Image) IsRelatedTo(digest string) bool {
+	if i.Subject != "" {
+		return i.Subject == digest
+	}
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	return ok && strings.HasPrefix(i.OriginalTag, algorithm+"-"+hex+".")
+}
+
 func (i Image) primarySource() *Source {
 	if len(i.Sources) == 0 {
 		panic("unextected empty image sources")
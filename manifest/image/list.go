@@ -116,8 +116,12 @@ func (l *ImageList) Dedup() error {
 				OriginalTag:  image.OriginalTag,
 				OriginalName: image.OriginalName,
 				Digest:       image.Digest,
+				DigestSource: image.DigestSource,
 				NewName:      image.NewName,
 				NewTag:       image.NewTag,
+				NewDigest:    image.NewDigest,
+				Subject:      image.Subject,
+				ArtifactType: image.ArtifactType,
 			}
 		}
 
@@ -180,8 +184,10 @@ func (l *ImageList) GroupByManifest() map[string]*ImageList {
 				OriginalName: item.OriginalName,
 				OriginalTag:  item.OriginalTag,
 				Digest:       item.Digest,
+				DigestSource: item.DigestSource,
 				NewName:      item.NewName,
 				NewTag:       item.NewTag,
+				NewDigest:    item.NewDigest,
 			})
 		}
 	}
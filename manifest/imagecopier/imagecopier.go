@@ -4,21 +4,320 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"hash"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/docker/labs-brown-tape/manifest/types"
 	"github.com/docker/labs-brown-tape/oci"
 )
 
+const (
+	DefaultJobs    = 4
+	DefaultRetries = 3
+
+	defaultRetryDelay = time.Second
+)
+
 type ImageCopier interface {
 	CopyImages(context.Context, ...*types.ImageList) ([]string, error)
+	PlanCopy(context.Context, ...*types.ImageList) ([]PlannedCopy, error)
+	WithJobs(int)
+	WithRetries(int)
+	WithProgress(func(Progress))
+	WithPlatforms(...oci.Platform)
+	WithNaming(NamingStrategy)
+}
+
+type ProgressStatus string
+
+const (
+	ProgressStatusCopying  ProgressStatus = "copying"
+	ProgressStatusCopied   ProgressStatus = "copied"
+	ProgressStatusSkipped  ProgressStatus = "skipped"
+	ProgressStatusRetrying ProgressStatus = "retrying"
+	ProgressStatusFailed   ProgressStatus = "failed"
+)
+
+// Progress is reported for every image as it gets copied, Completed and Total
+// count unique destination references
+type Progress struct {
+	Status      ProgressStatus
+	Source      string
+	Destination string
+	Digest      string
+	Attempt     int
+	Err         error
+
+	Completed, Total int
+}
+
+// copyPool copies images concurrently, it's common to all copiers
+type copyPool struct {
+	jobs       int
+	retries    int
+	retryDelay time.Duration
+	progress   func(Progress)
+	platforms  []oci.Platform
+	naming     NamingStrategy
+}
+
+func newCopyPool() copyPool {
+	return copyPool{
+		jobs:       DefaultJobs,
+		retries:    DefaultRetries,
+		retryDelay: defaultRetryDelay,
+		naming:     &singleRepoNaming{hash: sha256.New()},
+	}
+}
+
+func (p *copyPool) WithJobs(jobs int) {
+	if jobs > 0 {
+		p.jobs = jobs
+	}
+}
+
+func (p *copyPool) WithRetries(retries int) {
+	if retries >= 0 {
+		p.retries = retries
+	}
+}
+
+func (p *copyPool) WithProgress(progress func(Progress)) {
+	p.progress = progress
+}
+
+// WithNaming sets the strategy for naming copied images, single-repo naming is used by default
+func (p *copyPool) WithNaming(naming NamingStrategy) {
+	p.naming = naming
+}
+
+// WithPlatforms makes the copier only copy manifests for the given platforms from
+// image indexes, NewDigest of such images is set to the digest of the filtered index;
+// signatures, attestations and referrers of the original index are not copied
+func (p *copyPool) WithPlatforms(platforms ...oci.Platform) {
+	p.platforms = platforms
+}
+
+// filterPlatforms returns the filtered index when platforms are set and the image is
+// an index with any other platforms; referrers are always copied as they are, as their
+// digest has to match
+func (p *copyPool) filterPlatforms(ctx context.Context, client *oci.Client, image *types.Image) (oci.ImageIndex, error) {
+	if len(p.platforms) == 0 || image.Subject != "" {
+		return nil, nil
+	}
+	index, err := client.FilterIndex(ctx, image.Ref(true), p.platforms...)
+	if err != nil || index == nil {
+		return nil, err
+	}
+	digest, err := index.Digest()
+	if err != nil {
+		return nil, err
+	}
+	image.NewDigest = digest.String()
+	return index, nil
+}
+
+// filterAllPlatforms filters indexes of all images, it returns filtered indexes along with
+// original digests of these; images related to original digests are not copied, as the
+// filtered index has a different digest
+func (p *copyPool) filterAllPlatforms(ctx context.Context, client *oci.Client, lists ...*types.ImageList) (map[*types.Image]oci.ImageIndex, []string, error) {
+	indexes := map[*types.Image]oci.ImageIndex{}
+	filteredDigests := []string{}
+	for _, images := range lists {
+		for i := range images.Items() {
+			image := &images.Items()[i]
+			index, err := p.filterPlatforms(ctx, client, image)
+			if err != nil {
+				return nil, nil, err
+			}
+			if index != nil {
+				indexes[image] = index
+				filteredDigests = append(filteredDigests, image.Digest)
+			}
+		}
+	}
+	return indexes, filteredDigests, nil
+}
+
+// PlannedCopy describes an image that CopyImages would copy, it's returned by PlanCopy
+// which names the images, but doesn't write anything to the destination
+type PlannedCopy struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Digest      string `json:"digest"`
+}
+
+type copyTask struct {
+	source, destination, digest string
+	// subject is set for referrers, as these have to be copied one at a time
+	subject string
+	// index is set when it's written instead of copying the source
+	index oci.ImageIndex
+}
+
+func (t copyTask) String() string {
+	// referrers are copied by digest
+	if strings.HasSuffix(t.destination, "@"+t.digest) {
+		return t.destination
+	}
+	return t.destination + "@" + t.digest
+}
+
+// uniqueTasks omits tasks with the same destination
+func uniqueTasks(tasks []copyTask) []copyTask {
+	unique := make([]copyTask, 0, len(tasks))
+	seen := make(map[string]struct{}, len(tasks))
+	for _, task := range tasks {
+		if _, ok := seen[task.String()]; ok {
+			continue
+		}
+		seen[task.String()] = struct{}{}
+		unique = append(unique, task)
+	}
+	return unique
+}
+
+func planCopy(tasks []copyTask) []PlannedCopy {
+	plan := []PlannedCopy{}
+	for _, task := range uniqueTasks(tasks) {
+		plan = append(plan, PlannedCopy{
+			Source:      task.source,
+			Destination: task.destination,
+			Digest:      task.digest,
+		})
+	}
+	return plan
+}
+
+// copyAll runs the tasks with up to p.jobs at a time, any tasks with the same destination
+// are only run once; copying stops as soon as any of the tasks fails or ctx is cancelled
+func (p *copyPool) copyAll(ctx context.Context, client *oci.Client, tasks []copyTask) ([]string, error) {
+	copiedImages := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		copiedImages = append(copiedImages, task.String())
+	}
+	uniqueTasks := uniqueTasks(tasks)
+
+	// with registries that don't support referrers API, each of the referrers of the same
+	// subject updates the index under the referrers tag, so these cannot be copied concurrently
+	subjectLocks := map[string]*sync.Mutex{}
+	for _, task := range uniqueTasks {
+		if _, ok := subjectLocks[task.subject]; task.subject != "" && !ok {
+			subjectLocks[task.subject] = &sync.Mutex{}
+		}
+	}
+
+	var (
+		lock      sync.Mutex
+		completed int
+	)
+	report := func(progress Progress) {
+		lock.Lock()
+		defer lock.Unlock()
+		if progress.Status == ProgressStatusCopied || progress.Status == ProgressStatusSkipped {
+			completed++
+		}
+		if p.progress != nil {
+			progress.Completed, progress.Total = completed, len(uniqueTasks)
+			p.progress(progress)
+		}
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(p.jobs)
+	for i := range uniqueTasks {
+		task := uniqueTasks[i]
+		g.Go(func() error {
+			if subjectLock, ok := subjectLocks[task.subject]; ok {
+				subjectLock.Lock()
+				defer subjectLock.Unlock()
+			}
+			return p.copy(ctx, client, task, report)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return copiedImages, nil
+}
+
+func (p *copyPool) copy(ctx context.Context, client *oci.Client, task copyTask, report func(Progress)) error {
+	progress := Progress{
+		Source:      task.source,
+		Destination: task.destination,
+		Digest:      task.digest,
+	}
+
+	// destination may already have the image from a previous run
+	if digest, err := client.Digest(ctx, task.destination); err == nil && digest == task.digest {
+		progress.Status = ProgressStatusSkipped
+		report(progress)
+		return nil
+	}
+
+	delay := p.retryDelay
+	for attempt := 1; ; attempt++ {
+		progress.Attempt, progress.Status, progress.Err = attempt, ProgressStatusCopying, nil
+		report(progress)
+
+		var err error
+		if task.index != nil {
+			err = client.WriteIndex(ctx, task.destination, task.index)
+		} else {
+			err = client.Copy(ctx, task.source, task.destination, task.digest)
+		}
+		if err == nil {
+			progress.Status = ProgressStatusCopied
+			report(progress)
+			return nil
+		}
+
+		progress.Err = err
+		if attempt > p.retries || !isTransient(err) || ctx.Err() != nil {
+			progress.Status = ProgressStatusFailed
+			report(progress)
+			return fmt.Errorf("failed to copy %q to %q: %w", task.source, task.destination, err)
+		}
+		progress.Status = ProgressStatusRetrying
+		report(progress)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// isTransient returns true for errors that are worth retrying, i.e. registry errors
+// that are marked as temporary and network errors
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	transportErr := &transport.Error{}
+	if errors.As(err, &transportErr) {
+		return transportErr.Temporary()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
 
 type RegistryCopier struct {
 	*oci.Client
+	copyPool
 
 	DestinationRef string
-	hash           hash.Hash
 }
 
 func NewRegistryCopier(client *oci.Client, destinationRef string) ImageCopier {
@@ -27,24 +326,132 @@ func NewRegistryCopier(client *oci.Client, destinationRef string) ImageCopier {
 	}
 	return &RegistryCopier{
 		Client:         client,
+		copyPool:       newCopyPool(),
 		DestinationRef: destinationRef,
-		hash:           sha256.New(),
 	}
 }
 
 func (c *RegistryCopier) CopyImages(ctx context.Context, lists ...*types.ImageList) ([]string, error) {
-	copiedImages := []string{}
+	tasks, err := c.makeTasks(ctx, lists...)
+	if err != nil {
+		return nil, err
+	}
+	return c.copyAll(ctx, c.Client, tasks)
+}
+
+func (c *RegistryCopier) PlanCopy(ctx context.Context, lists ...*types.ImageList) ([]PlannedCopy, error) {
+	tasks, err := c.makeTasks(ctx, lists...)
+	if err != nil {
+		return nil, err
+	}
+	return planCopy(tasks), nil
+}
+
+func (c *RegistryCopier) makeTasks(ctx context.Context, lists ...*types.ImageList) ([]copyTask, error) {
+	if err := c.naming.SetNewImageRefs(c.DestinationRef, lists...); err != nil {
+		return nil, err
+	}
+	indexes, filteredDigests, err := c.filterAllPlatforms(ctx, c.Client, lists...)
+	if err != nil {
+		return nil, err
+	}
+	tasks := []copyTask{}
 	for _, images := range lists {
-		SetNewImageRefs(c.DestinationRef, c.hash, images.Items())
-		for _, image := range images.Items() {
-			newRef := image.NewName + ":" + image.NewTag
-			if err := c.Copy(ctx, image.Ref(true), newRef, image.Digest); err != nil {
-				return nil, err
+		for i := range images.Items() {
+			image := &images.Items()[i]
+			if slices.ContainsFunc(filteredDigests, image.IsRelatedTo) {
+				continue
+			}
+			task := copyTask{
+				source:      image.Ref(true),
+				destination: image.NewName + ":" + image.NewTag,
+				digest:      image.CopiedDigest(),
+				index:       indexes[image],
 			}
-			copiedImages = append(copiedImages, newRef+"@"+image.Digest)
+			if image.Subject != "" {
+				task.destination = image.NewName + "@" + image.Digest
+				task.subject = image.NewName + "@" + image.Subject
+			}
+			tasks = append(tasks, task)
 		}
 	}
-	return copiedImages, nil
+	return tasks, nil
+}
+
+// LayoutCopier names images the same way as RegistryCopier does, but instead of
+// copying them to the destination registry it writes them to an OCI layout; as all
+// images are kept in the same layout, only single-repo naming is supported
+type LayoutCopier struct {
+	*oci.Client
+	copyPool
+
+	DestinationRef string
+	LayoutPath     string
+}
+
+func NewLayoutCopier(client *oci.Client, destinationRef, layoutPath string) ImageCopier {
+	if client == nil {
+		client = oci.NewClient(nil)
+	}
+	return &LayoutCopier{
+		Client:         client,
+		copyPool:       newCopyPool(),
+		DestinationRef: destinationRef,
+		LayoutPath:     layoutPath,
+	}
+}
+
+func (c *LayoutCopier) CopyImages(ctx context.Context, lists ...*types.ImageList) ([]string, error) {
+	tasks, err := c.makeTasks(ctx, lists...)
+	if err != nil {
+		return nil, err
+	}
+	// all images are written to the index of the same layout, so it cannot be done concurrently
+	pool := c.copyPool
+	pool.jobs = 1
+	return pool.copyAll(ctx, c.Client, tasks)
+}
+
+func (c *LayoutCopier) PlanCopy(ctx context.Context, lists ...*types.ImageList) ([]PlannedCopy, error) {
+	tasks, err := c.makeTasks(ctx, lists...)
+	if err != nil {
+		return nil, err
+	}
+	return planCopy(tasks), nil
+}
+
+func (c *LayoutCopier) makeTasks(ctx context.Context, lists ...*types.ImageList) ([]copyTask, error) {
+	if _, ok := c.naming.(*singleRepoNaming); !ok {
+		return nil, fmt.Errorf("only single-repo naming is supported when copying to OCI layout")
+	}
+	if err := c.naming.SetNewImageRefs(c.DestinationRef, lists...); err != nil {
+		return nil, err
+	}
+	indexes, filteredDigests, err := c.filterAllPlatforms(ctx, c.Client, lists...)
+	if err != nil {
+		return nil, err
+	}
+	tasks := []copyTask{}
+	for _, images := range lists {
+		for i := range images.Items() {
+			image := &images.Items()[i]
+			if slices.ContainsFunc(filteredDigests, image.IsRelatedTo) {
+				continue
+			}
+			destination := oci.LayoutRef{Path: c.LayoutPath, Tag: image.NewTag}
+			if image.Subject != "" {
+				// referrers are stored untagged, PushLayout pushes these by digest
+				destination.Digest = image.Digest
+			}
+			tasks = append(tasks, copyTask{
+				source:      image.Ref(true),
+				destination: destination.String(),
+				digest:      image.CopiedDigest(),
+				index:       indexes[image],
+			})
+		}
+	}
+	return tasks, nil
 }
 
 func SetNewImageRefs(destinationRef string, hash hash.Hash, images []types.Image) {
@@ -56,6 +463,11 @@ func SetNewImageRefs(destinationRef string, hash hash.Hash, images []types.Image
 func doSetNewImageRef(destinationRef string, hash hash.Hash, i *types.Image) {
 	i.NewName = destinationRef
 
+	if i.Subject != "" {
+		i.NewTag = "" // referrers are copied by digest, so that subject still matches
+		return
+	}
+
 	if oci.IsCosignArtifact(i.OriginalTag) {
 		i.NewTag = i.OriginalTag // preserve tag of cosign artefact
 		return
@@ -3,15 +3,31 @@ package imagecopier_test
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	. "github.com/onsi/gomega"
 
+	"github.com/docker/labs-brown-tape/attest/manifest"
 	. "github.com/docker/labs-brown-tape/manifest/imagecopier"
 	"github.com/docker/labs-brown-tape/manifest/imageresolver"
 	"github.com/docker/labs-brown-tape/manifest/imagescanner"
 	"github.com/docker/labs-brown-tape/manifest/loader"
 	"github.com/docker/labs-brown-tape/manifest/testdata"
+	"github.com/docker/labs-brown-tape/manifest/types"
 	"github.com/docker/labs-brown-tape/oci"
 	"github.com/docker/labs-brown-tape/trex"
 	"github.com/google/go-containerregistry/pkg/crane"
@@ -72,3 +88,513 @@ func makeImageCopierTest(tc testdata.TestCase) func(t *testing.T) {
 		}
 	}
 }
+
+func TestRegistryCopierJobs(t *testing.T) {
+	trex.RunShared()
+	craneOptions := trex.Shared.CraneOptions()
+	makeDestination := trex.Shared.NewUniqueRepoNamer("bpt-copier-jobs-test")
+
+	g := NewWithT(t)
+	ctx := context.Background()
+	client := oci.NewClient(craneOptions)
+
+	const numImages = 10
+	images := types.NewImageList("")
+	for i := 0; i < numImages; i++ {
+		image, err := random.Image(128, 1)
+		g.Expect(err).ToNot(HaveOccurred())
+		digest, err := image.Digest()
+		g.Expect(err).ToNot(HaveOccurred())
+		src := makeDestination(fmt.Sprintf("src-%d", i))
+		g.Expect(crane.Push(image, src+":v1", craneOptions...)).To(Succeed())
+		images.Append(types.Image{
+			OriginalName: src,
+			OriginalTag:  "v1",
+			Digest:       digest.String(),
+		})
+	}
+
+	destination := makeDestination("dst")
+
+	var (
+		lock   sync.Mutex
+		events []Progress
+	)
+	copier := NewRegistryCopier(client, destination)
+	copier.WithJobs(4)
+	copier.WithProgress(func(progress Progress) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, progress)
+	})
+
+	copied, err := copier.CopyImages(ctx, images)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(copied).To(HaveLen(numImages))
+
+	countByStatus := func() map[ProgressStatus]int {
+		counts := map[ProgressStatus]int{}
+		for _, event := range events {
+			counts[event.Status]++
+			g.Expect(event.Total).To(Equal(numImages))
+		}
+		return counts
+	}
+	g.Expect(countByStatus()).To(Equal(map[ProgressStatus]int{
+		ProgressStatusCopying: numImages,
+		ProgressStatusCopied:  numImages,
+	}))
+	g.Expect(events[len(events)-1].Completed).To(Equal(numImages))
+
+	for _, image := range images.Items() {
+		digest, err := client.Digest(ctx, image.NewName+":"+image.NewTag)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(digest).To(Equal(image.Digest))
+	}
+
+	// all images are present now, so these should be skipped
+	events = nil
+	copiedAgain, err := copier.CopyImages(ctx, images)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(copiedAgain).To(Equal(copied))
+	g.Expect(countByStatus()).To(Equal(map[ProgressStatus]int{
+		ProgressStatusSkipped: numImages,
+	}))
+
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = NewRegistryCopier(client, makeDestination("cancelled")).CopyImages(cancelledCtx, images)
+	g.Expect(err).To(MatchError(context.Canceled))
+}
+
+// failingTransport fails the given number of manifest uploads with a network error
+type failingTransport struct {
+	http.RoundTripper
+
+	lock     sync.Mutex
+	failures int
+	attempts int
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPut || !strings.Contains(req.URL.Path, "/manifests/") {
+		return t.RoundTripper.RoundTrip(req)
+	}
+	t.lock.Lock()
+	t.attempts++
+	fail := t.attempts <= t.failures
+	t.lock.Unlock()
+	if fail {
+		return nil, &net.OpError{Op: "write", Net: "tcp", Err: errors.New("connection lost")}
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestRegistryCopierRetries(t *testing.T) {
+	trex.RunShared()
+	makeDestination := trex.Shared.NewUniqueRepoNamer("bpt-copier-retries-test")
+
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	image, err := random.Image(128, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	digest, err := image.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	src := makeDestination("src")
+	g.Expect(crane.Push(image, src+":v1", trex.Shared.CraneOptions()...)).To(Succeed())
+
+	for description, tc := range map[string]struct {
+		retries, failures int
+		expectedStatuses  []ProgressStatus
+	}{
+		"succeeds after transient failures": {
+			retries:  2,
+			failures: 2,
+			expectedStatuses: []ProgressStatus{
+				ProgressStatusCopying, ProgressStatusRetrying,
+				ProgressStatusCopying, ProgressStatusRetrying,
+				ProgressStatusCopying, ProgressStatusCopied,
+			},
+		},
+		"fails when out of retries": {
+			retries:  1,
+			failures: 3,
+			expectedStatuses: []ProgressStatus{
+				ProgressStatusCopying, ProgressStatusRetrying,
+				ProgressStatusCopying, ProgressStatusFailed,
+			},
+		},
+	} {
+		tc := tc
+		t.Run(description, func(t *testing.T) {
+			g := NewWithT(t)
+
+			transport := &failingTransport{
+				RoundTripper: trex.Shared.Transport(),
+				failures:     tc.failures,
+			}
+			client := oci.NewClient([]crane.Option{crane.WithTransport(transport)})
+
+			images := types.NewImageList("")
+			images.Append(types.Image{
+				OriginalName: src,
+				OriginalTag:  "v1",
+				Digest:       digest.String(),
+			})
+
+			events := []Progress{}
+			copier := NewRegistryCopier(client, makeDestination("dst-"+strings.ReplaceAll(description, " ", "-")))
+			copier.WithJobs(1)
+			copier.WithRetries(tc.retries)
+			copier.WithProgress(func(progress Progress) {
+				events = append(events, progress)
+			})
+
+			_, err := copier.CopyImages(ctx, images)
+
+			statuses := []ProgressStatus{}
+			for _, event := range events {
+				statuses = append(statuses, event.Status)
+				if event.Status == ProgressStatusRetrying {
+					g.Expect(event.Err).To(MatchError(ContainSubstring("connection lost")))
+				}
+			}
+			g.Expect(statuses).To(Equal(tc.expectedStatuses))
+
+			expectedAttempts := min(tc.failures+1, tc.retries+1)
+			g.Expect(transport.attempts).To(Equal(expectedAttempts))
+			g.Expect(events[len(events)-1].Attempt).To(Equal(expectedAttempts))
+
+			if tc.failures > tc.retries {
+				g.Expect(err).To(MatchError(ContainSubstring("connection lost")))
+				g.Expect(events[len(events)-1].Err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(events[len(events)-1].Err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func TestRegistryCopierReferrers(t *testing.T) {
+	trex.RunShared()
+	withReferrers := trex.New(0).WithReferrers()
+	withReferrers.RunInBackground(context.Background())
+
+	for description, registry := range map[string]*trex.Trex{
+		"tag-schema":    trex.Shared.Trex,
+		"referrers-api": withReferrers,
+	} {
+		registry := registry
+		t.Run(description, func(t *testing.T) {
+			craneOptions := registry.CraneOptions()
+			makeDestination := registry.NewUniqueRepoNamer("bpt-copier-referrers-test")
+
+			g := NewWithT(t)
+			ctx := context.Background()
+			client := oci.NewClient(craneOptions)
+
+			src := makeDestination("src")
+			subject, err := random.Image(128, 1)
+			g.Expect(err).ToNot(HaveOccurred())
+			subjectDescriptor, err := partial.Descriptor(subject)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(crane.Push(subject, src+":v1", craneOptions...)).To(Succeed())
+
+			referrerDigests := []string{}
+			for i := 0; i < 3; i++ {
+				referrer, err := random.Image(64, 1)
+				g.Expect(err).ToNot(HaveOccurred())
+				referrer = mutate.ConfigMediaType(referrer, "application/vnd.example.sig")
+				referrer = mutate.Subject(referrer, *subjectDescriptor).(v1.Image)
+				referrerDigest, err := referrer.Digest()
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(crane.Push(referrer, src+"@"+referrerDigest.String(), craneOptions...)).To(Succeed())
+				referrerDigests = append(referrerDigests, referrerDigest.String())
+			}
+
+			images := types.NewImageList("")
+			images.Append(types.Image{
+				Sources:      []types.Source{{OriginalRef: src + ":v1"}},
+				OriginalName: src,
+				OriginalTag:  "v1",
+				Digest:       subjectDescriptor.Digest.String(),
+			})
+			related, err := imageresolver.NewRegistryResolver(client).FindRelatedTags(ctx, images)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(related.Items()).To(HaveLen(len(referrerDigests)))
+
+			destination := makeDestination("dst")
+			copied, err := NewRegistryCopier(client, destination).CopyImages(ctx, images, related)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(copied).To(HaveLen(1 + len(referrerDigests)))
+			for _, digest := range referrerDigests {
+				g.Expect(copied).To(ContainElement(destination + "@" + digest))
+			}
+
+			// subject of the referrers is the same image, so the link is preserved
+			referrers, err := client.ListReferrers(ctx, destination, subjectDescriptor.Digest.String())
+			g.Expect(err).ToNot(HaveOccurred())
+			copiedReferrers := []string{}
+			for _, referrer := range referrers {
+				copiedReferrers = append(copiedReferrers, referrer.Digest.String())
+			}
+			g.Expect(copiedReferrers).To(ConsistOf(referrerDigests))
+		})
+	}
+}
+
+func TestCopierPlatforms(t *testing.T) {
+	trex.RunShared()
+	craneOptions := trex.Shared.CraneOptions()
+	makeDestination := trex.Shared.NewUniqueRepoNamer("bpt-copier-platforms-test")
+
+	g := NewWithT(t)
+	ctx := context.Background()
+	client := oci.NewClient(craneOptions)
+
+	// index with two platforms, each with an attestation manifest
+	index := v1.ImageIndex(empty.Index)
+	for _, platform := range []string{"linux/amd64", "linux/arm64"} {
+		parsedPlatform, err := v1.ParsePlatform(platform)
+		g.Expect(err).ToNot(HaveOccurred())
+		image, err := random.Image(64, 1)
+		g.Expect(err).ToNot(HaveOccurred())
+		imageDigest, err := image.Digest()
+		g.Expect(err).ToNot(HaveOccurred())
+		attestation, err := random.Image(32, 1)
+		g.Expect(err).ToNot(HaveOccurred())
+		index = mutate.AppendManifests(index,
+			mutate.IndexAddendum{
+				Add:        image,
+				Descriptor: v1.Descriptor{Platform: parsedPlatform},
+			},
+			mutate.IndexAddendum{
+				Add: attestation,
+				Descriptor: v1.Descriptor{
+					Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"},
+					Annotations: map[string]string{
+						"vnd.docker.reference.type":   "attestation-manifest",
+						"vnd.docker.reference.digest": imageDigest.String(),
+					},
+				},
+			},
+		)
+	}
+	indexDigest, err := index.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	src := makeDestination("src")
+	srcRef, err := name.ParseReference(src + ":v1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.WriteIndex(srcRef, index, crane.GetOptions(craneOptions...).Remote...)).To(Succeed())
+
+	makeImages := func() *types.ImageList {
+		images := types.NewImageList("")
+		images.Append(types.Image{
+			Sources:      []types.Source{{OriginalRef: src + ":v1"}},
+			OriginalName: src,
+			OriginalTag:  "v1",
+			Digest:       indexDigest.String(),
+		})
+		return images
+	}
+
+	// signature tag and a referrer of the index, neither refers to the filtered index
+	signature, err := random.Image(32, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	signatureDigest, err := signature.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	signatureTag := "sha256-" + indexDigest.Hex + ".sig"
+	g.Expect(crane.Push(signature, src+":"+signatureTag, craneOptions...)).To(Succeed())
+	indexDescriptor, err := partial.Descriptor(index)
+	g.Expect(err).ToNot(HaveOccurred())
+	referrer, err := random.Image(32, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	referrer = mutate.Subject(mutate.ConfigMediaType(referrer, "application/vnd.example.sig"), *indexDescriptor).(v1.Image)
+	referrerDigest, err := referrer.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(crane.Push(referrer, src+"@"+referrerDigest.String(), craneOptions...)).To(Succeed())
+
+	makeRelated := func() *types.ImageList {
+		related := types.NewImageList("")
+		related.Append(
+			types.Image{
+				Sources:      []types.Source{{OriginalRef: src + ":v1"}},
+				OriginalName: src,
+				OriginalTag:  signatureTag,
+				Digest:       signatureDigest.String(),
+			},
+			types.Image{
+				Sources:      []types.Source{{OriginalRef: src + ":v1"}},
+				OriginalName: src,
+				Digest:       referrerDigest.String(),
+				Subject:      indexDigest.String(),
+			},
+		)
+		return related
+	}
+
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+
+	resolver := imageresolver.NewRegistryResolver(client)
+	resolver.WithPlatforms(amd64)
+	manifests, _, err := resolver.FindRelatedFromIndecies(ctx, makeImages(), nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(manifests.Items()).To(HaveLen(2))
+
+	copiers := map[string]ImageCopier{
+		"registry": NewRegistryCopier(client, makeDestination("dst")),
+		"layout":   NewLayoutCopier(client, makeDestination("dst"), filepath.Join(t.TempDir(), "layout")),
+	}
+	for description, copier := range copiers {
+		copier := copier
+		t.Run(description, func(t *testing.T) {
+			g := NewWithT(t)
+
+			copier.WithPlatforms(amd64)
+
+			// planning names the images and filters the index, but doesn't write anything
+			planned := makeImages()
+			plan, err := copier.PlanCopy(ctx, planned)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(plan).To(HaveLen(1))
+			g.Expect(plan[0].Digest).To(Equal(planned.Items()[0].NewDigest))
+			g.Expect(plan[0].Digest).ToNot(Equal(indexDigest.String()))
+			_, err = client.Digest(ctx, plan[0].Destination)
+			g.Expect(err).To(HaveOccurred())
+
+			// signatures and referrers of the original index are skipped, and recorded as such
+			planned, related := makeImages(), makeRelated()
+			plan, err = copier.PlanCopy(ctx, planned, related)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(plan).To(HaveLen(1))
+			statements := manifest.MakeFilteredImageRefStatements(planned, []string{"linux/amd64"}, related)
+			g.Expect(statements).To(HaveLen(1))
+			predicate := statements[0].(*manifest.FilteredImageRef).ComparablePredicate.(manifest.FilteredImageIndex)
+			g.Expect(predicate.SkippedRelatedImages).To(ConsistOf(
+				src+":"+signatureTag+"@"+signatureDigest.String(),
+				src+"@"+referrerDigest.String(),
+			))
+
+			images := makeImages()
+			copied, err := copier.CopyImages(ctx, images)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(copied).To(HaveLen(1))
+
+			image := images.Items()[0]
+			g.Expect(image.NewDigest).ToNot(BeEmpty())
+			g.Expect(image.NewDigest).ToNot(Equal(image.Digest))
+			g.Expect(image.NewDigest).To(Equal(plan[0].Digest))
+
+			destination := image.Ref(false)
+			if layoutCopier, ok := copier.(*LayoutCopier); ok {
+				destination = oci.LayoutRef{Path: layoutCopier.LayoutPath, Tag: image.NewTag, Digest: image.NewDigest}.String()
+			} else {
+				g.Expect(copied).To(ConsistOf(destination))
+			}
+			_, indexManifest, _, err := client.GetIndexOrImage(ctx, destination)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(indexManifest.Manifests).To(HaveLen(2))
+			g.Expect(indexManifest.Manifests[0].Platform.Architecture).To(Equal("amd64"))
+			g.Expect(indexManifest.Manifests[1].Annotations).To(HaveKeyWithValue("vnd.docker.reference.digest", indexManifest.Manifests[0].Digest.String()))
+
+			// all platforms are copied as they are, along with related images
+			copier.WithPlatforms(amd64, v1.Platform{OS: "linux", Architecture: "arm64"})
+			images = makeImages()
+			_, err = copier.CopyImages(ctx, images)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(images.Items()[0].NewDigest).To(BeEmpty())
+			plan, err = copier.PlanCopy(ctx, makeImages(), makeRelated())
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(plan).To(HaveLen(3))
+
+			copier.WithPlatforms(v1.Platform{OS: "windows", Architecture: "amd64"})
+			_, err = copier.CopyImages(ctx, makeImages())
+			g.Expect(err).To(HaveOccurred())
+		})
+	}
+}
+
+func TestNamingStrategies(t *testing.T) {
+	digest := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	makeLists := func() (*types.ImageList, *types.ImageList) {
+		images := types.NewImageList("")
+		images.Append(
+			types.Image{OriginalName: "ghcr.io/a/app", OriginalTag: "v1", Digest: digest},
+			types.Image{OriginalName: "docker.io/b/app", OriginalTag: "v2", Digest: digest},
+			types.Image{OriginalName: "ghcr.io/c/db", Digest: digest},
+		)
+		related := types.NewImageList("")
+		related.Append(
+			types.Image{OriginalName: "ghcr.io/a/app", OriginalTag: "sha256-e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855.sig", Digest: digest},
+			types.Image{OriginalName: "ghcr.io/c/db", Digest: digest, Subject: digest},
+		)
+		return images, related
+	}
+	newRefs := func(lists ...*types.ImageList) []string {
+		refs := []string{}
+		for _, images := range lists {
+			for _, image := range images.Items() {
+				refs = append(refs, image.NewName+":"+image.NewTag)
+			}
+		}
+		return refs
+	}
+	hashTag := func(ref string) string {
+		return fmt.Sprintf("%s%x", types.AppImageTagPrefix, sha256.Sum256([]byte(ref)))
+	}
+	signatureTag := "sha256-e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855.sig"
+
+	expected := map[Naming][]string{
+		NamingSingleRepo: {
+			"example.com/dst:" + hashTag("ghcr.io/a/app:v1"),
+			"example.com/dst:" + hashTag("docker.io/b/app:v2"),
+			"example.com/dst:" + hashTag("ghcr.io/c/db:"),
+			"example.com/dst:" + signatureTag,
+			"example.com/dst:",
+		},
+		NamingPerImageRepo: {
+			"example.com/dst/a/app:v1",
+			"example.com/dst/b/app:v2",
+			"example.com/dst/db:" + hashTag("ghcr.io/c/db:"),
+			"example.com/dst/a/app:" + signatureTag,
+			"example.com/dst/db:",
+		},
+		NamingMirrorPath: {
+			"example.com/dst/a/app:v1",
+			"example.com/dst/b/app:v2",
+			"example.com/dst/c/db:" + hashTag("ghcr.io/c/db:"),
+			"example.com/dst/a/app:" + signatureTag,
+			"example.com/dst/c/db:",
+		},
+	}
+	for naming, expectedRefs := range expected {
+		g := NewWithT(t)
+		strategy, err := NewNamingStrategy(naming)
+		g.Expect(err).ToNot(HaveOccurred())
+		images, related := makeLists()
+		g.Expect(strategy.SetNewImageRefs("example.com/dst", images, related)).To(Succeed())
+		g.Expect(newRefs(images, related)).To(Equal(expectedRefs), string(naming))
+	}
+
+	g := NewWithT(t)
+	strategy, err := NewNamingStrategy(NamingMirrorPath)
+	g.Expect(err).ToNot(HaveOccurred())
+	images := types.NewImageList("")
+	images.Append(
+		types.Image{OriginalName: "ghcr.io/a/app", OriginalTag: "v1", Digest: digest},
+		types.Image{OriginalName: "docker.io/a/app", OriginalTag: "v1", Digest: digest},
+	)
+	g.Expect(strategy.SetNewImageRefs("example.com/dst", images)).ToNot(Succeed())
+
+	// all images in a layout are kept in the same repository
+	copier := NewLayoutCopier(nil, "example.com/dst", t.TempDir())
+	copier.WithNaming(strategy)
+	_, err = copier.CopyImages(context.Background(), images)
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = NewNamingStrategy("unknown")
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,127 @@
+package imagecopier
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/docker/labs-brown-tape/manifest/image"
+	"github.com/docker/labs-brown-tape/manifest/types"
+)
+
+type Naming string
+
+const (
+	// NamingSingleRepo copies all images to the destination repository, tags are
+	// derived from original name and tag
+	NamingSingleRepo Naming = "single-repo"
+	// NamingPerImageRepo copies each image to a repository named after the alias
+	// of the image under the destination, original tags are kept
+	NamingPerImageRepo Naming = "per-image-repo"
+	// NamingMirrorPath copies each image to a repository under the destination that
+	// has the same path as the original repository, original tags are kept
+	NamingMirrorPath Naming = "mirror-path"
+)
+
+// NamingStrategy sets NewName and NewTag of images that are copied to destinationRef,
+// images in all of the lists are named together, so that related images end up in the
+// same repository as the images they are related to
+type NamingStrategy interface {
+	SetNewImageRefs(destinationRef string, lists ...*types.ImageList) error
+}
+
+func NewNamingStrategy(naming Naming) (NamingStrategy, error) {
+	switch naming {
+	case NamingSingleRepo, "":
+		return &singleRepoNaming{hash: sha256.New()}, nil
+	case NamingPerImageRepo:
+		return &repoPerImageNaming{repoName: aliasRepoNames}, nil
+	case NamingMirrorPath:
+		return &repoPerImageNaming{repoName: mirrorPathRepoNames}, nil
+	default:
+		return nil, fmt.Errorf("unknown naming strategy %q", naming)
+	}
+}
+
+type singleRepoNaming struct {
+	hash hash.Hash
+}
+
+func (n *singleRepoNaming) SetNewImageRefs(destinationRef string, lists ...*types.ImageList) error {
+	for _, images := range lists {
+		SetNewImageRefs(destinationRef, n.hash, images.Items())
+	}
+	return nil
+}
+
+// repoPerImageNaming names repositories using repoName, which maps original names to
+// repository paths relative to the destination
+type repoPerImageNaming struct {
+	repoName func(originalNames []string) (map[string]string, error)
+}
+
+func (n *repoPerImageNaming) SetNewImageRefs(destinationRef string, lists ...*types.ImageList) error {
+	originalNames := []string{}
+	seen := map[string]struct{}{}
+	for _, images := range lists {
+		for _, image := range images.Items() {
+			if _, ok := seen[image.OriginalName]; !ok {
+				seen[image.OriginalName] = struct{}{}
+				originalNames = append(originalNames, image.OriginalName)
+			}
+		}
+	}
+
+	repoNames, err := n.repoName(originalNames)
+	if err != nil {
+		return err
+	}
+	usedBy := map[string]string{}
+	for _, originalName := range originalNames {
+		repoName := repoNames[originalName]
+		if other, ok := usedBy[repoName]; ok {
+			return fmt.Errorf("images %q and %q would be copied to the same repository %q", other, originalName, destinationRef+"/"+repoName)
+		}
+		usedBy[repoName] = originalName
+	}
+
+	hash := sha256.New()
+	for _, images := range lists {
+		for i := range images.Items() {
+			image := &images.Items()[i]
+			newName := destinationRef + "/" + repoNames[image.OriginalName]
+			if image.OriginalTag == "" || image.Subject != "" {
+				// images that only have a digest and referrers are tagged as with single-repo naming
+				doSetNewImageRef(newName, hash, image)
+				continue
+			}
+			image.NewName, image.NewTag = newName, image.OriginalTag
+		}
+	}
+	return nil
+}
+
+// aliasRepoNames uses the shortest unique suffix of each name
+func aliasRepoNames(originalNames []string) (map[string]string, error) {
+	aliases := image.NewAliasCache(originalNames).MakeAliasesForNames()
+	repoNames := make(map[string]string, len(originalNames))
+	for i, originalName := range originalNames {
+		repoNames[originalName] = aliases[i]
+	}
+	return repoNames, nil
+}
+
+// mirrorPathRepoNames uses repository path without the registry host
+func mirrorPathRepoNames(originalNames []string) (map[string]string, error) {
+	repoNames := make(map[string]string, len(originalNames))
+	for _, originalName := range originalNames {
+		repo, err := name.NewRepository(originalName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image name %q: %w", originalName, err)
+		}
+		repoNames[originalName] = repo.RepositoryStr()
+	}
+	return repoNames, nil
+}
@@ -3,6 +3,7 @@ package imageresolver
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	kimage "sigs.k8s.io/kustomize/api/image"
 
@@ -16,12 +17,16 @@ type (
 		ResolveDigests(context.Context, *types.ImageList) error
 		FindRelatedTags(context.Context, *types.ImageList) (*types.ImageList, error)
 		FindRelatedFromIndecies(context.Context, *types.ImageList, InspectIndexManifest) (*types.ImageList, *types.ImageList, error)
+		WithKnownDigests(KnownDigests)
+		WithPlatforms(...oci.Platform)
 	}
 )
 
-// TODO: add known digests to RegistryResolver, so that user can specify digests of newly built images
 type RegistryResolver struct {
 	*oci.Client
+
+	knownDigests KnownDigests
+	platforms    []oci.Platform
 }
 
 func NewRegistryResolver(client *oci.Client) Resolver {
@@ -33,6 +38,17 @@ func NewRegistryResolver(client *oci.Client) Resolver {
 	}
 }
 
+// WithKnownDigests sets digests that are used instead of looking these up in the registry,
+// so that images that have just been built can be referenced by tag
+func (r *RegistryResolver) WithKnownDigests(knownDigests KnownDigests) {
+	r.knownDigests = knownDigests
+}
+
+// WithPlatforms makes FindRelatedFromIndecies skip manifests for any other platforms
+func (r *RegistryResolver) WithPlatforms(platforms ...oci.Platform) {
+	r.platforms = platforms
+}
+
 func (r *RegistryResolver) ResolveDigests(ctx context.Context, images *types.ImageList) error {
 	for i := range images.Items() {
 		if err := r.doResolveDigest(ctx, &images.Items()[i]); err != nil {
@@ -43,6 +59,15 @@ func (r *RegistryResolver) ResolveDigests(ctx context.Context, images *types.Ima
 }
 
 func (r *RegistryResolver) doResolveDigest(ctx context.Context, i *types.Image) error {
+	if digest, ok := r.lookupKnownDigest(i); ok {
+		if i.Digest != "" && i.Digest != digest {
+			return fmt.Errorf("unexpected digest mismatch: %s (from manifest) != %s (known digest)", i.Digest, digest)
+		}
+		i.Digest = digest
+		i.DigestSource = types.DigestSourceUser
+		return nil
+	}
+
 	digest, err := r.Digest(ctx, i.Ref(true))
 	if err != nil {
 		return err
@@ -55,9 +80,21 @@ func (r *RegistryResolver) doResolveDigest(ctx context.Context, i *types.Image)
 	return nil
 }
 
+func (r *RegistryResolver) lookupKnownDigest(i *types.Image) (string, bool) {
+	if len(r.knownDigests) == 0 || (i.OriginalTag == "" && i.Digest != "") {
+		return "", false
+	}
+	// NB: digest is omitted, and when there is no tag it's the same as `latest`
+	ref := i.OriginalName
+	if i.OriginalTag != "" {
+		ref += ":" + i.OriginalTag
+	}
+	return r.knownDigests.Lookup(ref)
+}
+
 func (c *RegistryResolver) FindRelatedTags(ctx context.Context, images *types.ImageList) (*types.ImageList, error) {
-	// TODO: reduce redudant calls to registry, e.g. when multiple images have the same name;
-	// `images.Dedup()` doens't address this as it requires registry call, so proper registry cache is needed
+	// NB: when multiple images have the same name, tags are listed more than once,
+	// redundant registry calls are avoided when the client is using a cache
 	result := types.NewImageList(images.Dir())
 	for i := range images.Items() {
 		image := images.Items()[i]
@@ -74,6 +111,10 @@ func (c *RegistryResolver) FindRelatedTags(ctx context.Context, images *types.Im
 				return nil, fmt.Errorf("related image %s has no digest", relatedImage.URL)
 			}
 			name, tag, _ := kimage.Split(relatedImage.URL)
+			if tag == referrersTag(image.Digest) {
+				// index under referrers tag schema is read by ListReferrers
+				continue
+			}
 			err := result.AppendWithRelationTo(image, types.Image{
 				Sources: []types.Source{{
 					OriginalRef: relatedImage.URL,
@@ -85,7 +126,26 @@ func (c *RegistryResolver) FindRelatedTags(ctx context.Context, images *types.Im
 			if err != nil {
 				return nil, err
 			}
+		}
 
+		referrers, err := c.ListReferrers(ctx, image.OriginalName, image.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list referrers of %s: %w", image.Ref(true), err)
+		}
+		for _, referrer := range referrers {
+			ref := image.OriginalName + "@" + referrer.Digest.String()
+			err := result.AppendWithRelationTo(image, types.Image{
+				Sources: []types.Source{{
+					OriginalRef: ref,
+				}},
+				OriginalName: image.OriginalName,
+				Digest:       referrer.Digest.String(),
+				Subject:      image.Digest,
+				ArtifactType: referrer.ArtifactType,
+			})
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 	if err := result.Dedup(); err != nil {
@@ -94,6 +154,10 @@ func (c *RegistryResolver) FindRelatedTags(ctx context.Context, images *types.Im
 	return result, nil
 }
 
+// referrersTag returns the tag that registries without referrers API
+// use for the index of referrers
+func referrersTag(digest string) string { return strings.Replace(digest, ":", "-", 1) }
+
 func (c *RegistryResolver) FindRelatedFromIndecies(ctx context.Context, images *types.ImageList, inspect InspectIndexManifest) (*types.ImageList, *types.ImageList, error) {
 	manifests := types.NewImageList(images.Dir())
 	for i := range images.Items() {
@@ -110,6 +174,17 @@ func (c *RegistryResolver) FindRelatedFromIndecies(ctx context.Context, images *
 		if indexManifest == nil {
 			return nil, nil, fmt.Errorf("unexpected: FindRelatedFromIndecies is called on image %q which doesn't have index manifest", image.Ref(true))
 		}
+		if len(c.platforms) != 0 {
+			filteredIndex, err := c.FilterIndex(ctx, image.Ref(true), c.platforms...)
+			if err != nil {
+				return nil, nil, err
+			}
+			if filteredIndex != nil {
+				if indexManifest, err = filteredIndex.IndexManifest(); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
 		for i := range indexManifest.Manifests {
 			manifest := indexManifest.Manifests[i]
 			err := manifests.AppendWithRelationTo(image, types.Image{
@@ -2,8 +2,14 @@ package imageresolver_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 
+	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 	. "github.com/onsi/gomega"
 
 	. "github.com/docker/labs-brown-tape/manifest/imageresolver"
@@ -11,6 +17,8 @@ import (
 	"github.com/docker/labs-brown-tape/manifest/loader"
 	"github.com/docker/labs-brown-tape/manifest/testdata"
 	"github.com/docker/labs-brown-tape/manifest/types"
+	"github.com/docker/labs-brown-tape/oci"
+	"github.com/docker/labs-brown-tape/trex"
 )
 
 func TestImageResover(t *testing.T) {
@@ -75,3 +83,63 @@ func makeImageResolverTest(tc testdata.TestCase) func(t *testing.T) {
 		}
 	}
 }
+
+func TestFindRelatedReferrers(t *testing.T) {
+	trex.RunShared()
+	withReferrers := trex.New(0).WithReferrers()
+	withReferrers.RunInBackground(context.Background())
+
+	for description, registry := range map[string]*trex.Trex{
+		"tag-schema":    trex.Shared.Trex,
+		"referrers-api": withReferrers,
+	} {
+		registry := registry
+		t.Run(description, func(t *testing.T) {
+			craneOptions := registry.CraneOptions()
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			src := registry.NewUniqueRepoNamer("bpt-resolver-referrers-test")("app")
+
+			subject, err := random.Image(128, 1)
+			g.Expect(err).ToNot(HaveOccurred())
+			subjectDescriptor, err := partial.Descriptor(subject)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(crane.Push(subject, src+":v1", craneOptions...)).To(Succeed())
+
+			signature, err := random.Image(64, 1)
+			g.Expect(err).ToNot(HaveOccurred())
+			signatureTag := strings.Replace(subjectDescriptor.Digest.String(), ":", "-", 1) + ".sig"
+			g.Expect(crane.Push(signature, src+":"+signatureTag, craneOptions...)).To(Succeed())
+
+			referrer, err := random.Image(64, 1)
+			g.Expect(err).ToNot(HaveOccurred())
+			referrer = mutate.ConfigMediaType(referrer, "application/vnd.example.sbom")
+			referrer = mutate.Subject(referrer, *subjectDescriptor).(v1.Image)
+			referrerDigest, err := referrer.Digest()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(crane.Push(referrer, src+"@"+referrerDigest.String(), craneOptions...)).To(Succeed())
+
+			images := types.NewImageList("")
+			images.Append(types.Image{
+				Sources:      []types.Source{{OriginalRef: src + ":v1"}},
+				OriginalName: src,
+				OriginalTag:  "v1",
+				Digest:       subjectDescriptor.Digest.String(),
+			})
+
+			related, err := NewRegistryResolver(oci.NewClient(craneOptions)).FindRelatedTags(ctx, images)
+			g.Expect(err).ToNot(HaveOccurred())
+			// index under referrers tag schema is not listed as a related tag
+			g.Expect(related.Items()).To(HaveLen(2))
+			g.Expect(related.RelatedTo(images.Items()[0].Ref(true))).To(HaveLen(2))
+
+			relatedReferrer := related.GetItemByDigest(referrerDigest.String())
+			g.Expect(relatedReferrer).ToNot(BeNil())
+			g.Expect(relatedReferrer.OriginalTag).To(BeEmpty())
+			g.Expect(relatedReferrer.Subject).To(Equal(subjectDescriptor.Digest.String()))
+			g.Expect(relatedReferrer.ArtifactType).To(Equal("application/vnd.example.sbom"))
+			g.Expect(relatedReferrer.Ref(true)).To(Equal(src + "@" + referrerDigest.String()))
+		})
+	}
+}
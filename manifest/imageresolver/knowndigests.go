@@ -0,0 +1,124 @@
+package imageresolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	buildxMetadataDigestKey = "containerimage.digest"
+	buildxMetadataNameKey   = "image.name"
+)
+
+// KnownDigests maps image references to digests given by the user, these are used
+// instead of looking up the digest in the registry; references are normalised,
+// so that e.g. `nginx:1.25` and `docker.io/library/nginx:1.25` are the same
+type KnownDigests map[string]string
+
+func normaliseRef(ref string) (string, error) {
+	parsedRef, err := name.NewTag(ref, name.WeakValidation)
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %q: %w", ref, err)
+	}
+	return parsedRef.Name(), nil
+}
+
+// Add adds a digest for the given reference, which must be a tag;
+// it's an error to add a different digest for the same reference
+func (k KnownDigests) Add(ref, digest string) error {
+	key, err := normaliseRef(ref)
+	if err != nil {
+		return err
+	}
+	if _, err := v1.NewHash(digest); err != nil {
+		return fmt.Errorf("invalid digest %q for %q: %w", digest, ref, err)
+	}
+	if existing, ok := k[key]; ok && existing != digest {
+		return fmt.Errorf("conflicting digests for %q: %s != %s", ref, existing, digest)
+	}
+	k[key] = digest
+	return nil
+}
+
+// Lookup returns digest for the given reference, if there is one
+func (k KnownDigests) Lookup(ref string) (string, bool) {
+	key, err := normaliseRef(ref)
+	if err != nil {
+		return "", false
+	}
+	digest, ok := k[key]
+	return digest, ok
+}
+
+// ParseKnownDigest parses a known digest in the form of `<name>:<tag>=<digest>`
+func (k KnownDigests) ParseKnownDigest(knownDigest string) error {
+	ref, digest, ok := strings.Cut(knownDigest, "=")
+	if !ok || ref == "" || digest == "" {
+		return fmt.Errorf("invalid known digest %q: must be in the form of '<name>:<tag>=<digest>'", knownDigest)
+	}
+	return k.Add(ref, digest)
+}
+
+// LoadKnownDigests reads known digests from a metadata file written by `docker buildx build --metadata-file`
+// or `docker buildx bake --metadata-file`, the latter contains metadata of each of the targets
+func (k KnownDigests) LoadKnownDigests(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read known digests from %q: %w", path, err)
+	}
+
+	metadata := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return fmt.Errorf("unable to parse known digests in %q: %w", path, err)
+	}
+
+	if _, ok := metadata[buildxMetadataDigestKey]; ok {
+		if err := k.addFromBuildMetadata(metadata); err != nil {
+			return fmt.Errorf("invalid build metadata in %q: %w", path, err)
+		}
+		return nil
+	}
+
+	for target, targetData := range metadata {
+		targetMetadata := map[string]json.RawMessage{}
+		if err := json.Unmarshal(targetData, &targetMetadata); err != nil {
+			// bake metadata may include other entries, e.g. `buildx.build.warnings`
+			continue
+		}
+		if _, ok := targetMetadata[buildxMetadataDigestKey]; !ok {
+			continue
+		}
+		if err := k.addFromBuildMetadata(targetMetadata); err != nil {
+			return fmt.Errorf("invalid build metadata for target %q in %q: %w", target, path, err)
+		}
+	}
+	return nil
+}
+
+func (k KnownDigests) addFromBuildMetadata(metadata map[string]json.RawMessage) error {
+	var digest, names string
+	if err := json.Unmarshal(metadata[buildxMetadataDigestKey], &digest); err != nil {
+		return fmt.Errorf("unable to parse %q: %w", buildxMetadataDigestKey, err)
+	}
+	if data, ok := metadata[buildxMetadataNameKey]; ok {
+		if err := json.Unmarshal(data, &names); err != nil {
+			return fmt.Errorf("unable to parse %q: %w", buildxMetadataNameKey, err)
+		}
+	}
+	if names == "" {
+		// image was built without a name, so it cannot be referenced by manifests
+		return nil
+	}
+	// multiple names are separated by commas, same as with `--tag` flags
+	for _, ref := range strings.Split(names, ",") {
+		if err := k.Add(strings.TrimSpace(ref), digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package imageresolver_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/docker/labs-brown-tape/attest/manifest"
+	. "github.com/docker/labs-brown-tape/manifest/imageresolver"
+	"github.com/docker/labs-brown-tape/manifest/imagescanner"
+	"github.com/docker/labs-brown-tape/manifest/loader"
+	"github.com/docker/labs-brown-tape/manifest/testdata"
+	"github.com/docker/labs-brown-tape/manifest/types"
+)
+
+const (
+	nginxDigest = "sha256:6db391d1c0cfb30588ba0bf72ea999404f2764febf0f1f196acd5867ac7efa7e"
+	redisDigest = "sha256:1c4ed1d4a0b0cf5ee0d8d4b0ea0b4c79f1e5d8f9e0c9bbd8f0f4f3c0ea1d9c3a"
+)
+
+func TestKnownDigests(t *testing.T) {
+	g := NewWithT(t)
+
+	knownDigests := KnownDigests{}
+	g.Expect(knownDigests.ParseKnownDigest("nginx:1.25=" + nginxDigest)).To(Succeed())
+	g.Expect(knownDigests.ParseKnownDigest("docker.io/library/nginx:1.25=" + nginxDigest)).To(Succeed())
+	g.Expect(knownDigests.ParseKnownDigest("docker.io/library/nginx:1.25=" + redisDigest)).ToNot(Succeed())
+	g.Expect(knownDigests.ParseKnownDigest("nginx:1.25")).ToNot(Succeed())
+	g.Expect(knownDigests.ParseKnownDigest("nginx:1.25=sha256:foo")).ToNot(Succeed())
+	g.Expect(knownDigests.ParseKnownDigest("nginx@" + nginxDigest + "=" + nginxDigest)).ToNot(Succeed())
+
+	for _, ref := range []string{"nginx:1.25", "library/nginx:1.25", "index.docker.io/library/nginx:1.25"} {
+		digest, ok := knownDigests.Lookup(ref)
+		g.Expect(ok).To(BeTrue(), ref)
+		g.Expect(digest).To(Equal(nginxDigest))
+	}
+	_, ok := knownDigests.Lookup("nginx")
+	g.Expect(ok).To(BeFalse())
+
+	dir := t.TempDir()
+	writeJSON := func(name string, obj any) string {
+		path := filepath.Join(dir, name)
+		data, err := json.Marshal(obj)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(os.WriteFile(path, data, 0o644)).To(Succeed())
+		return path
+	}
+
+	buildMetadata := writeJSON("build.json", map[string]any{
+		"buildx.build.ref":      "default/default/abc",
+		"containerimage.digest": nginxDigest,
+		"image.name":            "example.com/app:v1,example.com/app:latest",
+	})
+	bakeMetadata := writeJSON("bake.json", map[string]any{
+		"app": map[string]any{
+			"containerimage.digest": nginxDigest,
+			"image.name":            "example.com/app:v2",
+		},
+		"cache": map[string]any{
+			"containerimage.digest": redisDigest,
+			"image.name":            "example.com/cache:v2",
+		},
+		"unnamed": map[string]any{
+			"containerimage.digest": redisDigest,
+		},
+		"buildx.build.warnings": []any{},
+	})
+
+	knownDigests = KnownDigests{}
+	g.Expect(knownDigests.LoadKnownDigests(buildMetadata)).To(Succeed())
+	g.Expect(knownDigests.LoadKnownDigests(bakeMetadata)).To(Succeed())
+	g.Expect(knownDigests).To(Equal(KnownDigests{
+		"example.com/app:v1":     nginxDigest,
+		"example.com/app:latest": nginxDigest,
+		"example.com/app:v2":     nginxDigest,
+		"example.com/cache:v2":   redisDigest,
+	}))
+
+	g.Expect(knownDigests.LoadKnownDigests(filepath.Join(dir, "missing.json"))).ToNot(Succeed())
+	g.Expect(knownDigests.LoadKnownDigests(writeJSON("invalid.json", map[string]any{
+		"containerimage.digest": "foo",
+		"image.name":            "example.com/app:v3",
+	}))).ToNot(Succeed())
+}
+
+func TestResolverWithKnownDigests(t *testing.T) {
+	tc := testdata.BasicJSONCases()[0]
+	g := NewWithT(t)
+
+	loader := loader.NewRecursiveManifestDirectoryLoader(filepath.Join("../..", tc.Directory))
+	g.Expect(loader.Load()).To(Succeed())
+
+	scanner := imagescanner.NewDefaultImageScanner()
+	g.Expect(scanner.Scan(loader.RelPaths())).To(Succeed())
+
+	images := scanner.GetImages()
+	g.Expect(images.Items()).To(HaveLen(len(tc.Expected)))
+
+	knownDigests := KnownDigests{}
+	g.Expect(knownDigests.ParseKnownDigest("nginx:latest=" + nginxDigest)).To(Succeed())
+	g.Expect(knownDigests.ParseKnownDigest("nginx:1.16.1=" + nginxDigest)).To(Succeed())
+	g.Expect(knownDigests.ParseKnownDigest("docker.io/library/redis:latest=" + redisDigest)).To(Succeed())
+
+	// no registry calls are made, as all of the digests are known
+	resolver := NewRegistryResolver(nil)
+	resolver.WithKnownDigests(knownDigests)
+	g.Expect(resolver.ResolveDigests(context.Background(), images)).To(Succeed())
+
+	for _, image := range images.Items() {
+		g.Expect(image.DigestSource).To(Equal(types.DigestSourceUser))
+		switch image.OriginalName {
+		case "nginx":
+			g.Expect(image.Digest).To(Equal(nginxDigest))
+		case "redis":
+			g.Expect(image.Digest).To(Equal(redisDigest))
+		}
+	}
+
+	g.Expect(images.Dedup()).To(Succeed())
+	statements := manifest.MakeResovedImageRefStatements(images)
+	g.Expect(statements).To(HaveLen(len(tc.Expected)))
+	for _, statement := range statements {
+		predicate, err := json.Marshal(statement.GetPredicate())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(predicate).To(ContainSubstring(`"digestSource":"user"`))
+	}
+
+	// digest given in the manifest must match
+	images = types.NewImageList(images.Dir())
+	images.Append(types.Image{
+		OriginalName: "nginx",
+		OriginalTag:  "1.16.1",
+		Digest:       redisDigest,
+	})
+	g.Expect(resolver.ResolveDigests(context.Background(), images)).ToNot(Succeed())
+}
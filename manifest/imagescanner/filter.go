@@ -6,12 +6,17 @@ import (
 
 	"sigs.k8s.io/kustomize/api/filters/filtersutil"
 	"sigs.k8s.io/kustomize/api/filters/fsslice"
+	kustomize "sigs.k8s.io/kustomize/api/types"
 
 	"github.com/docker/labs-brown-tape/attest/digest"
 	"github.com/docker/labs-brown-tape/manifest/types"
 )
 
 type Filter struct {
+	// FsSlice contains the FieldSpecs to locate image fields,
+	// default image paths are used when it's not set
+	FsSlice kustomize.FsSlice
+
 	trackableSetter filtersutil.TrackableSetter
 }
 
@@ -28,19 +33,24 @@ func (f Filter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
 }
 
 func (f Filter) filter(node *yaml.RNode) (*yaml.RNode, error) {
+	return node, types.ForEachResource(node, f.filterResource)
+}
+
+func (f Filter) filterResource(node *yaml.RNode) error {
 	// FsSlice is an allowlist, not a denyList, so to deny
 	// something via configuration a new config mechanism is
 	// needed. Until then, hardcode it.
 	if f.isOnDenyList(node) {
-		return node, nil
+		return nil
 	}
-	if err := node.PipeE(fsslice.Filter{
-		FsSlice:  types.ImagePaths(),
-		SetValue: f.SetValue,
-	}); err != nil {
-		return nil, err
+	fsSlice := f.FsSlice
+	if fsSlice == nil {
+		fsSlice = types.ImagePaths()
 	}
-	return node, nil
+	return node.PipeE(fsslice.Filter{
+		FsSlice:  fsSlice,
+		SetValue: f.SetValue,
+	})
 }
 
 func (f Filter) isOnDenyList(node *yaml.RNode) bool {
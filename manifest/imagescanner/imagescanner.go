@@ -9,6 +9,7 @@ import (
 	"crypto/sha256"
 
 	kimage "sigs.k8s.io/kustomize/api/image"
+	kustomize "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/kio"
 
 	"github.com/docker/labs-brown-tape/attest"
@@ -19,8 +20,10 @@ import (
 type ImageScanner interface {
 	Scan(string, []string) error
 	GetImages() *types.ImageList
+	GetManifestDigests() map[string]digest.SHA256
 	Reset()
 	WithProvinanceAttestor(*attest.PathCheckerRegistry)
+	WithFieldSpecs(...kustomize.FieldSpec)
 }
 
 type DefaultImageScanner struct {
@@ -28,6 +31,7 @@ type DefaultImageScanner struct {
 	trackers  []*Tracker
 	hash      hash.Hash
 	attestor  *attest.PathCheckerRegistry
+	fsSlice   kustomize.FsSlice
 }
 
 func NewDefaultImageScanner() ImageScanner {
@@ -47,7 +51,7 @@ func (s *DefaultImageScanner) Scan(dir string, manifests []string) error {
 
 		s.hash.Reset()
 
-		filter := &Filter{}
+		filter := &Filter{FsSlice: s.fsSlice}
 		tracker := &Tracker{
 			Manifest: manifests[m],
 		}
@@ -86,6 +90,12 @@ func (s *DefaultImageScanner) WithProvinanceAttestor(pcr *attest.PathCheckerRegi
 	s.attestor = pcr
 }
 
+// WithFieldSpecs adds paths to look for images in, on top of the default ones,
+// these are retained on reset
+func (s *DefaultImageScanner) WithFieldSpecs(fieldSpecs ...kustomize.FieldSpec) {
+	s.fsSlice = types.ImagePathsWith(fieldSpecs...)
+}
+
 func (s *DefaultImageScanner) GetImages() *types.ImageList {
 	images := types.NewImageList(s.directory)
 	for _, v := range s.trackers {
@@ -111,6 +121,16 @@ func (s *DefaultImageScanner) GetImages() *types.ImageList {
 	return images
 }
 
+// GetManifestDigests returns digests of all scanned manifests, including
+// the ones that don't reference any images
+func (s *DefaultImageScanner) GetManifestDigests() map[string]digest.SHA256 {
+	digests := make(map[string]digest.SHA256, len(s.trackers))
+	for _, v := range s.trackers {
+		digests[v.Manifest] = v.ManifestDigest
+	}
+	return digests
+}
+
 func (s *DefaultImageScanner) Reset() {
 	s.trackers = []*Tracker{}
 	s.attestor = nil
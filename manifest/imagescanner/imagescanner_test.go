@@ -1,6 +1,7 @@
 package imagescanner_test
 
 import (
+	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/gomega"
@@ -8,6 +9,7 @@ import (
 	. "github.com/docker/labs-brown-tape/manifest/imagescanner"
 	"github.com/docker/labs-brown-tape/manifest/loader"
 	"github.com/docker/labs-brown-tape/manifest/testdata"
+	"github.com/docker/labs-brown-tape/manifest/types"
 )
 
 func TestImageScanner(t *testing.T) {
@@ -16,6 +18,8 @@ func TestImageScanner(t *testing.T) {
 
 	cases = append(cases, testdata.BasicJSONCases()...)
 	cases = append(cases, testdata.BaseYAMLCases()...)
+	cases = append(cases, testdata.KustomizeCases()...)
+	cases = append(cases, testdata.NestedListCases()...)
 
 	cases.Run(t, ("../../"), makeImageScannerTest)
 }
@@ -36,9 +40,12 @@ func makeImageScannerTest(tc testdata.TestCase) func(t *testing.T) {
 		g.Expect(scanner.Scan(loader.RelPaths())).To(Succeed())
 
 		images := scanner.GetImages()
+		manifestDigests := scanner.GetManifestDigests()
+		g.Expect(manifestDigests).To(HaveLen(expectedNumPaths))
 
 		for _, image := range images.Items() {
 			g.Expect(image.Sources).To(HaveLen(1))
+			g.Expect(manifestDigests).To(HaveKeyWithValue(image.Manifest(), image.ManifestDigest()))
 		}
 
 		if tc.Expected != nil {
@@ -48,3 +55,34 @@ func makeImageScannerTest(tc testdata.TestCase) func(t *testing.T) {
 		}
 	}
 }
+
+func TestImageScannerWithFieldSpecs(t *testing.T) {
+	fieldSpecs, err := types.LoadImageFieldSpecs(filepath.Join("../../", testdata.CustomResourceFieldSpecs))
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	cases := testdata.CustomResourceCases()
+	cases.Run(t, ("../../"), func(tc testdata.TestCase) func(t *testing.T) {
+		return func(t *testing.T) {
+			g := NewWithT(t)
+
+			loader := loader.NewRecursiveManifestDirectoryLoader(tc.Directory)
+			g.Expect(loader.Load()).To(Succeed())
+
+			scanner := NewDefaultImageScanner()
+			g.Expect(scanner.Scan(loader.RelPaths())).To(Succeed())
+			// only the rollout uses a default path
+			g.Expect(scanner.GetImages().Items()).To(HaveLen(1))
+			g.Expect(scanner.GetImages().Items()[0].Manifest()).To(Equal("rollout.yaml"))
+
+			scanner.Reset()
+			scanner.WithFieldSpecs(fieldSpecs...)
+			g.Expect(scanner.Scan(loader.RelPaths())).To(Succeed())
+			g.Expect(scanner.GetImages().Items()).To(ConsistOf(tc.Expected))
+
+			// field specs should be retained on reset
+			scanner.Reset()
+			g.Expect(scanner.Scan(loader.RelPaths())).To(Succeed())
+			g.Expect(scanner.GetImages().Items()).To(ConsistOf(tc.Expected))
+		}
+	})
+}
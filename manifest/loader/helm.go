@@ -0,0 +1,262 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	helmloader "helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+
+	"github.com/docker/labs-brown-tape/attest/digest"
+)
+
+const (
+	DefaultHelmReleaseName = "release-name"
+	DefaultHelmNamespace   = "default"
+)
+
+// HelmChart describes the chart that was rendered, all paths are relative to BaseDir
+type HelmChart struct {
+	Name       string
+	Version    string
+	AppVersion string
+
+	ReleaseName string
+	Namespace   string
+
+	// BaseDir is the chart directory, or the directory containing the chart archive
+	BaseDir string
+	// Archive is set when the chart was loaded from an archive
+	Archive     string
+	ValuesFiles []string
+	// ValuesDigest is computed from values given by the user after these have been merged,
+	// default values of the chart are not included
+	ValuesDigest digest.SHA256
+
+	// Outputs are the rendered templates written to the temp dir
+	Outputs map[string]digest.SHA256
+}
+
+// Sources returns local paths that the outputs were rendered from, besides BaseDir itself
+func (c *HelmChart) Sources() []string {
+	sources := []string{}
+	if c.Archive != "" {
+		sources = append(sources, c.Archive)
+	}
+	return append(sources, c.ValuesFiles...)
+}
+
+type HelmChartLoader struct {
+	chartPath   string
+	valuesFiles []string
+	releaseName string
+	namespace   string
+	loadedFiles
+
+	helmChart *HelmChart
+}
+
+func NewHelmChartLoader(chartPath string, valuesFiles ...string) *HelmChartLoader {
+	return &HelmChartLoader{
+		chartPath:   chartPath,
+		valuesFiles: valuesFiles,
+		releaseName: DefaultHelmReleaseName,
+		namespace:   DefaultHelmNamespace,
+	}
+}
+
+func (l *HelmChartLoader) WithRelease(name, namespace string) *HelmChartLoader {
+	if name != "" {
+		l.releaseName = name
+	}
+	if namespace != "" {
+		l.namespace = namespace
+	}
+	return l
+}
+
+func (l *HelmChartLoader) Load() error {
+	tempDir, err := mkdirTemp()
+	if err != nil {
+		return err
+	}
+	l.tempDir = tempDir
+
+	chart, err := helmloader.Load(l.chartPath)
+	if err != nil {
+		return fmt.Errorf("unable to load chart %q: %w", l.chartPath, err)
+	}
+
+	values := map[string]interface{}{}
+	for _, valuesFile := range l.valuesFiles {
+		fileValues, err := chartutil.ReadValuesFile(valuesFile)
+		if err != nil {
+			return fmt.Errorf("unable to read values file %q: %w", valuesFile, err)
+		}
+		values = mergeValues(values, fileValues)
+	}
+	// NB: map keys are sorted by encoding/json, so the digest is stable
+	valuesJSON, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("unable to encode values: %w", err)
+	}
+
+	// disabled dependencies are removed from the chart and values of the enabled ones
+	// are imported, the same way as helm does it on install
+	if err := chartutil.ProcessDependencies(chart, values); err != nil {
+		return fmt.Errorf("unable to process dependencies of chart %q: %w", l.chartPath, err)
+	}
+
+	renderValues, err := chartutil.ToRenderValues(chart, values, chartutil.ReleaseOptions{
+		Name:      l.releaseName,
+		Namespace: l.namespace,
+		Revision:  1,
+		IsInstall: true,
+	}, chartutil.DefaultCapabilities)
+	if err != nil {
+		return fmt.Errorf("unable to prepare values for chart %q: %w", l.chartPath, err)
+	}
+
+	rendered, err := engine.Render(chart, renderValues)
+	if err != nil {
+		return fmt.Errorf("unable to render chart %q: %w", l.chartPath, err)
+	}
+	// CRDs are not templated, but are installed along with the chart
+	for _, crd := range chart.CRDObjects() {
+		rendered[crd.Filename] = string(crd.File.Data)
+	}
+
+	helmChart, err := l.makeHelmChart(chart)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, _ = hash.Write(valuesJSON)
+	helmChart.ValuesDigest = digest.MakeSHA256(hash)
+
+	modTime, err := mostRecentModTime(append([]string{l.chartPath}, l.valuesFiles...)...)
+	if err != nil {
+		return err
+	}
+
+	l.relPaths = make(map[string]string, len(rendered))
+	for name, data := range rendered {
+		// same as helm, notes are not part of the manifests and partials or templates
+		// that render to nothing are skipped
+		relPath := strings.TrimPrefix(name, chart.Name()+"/")
+		if strings.HasSuffix(relPath, "NOTES.txt") || ignoreFile(relPath) || strings.TrimSpace(data) == "" {
+			continue
+		}
+		path := filepath.Join(l.tempDir, relPath)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			return err
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			return err
+		}
+		l.relPaths[relPath] = name
+		hash.Reset()
+		_, _ = hash.Write([]byte(data))
+		helmChart.Outputs[relPath] = digest.MakeSHA256(hash)
+	}
+	if len(l.relPaths) == 0 {
+		return fmt.Errorf("no manifests were rendered from chart %q", l.chartPath)
+	}
+
+	files, err := getFiles(l.tempDir)
+	if err != nil {
+		return err
+	}
+	l.files = files
+	l.helmChart = helmChart
+	return nil
+}
+
+func (l *HelmChartLoader) makeHelmChart(chart *chart.Chart) (*HelmChart, error) {
+	helmChart := &HelmChart{
+		Name:        chart.Metadata.Name,
+		Version:     chart.Metadata.Version,
+		AppVersion:  chart.Metadata.AppVersion,
+		ReleaseName: l.releaseName,
+		Namespace:   l.namespace,
+		BaseDir:     l.chartPath,
+		ValuesFiles: make([]string, 0, len(l.valuesFiles)),
+		Outputs:     map[string]digest.SHA256{},
+	}
+
+	fi, err := os.Stat(l.chartPath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		helmChart.BaseDir = filepath.Dir(l.chartPath)
+		helmChart.Archive = filepath.Base(l.chartPath)
+	}
+
+	for _, valuesFile := range l.valuesFiles {
+		relPath, err := filepath.Rel(helmChart.BaseDir, valuesFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine path of values file %q relative to %q: %w", valuesFile, helmChart.BaseDir, err)
+		}
+		helmChart.ValuesFiles = append(helmChart.ValuesFiles, relPath)
+	}
+	return helmChart, nil
+}
+
+func (l *HelmChartLoader) HelmChart() *HelmChart { return l.helmChart }
+
+// mergeValues is the same as what helm does for `--values` flags, i.e. nested
+// maps are merged and any other values are overridden
+func mergeValues(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]interface{}); ok {
+			if bv, ok := out[k]; ok {
+				if bv, ok := bv.(map[string]interface{}); ok {
+					out[k] = mergeValues(bv, v)
+					continue
+				}
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// mostRecentModTime is used to set timestamps of rendered files, so these don't
+// change on every run
+func mostRecentModTime(paths ...string) (time.Time, error) {
+	modTime := time.Time{}
+	for _, path := range paths {
+		if err := filepath.WalkDir(path, func(_ string, e fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := e.Info()
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && info.ModTime().After(modTime) {
+				modTime = info.ModTime()
+			}
+			return nil
+		}); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return modTime, nil
+}
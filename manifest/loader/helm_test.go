@@ -0,0 +1,150 @@
+package loader_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	helmloader "helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+
+	"github.com/docker/labs-brown-tape/manifest/imagescanner"
+	. "github.com/docker/labs-brown-tape/manifest/loader"
+)
+
+const (
+	testChartDir    = "../testdata/helm/chart"
+	testChartValues = "../testdata/helm/values-prod.yaml"
+)
+
+func TestHelmChartLoader(t *testing.T) {
+	g := NewWithT(t)
+
+	loader := NewHelmChartLoader(testChartDir, testChartValues).WithRelease("web", "prod")
+	g.Expect(loader.Load()).To(Succeed())
+	defer loader.Cleanup()
+
+	chart := loader.HelmChart()
+	g.Expect(chart).ToNot(BeNil())
+	g.Expect(chart.Name).To(Equal("web"))
+	g.Expect(chart.Version).To(Equal("0.1.0"))
+	g.Expect(chart.AppVersion).To(Equal("1.25.3"))
+	g.Expect(chart.ReleaseName).To(Equal("web"))
+	g.Expect(chart.Namespace).To(Equal("prod"))
+	g.Expect(chart.BaseDir).To(Equal(testChartDir))
+	g.Expect(chart.Archive).To(BeEmpty())
+	g.Expect(chart.ValuesFiles).To(ConsistOf("../values-prod.yaml"))
+	g.Expect(chart.Sources()).To(ConsistOf("../values-prod.yaml"))
+	g.Expect(chart.ValuesDigest).ToNot(BeEmpty())
+	g.Expect(chart.Outputs).To(HaveLen(2))
+
+	// notes and helpers are not included
+	dir, relPaths := loader.RelPaths()
+	g.Expect(relPaths).To(ConsistOf("templates/deployment.yaml", "templates/service.yaml"))
+	g.Expect(loader.ContainsRelPath("templates/NOTES.txt")).To(BeFalse())
+
+	data, err := os.ReadFile(filepath.Join(dir, "templates/deployment.yaml"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(data)).To(ContainSubstring("name: web-web"))
+	g.Expect(string(data)).To(ContainSubstring("namespace: prod"))
+	g.Expect(string(data)).To(ContainSubstring("replicas: 3"))
+
+	scanner := imagescanner.NewDefaultImageScanner()
+	g.Expect(scanner.Scan(loader.RelPaths())).To(Succeed())
+	refs := []string{}
+	for _, image := range scanner.GetImages().Items() {
+		refs = append(refs, image.OriginalRef())
+		g.Expect(image.Sources[0].ManifestDigest).To(Equal(chart.Outputs[image.Sources[0].Manifest]))
+	}
+	g.Expect(refs).To(ConsistOf("docker.io/library/nginx:1.25.3", "busybox:1.36"))
+
+	_, mrmTimestamp1 := loader.MostRecentlyModified()
+
+	// default values only
+	defaults := NewHelmChartLoader(testChartDir)
+	g.Expect(defaults.Load()).To(Succeed())
+	defer defaults.Cleanup()
+	g.Expect(defaults.HelmChart().ReleaseName).To(Equal(DefaultHelmReleaseName))
+	g.Expect(defaults.HelmChart().Namespace).To(Equal(DefaultHelmNamespace))
+	g.Expect(defaults.HelmChart().ValuesDigest).ToNot(Equal(chart.ValuesDigest))
+
+	scanner = imagescanner.NewDefaultImageScanner()
+	g.Expect(scanner.Scan(defaults.RelPaths())).To(Succeed())
+	images := scanner.GetImages().Items()
+	g.Expect(images).To(HaveLen(1))
+	g.Expect(images[0].OriginalRef()).To(Equal("nginx:1.25.3"))
+
+	// the same chart packaged as an archive
+	helmChart, err := helmloader.Load(testChartDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	archiveDir := t.TempDir()
+	archive, err := chartutil.Save(helmChart, archiveDir)
+	g.Expect(err).ToNot(HaveOccurred())
+	values := filepath.Join(archiveDir, "values.yaml")
+	valuesData, err := os.ReadFile(testChartValues)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(os.WriteFile(values, valuesData, 0o644)).To(Succeed())
+
+	fromArchive := NewHelmChartLoader(archive, values).WithRelease("web", "prod")
+	g.Expect(fromArchive.Load()).To(Succeed())
+	defer fromArchive.Cleanup()
+
+	g.Expect(fromArchive.HelmChart().BaseDir).To(Equal(archiveDir))
+	g.Expect(fromArchive.HelmChart().Archive).To(Equal("web-0.1.0.tgz"))
+	g.Expect(fromArchive.HelmChart().ValuesFiles).To(ConsistOf("values.yaml"))
+	g.Expect(fromArchive.HelmChart().Sources()).To(ConsistOf("web-0.1.0.tgz", "values.yaml"))
+	g.Expect(fromArchive.HelmChart().ValuesDigest).To(Equal(chart.ValuesDigest))
+	g.Expect(fromArchive.HelmChart().Outputs).To(Equal(chart.Outputs))
+
+	g.Expect(loader.Cleanup()).To(Succeed())
+	g.Expect(loader.Load()).To(Succeed())
+	_, mrmTimestamp2 := loader.MostRecentlyModified()
+	g.Expect(mrmTimestamp2).To(Equal(mrmTimestamp1))
+}
+
+func TestHelmChartLoaderWithConditionalDependency(t *testing.T) {
+	g := NewWithT(t)
+
+	chartDir := filepath.Join(t.TempDir(), "app")
+	for path, contents := range map[string]string{
+		"Chart.yaml":                      "apiVersion: v2\nname: app\nversion: 0.1.0\ndependencies:\n- name: cache\n  version: 0.1.0\n  condition: cache.enabled\n",
+		"values.yaml":                     "cache:\n  enabled: false\n",
+		"templates/configmap.yaml":        "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}-app\n",
+		"charts/cache/Chart.yaml":         "apiVersion: v2\nname: cache\nversion: 0.1.0\n",
+		"charts/cache/values.yaml":        "image: redis:7.2\n",
+		"charts/cache/templates/pod.yaml": "apiVersion: v1\nkind: Pod\nmetadata:\n  name: {{ .Release.Name }}-cache\nspec:\n  containers:\n  - name: cache\n    image: {{ .Values.image }}\n",
+	} {
+		path = filepath.Join(chartDir, path)
+		g.Expect(os.MkdirAll(filepath.Dir(path), 0o755)).To(Succeed())
+		g.Expect(os.WriteFile(path, []byte(contents), 0o644)).To(Succeed())
+	}
+	enableCache := filepath.Join(t.TempDir(), "values-cache.yaml")
+	g.Expect(os.WriteFile(enableCache, []byte("cache:\n  enabled: true\n"), 0o644)).To(Succeed())
+
+	disabled := NewHelmChartLoader(chartDir)
+	g.Expect(disabled.Load()).To(Succeed())
+	defer disabled.Cleanup()
+	_, relPaths := disabled.RelPaths()
+	g.Expect(relPaths).To(ConsistOf("templates/configmap.yaml"))
+
+	enabled := NewHelmChartLoader(chartDir, enableCache)
+	g.Expect(enabled.Load()).To(Succeed())
+	defer enabled.Cleanup()
+	_, relPaths = enabled.RelPaths()
+	g.Expect(relPaths).To(ConsistOf("templates/configmap.yaml", "charts/cache/templates/pod.yaml"))
+
+	scanner := imagescanner.NewDefaultImageScanner()
+	g.Expect(scanner.Scan(enabled.RelPaths())).To(Succeed())
+	images := scanner.GetImages().Items()
+	g.Expect(images).To(HaveLen(1))
+	g.Expect(images[0].OriginalRef()).To(Equal("redis:7.2"))
+}
+
+func TestHelmChartLoaderErrors(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(NewHelmChartLoader("../testdata/helm/missing").Load()).ToNot(Succeed())
+	g.Expect(NewHelmChartLoader(testChartDir, "../testdata/helm/missing.yaml").Load()).ToNot(Succeed())
+	g.Expect(NewHelmChartLoader("../testdata/basic").Load()).ToNot(Succeed())
+}
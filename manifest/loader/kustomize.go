@@ -0,0 +1,252 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	kustomize "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/yaml"
+
+	"github.com/docker/labs-brown-tape/attest/digest"
+)
+
+const (
+	KustomizeBuildOutput = "kustomize-build.yaml"
+)
+
+// KustomizeBuild describes the result of kustomize build, all paths
+// are relative to the manifest directory
+type KustomizeBuild struct {
+	// Output is where rendered resources are written in the temp dir
+	Output       string
+	OutputDigest digest.SHA256
+	// Kustomization is the path to the top-level kustomization file
+	Kustomization string
+	// Overlays are directories of other kustomizations that were used
+	// as resources or components, remote references are included as is
+	Overlays []string
+	// Excluded are manifest files in the directory that kustomize build
+	// didn't read, these are not part of the output
+	Excluded []string
+}
+
+// Sources returns local paths that the output was built from
+func (b *KustomizeBuild) Sources() []string {
+	sources := []string{b.Kustomization}
+	for _, overlay := range b.Overlays {
+		if !isRemote(overlay) {
+			sources = append(sources, overlay)
+		}
+	}
+	return sources
+}
+
+// findKustomization returns kustomization file at the top of the directory,
+// nested kustomizations are not built unless referenced by the top-level one
+func findKustomization(path string, files []fileWithModTime) (string, bool) {
+	for i := range files {
+		if filepath.Dir(files[i].path) == filepath.Clean(path) && useKustomize(files[i].path) {
+			return files[i].path, true
+		}
+	}
+	return "", false
+}
+
+// readTrackingFS records absolute paths of all files that were read
+type readTrackingFS struct {
+	filesys.FileSystem
+	read map[string]struct{}
+}
+
+func (fs *readTrackingFS) ReadFile(path string) ([]byte, error) {
+	fs.read[filepath.Clean(path)] = struct{}{}
+	return fs.FileSystem.ReadFile(path)
+}
+
+func (l *RecursiveManifestDirectoryLoader) runKustomize(kustomizationPath string, files []fileWithModTime) error {
+	dir := filepath.Dir(kustomizationPath)
+
+	overlays, err := findOverlays(dir, map[string]struct{}{dir: {}})
+	if err != nil {
+		return err
+	}
+
+	fs := &readTrackingFS{FileSystem: filesys.MakeFsOnDisk(), read: map[string]struct{}{}}
+	resources, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fs, dir)
+	if err != nil {
+		return fmt.Errorf("kustomize build failed for %q: %w", dir, err)
+	}
+	data, err := resources.AsYaml()
+	if err != nil {
+		return fmt.Errorf("failed to serialise output of kustomize build for %q: %w", dir, err)
+	}
+
+	// output file should have the same timestamp as the most recently modified source
+	// file, otherwise it would change on every build
+	modTime := time.Time{}
+	for _, sourceDir := range append([]string{dir}, overlays...) {
+		if isRemote(sourceDir) {
+			continue
+		}
+		files, err := getFiles(sourceDir)
+		if err != nil {
+			return err
+		}
+		if mostRecent := files[len(files)-1].time; mostRecent.After(modTime) {
+			modTime = mostRecent
+		}
+	}
+
+	output := filepath.Join(l.tempDir, KustomizeBuildOutput)
+	if err := os.WriteFile(output, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(output, modTime, modTime); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	_, _ = hash.Write(data)
+	build := &KustomizeBuild{
+		Output:        KustomizeBuildOutput,
+		OutputDigest:  digest.MakeSHA256(hash),
+		Kustomization: filepath.Base(kustomizationPath),
+		Overlays:      make([]string, 0, len(overlays)),
+	}
+	for _, overlay := range overlays {
+		if !isRemote(overlay) {
+			if overlay, err = filepath.Rel(dir, overlay); err != nil {
+				return err
+			}
+		}
+		build.Overlays = append(build.Overlays, overlay)
+	}
+	for _, file := range files {
+		// kustomize reads files by absolute paths with symlinks resolved
+		path, err := filepath.Abs(file.path)
+		if err != nil {
+			return err
+		}
+		if path, err = filepath.EvalSymlinks(path); err != nil {
+			return err
+		}
+		if _, ok := fs.read[path]; ok {
+			continue
+		}
+		relPath, err := filepath.Rel(dir, file.path)
+		if err != nil {
+			return err
+		}
+		build.Excluded = append(build.Excluded, relPath)
+	}
+	slices.Sort(build.Excluded)
+	l.kustomizeBuild = build
+	l.relPaths = map[string]string{KustomizeBuildOutput: kustomizationPath}
+	return nil
+}
+
+// findOverlays returns all directories containing kustomizations that are referenced
+// from the kustomization in dir, either directly or indirectly; files that are used as
+// resources or patches must be in or below dir, so that these are covered by the
+// directory itself, otherwise an error is returned
+func findOverlays(dir string, seen map[string]struct{}) ([]string, error) {
+	kustomization, err := readKustomization(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries := append(append(append([]string{}, kustomization.Resources...), kustomization.Components...), kustomization.Bases...)
+	overlays := []string{}
+	for _, entry := range entries {
+		if isRemote(entry) {
+			if _, ok := seen[entry]; !ok {
+				seen[entry] = struct{}{}
+				overlays = append(overlays, entry)
+			}
+			continue
+		}
+		path := filepath.Join(dir, entry)
+		fi, err := os.Stat(path)
+		if err != nil {
+			// any missing resources will be reported by the build
+			continue
+		}
+		if !fi.IsDir() {
+			if err := checkFileInDir(dir, entry); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		nested, err := findOverlays(path, seen)
+		if err != nil {
+			return nil, err
+		}
+		overlays = append(overlays, path)
+		overlays = append(overlays, nested...)
+	}
+	for _, patch := range patchFiles(kustomization) {
+		if err := checkFileInDir(dir, patch); err != nil {
+			return nil, err
+		}
+	}
+	return overlays, nil
+}
+
+// patchFiles returns paths of all patches, strategic merge patches can also be given
+// inline, those are not included
+func patchFiles(kustomization *kustomize.Kustomization) []string {
+	paths := []string{}
+	for _, patch := range kustomization.PatchesStrategicMerge {
+		if !strings.Contains(string(patch), "\n") {
+			paths = append(paths, string(patch))
+		}
+	}
+	for _, patches := range [][]kustomize.Patch{kustomization.Patches, kustomization.PatchesJson6902} {
+		for _, patch := range patches {
+			if patch.Path != "" {
+				paths = append(paths, patch.Path)
+			}
+		}
+	}
+	return paths
+}
+
+func checkFileInDir(dir, file string) error {
+	if !filepath.IsLocal(file) {
+		return fmt.Errorf("kustomization in %q refers to %q, which is outside of its directory", dir, file)
+	}
+	return nil
+}
+
+func readKustomization(dir string) (*kustomize.Kustomization, error) {
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		kustomization := &kustomize.Kustomization{}
+		if err := yaml.Unmarshal(data, kustomization); err != nil {
+			return nil, fmt.Errorf("unable to parse kustomization in %q: %w", dir, err)
+		}
+		return kustomization, nil
+	}
+	return nil, fmt.Errorf("no kustomization found in %q", dir)
+}
+
+func isRemote(ref string) bool {
+	return strings.Contains(ref, "://") || strings.HasPrefix(ref, "github.com/") || strings.HasPrefix(ref, "git@")
+}
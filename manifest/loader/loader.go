@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/otiai10/copy"
+	"sigs.k8s.io/kustomize/api/konfig"
 )
 
 type Loader interface {
@@ -21,14 +22,21 @@ type Loader interface {
 	MostRecentlyModified() (string, time.Time)
 }
 
-type RecursiveManifestDirectoryLoader struct {
-	fromPath string
+// loadedFiles keeps track of files written into the temp dir, it's common to all loaders
+type loadedFiles struct {
 	tempDir  string
 	files    []fileWithModTime
 	relPaths map[string]string
 }
 
-func NewRecursiveManifestDirectoryLoader(path string) Loader {
+type RecursiveManifestDirectoryLoader struct {
+	fromPath string
+	loadedFiles
+
+	kustomizeBuild *KustomizeBuild
+}
+
+func NewRecursiveManifestDirectoryLoader(path string) *RecursiveManifestDirectoryLoader {
 	return &RecursiveManifestDirectoryLoader{fromPath: path}
 }
 
@@ -60,13 +68,18 @@ func (l *RecursiveManifestDirectoryLoader) Load() error {
 		l.relPaths[relPath] = f.path
 	}
 
-	// if useKustomize(l.fromPath) || useKustomize(files...) {
-	// 	// it's possible that kustomization references files out of the tempDir
-	// 	// this will do `kustomize build` on l.fromPath and write the result into l.tempDir
-	// 	// TODO: this assumes all files are to be handled by kustomize,
-	// 	// it would be sensible to enable using different handlers for different subdirs
-	// 	return fmt.Errorf("TODO: implement kustomize build")
-	// }
+	if kustomizationPath, ok := findKustomization(l.fromPath, files); ok {
+		// it's possible that kustomization references files out of the tempDir
+		// this will do `kustomize build` on l.fromPath and write the result into l.tempDir
+		// TODO: this assumes all files are to be handled by kustomize,
+		// it would be sensible to enable using different handlers for different subdirs;
+		// files that kustomize didn't read are listed in KustomizeBuild().Excluded
+		if err := l.runKustomize(kustomizationPath, files); err != nil {
+			return err
+		}
+		l.files, err = getFiles(l.tempDir)
+		return err
+	}
 
 	copyOptions := copy.Options{
 		// TODO: documentation for PreserveTimes says there is limited accuracy on Linux,
@@ -96,11 +109,16 @@ func (l *RecursiveManifestDirectoryLoader) Load() error {
 	return nil
 }
 
-func (l *RecursiveManifestDirectoryLoader) MostRecentlyModified() (string, time.Time) {
+func (l *loadedFiles) MostRecentlyModified() (string, time.Time) {
 	return l.files[0].path, l.files[0].time
 }
 
-func (l *RecursiveManifestDirectoryLoader) Paths() []string {
+// KustomizeBuild returns nil unless kustomization was found in the manifest directory
+func (l *RecursiveManifestDirectoryLoader) KustomizeBuild() *KustomizeBuild {
+	return l.kustomizeBuild
+}
+
+func (l *loadedFiles) Paths() []string {
 	paths := make([]string, 0, len(l.files))
 	for _, file := range l.files {
 		paths = append(paths, file.path)
@@ -108,7 +126,7 @@ func (l *RecursiveManifestDirectoryLoader) Paths() []string {
 	return paths
 }
 
-func (l *RecursiveManifestDirectoryLoader) RelPaths() (string, []string) {
+func (l *loadedFiles) RelPaths() (string, []string) {
 	relPaths := make([]string, 0, len(l.relPaths))
 	for p := range l.relPaths {
 		relPaths = append(relPaths, p)
@@ -116,12 +134,12 @@ func (l *RecursiveManifestDirectoryLoader) RelPaths() (string, []string) {
 	return l.tempDir, relPaths
 }
 
-func (l *RecursiveManifestDirectoryLoader) ContainsRelPath(p string) bool {
+func (l *loadedFiles) ContainsRelPath(p string) bool {
 	_, ok := l.relPaths[p]
 	return ok
 }
 
-func (l *RecursiveManifestDirectoryLoader) Cleanup() error {
+func (l *loadedFiles) Cleanup() error {
 	if l.tempDir == "" {
 		return nil
 	}
@@ -206,8 +224,7 @@ func ignoreFile(path string) bool {
 
 func useKustomize(paths ...string) bool {
 	for i := range paths {
-		switch filepath.Base(paths[i]) {
-		case "kustomization.yaml", "kustomization.yml", "Kustomization":
+		if slices.Contains(konfig.RecognizedKustomizationFileNames(), filepath.Base(paths[i])) {
 			return true
 		}
 	}
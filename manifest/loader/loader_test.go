@@ -1,6 +1,8 @@
 package loader_test
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -15,10 +17,137 @@ func TestLoader(t *testing.T) {
 
 	cases = append(cases, testdata.BasicJSONCases()...)
 	cases = append(cases, testdata.BaseYAMLCases()...)
+	cases = append(cases, testdata.KustomizeCases()...)
+	cases = append(cases, testdata.NestedListCases()...)
 
 	cases.Run(t, ("../../"), makeLoaderTest)
 }
 
+func TestLoaderKustomizeBuild(t *testing.T) {
+	g := NewWithT(t)
+
+	loader := NewRecursiveManifestDirectoryLoader("../testdata/kustomize/overlays/prod")
+	g.Expect(loader.Load()).To(Succeed())
+	defer loader.Cleanup()
+
+	build := loader.KustomizeBuild()
+	g.Expect(build).ToNot(BeNil())
+	g.Expect(build.Output).To(Equal(KustomizeBuildOutput))
+	g.Expect(build.Kustomization).To(Equal("kustomization.yaml"))
+	g.Expect(build.Overlays).To(ConsistOf("../../base"))
+	g.Expect(build.Sources()).To(ConsistOf("kustomization.yaml", "../../base"))
+	g.Expect(build.Excluded).To(BeEmpty())
+
+	dir, relPaths := loader.RelPaths()
+	g.Expect(relPaths).To(ConsistOf(KustomizeBuildOutput))
+	data, err := os.ReadFile(filepath.Join(dir, KustomizeBuildOutput))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(data)).To(ContainSubstring("namespace: prod"))
+	g.Expect(string(data)).To(ContainSubstring("kind: Service"))
+
+	_, mrmTimestamp := loader.MostRecentlyModified()
+	base, err := os.Stat("../testdata/kustomize/base/deployment.yaml")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(mrmTimestamp).ToNot(BeTemporally("<", base.ModTime()))
+
+	plain := NewRecursiveManifestDirectoryLoader("../testdata/tekton")
+	g.Expect(plain.Load()).To(Succeed())
+	defer plain.Cleanup()
+	g.Expect(plain.KustomizeBuild()).To(BeNil())
+}
+
+func TestLoaderKustomizeFilesOutsideDir(t *testing.T) {
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\ndata:\n  key: %s\n"
+
+	for name, tc := range map[string]struct {
+		kustomization string
+		expectedErr   string
+	}{
+		"patch in dir": {
+			kustomization: "resources:\n- configmap.yaml\npatches:\n- path: patches/configmap.yaml\n",
+		},
+		"patch outside dir": {
+			kustomization: "resources:\n- configmap.yaml\npatches:\n- path: ../patches/configmap.yaml\n",
+			expectedErr:   `refers to "../patches/configmap.yaml", which is outside of its directory`,
+		},
+		"strategic merge patch outside dir": {
+			kustomization: "resources:\n- configmap.yaml\npatchesStrategicMerge:\n- ../patches/configmap.yaml\n",
+			expectedErr:   `refers to "../patches/configmap.yaml", which is outside of its directory`,
+		},
+		"resource outside dir": {
+			kustomization: "resources:\n- ../shared/configmap.yaml\n",
+			expectedErr:   `refers to "../shared/configmap.yaml", which is outside of its directory`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			root := t.TempDir()
+			for path, contents := range map[string]string{
+				"app/kustomization.yaml":     tc.kustomization,
+				"app/configmap.yaml":         fmt.Sprintf(configMap, "app"),
+				"app/patches/configmap.yaml": fmt.Sprintf(configMap, "patched"),
+				"patches/configmap.yaml":     fmt.Sprintf(configMap, "patched"),
+				"shared/configmap.yaml":      fmt.Sprintf(configMap, "shared"),
+			} {
+				path = filepath.Join(root, path)
+				g.Expect(os.MkdirAll(filepath.Dir(path), 0o755)).To(Succeed())
+				g.Expect(os.WriteFile(path, []byte(contents), 0o644)).To(Succeed())
+			}
+
+			// loader doesn't accept absolute paths
+			workDir, err := os.Getwd()
+			g.Expect(err).ToNot(HaveOccurred())
+			dir, err := filepath.Rel(workDir, filepath.Join(root, "app"))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			loader := NewRecursiveManifestDirectoryLoader(dir)
+			defer loader.Cleanup()
+			err = loader.Load()
+			if tc.expectedErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectedErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(loader.KustomizeBuild().Sources()).To(ConsistOf("kustomization.yaml"))
+		})
+	}
+}
+
+func TestLoaderKustomizeExcludedFiles(t *testing.T) {
+	g := NewWithT(t)
+
+	configMap := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: %s\n"
+	root := t.TempDir()
+	for path, contents := range map[string]string{
+		"kustomization.yaml":      "resources:\n- configmap.yaml\n- base\n",
+		"configmap.yaml":          fmt.Sprintf(configMap, "app"),
+		"base/kustomization.yaml": "resources:\n- configmap.yaml\n",
+		"base/configmap.yaml":     fmt.Sprintf(configMap, "base"),
+		"base/unused.yaml":        fmt.Sprintf(configMap, "unused-base"),
+		"unused.yaml":             fmt.Sprintf(configMap, "unused"),
+		"README.md":               "not a manifest",
+	} {
+		path = filepath.Join(root, path)
+		g.Expect(os.MkdirAll(filepath.Dir(path), 0o755)).To(Succeed())
+		g.Expect(os.WriteFile(path, []byte(contents), 0o644)).To(Succeed())
+	}
+
+	// loader doesn't accept absolute paths
+	workDir, err := os.Getwd()
+	g.Expect(err).ToNot(HaveOccurred())
+	dir, err := filepath.Rel(workDir, root)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	loader := NewRecursiveManifestDirectoryLoader(dir)
+	defer loader.Cleanup()
+	g.Expect(loader.Load()).To(Succeed())
+	g.Expect(loader.KustomizeBuild().Excluded).To(Equal([]string{
+		filepath.Join("base", "unused.yaml"),
+		"unused.yaml",
+	}))
+}
+
 func makeLoaderTest(tc testdata.TestCase) func(t *testing.T) {
 	return func(t *testing.T) {
 		g := NewWithT(t)
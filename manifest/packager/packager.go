@@ -4,6 +4,8 @@ import (
 	"context"
 	"time"
 
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+
 	attestTypes "github.com/docker/labs-brown-tape/attest/types"
 	"github.com/docker/labs-brown-tape/oci"
 )
@@ -11,6 +13,10 @@ import (
 type Packager interface {
 	//Pull(string) error
 	Push(context.Context, string) (string, error)
+	WithAttestationSigner(dsse.SignerVerifier)
+	WithForce(bool)
+	WithReproducible(bool)
+	WithAttestationsLayout(oci.AttestationsLayout)
 }
 
 type DefaultPackager struct {
@@ -18,6 +24,10 @@ type DefaultPackager struct {
 	destinationRef       string
 	sourceEpochTimestamp *time.Time
 	sourceAttestations   attestTypes.Statements
+	attestationSigner    dsse.SignerVerifier
+	force                bool
+	reproducible         bool
+	attestationsLayout   oci.AttestationsLayout
 }
 
 func NewDefaultPackager(client *oci.Client, destinationRef string, sourceEpochTimestamp *time.Time, sourceAttestations ...attestTypes.Statement) Packager {
@@ -29,10 +39,31 @@ func NewDefaultPackager(client *oci.Client, destinationRef string, sourceEpochTi
 		destinationRef:       destinationRef,
 		sourceEpochTimestamp: sourceEpochTimestamp,
 		sourceAttestations:   sourceAttestations,
+		attestationsLayout:   oci.AttestationsEmbedded,
 	}
 }
 
+func (r *DefaultPackager) WithAttestationSigner(signer dsse.SignerVerifier) {
+	r.attestationSigner = signer
+}
+
+// WithForce makes the packager push the artefact even when an identical one already exists
+func (r *DefaultPackager) WithForce(force bool) {
+	r.force = force
+}
+
+// WithReproducible makes the packager build the artefact in strict reproducible mode
+func (r *DefaultPackager) WithReproducible(reproducible bool) {
+	r.reproducible = reproducible
+}
+
+// WithAttestationsLayout sets whether attestations are embedded in the artefact index
+// or pushed as a referrer of it
+func (r *DefaultPackager) WithAttestationsLayout(layout oci.AttestationsLayout) {
+	r.attestationsLayout = layout
+}
+
 func (r *DefaultPackager) Push(ctx context.Context, dir string) (string, error) {
 	return r.Client.PushArtefact(ctx, r.destinationRef, dir,
-		r.sourceEpochTimestamp, r.sourceAttestations...)
+		r.sourceEpochTimestamp, r.attestationSigner, r.force, r.reproducible, r.attestationsLayout, r.sourceAttestations...)
 }
@@ -47,6 +47,9 @@ func makePackagerTest(tc testdata.TestCase) func(t *testing.T) {
 		g.Expect(err).NotTo(HaveOccurred())
 		g.Expect(pathChecker).ToNot(BeNil())
 		g.Expect(attreg).ToNot(BeNil())
+		if build := loader.KustomizeBuild(); build != nil {
+			attreg.RegisterDerived(build.Output, build.Sources()...)
+		}
 
 		scanner := imagescanner.NewDefaultImageScanner()
 		scanner.WithProvinanceAttestor(attreg)
@@ -7,6 +7,9 @@ import (
 	"github.com/docker/labs-brown-tape/manifest/types"
 )
 
+// CustomResourceFieldSpecs is the path to image field specs for CustomResourceCases
+const CustomResourceFieldSpecs = "manifest/testdata/custom-resources/image-field-specs.yaml"
+
 type TestCase struct {
 	Description    string
 	Directory      string
@@ -96,34 +99,219 @@ func BasicJSONCases() TestCases {
 	}}
 }
 
+func KustomizeCases() TestCases {
+	return []TestCase{{
+		Description: "kustomize",
+		Directory:   "manifest/testdata/kustomize/overlays/prod",
+		Manifests: []string{
+			"kustomize-build.yaml",
+		},
+		Expected: []types.Image{
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "kustomize-build.yaml",
+						ManifestDigest: "88b829572556d767de81591a3c911e878b11f7475d21d65ad341a98a779e9e8a",
+						NodePath:       []string{"spec", "template", "spec", "containers", "image"},
+						Line:           19,
+						Column:         16,
+					},
+					OriginalRef: "docker.io/library/nginx:1.25.3",
+				}},
+				OriginalName: "docker.io/library/nginx",
+				OriginalTag:  "1.25.3",
+			},
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "kustomize-build.yaml",
+						ManifestDigest: "88b829572556d767de81591a3c911e878b11f7475d21d65ad341a98a779e9e8a",
+						NodePath:       []string{"spec", "template", "spec", "initContainers", "image"},
+						Line:           26,
+						Column:         16,
+					},
+					OriginalRef: "busybox:1.36",
+				}},
+				OriginalName: "busybox",
+				OriginalTag:  "1.36",
+			},
+		},
+	}}
+}
+
+// NestedListCases contain lists nested at different depths, including
+// multi-document files where lists are not unwrapped by kio readers
+func NestedListCases() TestCases {
+	return []TestCase{{
+		Description: "nested-lists",
+		Directory:   "manifest/testdata/nested-lists",
+		Manifests: []string{
+			"bundle.yaml",
+			"deep.json",
+		},
+		Expected: []types.Image{
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "bundle.yaml",
+						ManifestDigest: "e9b44b35091f5ff4157280d17b1a880ebffe02e317dda56e7594e8f41eb5c650",
+						NodePath:       []string{"items", "items", "items", "spec", "template", "spec", "containers", "image"},
+						Line:           28,
+						Column:         22,
+					},
+					OriginalRef: "nginx:1.25.3",
+				}},
+				OriginalName: "nginx",
+				OriginalTag:  "1.25.3",
+			},
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "bundle.yaml",
+						ManifestDigest: "e9b44b35091f5ff4157280d17b1a880ebffe02e317dda56e7594e8f41eb5c650",
+						NodePath:       []string{"items", "items", "items", "spec", "template", "spec", "initContainers", "image"},
+						Line:           25,
+						Column:         22,
+					},
+					OriginalRef: "busybox:1.36",
+				}},
+				OriginalName: "busybox",
+				OriginalTag:  "1.36",
+			},
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "bundle.yaml",
+						ManifestDigest: "e9b44b35091f5ff4157280d17b1a880ebffe02e317dda56e7594e8f41eb5c650",
+						NodePath:       []string{"items", "items", "spec", "containers", "image"},
+						Line:           36,
+						Column:         16,
+					},
+					OriginalRef: "redis:7.2",
+				}},
+				OriginalName: "redis",
+				OriginalTag:  "7.2",
+			},
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "bundle.yaml",
+						ManifestDigest: "e9b44b35091f5ff4157280d17b1a880ebffe02e317dda56e7594e8f41eb5c650",
+						NodePath:       []string{"items", "spec", "containers", "image"},
+						Line:           11,
+						Column:         14,
+					},
+					OriginalRef: "memcached:1.6",
+				}},
+				OriginalName: "memcached",
+				OriginalTag:  "1.6",
+			},
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "deep.json",
+						ManifestDigest: "5f5d927f62f49ebe2d55d23cffc05fdfba319269303d1cb904c51539a743280d",
+						NodePath:       []string{"items", "items", "items", "spec", "containers", "image"},
+						Line:           27,
+						Column:         58,
+					},
+					OriginalRef: "ghcr.io/example/app:1.0.0",
+				}},
+				OriginalName: "ghcr.io/example/app",
+				OriginalTag:  "1.0.0",
+			},
+		},
+	}}
+}
+
+// CustomResourceCases contain images in custom resources, most of which are
+// only found with CustomResourceFieldSpecs
+func CustomResourceCases() TestCases {
+	return []TestCase{{
+		Description: "custom-resources",
+		Directory:   "manifest/testdata/custom-resources/manifests",
+		Manifests: []string{
+			"helmrelease.yaml",
+			"rollout.yaml",
+			"task.yaml",
+		},
+		Expected: []types.Image{
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "task.yaml",
+						ManifestDigest: "9725beee75930412ab519abcdc78e5fdc859a5962085b7bc1736574d3a54927a",
+						NodePath:       []string{"spec", "steps", "image"},
+						Line:           8,
+						Column:         12,
+					},
+					OriginalRef: "golang:1.21",
+				}},
+				OriginalName: "golang",
+				OriginalTag:  "1.21",
+			},
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "task.yaml",
+						ManifestDigest: "9725beee75930412ab519abcdc78e5fdc859a5962085b7bc1736574d3a54927a",
+						NodePath:       []string{"spec", "steps", "image"},
+						Line:           11,
+						Column:         12,
+					},
+					OriginalRef: "golang:1.21",
+				}},
+				OriginalName: "golang",
+				OriginalTag:  "1.21",
+			},
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "helmrelease.yaml",
+						ManifestDigest: "10ea8e129d4ecc103a889e475eeb1e39c5179d0ae055f392da6e8de8bc3fb20d",
+						NodePath:       []string{"spec", "values", "image"},
+						Line:           14,
+						Column:         12,
+					},
+					OriginalRef: "ghcr.io/stefanprodan/podinfo:6.5.3",
+				}},
+				OriginalName: "ghcr.io/stefanprodan/podinfo",
+				OriginalTag:  "6.5.3",
+			},
+			{
+				Sources: []types.Source{{
+					ImageSourceLocation: types.ImageSourceLocation{
+						Manifest:       "rollout.yaml",
+						ManifestDigest: "56a2cf0d65cdce782ff80a4c9d9a9cd8b445a740b033b864c2561a7fb1e54db0",
+						NodePath:       []string{"spec", "template", "spec", "containers", "image"},
+						Line:           16,
+						Column:         16,
+					},
+					OriginalRef: "nginx:1.25.3",
+				}},
+				OriginalName: "nginx",
+				OriginalTag:  "1.25.3",
+			},
+		},
+	}}
+}
+
 var baseYAMLCases = []TestCase{
 	{
 		Description: "contour",
 		Directory:   "manifest/testdata/contour",
 		Manifests: []string{
-			"00-common.yaml",
-			"00-crds.yaml",
-			"01-contour-config.yaml",
-			"01-crds.yaml",
-			"02-job-certgen.yaml",
-			"02-rbac.yaml",
-			"02-role-contour.yaml",
-			"02-service-contour.yaml",
-			"02-service-envoy.yaml",
-			"03-contour.yaml",
-			"03-envoy.yaml",
-			"04-gatewayclass.yaml",
-			"kustomization.yaml",
+			"kustomize-build.yaml",
 		},
 		NumRelatedTags: 0,
 		Expected: []types.Image{
 			{
 				Sources: []types.Source{{
 					ImageSourceLocation: types.ImageSourceLocation{
-						Manifest:       "02-job-certgen.yaml",
-						ManifestDigest: "ba03dc02890e0ca080f12f03fd06a1d4f6b76ff75be0346ee27c9aa73c6d1d31",
+						Manifest:       "kustomize-build.yaml",
+						ManifestDigest: "5582b193f15fdb0b9f2ffe41da96c17c45c615d47fa658cd4758488a96e07506",
 						NodePath:       []string{"spec", "template", "spec", "containers", "image"},
-						Line:           14,
+						Line:           29,
 						Column:         16,
 					},
 					OriginalRef: "ghcr.io/projectcontour/contour:v1.24.1",
@@ -134,10 +322,10 @@ var baseYAMLCases = []TestCase{
 			{
 				Sources: []types.Source{{
 					ImageSourceLocation: types.ImageSourceLocation{
-						Manifest:       "03-contour.yaml",
-						ManifestDigest: "a9de49647bab938407cb76c29f6b9465690bedb0b99a10736136f982d349d928",
+						Manifest:       "kustomize-build.yaml",
+						ManifestDigest: "5582b193f15fdb0b9f2ffe41da96c17c45c615d47fa658cd4758488a96e07506",
 						NodePath:       []string{"spec", "template", "spec", "containers", "image"},
-						Line:           48,
+						Line:           57,
 						Column:         16,
 					},
 					OriginalRef: "ghcr.io/projectcontour/contour:v1.24.1",
@@ -148,10 +336,10 @@ var baseYAMLCases = []TestCase{
 			{
 				Sources: []types.Source{{
 					ImageSourceLocation: types.ImageSourceLocation{
-						Manifest:       "03-envoy.yaml",
-						ManifestDigest: "e83cd3f98ddbbd91374511c8ce1e437d938ffc8ea8d50bc6d4ccdbf224e53ed4",
+						Manifest:       "kustomize-build.yaml",
+						ManifestDigest: "5582b193f15fdb0b9f2ffe41da96c17c45c615d47fa658cd4758488a96e07506",
 						NodePath:       []string{"spec", "template", "spec", "containers", "image"},
-						Line:           32,
+						Line:           28,
 						Column:         16,
 					},
 					OriginalRef: "ghcr.io/projectcontour/contour:v1.24.1",
@@ -162,10 +350,10 @@ var baseYAMLCases = []TestCase{
 			{
 				Sources: []types.Source{{
 					ImageSourceLocation: types.ImageSourceLocation{
-						Manifest:       "03-envoy.yaml",
-						ManifestDigest: "e83cd3f98ddbbd91374511c8ce1e437d938ffc8ea8d50bc6d4ccdbf224e53ed4",
+						Manifest:       "kustomize-build.yaml",
+						ManifestDigest: "5582b193f15fdb0b9f2ffe41da96c17c45c615d47fa658cd4758488a96e07506",
 						NodePath:       []string{"spec", "template", "spec", "containers", "image"},
-						Line:           53,
+						Line:           60,
 						Column:         16,
 					},
 					OriginalRef: "docker.io/envoyproxy/envoy:v1.25.1",
@@ -176,10 +364,10 @@ var baseYAMLCases = []TestCase{
 			{
 				Sources: []types.Source{{
 					ImageSourceLocation: types.ImageSourceLocation{
-						Manifest:       "03-envoy.yaml",
-						ManifestDigest: "e83cd3f98ddbbd91374511c8ce1e437d938ffc8ea8d50bc6d4ccdbf224e53ed4",
+						Manifest:       "kustomize-build.yaml",
+						ManifestDigest: "5582b193f15fdb0b9f2ffe41da96c17c45c615d47fa658cd4758488a96e07506",
 						NodePath:       []string{"spec", "template", "spec", "initContainers", "image"},
-						Line:           110,
+						Line:           111,
 						Column:         16,
 					},
 					OriginalRef: "ghcr.io/projectcontour/contour:v1.24.1",
@@ -193,16 +381,15 @@ var baseYAMLCases = []TestCase{
 		Description: "flux",
 		Directory:   "manifest/testdata/flux",
 		Manifests: []string{
-			"flux.yaml",
-			"kustomization.yaml",
+			"kustomize-build.yaml",
 		},
 		NumRelatedTags: 2,
 		Expected: []types.Image{
 			{
 				Sources: []types.Source{{
 					ImageSourceLocation: types.ImageSourceLocation{
-						Manifest:       "flux.yaml",
-						ManifestDigest: "39ad63101dbb2ead069ca6185bd44f99f52b8513682d6002109c9b0db23f73b5",
+						Manifest:       "kustomize-build.yaml",
+						ManifestDigest: "93f1fc883274d1ce76d8c3ea55b6550cddb07c148b3d1504c63123826c29c80b",
 						NodePath:       []string{"spec", "template", "spec", "containers", "image"},
 						Line:           37,
 						Column:         16,
@@ -215,8 +402,8 @@ var baseYAMLCases = []TestCase{
 			{
 				Sources: []types.Source{{
 					ImageSourceLocation: types.ImageSourceLocation{
-						Manifest:       "flux.yaml",
-						ManifestDigest: "39ad63101dbb2ead069ca6185bd44f99f52b8513682d6002109c9b0db23f73b5",
+						Manifest:       "kustomize-build.yaml",
+						ManifestDigest: "93f1fc883274d1ce76d8c3ea55b6550cddb07c148b3d1504c63123826c29c80b",
 						NodePath:       []string{"spec", "template", "spec", "containers", "image"},
 						Line:           43,
 						Column:         16,
@@ -0,0 +1,95 @@
+package types
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	kustomize "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+	"sigs.k8s.io/yaml"
+)
+
+// ImageFieldSpecsConfig is the format of the file that provides additional image paths,
+// it's the same as `images` section of kustomize transformer configuration, e.g.:
+//
+//	images:
+//	- group: tekton.dev
+//	  kind: Task
+//	  path: spec/steps[]/image
+type ImageFieldSpecsConfig struct {
+	Images []kustomize.FieldSpec `json:"images"`
+}
+
+// LoadImageFieldSpecs reads additional image paths from a file
+func LoadImageFieldSpecs(path string) ([]kustomize.FieldSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read image field specs from %q: %w", path, err)
+	}
+	config := &ImageFieldSpecsConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("unable to parse image field specs in %q: %w", path, err)
+	}
+	for i := range config.Images {
+		if err := validateImageFieldSpec(config.Images[i]); err != nil {
+			return nil, fmt.Errorf("invalid image field spec in %q: %w", path, err)
+		}
+	}
+	return config.Images, nil
+}
+
+// ParseImageFieldSpec parses a field spec in the form of `[[<group>/]<version>/]<kind>:<path>`,
+// when only path is given it applies to all kinds
+func ParseImageFieldSpec(spec string) (kustomize.FieldSpec, error) {
+	fieldSpec := kustomize.FieldSpec{}
+	gvk, path, ok := strings.Cut(spec, ":")
+	if !ok {
+		path = spec
+	} else {
+		parts := strings.Split(gvk, "/")
+		switch len(parts) {
+		case 1:
+			fieldSpec.Gvk = resid.Gvk{Kind: parts[0]}
+		case 2:
+			fieldSpec.Gvk = resid.Gvk{Version: parts[0], Kind: parts[1]}
+		case 3:
+			fieldSpec.Gvk = resid.Gvk{Group: parts[0], Version: parts[1], Kind: parts[2]}
+		default:
+			return fieldSpec, fmt.Errorf("invalid image field spec %q: too many elements in group/version/kind", spec)
+		}
+		if fieldSpec.Kind == "" {
+			return fieldSpec, fmt.Errorf("invalid image field spec %q: kind must not be empty", spec)
+		}
+	}
+	fieldSpec.Path = path
+	if err := validateImageFieldSpec(fieldSpec); err != nil {
+		return fieldSpec, fmt.Errorf("invalid image field spec %q: %w", spec, err)
+	}
+	return fieldSpec, nil
+}
+
+func validateImageFieldSpec(fieldSpec kustomize.FieldSpec) error {
+	if strings.Trim(fieldSpec.Path, "/") == "" {
+		return fmt.Errorf("path must not be empty")
+	}
+	if fieldSpec.CreateIfNotPresent {
+		return fmt.Errorf("image fields cannot be created, `create` must not be set")
+	}
+	return nil
+}
+
+// ImagePathsWith returns default image paths along with the given ones, any paths
+// that are already covered by others are omitted, so that no image is visited twice
+func ImagePathsWith(fieldSpecs ...kustomize.FieldSpec) []kustomize.FieldSpec {
+	paths := ImagePaths()
+	for _, fieldSpec := range fieldSpecs {
+		if !slices.ContainsFunc(paths, func(path kustomize.FieldSpec) bool {
+			return path.Path == fieldSpec.Path && fieldSpec.IsSelected(&path.Gvk)
+		}) {
+			paths = append(paths, fieldSpec)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,61 @@
+package types_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	kustomize "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/resid"
+
+	. "github.com/docker/labs-brown-tape/manifest/types"
+)
+
+func TestParseImageFieldSpec(t *testing.T) {
+	valid := map[string]kustomize.FieldSpec{
+		"spec/image": {Path: "spec/image"},
+		"Task:spec/steps[]/image": {
+			Gvk:  resid.Gvk{Kind: "Task"},
+			Path: "spec/steps[]/image",
+		},
+		"v1/Pod:spec/containers[]/image": {
+			Gvk:  resid.Gvk{Version: "v1", Kind: "Pod"},
+			Path: "spec/containers[]/image",
+		},
+		"helm.toolkit.fluxcd.io/v2beta1/HelmRelease:spec/values/image": {
+			Gvk:  resid.Gvk{Group: "helm.toolkit.fluxcd.io", Version: "v2beta1", Kind: "HelmRelease"},
+			Path: "spec/values/image",
+		},
+	}
+	for spec, expected := range valid {
+		g := NewWithT(t)
+		fieldSpec, err := ParseImageFieldSpec(spec)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(fieldSpec).To(Equal(expected))
+	}
+
+	invalid := []string{
+		"",
+		"Task:",
+		":spec/image",
+		"a/b/c/Task:spec/image",
+	}
+	for _, spec := range invalid {
+		g := NewWithT(t)
+		_, err := ParseImageFieldSpec(spec)
+		g.Expect(err).To(HaveOccurred(), spec)
+	}
+}
+
+func TestImagePathsWith(t *testing.T) {
+	g := NewWithT(t)
+
+	defaults := ImagePaths()
+
+	extra := kustomize.FieldSpec{Gvk: resid.Gvk{Kind: "Task"}, Path: "spec/steps[]/image"}
+	covered := kustomize.FieldSpec{Gvk: resid.Gvk{Kind: "Rollout"}, Path: "spec/template/spec/containers[]/image"}
+
+	paths := ImagePathsWith(extra, covered, extra)
+	g.Expect(paths).To(HaveLen(len(defaults) + 1))
+	g.Expect(paths).To(ContainElement(extra))
+	g.Expect(paths).ToNot(ContainElement(covered))
+}
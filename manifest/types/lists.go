@@ -0,0 +1,41 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// IsList returns true for `kind: List` as well as any of `kind: *List` resources
+// that have items, e.g. `kind: PodList`
+func IsList(node *yaml.RNode) bool {
+	if !strings.HasSuffix(node.GetKind(), "List") {
+		return false
+	}
+	items := node.Field("items")
+	return items != nil && items.Value.YNode().Kind == yaml.SequenceNode
+}
+
+// ForEachResource calls fn for the given node, unless it's a list, in which case fn
+// is called for each of the items, with lists nested at any depth; kio readers only
+// unwrap a single top-level list, so this is needed for nested lists and multi-document
+// files that contain lists; field path of each item starts with all of the parent
+// `items` fields, the same way it would if `items[]` was in the field spec path
+func ForEachResource(node *yaml.RNode, fn func(*yaml.RNode) error) error {
+	if !IsList(node) {
+		return fn(node)
+	}
+	items, err := node.Field("items").Value.Elements()
+	if err != nil {
+		return fmt.Errorf("unable to get items of %s: %w", node.GetKind(), err)
+	}
+	fieldPath := append(append([]string{}, node.FieldPath()...), "items")
+	for _, item := range items {
+		item.AppendToFieldPath(fieldPath...)
+		if err := ForEachResource(item, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
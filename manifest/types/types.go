@@ -16,8 +16,11 @@ type (
 	Source              = image.Source
 	ImageSourceLocation = image.ImageSourceLocation
 	ImageList           = image.ImageList
+	DigestSource        = image.DigestSource
 )
 
+const DigestSourceUser = image.DigestSourceUser
+
 func NewImageList(dir string) *ImageList { return image.NewImageList(dir) }
 
 func ImagePaths() []kustomize.FieldSpec {
@@ -26,12 +29,6 @@ func ImagePaths() []kustomize.FieldSpec {
 		{Path: "spec/initContainers[]/image"},
 		{Path: "spec/template/spec/containers[]/image"},
 		{Path: "spec/template/spec/initContainers[]/image"},
-		// kustomize can process flat lists, but not nested lists,
-		// these paths enable 1 level of nesting
-		// TODO: find a better way to address it for arbitrary depths
-		{Path: "items[]/spec/containers[]/image"},
-		{Path: "items[]/spec/initContainers[]/image"},
-		{Path: "items[]/spec/template/spec/containers[]/image"},
-		{Path: "items[]/spec/template/spec/initContainers[]/image"},
+		// items of lists are handled by ForEachResource
 	}
 }
@@ -9,6 +9,7 @@ import (
 	kustomize "sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/kio"
 	"sigs.k8s.io/kustomize/kyaml/kio/kioutil"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
 
 	"github.com/docker/labs-brown-tape/attest/digest"
 	attestTypes "github.com/docker/labs-brown-tape/attest/types"
@@ -19,18 +20,27 @@ import (
 type Updater interface {
 	Update(*manifestTypes.ImageList) error
 	Mutations() attestTypes.Mutations
+	WithFieldSpecs(...kustomize.FieldSpec)
 }
 
 func NewFileUpdater() Updater {
 	return &FileUpdater{
 		hash:      sha256.New(),
 		mutations: attestTypes.Mutations{},
+		fsSlice:   types.ImagePaths(),
 	}
 }
 
 type FileUpdater struct {
 	hash      hash.Hash
 	mutations attestTypes.Mutations
+	fsSlice   kustomize.FsSlice
+}
+
+// WithFieldSpecs adds paths to look for images in, on top of the default ones,
+// it should be given the same field specs as the scanner
+func (u *FileUpdater) WithFieldSpecs(fieldSpecs ...kustomize.FieldSpec) {
+	u.fsSlice = types.ImagePathsWith(fieldSpecs...)
 }
 
 func (u *FileUpdater) Update(images *manifestTypes.ImageList) error {
@@ -54,6 +64,8 @@ func (u *FileUpdater) doUpdate(manifestPath string, images []types.Image) error
 		Inputs: []kio.Reader{
 			kio.LocalPackageReader{
 				PackagePath: manifestPath,
+				// JSON files are not read by default
+				MatchFilesGlob: kio.MatchAll,
 			},
 		},
 		Filters: make([]kio.Filter, len(images)),
@@ -73,20 +85,20 @@ func (u *FileUpdater) doUpdate(manifestPath string, images []types.Image) error
 	}
 
 	for i := range images {
-		pipeline.Filters[i] = imagetag.Filter{
+		pipeline.Filters[i] = listItemsFilter{imagetag.Filter{
 			ImageTag: kustomize.Image{
 				Name:    images[i].OriginalName,
 				NewName: images[i].NewName,
 				// NB: docs say NewTag is ignored when digest is set, but it's not true
 				NewTag: images[i].NewTag,
-				Digest: images[i].Digest,
+				Digest: images[i].CopiedDigest(),
 			},
 			// this is not optimal, however `(*yaml.RNode).FieldPath()` only returns a flat slice
 			// where `contianers[]` is presented as `containers` for some reason; but having
 			// a full list of search paths here shouldn't affect performance too much as it's only
 			// a short list
-			FsSlice: types.ImagePaths(),
-		}
+			FsSlice: u.fsSlice,
+		}}
 	}
 
 	if err := pipeline.Execute(); err != nil {
@@ -105,4 +117,21 @@ func (u *FileUpdater) doUpdate(manifestPath string, images []types.Image) error
 	return nil
 }
 
+// listItemsFilter applies the image tag filter to items of lists nested at any depth
+type listItemsFilter struct {
+	imageTag imagetag.Filter
+}
+
+func (f listItemsFilter) Filter(nodes []*yaml.RNode) ([]*yaml.RNode, error) {
+	for i := range nodes {
+		if err := types.ForEachResource(nodes[i], func(resource *yaml.RNode) error {
+			_, err := f.imageTag.Filter([]*yaml.RNode{resource})
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
+
 func (u *FileUpdater) Mutations() attestTypes.Mutations { return u.mutations }
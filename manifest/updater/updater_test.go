@@ -3,10 +3,13 @@ package updater_test
 import (
 	"context"
 	"crypto/sha256"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-containerregistry/pkg/crane"
 	. "github.com/onsi/gomega"
+	kustomize "sigs.k8s.io/kustomize/api/types"
 
 	"github.com/docker/labs-brown-tape/manifest/imagecopier"
 	"github.com/docker/labs-brown-tape/manifest/imageresolver"
@@ -122,3 +125,57 @@ func makeUpdaterTest(tc testdata.TestCase) func(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdaterWithNestedLists(t *testing.T) {
+	cases := testdata.NestedListCases()
+	cases.Run(t, ("../../"), makeOfflineUpdaterTest())
+}
+
+func TestUpdaterWithFieldSpecs(t *testing.T) {
+	fieldSpecs, err := types.LoadImageFieldSpecs(filepath.Join("../../", testdata.CustomResourceFieldSpecs))
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+
+	cases := testdata.CustomResourceCases()
+	cases.Run(t, ("../../"), makeOfflineUpdaterTest(fieldSpecs...))
+}
+
+// makeOfflineUpdaterTest sets new image references without resolving or copying
+// any images, so it only checks that all of the image fields get updated
+func makeOfflineUpdaterTest(fieldSpecs ...kustomize.FieldSpec) func(tc testdata.TestCase) func(t *testing.T) {
+	return func(tc testdata.TestCase) func(t *testing.T) {
+		return func(t *testing.T) {
+			g := NewWithT(t)
+
+			loader := loader.NewRecursiveManifestDirectoryLoader(tc.Directory)
+			g.Expect(loader.Load()).To(Succeed())
+
+			scanner := imagescanner.NewDefaultImageScanner()
+			scanner.WithFieldSpecs(fieldSpecs...)
+			g.Expect(scanner.Scan(loader.RelPaths())).To(Succeed())
+
+			images := scanner.GetImages()
+			g.Expect(images.Items()).To(HaveLen(len(tc.Expected)))
+
+			const newName = "registry.example.com/app"
+			digest := "sha256:" + strings.Repeat("a", 64)
+			for i := range images.Items() {
+				images.Items()[i].Digest = digest
+				images.Items()[i].NewName = newName
+			}
+
+			updater := NewFileUpdater()
+			updater.WithFieldSpecs(fieldSpecs...)
+			g.Expect(updater.Update(images)).To(Succeed())
+			g.Expect(updater.Mutations()).To(HaveLen(len(tc.Manifests)))
+
+			scanner.Reset()
+			g.Expect(scanner.Scan(loader.RelPaths())).To(Succeed())
+			updatedImages := scanner.GetImages()
+			g.Expect(updatedImages.Items()).To(HaveLen(len(tc.Expected)))
+			for _, image := range updatedImages.Items() {
+				g.Expect(image.OriginalName).To(Equal(newName))
+				g.Expect(image.Digest).To(Equal(digest))
+			}
+		}
+	}
+}
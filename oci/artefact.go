@@ -3,14 +3,18 @@ package oci
 import (
 	"archive/tar"
 	"bytes"
+	"cmp"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	ociclient "github.com/fluxcd/pkg/oci"
@@ -19,9 +23,11 @@ import (
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	typesv1 "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 
 	attestTypes "github.com/docker/labs-brown-tape/attest/types"
 	manifestTypes "github.com/docker/labs-brown-tape/manifest/types"
@@ -33,18 +39,39 @@ const (
 	ContentMediaType MediaType = mediaTypePrefix + ".content.v1alpha1.tar+gzip"
 	AttestMediaType  MediaType = mediaTypePrefix + ".attest.v1alpha1.jsonl+gzip"
 
+	SignedAttestMediaType MediaType = mediaTypePrefix + ".attest.dsse.v1alpha1.jsonl+gzip"
+
 	ContentInterpreterAnnotation   = mediaTypePrefix + ".content-interpreter.v1alpha1"
 	ContentInterpreterKubectlApply = mediaTypePrefix + ".kubectl-apply.v1alpha1.tar+gzip"
 
 	AttestationsSummaryAnnotation = mediaTypePrefix + ".attestations-summary.v1alpha1"
+	// AttestationsDigestAnnotation is set on the index and the attestations manifest, it's
+	// the digest of unsigned statements, so it's the same for identical attestations even
+	// when these are signed and signatures are randomized
+	AttestationsDigestAnnotation = mediaTypePrefix + ".attestations-digest.v1alpha1"
+	// AttestationsSignerAnnotation is set along with AttestationsDigestAnnotation when
+	// attestations are signed, it's the ID of the key that was used
+	AttestationsSignerAnnotation = mediaTypePrefix + ".attestations-signer.v1alpha1"
 
 	// TODO: content interpreter invocation with an image
 
 	regularFileMode = 0o640
 
+	reproducibleFileMode       = 0o644
+	reproducibleExecutableMode = 0o755
+
 	OCIManifestSchema1 = typesv1.OCIManifestSchema1
 )
 
+// AttestationsLayout determines where attestations are stored, either in the artefact
+// index next to the content, or in a separate manifest that refers to the index
+type AttestationsLayout string
+
+const (
+	AttestationsEmbedded AttestationsLayout = "embedded"
+	AttestationsReferrer AttestationsLayout = "referrer"
+)
+
 type ArtefactInfo struct {
 	io.ReadCloser
 
@@ -53,13 +80,189 @@ type ArtefactInfo struct {
 	Digest      string
 }
 
+// Fetch returns artefacts from the index or the image, as well as from any of its referrers
 func (c *Client) Fetch(ctx context.Context, ref string, mediaTypes ...MediaType) ([]*ArtefactInfo, error) {
 	imageIndex, indexManifest, image, err := c.GetIndexOrImage(ctx, ref)
 	if err != nil {
 		return nil, err
 	}
 	artefactInfo, _, err := c.FetchFromIndexOrImage(ctx, imageIndex, indexManifest, image, mediaTypes...)
-	return artefactInfo, err
+	if err != nil {
+		return nil, err
+	}
+	digest, err := digestOf(imageIndex, image)
+	if err != nil {
+		return nil, err
+	}
+	referrersInfo, _, err := c.FetchReferrers(ctx, ref, digest.String(), mediaTypes...)
+	if err != nil {
+		return nil, err
+	}
+	artefactInfo = append(artefactInfo, referrersInfo...)
+	if err := CheckAttestationsLayers(ref, artefactInfo); err != nil {
+		for _, info := range artefactInfo {
+			_ = info.Close()
+		}
+		return nil, err
+	}
+	return artefactInfo, nil
+}
+
+// CheckAttestationsLayers returns an error when artefacts have more than one attestations
+// layer, e.g. when attestations are embedded in the index and also attached as a referrer,
+// as it's not possible to tell which of these is meant to be used
+func CheckAttestationsLayers(ref string, artefacts []*ArtefactInfo) error {
+	numAttestationsLayers := 0
+	for _, artefact := range artefacts {
+		if artefact.MediaType == AttestMediaType || artefact.MediaType == SignedAttestMediaType {
+			numAttestationsLayers++
+		}
+	}
+	if numAttestationsLayers > 1 {
+		return fmt.Errorf("%q has %d attestations layers, expected only one", ref, numAttestationsLayers)
+	}
+	return nil
+}
+
+// FetchReferrers returns artefacts from manifests that refer to the given digest as
+// the subject, ref may point to any image in the same repository or OCI layout; referrers
+// are returned in order of their digests, and of attestations referrers only the one that
+// belongs to the subject is returned
+func (c *Client) FetchReferrers(ctx context.Context, ref, digest string, mediaTypes ...MediaType) ([]*ArtefactInfo, map[Hash]*Manifest, error) {
+	images, manifests, err := c.getReferrers(ctx, ref, digest, mediaTypes...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.selectAttestationsReferrer(ctx, ref, digest, manifests); err != nil {
+		return nil, nil, err
+	}
+	referrerDigests := make([]Hash, 0, len(manifests))
+	for referrerDigest := range manifests {
+		referrerDigests = append(referrerDigests, referrerDigest)
+	}
+	slices.SortFunc(referrerDigests, func(a, b Hash) int {
+		return cmp.Compare(a.String(), b.String())
+	})
+	artefacts := []*ArtefactInfo{}
+	for _, referrerDigest := range referrerDigests {
+		manifest := manifests[referrerDigest]
+		for _, layerDescriptor := range manifest.Layers {
+			if len(mediaTypes) > 0 && !slices.Contains(mediaTypes, layerDescriptor.MediaType) {
+				continue
+			}
+			info, err := newArtifcatInfoFromLayerDescriptor(images[referrerDigest], layerDescriptor, manifest.Annotations)
+			if err != nil {
+				return nil, nil, err
+			}
+			artefacts = append(artefacts, info)
+		}
+	}
+	return artefacts, manifests, nil
+}
+
+// selectAttestationsReferrer removes attestations referrers other than the one with the same
+// attestations digest as the subject, when there are several identical ones the first by digest
+// is kept; for subjects without the annotation, only a single attestations referrer is allowed
+func (c *Client) selectAttestationsReferrer(ctx context.Context, ref, digest string, manifests map[Hash]*Manifest) error {
+	attestations := []Hash{}
+	for referrerDigest, manifest := range manifests {
+		if manifest.Config.MediaType == AttestMediaType || manifest.Config.MediaType == SignedAttestMediaType {
+			attestations = append(attestations, referrerDigest)
+		}
+	}
+	if len(attestations) == 0 {
+		return nil
+	}
+	slices.SortFunc(attestations, func(a, b Hash) int {
+		return cmp.Compare(a.String(), b.String())
+	})
+
+	repo, err := repositoryOf(ref)
+	if err != nil {
+		return err
+	}
+	_, indexManifest, image, err := c.GetIndexOrImage(ctx, repo+"@"+digest)
+	if err != nil {
+		return err
+	}
+	var annotations map[string]string
+	if indexManifest != nil {
+		annotations = indexManifest.Annotations
+	} else {
+		manifest, err := image.Manifest()
+		if err != nil {
+			return fmt.Errorf("failed to get manifest for %q: %w", digest, err)
+		}
+		annotations = manifest.Annotations
+	}
+
+	selected := attestations[0]
+	if expected, ok := annotations[AttestationsDigestAnnotation]; ok {
+		matching := slices.IndexFunc(attestations, func(referrerDigest Hash) bool {
+			return manifests[referrerDigest].Annotations[AttestationsDigestAnnotation] == expected
+		})
+		if matching == -1 {
+			return fmt.Errorf("none of %d attestations referrers of %s have the expected attestations digest %s", len(attestations), digest, expected)
+		}
+		selected = attestations[matching]
+	} else if len(attestations) > 1 {
+		return fmt.Errorf("%s has %d attestations referrers and no attestations digest to choose one by", digest, len(attestations))
+	}
+
+	for _, referrerDigest := range attestations {
+		if referrerDigest != selected {
+			delete(manifests, referrerDigest)
+		}
+	}
+	return nil
+}
+
+// getReferrers returns images and manifests of referrers with one of the artifact types
+func (c *Client) getReferrers(ctx context.Context, ref, digest string, artifactTypes ...MediaType) (map[Hash]Image, map[Hash]*Manifest, error) {
+	repo, err := repositoryOf(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	referrers, err := c.ListReferrers(ctx, repo, digest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list referrers of %s: %w", digest, err)
+	}
+	images := map[Hash]Image{}
+	manifests := map[Hash]*Manifest{}
+	for _, referrer := range referrers {
+		if len(artifactTypes) > 0 && !slices.Contains(artifactTypes, MediaType(referrer.ArtifactType)) {
+			continue
+		}
+		_, _, image, err := c.GetIndexOrImage(ctx, repo+"@"+referrer.Digest.String())
+		if err != nil {
+			return nil, nil, err
+		}
+		if image == nil {
+			continue
+		}
+		manifest, err := image.Manifest()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get manifest for %q: %w", referrer.Digest.String(), err)
+		}
+		images[referrer.Digest], manifests[referrer.Digest] = image, manifest
+	}
+	return images, manifests, nil
+}
+
+// repositoryOf returns reference to the repository or OCI layout without tag and digest
+func repositoryOf(ref string) (string, error) {
+	if IsLayoutRef(ref) {
+		layoutRef, err := ParseLayoutRef(ref)
+		if err != nil {
+			return "", err
+		}
+		return LayoutRef{Path: layoutRef.Path}.String(), nil
+	}
+	parsedRef, err := name.ParseReference(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", ref, err)
+	}
+	return parsedRef.Context().String(), nil
 }
 
 func (c *Client) FetchFromIndexOrImage(ctx context.Context, imageIndex ImageIndex, indexManifest *IndexManifest, image Image, mediaTypes ...MediaType) ([]*ArtefactInfo, map[Hash]*Manifest, error) {
@@ -153,6 +356,29 @@ func (c *Client) GetSingleArtefact(ctx context.Context, ref string) (*ArtefactIn
 	return newArtifcatInfoFromLayerDescriptor(image, layers[0], annotations)
 }
 
+// GetArtefacts returns all layers of an artefact, annotations of each layer are merged
+// with annotations of the manifest, as some tools (e.g. cosign) set these on layers
+func (c *Client) GetArtefacts(ctx context.Context, ref string) ([]*ArtefactInfo, error) {
+	image, layers, annotations, err := c.getFlatArtefactLayers(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	artefacts := make([]*ArtefactInfo, 0, len(layers))
+	for _, layer := range layers {
+		layerAnnotations := maps.Clone(annotations)
+		if layerAnnotations == nil {
+			layerAnnotations = make(map[string]string, len(layer.Annotations))
+		}
+		maps.Copy(layerAnnotations, layer.Annotations)
+		info, err := newArtifcatInfoFromLayerDescriptor(image, layer, layerAnnotations)
+		if err != nil {
+			return nil, err
+		}
+		artefacts = append(artefacts, info)
+	}
+	return artefacts, nil
+}
+
 func newArtifcatInfoFromLayerDescriptor(image Image, layerDecriptor Descriptor, annotations map[string]string) (*ArtefactInfo, error) {
 	layer, err := image.LayerByDigest(layerDecriptor.Digest)
 	if err != nil {
@@ -216,7 +442,11 @@ func (c *Client) getImage(ctx context.Context, imageIndex ImageIndex, digest Has
 }
 
 // based on https://github.com/fluxcd/pkg/blob/2a323d771e17af02dee2ccbbb9b445b78ab048e5/oci/client/push.go
-func (c *Client) PushArtefact(ctx context.Context, destinationRef, sourceDir string, timestamp *time.Time, sourceAttestations ...attestTypes.Statement) (string, error) {
+// unless force is set, pushing is skipped when the content hash tag already points to an
+// artefact with the same content and attestations, and reference to that artefact is returned;
+// when reproducible is set, the content is built in strict reproducible mode; with referrer
+// layout attestations are pushed separately with the index as the subject
+func (c *Client) PushArtefact(ctx context.Context, destinationRef, sourceDir string, timestamp *time.Time, signer dsse.SignerVerifier, force, reproducible bool, attestationsLayout AttestationsLayout, sourceAttestations ...attestTypes.Statement) (string, error) {
 	tmpDir, err := os.MkdirTemp("", "bpt-oci-artefact-*")
 	if err != nil {
 		return "", err
@@ -235,22 +465,43 @@ func (c *Client) PushArtefact(ctx context.Context, destinationRef, sourceDir str
 
 	output := io.MultiWriter(outputFile, c.hash)
 
-	if err := c.BuildArtefact(tmpFile, sourceDir, output); err != nil {
+	if err := c.BuildArtefact(tmpFile, sourceDir, output, reproducible); err != nil {
 		return "", err
 	}
 
-	attestLayer, err := c.BuildAttestations(sourceAttestations)
+	attestLayer, attestMediaType, err := c.BuildAttestations(ctx, sourceAttestations, signer)
 	if err != nil {
 		return "", fmt.Errorf("failed to serialise attestations: %w", err)
 	}
 
-	repo, err := name.NewRepository(destinationRef)
-	if err != nil {
-		return "", fmt.Errorf("invalid URL: %w", err)
+	attestations := expectedAttestations{mediaType: attestMediaType, layout: attestationsLayout}
+	if attestLayer != nil {
+		digest, err := attestTypes.Statements(sourceAttestations).Digest()
+		if err != nil {
+			return "", err
+		}
+		attestations.digest = "sha256:" + digest.String()
+		if signer != nil {
+			attestations.signerKeyID, err = signer.KeyID()
+			if err != nil {
+				return "", fmt.Errorf("unable to get ID of the signing key: %w", err)
+			}
+		}
 	}
+
 	hash := hex.EncodeToString(c.hash.Sum(nil))
-	tag := repo.Tag(manifestTypes.ConfigImageTagPrefix + hash)
-	tagAlias := tag.Context().Tag(manifestTypes.ConfigImageTagPrefix + hash[:7])
+	tag := manifestTypes.ConfigImageTagPrefix + hash
+	tagAlias := manifestTypes.ConfigImageTagPrefix + hash[:7]
+
+	if !force {
+		ref, err := c.pushExistingArtefact(ctx, destinationRef, tag, tagAlias, hash, attestations)
+		if err != nil {
+			return "", err
+		}
+		if ref != "" {
+			return ref, nil
+		}
+	}
 
 	if timestamp == nil {
 		timestamp = new(time.Time)
@@ -261,13 +512,20 @@ func (c *Client) PushArtefact(ctx context.Context, destinationRef, sourceDir str
 		ociclient.CreatedAnnotation: timestamp.Format(time.RFC3339),
 	}
 
+	configAnnotations := maps.Clone(indexAnnotations)
+
+	if attestations.digest != "" {
+		indexAnnotations[AttestationsDigestAnnotation] = attestations.digest
+	}
+	if attestations.signerKeyID != "" {
+		indexAnnotations[AttestationsSignerAnnotation] = attestations.signerKeyID
+	}
+
 	index := mutate.Annotations(
 		empty.Index,
 		indexAnnotations,
 	).(ImageIndex)
 
-	configAnnotations := maps.Clone(indexAnnotations)
-
 	configAnnotations[ContentInterpreterAnnotation] = ContentInterpreterKubectlApply
 
 	config := mutate.Annotations(
@@ -302,6 +560,7 @@ func (c *Client) PushArtefact(ctx context.Context, destinationRef, sourceDir str
 		},
 	)
 
+	var attestReferrer Image
 	if attestLayer != nil {
 		attestAnnotations := maps.Clone(indexAnnotations)
 
@@ -314,7 +573,7 @@ func (c *Client) PushArtefact(ctx context.Context, destinationRef, sourceDir str
 		attest := mutate.Annotations(
 			mutate.ConfigMediaType(
 				mutate.MediaType(empty.Image, OCIManifestSchema1),
-				AttestMediaType,
+				attestMediaType,
 			),
 			attestAnnotations,
 		).(Image)
@@ -324,12 +583,16 @@ func (c *Client) PushArtefact(ctx context.Context, destinationRef, sourceDir str
 			return "", fmt.Errorf("appeding attestations to artifact failed: %w", err)
 		}
 
-		index = mutate.AppendManifests(index,
-			mutate.IndexAddendum{
-				Descriptor: makeDescriptorWithPlatform(),
-				Add:        attest,
-			},
-		)
+		if attestationsLayout == AttestationsReferrer {
+			attestReferrer = attest
+		} else {
+			index = mutate.AppendManifests(index,
+				mutate.IndexAddendum{
+					Descriptor: makeDescriptorWithPlatform(),
+					Add:        attest,
+				},
+			)
+		}
 	}
 
 	digest, err := index.Digest()
@@ -337,15 +600,174 @@ func (c *Client) PushArtefact(ctx context.Context, destinationRef, sourceDir str
 		return "", fmt.Errorf("parsing index digest failed: %w", err)
 	}
 
-	if err := remote.WriteIndex(tag, index, c.remoteWithContext(ctx)...); err != nil {
+	if attestReferrer != nil {
+		indexDescriptor, err := partial.Descriptor(index)
+		if err != nil {
+			return "", fmt.Errorf("creating index descriptor failed: %w", err)
+		}
+		attestReferrer = mutate.Subject(attestReferrer, *indexDescriptor).(Image)
+	}
+
+	// attestations are pushed first, so that these can be found as soon as the artefact is tagged
+	if IsLayoutRef(destinationRef) {
+		layoutRef, err := ParseLayoutRef(destinationRef)
+		if err != nil {
+			return "", err
+		}
+		if attestReferrer != nil {
+			attestDigest, err := attestReferrer.Digest()
+			if err != nil {
+				return "", err
+			}
+			if err := (LayoutRef{Path: layoutRef.Path, Digest: attestDigest.String()}).write(nil, attestReferrer); err != nil {
+				return "", fmt.Errorf("writing attestations failed: %w", err)
+			}
+		}
+		if err := layoutRef.WithTag(tag).write(index, nil); err != nil {
+			return "", fmt.Errorf("writing index failed: %w", err)
+		}
+		if err := layoutRef.WithTag(tagAlias).write(index, nil); err != nil {
+			return "", fmt.Errorf("adding alias tagging failed: %w", err)
+		}
+		return layoutRef.WithTag(tagAlias).String() + "@" + digest.String(), nil
+	}
+
+	repo, err := name.NewRepository(destinationRef)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+
+	if attestReferrer != nil {
+		attestDigest, err := attestReferrer.Digest()
+		if err != nil {
+			return "", err
+		}
+		if err := remote.Write(repo.Digest(attestDigest.String()), attestReferrer, c.remoteWithContext(ctx)...); err != nil {
+			return "", fmt.Errorf("pushing attestations failed: %w", err)
+		}
+	}
+
+	if err := remote.WriteIndex(repo.Tag(tag), index, c.remoteWithContext(ctx)...); err != nil {
 		return "", fmt.Errorf("pushing index failed: %w", err)
 	}
 
-	if err := remote.Tag(tagAlias, index, c.remoteWithContext(ctx)...); err != nil {
+	if err := remote.Tag(repo.Tag(tagAlias), index, c.remoteWithContext(ctx)...); err != nil {
+		return "", fmt.Errorf("adding alias tagging failed: %w", err)
+	}
+
+	return repo.Tag(tagAlias).String() + "@" + digest.String(), err
+}
+
+// expectedAttestations describes attestations of the artefact that is being pushed,
+// digest is empty when there are no attestations
+type expectedAttestations struct {
+	digest      string
+	mediaType   MediaType
+	signerKeyID string
+	layout      AttestationsLayout
+}
+
+// pushExistingArtefact checks if the tag already points to an index with the given content
+// and attestations, if it does the alias tag is added and reference is returned
+func (c *Client) pushExistingArtefact(ctx context.Context, destinationRef, tag, tagAlias, contentHash string, attestations expectedAttestations) (string, error) {
+	if IsLayoutRef(destinationRef) {
+		layoutRef, err := ParseLayoutRef(destinationRef)
+		if err != nil {
+			return "", err
+		}
+		index := c.findArtefact(ctx, layoutRef.WithTag(tag).String(), contentHash, attestations)
+		if index == nil {
+			return "", nil
+		}
+		digest, err := index.Digest()
+		if err != nil {
+			return "", fmt.Errorf("parsing index digest failed: %w", err)
+		}
+		if err := layoutRef.WithTag(tagAlias).write(index, nil); err != nil {
+			return "", fmt.Errorf("adding alias tagging failed: %w", err)
+		}
+		return layoutRef.WithTag(tagAlias).String() + "@" + digest.String(), nil
+	}
+
+	repo, err := name.NewRepository(destinationRef)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %w", err)
+	}
+	index := c.findArtefact(ctx, repo.Tag(tag).String(), contentHash, attestations)
+	if index == nil {
+		return "", nil
+	}
+	digest, err := index.Digest()
+	if err != nil {
+		return "", fmt.Errorf("parsing index digest failed: %w", err)
+	}
+	if err := remote.Tag(repo.Tag(tagAlias), index, c.remoteWithContext(ctx)...); err != nil {
 		return "", fmt.Errorf("adding alias tagging failed: %w", err)
 	}
+	return repo.Tag(tagAlias).String() + "@" + digest.String(), nil
+}
 
-	return tagAlias.String() + "@" + digest.String(), err
+// findArtefact returns the index that ref points to, if it has the given content and
+// attestations in the same layout; attestations are compared by the digest annotation,
+// as layers of signed attestations are different every time, along with media type and
+// signing key, so that signing or using a different key results in a new artefact;
+// lookup errors are not returned, as the artefact is pushed in that case and any
+// persistent problem with the destination surfaces then
+func (c *Client) findArtefact(ctx context.Context, ref, contentHash string, attestations expectedAttestations) ImageIndex {
+	imageIndex, indexManifest, _, err := c.GetIndexOrImage(ctx, ref)
+	if err != nil || imageIndex == nil {
+		return nil
+	}
+	if indexManifest.Annotations[AttestationsDigestAnnotation] != attestations.digest ||
+		indexManifest.Annotations[AttestationsSignerAnnotation] != attestations.signerKeyID {
+		return nil
+	}
+	contentLayers, attestLayers := []string{}, []Descriptor{}
+	for _, descriptor := range indexManifest.Manifests {
+		_, manifest, err := c.getImage(ctx, imageIndex, descriptor.Digest)
+		if err != nil {
+			return nil
+		}
+		for _, layer := range manifest.Layers {
+			if layer.MediaType == AttestMediaType || layer.MediaType == SignedAttestMediaType {
+				attestLayers = append(attestLayers, layer)
+			} else {
+				contentLayers = append(contentLayers, layer.Digest.String())
+			}
+		}
+	}
+	if !slices.Equal(contentLayers, []string{"sha256:" + contentHash}) {
+		return nil
+	}
+	embedded := attestations.digest != "" && attestations.layout != AttestationsReferrer
+	if embedded != (len(attestLayers) == 1) {
+		return nil
+	}
+	if attestations.digest == "" {
+		return imageIndex
+	}
+	if embedded {
+		if attestLayers[0].MediaType != attestations.mediaType {
+			return nil
+		}
+		return imageIndex
+	}
+	digest, err := imageIndex.Digest()
+	if err != nil {
+		return nil
+	}
+	_, manifests, err := c.getReferrers(ctx, ref, digest.String(), AttestMediaType, SignedAttestMediaType)
+	if err != nil {
+		return nil
+	}
+	for _, manifest := range manifests {
+		if manifest.Annotations[AttestationsDigestAnnotation] == attestations.digest &&
+			manifest.Annotations[AttestationsSignerAnnotation] == attestations.signerKeyID &&
+			manifest.Config.MediaType == attestations.mediaType {
+			return imageIndex
+		}
+	}
+	return nil
 }
 
 func makeDescriptorWithPlatform() Descriptor {
@@ -357,9 +779,23 @@ func makeDescriptorWithPlatform() Descriptor {
 	}
 }
 
+// ContentDigest returns digest of the content layer that PushArtefact would build
+// from the given dir, without writing it anywhere
+func (c *Client) ContentDigest(sourceDir string, reproducible bool) (string, error) {
+	hash := sha256.New()
+	if err := c.BuildArtefact("", sourceDir, hash, reproducible); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
 // based on https://github.com/fluxcd/pkg/blob/2a323d771e17af02dee2ccbbb9b445b78ab048e5/oci/client/build.go
+//
+// in reproducible mode, entries are sorted by name, file modes are normalised and gzip
+// compression level is set explicitly, so that the output only depends on names and
+// contents of the files
 func (c *Client) BuildArtefact(artifactPath,
-	sourceDir string, output io.Writer) error {
+	sourceDir string, output io.Writer, reproducible bool) error {
 	absDir, err := filepath.Abs(sourceDir)
 	if err != nil {
 		return err
@@ -370,8 +806,11 @@ func (c *Client) BuildArtefact(artifactPath,
 		return fmt.Errorf("invalid source dir path: %s", absDir)
 	}
 
-	gw := gzip.NewWriter(output)
-	tw := tar.NewWriter(gw)
+	type entry struct {
+		path   string
+		header *tar.Header
+	}
+	entries := []entry{}
 	if err := filepath.WalkDir(absDir, func(p string, di os.DirEntry, prevErr error) (err error) {
 		if prevErr != nil {
 			return prevErr
@@ -415,28 +854,41 @@ func (c *Client) BuildArtefact(artifactPath,
 		header.AccessTime = time.Time{}
 		header.ChangeTime = time.Time{}
 
-		if err := tw.WriteHeader(header); err != nil {
-			return err
+		if reproducible {
+			header.Mode = reproducibleFileMode
+			if ft.IsDir() || fi.Mode()&0o111 != 0 {
+				header.Mode = reproducibleExecutableMode
+			}
+			header.Devmajor = 0
+			header.Devminor = 0
+			header.PAXRecords = nil
 		}
 
-		if !ft.IsRegular() {
-			return nil
-		}
+		entries = append(entries, entry{path: p, header: header})
+		return nil
+	}); err != nil {
+		return err
+	}
 
-		file, err := os.Open(p)
-		if err != nil {
-			return err
-		}
-		defer ioutil.CheckClose(file, &err)
+	if reproducible {
+		slices.SortFunc(entries, func(a, b entry) int { return strings.Compare(a.header.Name, b.header.Name) })
+	}
 
-		if _, err := io.Copy(tw, file); err != nil {
+	level := gzip.DefaultCompression
+	if reproducible {
+		level = gzip.BestCompression
+	}
+	gw, err := gzip.NewWriterLevel(output, level)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gw)
+	for _, entry := range entries {
+		if err := writeTarEntry(tw, entry.path, entry.header); err != nil {
+			_ = tw.Close()
+			_ = gw.Close()
 			return err
 		}
-		return nil
-	}); err != nil {
-		_ = tw.Close()
-		_ = gw.Close()
-		return err
 	}
 
 	if err := tw.Close(); err != nil {
@@ -450,19 +902,50 @@ func (c *Client) BuildArtefact(artifactPath,
 	return nil
 }
 
-func (c *Client) BuildAttestations(statements []attestTypes.Statement) (Layer, error) {
+func writeTarEntry(tw *tar.Writer, path string, header *tar.Header) (err error) {
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer ioutil.CheckClose(file, &err)
+
+	if _, err := io.Copy(tw, file); err != nil {
+		return err
+	}
+	return nil
+}
+
+// BuildAttestations serialises statements as gzipped JSONL, when signer is set
+// each statement is wrapped in a DSSE envelope and signed media type is used
+func (c *Client) BuildAttestations(ctx context.Context, statements []attestTypes.Statement, signer dsse.SignerVerifier) (Layer, MediaType, error) {
 	if len(statements) == 0 {
-		return nil, nil
+		return nil, "", nil
 	}
 	output := bytes.NewBuffer(nil)
 	gw := gzip.NewWriter(output)
 
-	if err := attestTypes.Statements(statements).Encode(gw); err != nil {
-		return nil, err
+	mediaType := AttestMediaType
+	if signer != nil {
+		mediaType = SignedAttestMediaType
+		if err := attestTypes.Statements(statements).EncodeSigned(ctx, gw, signer); err != nil {
+			return nil, "", err
+		}
+	} else {
+		if err := attestTypes.Statements(statements).Encode(gw); err != nil {
+			return nil, "", err
+		}
 	}
 
 	if err := gw.Close(); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	layer, err := tarball.LayerFromOpener(
@@ -470,13 +953,13 @@ func (c *Client) BuildAttestations(statements []attestTypes.Statement) (Layer, e
 			// this doesn't copy data, it should re-use same undelying slice
 			return io.NopCloser(bytes.NewReader(output.Bytes())), nil
 		},
-		tarball.WithMediaType(AttestMediaType),
+		tarball.WithMediaType(mediaType),
 		tarball.WithCompression(compression.GZip),
 		tarball.WithCompressedCaching,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("creating attestations layer failed: %w", err)
+		return nil, "", fmt.Errorf("creating attestations layer failed: %w", err)
 	}
 
-	return layer, nil
+	return layer, mediaType, nil
 }
@@ -0,0 +1,238 @@
+package oci_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	. "github.com/onsi/gomega"
+
+	"github.com/docker/labs-brown-tape/attest/digest"
+	"github.com/docker/labs-brown-tape/attest/manifest"
+	"github.com/docker/labs-brown-tape/attest/signer"
+	attestTypes "github.com/docker/labs-brown-tape/attest/types"
+	. "github.com/docker/labs-brown-tape/oci"
+	"github.com/docker/labs-brown-tape/trex"
+)
+
+func TestPushArtefactWithReferrerAttestations(t *testing.T) {
+	trex.RunShared()
+	withReferrers := trex.New(0).WithReferrers()
+	withReferrers.RunInBackground(context.Background())
+
+	sourceDir := t.TempDir()
+	g := NewWithT(t)
+	g.Expect(os.WriteFile(filepath.Join(sourceDir, "cm.yaml"), []byte("kind: ConfigMap\n"), 0o644)).To(Succeed())
+	statement := manifest.MakeKustomizationStatement(
+		attestTypes.MakeSubject("cm.yaml", digest.SHA256("c1a5e4b9ee3bbb0bd4b16b4c4e3c7dcaf2bba2d6c6c2a1f2f2b9d2a3b6e4f0a1")), ".", nil)
+
+	destinations := map[string]func() (string, *Client){
+		"layout": func() (string, *Client) {
+			return LayoutRef{Path: filepath.Join(t.TempDir(), "layout")}.String(), NewClient(nil)
+		},
+		"tag-schema": func() (string, *Client) {
+			return trex.Shared.NewUniqueRepoNamer("bpt-referrer-attest-test")("dst"), NewClient(trex.Shared.CraneOptions())
+		},
+		"referrers-api": func() (string, *Client) {
+			return withReferrers.NewUniqueRepoNamer("bpt-referrer-attest-test")("dst"), NewClient(withReferrers.CraneOptions())
+		},
+	}
+	for description, makeDestination := range destinations {
+		makeDestination := makeDestination
+		t.Run(description, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+			destination, client := makeDestination()
+
+			ref, err := client.PushArtefact(ctx, destination, sourceDir, nil, nil, false, false, AttestationsReferrer, statement)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			// index only has the content, attestations are found via referrers
+			_, indexManifest, _, err := client.GetIndexOrImage(ctx, ref)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(indexManifest.Manifests).To(HaveLen(1))
+
+			for _, mediaType := range []MediaType{ContentMediaType, AttestMediaType} {
+				artefacts, err := client.Fetch(ctx, ref, mediaType)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(artefacts).To(HaveLen(1))
+				g.Expect(artefacts[0].MediaType).To(Equal(mediaType))
+				g.Expect(artefacts[0].Close()).To(Succeed())
+			}
+
+			existingRef, err := client.PushArtefact(ctx, destination, sourceDir, nil, nil, false, false, AttestationsReferrer, statement)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(existingRef).To(Equal(ref))
+
+			// embedded attestations make a different index
+			embeddedRef, err := client.PushArtefact(ctx, destination, sourceDir, nil, nil, false, false, AttestationsEmbedded, statement)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(embeddedRef).ToNot(Equal(ref))
+			artefacts, err := client.Fetch(ctx, embeddedRef, AttestMediaType)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(artefacts).To(HaveLen(1))
+			g.Expect(artefacts[0].Close()).To(Succeed())
+		})
+	}
+}
+
+func TestPushArtefactWithSignedAttestations(t *testing.T) {
+	g := NewWithT(t)
+
+	// ECDSA signatures are randomized, so layers are different every time
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	signerVerifier, err := signer.NewSignerVerifier(key)
+	g.Expect(err).ToNot(HaveOccurred())
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	otherSignerVerifier, err := signer.NewSignerVerifier(otherKey)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	sourceDir := t.TempDir()
+	g.Expect(os.WriteFile(filepath.Join(sourceDir, "cm.yaml"), []byte("kind: ConfigMap\n"), 0o644)).To(Succeed())
+	makeStatement := func(kustomization string) attestTypes.Statement {
+		return manifest.MakeKustomizationStatement(
+			attestTypes.MakeSubject("cm.yaml", digest.SHA256("c1a5e4b9ee3bbb0bd4b16b4c4e3c7dcaf2bba2d6c6c2a1f2f2b9d2a3b6e4f0a1")), kustomization, nil)
+	}
+
+	for _, attestationsLayout := range []AttestationsLayout{AttestationsEmbedded, AttestationsReferrer} {
+		attestationsLayout := attestationsLayout
+		t.Run(string(attestationsLayout), func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+			client := NewClient(nil)
+			destination := LayoutRef{Path: filepath.Join(t.TempDir(), "layout")}.String()
+
+			// unsigned artefact with the same statements doesn't count as existing
+			unsignedRef, err := client.PushArtefact(ctx, destination, sourceDir, nil, nil, false, false, attestationsLayout, makeStatement("kustomization.yaml"))
+			g.Expect(err).ToNot(HaveOccurred())
+
+			ref, err := client.PushArtefact(ctx, destination, sourceDir, nil, signerVerifier, false, false, attestationsLayout, makeStatement("kustomization.yaml"))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(ref).ToNot(Equal(unsignedRef))
+
+			_, indexManifest, _, err := client.GetIndexOrImage(ctx, ref)
+			g.Expect(err).ToNot(HaveOccurred())
+			expectedDigest, err := attestTypes.Statements{makeStatement("kustomization.yaml")}.Digest()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(indexManifest.Annotations).To(HaveKeyWithValue(AttestationsDigestAnnotation, "sha256:"+expectedDigest.String()))
+			keyID, err := signerVerifier.KeyID()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(indexManifest.Annotations).To(HaveKeyWithValue(AttestationsSignerAnnotation, keyID))
+
+			artefacts, err := client.Fetch(ctx, ref, AttestMediaType, SignedAttestMediaType)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(artefacts).To(HaveLen(1))
+			g.Expect(artefacts[0].MediaType).To(Equal(SignedAttestMediaType))
+			g.Expect(artefacts[0].Close()).To(Succeed())
+
+			existingRef, err := client.PushArtefact(ctx, destination, sourceDir, nil, signerVerifier, false, false, attestationsLayout, makeStatement("kustomization.yaml"))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(existingRef).To(Equal(ref))
+
+			changedRef, err := client.PushArtefact(ctx, destination, sourceDir, nil, signerVerifier, false, false, attestationsLayout, makeStatement("other/kustomization.yaml"))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(changedRef).ToNot(Equal(ref))
+
+			// signing with a different key results in a new artefact
+			otherRef, err := client.PushArtefact(ctx, destination, sourceDir, nil, otherSignerVerifier, false, false, attestationsLayout, makeStatement("kustomization.yaml"))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(otherRef).ToNot(Equal(ref))
+			g.Expect(otherRef).ToNot(Equal(unsignedRef))
+		})
+	}
+}
+
+func TestFetchAttestationsReferrer(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	client := NewClient(nil)
+
+	sourceDir := t.TempDir()
+	g.Expect(os.WriteFile(filepath.Join(sourceDir, "cm.yaml"), []byte("kind: ConfigMap\n"), 0o644)).To(Succeed())
+	statement := manifest.MakeKustomizationStatement(
+		attestTypes.MakeSubject("cm.yaml", digest.SHA256("c1a5e4b9ee3bbb0bd4b16b4c4e3c7dcaf2bba2d6c6c2a1f2f2b9d2a3b6e4f0a1")), ".", nil)
+
+	// attachAttestations adds an attestations referrer with the given digest annotation to the subject
+	attachAttestations := func(layoutPath string, subject ImageIndex, attestationsDigest string) {
+		layer, mediaType, err := client.BuildAttestations(ctx, attestTypes.Statements{statement}, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		subjectDescriptor, err := partial.Descriptor(subject)
+		g.Expect(err).ToNot(HaveOccurred())
+		referrer := mutate.Annotations(
+			mutate.ConfigMediaType(mutate.MediaType(empty.Image, OCIManifestSchema1), mediaType),
+			map[string]string{AttestationsDigestAnnotation: attestationsDigest},
+		).(Image)
+		referrer, err = mutate.Append(referrer, mutate.Addendum{Layer: layer})
+		g.Expect(err).ToNot(HaveOccurred())
+		referrer = mutate.Subject(referrer, *subjectDescriptor).(Image)
+		g.Expect(layout.Path(layoutPath).AppendImage(referrer)).To(Succeed())
+	}
+
+	fetchAttestations := func(ref string) ([]*ArtefactInfo, error) {
+		artefacts, err := client.Fetch(ctx, ref, AttestMediaType)
+		for _, artefact := range artefacts {
+			g.Expect(artefact.Close()).To(Succeed())
+		}
+		return artefacts, err
+	}
+
+	t.Run("referrer matching the index", func(t *testing.T) {
+		layoutPath := filepath.Join(t.TempDir(), "layout")
+		ref, err := client.PushArtefact(ctx, LayoutRef{Path: layoutPath}.String(), sourceDir, nil, nil, false, false, AttestationsReferrer, statement)
+		g.Expect(err).ToNot(HaveOccurred())
+		index, indexManifest, _, err := client.GetIndexOrImage(ctx, ref)
+		g.Expect(err).ToNot(HaveOccurred())
+		expectedDigest := indexManifest.Annotations[AttestationsDigestAnnotation]
+		g.Expect(expectedDigest).ToNot(BeEmpty())
+
+		for i := 0; i < 3; i++ {
+			attachAttestations(layoutPath, index, fmt.Sprintf("sha256:%064d", i))
+		}
+
+		artefacts, err := fetchAttestations(ref)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(artefacts).To(HaveLen(1))
+		g.Expect(artefacts[0].Annotations).To(HaveKeyWithValue(AttestationsDigestAnnotation, expectedDigest))
+	})
+
+	t.Run("index without attestations digest", func(t *testing.T) {
+		layoutPath := filepath.Join(t.TempDir(), "layout")
+		ref, err := client.PushArtefact(ctx, LayoutRef{Path: layoutPath}.String(), sourceDir, nil, nil, false, false, AttestationsEmbedded)
+		g.Expect(err).ToNot(HaveOccurred())
+		index, _, _, err := client.GetIndexOrImage(ctx, ref)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		attachAttestations(layoutPath, index, "")
+		artefacts, err := fetchAttestations(ref)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(artefacts).To(HaveLen(1))
+
+		attachAttestations(layoutPath, index, "sha256:"+strings.Repeat("0", 64))
+		_, err = fetchAttestations(ref)
+		g.Expect(err).To(MatchError(ContainSubstring("has 2 attestations referrers and no attestations digest to choose one by")))
+	})
+
+	t.Run("embedded and referrer attestations", func(t *testing.T) {
+		layoutPath := filepath.Join(t.TempDir(), "layout")
+		ref, err := client.PushArtefact(ctx, LayoutRef{Path: layoutPath}.String(), sourceDir, nil, nil, false, false, AttestationsEmbedded, statement)
+		g.Expect(err).ToNot(HaveOccurred())
+		index, indexManifest, _, err := client.GetIndexOrImage(ctx, ref)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		attachAttestations(layoutPath, index, indexManifest.Annotations[AttestationsDigestAnnotation])
+		_, err = fetchAttestations(ref)
+		g.Expect(err).To(MatchError(ContainSubstring("has 2 attestations layers, expected only one")))
+	})
+}
@@ -0,0 +1,43 @@
+package oci
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+type staticKeychain struct {
+	registry string
+	auth     authn.Authenticator
+}
+
+// NewKeychain returns a keychain that uses Docker config (including `credHelpers`
+// and `credsStore`), and if registry is given, the explicit credentials take
+// precedence for that registry
+func NewKeychain(registry, username, password string) (authn.Keychain, error) {
+	if registry == "" {
+		return authn.DefaultKeychain, nil
+	}
+	parsedRegistry, err := name.NewRegistry(registry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry name %q: %w", registry, err)
+	}
+	return authn.NewMultiKeychain(
+		&staticKeychain{
+			registry: parsedRegistry.RegistryStr(),
+			auth: authn.FromConfig(authn.AuthConfig{
+				Username: username,
+				Password: password,
+			}),
+		},
+		authn.DefaultKeychain,
+	), nil
+}
+
+func (k *staticKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if target.RegistryStr() != k.registry {
+		return authn.Anonymous, nil
+	}
+	return k.auth, nil
+}
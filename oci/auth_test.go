@@ -0,0 +1,136 @@
+package oci_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	. "github.com/onsi/gomega"
+
+	. "github.com/docker/labs-brown-tape/oci"
+	"github.com/docker/labs-brown-tape/trex"
+)
+
+const (
+	testUsername = "tape"
+	testPassword = "s3cr3t"
+)
+
+func TestAuth(t *testing.T) {
+	g := NewWithT(t)
+
+	registry := trex.New(0).WithBasicAuth(testUsername, testPassword)
+	registry.RunInBackground(context.Background())
+
+	makeDestination := registry.NewUniqueRepoNamer("bpt-auth-test")
+
+	image, err := random.Image(256, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	pushWith := func(t *testing.T, opts ...crane.Option) (string, error) {
+		ref := makeDestination(strings.ToLower(t.Name())) + ":test"
+		opts = append(registry.CraneOptions(), opts...)
+		if err := crane.Push(image, ref, opts...); err != nil {
+			return "", err
+		}
+		return NewClient(opts).Digest(context.Background(), ref)
+	}
+
+	setDockerConfig := func(t *testing.T, config map[string]any) {
+		dir := t.TempDir()
+		data, err := json.Marshal(config)
+		NewWithT(t).Expect(err).ToNot(HaveOccurred())
+		NewWithT(t).Expect(os.WriteFile(filepath.Join(dir, "config.json"), data, 0o600)).To(Succeed())
+		t.Setenv("HOME", dir)
+		t.Setenv("DOCKER_CONFIG", dir)
+	}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		g := NewWithT(t)
+		setDockerConfig(t, map[string]any{})
+
+		_, err := pushWith(t)
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("ExplicitCredentials", func(t *testing.T) {
+		g := NewWithT(t)
+		setDockerConfig(t, map[string]any{})
+
+		keychain, err := NewKeychain(registry.Addr(), testUsername, testPassword)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		digest, err := pushWith(t, crane.WithAuthFromKeychain(keychain))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(digest).To(HavePrefix("sha256:"))
+	})
+
+	t.Run("ExplicitCredentialsForOtherRegistry", func(t *testing.T) {
+		g := NewWithT(t)
+		setDockerConfig(t, map[string]any{})
+
+		keychain, err := NewKeychain("registry.example.com", testUsername, testPassword)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = pushWith(t, crane.WithAuthFromKeychain(keychain))
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("WrongExplicitCredentials", func(t *testing.T) {
+		g := NewWithT(t)
+		setDockerConfig(t, map[string]any{})
+
+		keychain, err := NewKeychain(registry.Addr(), testUsername, "wrong")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = pushWith(t, crane.WithAuthFromKeychain(keychain))
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("DockerConfigAuths", func(t *testing.T) {
+		g := NewWithT(t)
+		setDockerConfig(t, map[string]any{
+			"auths": map[string]any{
+				registry.Addr(): map[string]string{
+					"auth": base64.StdEncoding.EncodeToString([]byte(testUsername + ":" + testPassword)),
+				},
+			},
+		})
+
+		keychain, err := NewKeychain("", "", "")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		digest, err := pushWith(t, crane.WithAuthFromKeychain(keychain))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(digest).To(HavePrefix("sha256:"))
+	})
+
+	t.Run("DockerConfigCredHelpers", func(t *testing.T) {
+		g := NewWithT(t)
+		setDockerConfig(t, map[string]any{
+			"credHelpers": map[string]string{
+				registry.Addr(): "trex",
+			},
+		})
+
+		binDir := t.TempDir()
+		helper := fmt.Sprintf("#!/bin/sh\nread server\necho '{\"ServerURL\":\"'$server'\",\"Username\":\"%s\",\"Secret\":\"%s\"}'\n",
+			testUsername, testPassword)
+		g.Expect(os.WriteFile(filepath.Join(binDir, "docker-credential-trex"), []byte(helper), 0o700)).To(Succeed())
+		t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+		keychain, err := NewKeychain("", "", "")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		digest, err := pushWith(t, crane.WithAuthFromKeychain(keychain))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(digest).To(HavePrefix("sha256:"))
+	})
+}
@@ -0,0 +1,313 @@
+package oci
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const (
+	DefaultCacheTagTTL = 5 * time.Minute
+
+	cacheDirName = "tape/registry"
+)
+
+// Cache holds registry responses for manifest and tag list lookups, so that repeated
+// lookups of the same image don't result in repeated registry calls; manifests looked
+// up by digest never change, so these are held indefinitely, while anything looked up
+// by tag only lives for a limited time; when dir is set, entries are also stored on disk
+type Cache struct {
+	lock    sync.Mutex
+	dir     string
+	tagTTL  time.Duration
+	entries map[string]*cacheEntry
+	// urls keeps track of keys by URL, so that entries can be removed when a manifest is pushed
+	urls map[string]map[string]struct{}
+}
+
+type cacheEntry struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body,omitempty"`
+	HasBody    bool        `json:"hasBody"`
+	Time       time.Time   `json:"time"`
+	Immutable  bool        `json:"immutable"`
+}
+
+func NewCache(dir string, tagTTL time.Duration) *Cache {
+	return &Cache{
+		dir:     dir,
+		tagTTL:  tagTTL,
+		entries: map[string]*cacheEntry{},
+		urls:    map[string]map[string]struct{}{},
+	}
+}
+
+// DefaultCacheDir returns a directory for cache entries under user cache dir
+func DefaultCacheDir() (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine user cache dir: %w", err)
+	}
+	return filepath.Join(userCacheDir, cacheDirName), nil
+}
+
+// WithCache returns an option that makes all registry calls go through the cache,
+// base transport is used for any requests that are not cached, and when it's nil
+// remote.DefaultTransport is used
+func WithCache(cache *Cache, base http.RoundTripper) crane.Option {
+	if base == nil {
+		base = remote.DefaultTransport
+	}
+	return crane.WithTransport(&cacheTransport{cache: cache, base: base})
+}
+
+type cacheTransport struct {
+	cache *Cache
+	base  http.RoundTripper
+}
+
+// cacheableRequest returns true for manifest, tag list and referrers lookups,
+// and whether the reference is a digest
+func cacheableRequest(req *http.Request) (bool, bool) {
+	_, reference, ok := splitManifestPath(req.URL.Path)
+	if ok && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+		return true, strings.Contains(reference, ":")
+	}
+	if strings.HasPrefix(req.URL.Path, "/v2/") && strings.HasSuffix(req.URL.Path, "/tags/list") && req.Method == http.MethodGet {
+		return true, false
+	}
+	if strings.HasPrefix(req.URL.Path, "/v2/") && strings.Contains(req.URL.Path, "/referrers/") && req.Method == http.MethodGet {
+		return true, false
+	}
+	return false, false
+}
+
+// splitManifestPath splits `/v2/<name>/manifests/<reference>`
+func splitManifestPath(path string) (string, string, bool) {
+	if !strings.HasPrefix(path, "/v2/") {
+		return "", "", false
+	}
+	i := strings.LastIndex(path, "/manifests/")
+	if i < len("/v2") {
+		return "", "", false
+	}
+	return path[len("/v2/"):i], path[i+len("/manifests/"):], true
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cacheable, immutable := cacheableRequest(req)
+	if !cacheable {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			t.cache.invalidate(req)
+		}
+		return t.base.RoundTrip(req)
+	}
+
+	if resp := t.cache.get(req); resp != nil {
+		return resp, nil
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+
+	entry := &cacheEntry{
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Time:       time.Now(),
+		Immutable:  immutable,
+	}
+	if req.Method == http.MethodGet {
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		entry.Body, entry.HasBody = body, true
+	}
+	if immutable && !entry.matchesDigest(req) {
+		return resp, nil
+	}
+	t.cache.put(req, entry)
+	return resp, nil
+}
+
+// matchesDigest checks that the response is for the digest that was requested,
+// so that nothing unexpected is held indefinitely
+func (e *cacheEntry) matchesDigest(req *http.Request) bool {
+	_, reference, _ := splitManifestPath(req.URL.Path)
+	if e.HasBody && strings.HasPrefix(reference, "sha256:") {
+		sum := sha256.Sum256(e.Body)
+		return reference == "sha256:"+hex.EncodeToString(sum[:])
+	}
+	return e.Header.Get("Docker-Content-Digest") == reference
+}
+
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          http.NoBody,
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+	if req.Method == http.MethodGet {
+		resp.Body = io.NopCloser(bytes.NewReader(e.Body))
+	}
+	return resp
+}
+
+func cacheKey(method string, req *http.Request) string {
+	return method + " " + req.URL.String() + " " + req.Header.Get("Accept")
+}
+
+func (c *Cache) get(req *http.Request) *http.Response {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// response to GET can be used for HEAD as well, but not the other way around
+	methods := []string{http.MethodGet}
+	if req.Method == http.MethodHead {
+		methods = append(methods, http.MethodHead)
+	}
+	for _, method := range methods {
+		key := cacheKey(method, req)
+		entry, ok := c.entries[key]
+		if !ok {
+			if entry = c.load(key); entry == nil {
+				continue
+			}
+			c.add(key, entry)
+		}
+		if !entry.Immutable && time.Since(entry.Time) > c.tagTTL {
+			c.remove(key)
+			continue
+		}
+		return entry.response(req)
+	}
+	return nil
+}
+
+func (c *Cache) put(req *http.Request, entry *cacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := cacheKey(req.Method, req)
+	c.add(key, entry)
+	c.store(key, entry)
+}
+
+func (c *Cache) add(key string, entry *cacheEntry) {
+	c.entries[key] = entry
+	if _, ok := c.urls[entry.URL]; !ok {
+		c.urls[entry.URL] = map[string]struct{}{}
+	}
+	c.urls[entry.URL][key] = struct{}{}
+}
+
+func (c *Cache) remove(key string) {
+	if entry, ok := c.entries[key]; ok {
+		delete(c.urls[entry.URL], key)
+		delete(c.entries, key)
+	}
+	if c.dir != "" {
+		_ = os.Remove(c.path(key))
+	}
+}
+
+// invalidate removes entries that may be out of date after a write to a repository,
+// i.e. the manifest under the same reference, the list of tags and lists of referrers
+func (c *Cache) invalidate(req *http.Request) {
+	name, _, ok := splitManifestPath(req.URL.Path)
+	if !ok {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	tagsURL, referrersURL := *req.URL, *req.URL
+	tagsURL.Path, tagsURL.RawQuery = "/v2/"+name+"/tags/list", ""
+	referrersURL.Path, referrersURL.RawQuery = "/v2/"+name+"/referrers/", ""
+	for url := range c.urls {
+		if url == req.URL.String() || strings.HasPrefix(url, tagsURL.String()) || strings.HasPrefix(url, referrersURL.String()) {
+			for key := range c.urls[url] {
+				c.remove(key)
+			}
+		}
+	}
+	// there may be entries on disk that haven't been loaded, which can only be found by key
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		c.remove(cacheKey(method, req))
+	}
+}
+
+func (c *Cache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (c *Cache) load(key string) *cacheEntry {
+	if c.dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		// broken entries are treated as missing and overwritten later
+		return nil
+	}
+	return entry
+}
+
+// store writes the entry to disk, failure to do so is not an error
+// as the entry can be fetched from the registry again
+func (c *Cache) store(key string, entry *cacheEntry) {
+	if c.dir == "" {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return
+	}
+	// write to a temporary file first, so that concurrent readers never see partial entries
+	temp, err := os.CreateTemp(c.dir, ".entry-*")
+	if err != nil {
+		return
+	}
+	_, err = temp.Write(data)
+	err = errors.Join(err, temp.Close())
+	if err == nil {
+		err = os.Rename(temp.Name(), c.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+	}
+}
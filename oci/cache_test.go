@@ -0,0 +1,148 @@
+package oci_test
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+
+	. "github.com/docker/labs-brown-tape/oci"
+	"github.com/docker/labs-brown-tape/trex"
+)
+
+// countingTransport counts manifest and tag list requests that reach the registry
+type countingTransport struct {
+	http.RoundTripper
+
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+func newCountingTransport(base http.RoundTripper) *countingTransport {
+	return &countingTransport{RoundTripper: base, counts: map[string]int{}}
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Path, "/manifests/") || strings.HasSuffix(req.URL.Path, "/tags/list") {
+		t.lock.Lock()
+		t.counts[req.Method+" "+path.Join(path.Base(path.Dir(req.URL.Path)), path.Base(req.URL.Path))]++
+		t.lock.Unlock()
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func (t *countingTransport) total() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	total := 0
+	for _, count := range t.counts {
+		total += count
+	}
+	return total
+}
+
+func TestCache(t *testing.T) {
+	trex.RunShared()
+	craneOptions := trex.Shared.CraneOptions()
+	makeDestination := trex.Shared.NewUniqueRepoNamer("bpt-cache-test")
+
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	index, err := random.Index(128, 1, 2)
+	g.Expect(err).ToNot(HaveOccurred())
+	indexDigest, err := index.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repo := makeDestination("app")
+	ref, err := name.ParseReference(repo + ":v1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.WriteIndex(ref, index, crane.GetOptions(craneOptions...).Remote...)).To(Succeed())
+
+	signature, err := random.Image(128, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	signatureTag := strings.Replace(indexDigest.String(), ":", "-", 1) + ".sig"
+	g.Expect(crane.Push(signature, repo+":"+signatureTag, craneOptions...)).To(Succeed())
+
+	cacheDir := t.TempDir()
+	transport := newCountingTransport(trex.Shared.Transport())
+	client := NewClient([]crane.Option{WithCache(NewCache(cacheDir, time.Minute), transport)})
+
+	getManifests := func(imageIndex ImageIndex, indexManifest *IndexManifest) {
+		for _, descriptor := range indexManifest.Manifests {
+			image, err := imageIndex.Image(descriptor.Digest)
+			g.Expect(err).ToNot(HaveOccurred())
+			_, err = image.Manifest()
+			g.Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	lookup := func(client *Client) {
+		digest, err := client.Digest(ctx, repo+":v1")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(digest).To(Equal(indexDigest.String()))
+
+		imageIndex, indexManifest, _, err := client.GetIndexOrImage(ctx, repo+":v1")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(indexManifest.Manifests).To(HaveLen(2))
+
+		getManifests(imageIndex, indexManifest)
+
+		_, _, _, err = client.GetIndexOrImage(ctx, repo+"@"+indexDigest.String())
+		g.Expect(err).ToNot(HaveOccurred())
+
+		related, err := client.ListRelated(ctx, repo, indexDigest.String())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(related).To(HaveLen(1))
+		g.Expect(related[0].URL).To(Equal(repo + ":" + signatureTag))
+	}
+
+	lookup(client)
+	numRequests := transport.total()
+	g.Expect(numRequests).ToNot(BeZero())
+	g.Expect(transport.counts).To(HaveKeyWithValue("GET tags/list", 1))
+
+	// everything should be served from the cache
+	lookup(client)
+	g.Expect(transport.total()).To(Equal(numRequests))
+
+	// anything pushed by another client is not visible until the tag expires
+	newImage, err := random.Image(128, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	newDigest, err := newImage.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(crane.Push(newImage, repo+":v2", craneOptions...)).To(Succeed())
+	g.Expect(crane.Push(newImage, repo+":v1", craneOptions...)).To(Succeed())
+	digest, err := client.Digest(ctx, repo+":v1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(digest).To(Equal(indexDigest.String()))
+
+	// pushing to the same tag with the same client should invalidate the entries for the tag
+	g.Expect(client.Copy(ctx, repo+":v2", repo+":v1", newDigest.String())).To(Succeed())
+	digest, err = client.Digest(ctx, repo+":v1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(digest).To(Equal(newDigest.String()))
+
+	// a new cache using the same directory should only need to look up tags again, as these expire
+	transport = newCountingTransport(trex.Shared.Transport())
+	client = NewClient([]crane.Option{WithCache(NewCache(cacheDir, 0), transport)})
+	imageIndex, indexManifest, _, err := client.GetIndexOrImage(ctx, repo+"@"+indexDigest.String())
+	g.Expect(err).ToNot(HaveOccurred())
+	getManifests(imageIndex, indexManifest)
+	g.Expect(transport.total()).To(BeZero())
+
+	_, err = client.Digest(ctx, repo+":v1")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = client.Digest(ctx, repo+":v1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(transport.counts).To(HaveKeyWithValue("HEAD manifests/v1", 2))
+}
@@ -0,0 +1,404 @@
+package oci
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	typesv1 "github.com/google/go-containerregistry/pkg/v1/types"
+	OCIv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	manifestTypes "github.com/docker/labs-brown-tape/manifest/types"
+)
+
+const (
+	LayoutRefPrefix = "oci-layout://"
+)
+
+// LayoutRef points to an OCI image layout directory, which is treated the same
+// way as a single repository, i.e. tags are stored as ref name annotations
+// in the top-level index
+type LayoutRef struct {
+	Path   string
+	Tag    string
+	Digest string
+}
+
+func IsLayoutRef(ref string) bool { return strings.HasPrefix(ref, LayoutRefPrefix) }
+
+func ParseLayoutRef(ref string) (*LayoutRef, error) {
+	if !IsLayoutRef(ref) {
+		return nil, fmt.Errorf("%q is not an OCI layout reference, it must start with %q", ref, LayoutRefPrefix)
+	}
+	layoutRef := &LayoutRef{}
+	path := strings.TrimPrefix(ref, LayoutRefPrefix)
+	if i := strings.LastIndex(path, "@"); i >= 0 {
+		layoutRef.Digest = path[i+1:]
+		path = path[:i]
+		if _, err := v1.NewHash(layoutRef.Digest); err != nil {
+			return nil, fmt.Errorf("invalid digest in OCI layout reference %q: %w", ref, err)
+		}
+	}
+	if i := strings.LastIndex(path, ":"); i >= 0 && i > strings.LastIndex(path, "/") {
+		layoutRef.Tag = path[i+1:]
+		path = path[:i]
+		if layoutRef.Tag == "" {
+			return nil, fmt.Errorf("tag must not be empty in OCI layout reference %q", ref)
+		}
+		if _, err := name.NewTag("layout:" + layoutRef.Tag); err != nil {
+			return nil, fmt.Errorf("invalid tag in OCI layout reference %q: %w", ref, err)
+		}
+	}
+	if path == "" {
+		return nil, fmt.Errorf("path must not be empty in OCI layout reference %q", ref)
+	}
+	layoutRef.Path = path
+	return layoutRef, nil
+}
+
+func (r LayoutRef) String() string {
+	ref := LayoutRefPrefix + r.Path
+	if r.Tag != "" {
+		ref += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		ref += "@" + r.Digest
+	}
+	return ref
+}
+
+func (r LayoutRef) WithTag(tag string) LayoutRef {
+	return LayoutRef{Path: r.Path, Tag: tag}
+}
+
+func (r LayoutRef) matches(descriptor Descriptor) bool {
+	if r.Digest != "" && descriptor.Digest.String() != r.Digest {
+		return false
+	}
+	if r.Tag != "" && descriptor.Annotations[OCIv1.AnnotationRefName] != r.Tag {
+		return false
+	}
+	return true
+}
+
+func (r LayoutRef) Tags() (map[string]Descriptor, error) {
+	_, indexManifest, err := r.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]Descriptor{}
+	for _, descriptor := range indexManifest.Manifests {
+		if tag, ok := descriptor.Annotations[OCIv1.AnnotationRefName]; ok {
+			tags[tag] = descriptor
+		}
+	}
+	return tags, nil
+}
+
+func (r LayoutRef) readIndex() (ImageIndex, *IndexManifest, error) {
+	path, err := layout.FromPath(r.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open OCI layout %q: %w", r.Path, err)
+	}
+	imageIndex, err := path.ImageIndex()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read index of OCI layout %q: %w", r.Path, err)
+	}
+	indexManifest, err := imageIndex.IndexManifest()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read index manifest of OCI layout %q: %w", r.Path, err)
+	}
+	return imageIndex, indexManifest, nil
+}
+
+func (r LayoutRef) descriptor() (ImageIndex, *Descriptor, error) {
+	imageIndex, indexManifest, err := r.readIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+	var found *Descriptor
+	for i := range indexManifest.Manifests {
+		descriptor := indexManifest.Manifests[i]
+		if !r.matches(descriptor) {
+			continue
+		}
+		if found != nil && found.Digest != descriptor.Digest {
+			return nil, nil, fmt.Errorf("multiple manifests match %q", r.String())
+		}
+		found = &descriptor
+	}
+	if found == nil {
+		return nil, nil, fmt.Errorf("no manifest matching %q found", r.String())
+	}
+	return imageIndex, found, nil
+}
+
+func (r LayoutRef) getIndexOrImage() (ImageIndex, *IndexManifest, Image, error) {
+	layoutIndex, descriptor, err := r.descriptor()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	switch descriptor.MediaType {
+	case typesv1.OCIImageIndex, typesv1.DockerManifestList:
+		imageIndex, err := layoutIndex.ImageIndex(descriptor.Digest)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to get index for %s: %w", r.String(), err)
+		}
+		indexManifest, err := imageIndex.IndexManifest()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to get index manifest for %s: %w", r.String(), err)
+		}
+		if len(indexManifest.Manifests) == 0 {
+			return nil, nil, nil, fmt.Errorf("no manifests found in image %q", r.String())
+		}
+		return imageIndex, indexManifest, nil, nil
+	default:
+		image, err := layoutIndex.Image(descriptor.Digest)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to get image for %s: %w", r.String(), err)
+		}
+		return nil, nil, image, nil
+	}
+}
+
+func (r LayoutRef) openForWriting() (layout.Path, error) {
+	path, err := layout.FromPath(r.Path)
+	if err == nil {
+		return path, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to open OCI layout %q: %w", r.Path, err)
+	}
+	path, err = layout.Write(r.Path, empty.Index)
+	if err != nil {
+		return "", fmt.Errorf("failed to create OCI layout %q: %w", r.Path, err)
+	}
+	return path, nil
+}
+
+// write stores the index or the image in the layout, replacing any existing manifest
+// with the same tag
+func (r LayoutRef) write(imageIndex ImageIndex, image Image) error {
+	path, err := r.openForWriting()
+	if err != nil {
+		return err
+	}
+
+	var (
+		matcher = match.Name(r.Tag)
+		options = []layout.Option{layout.WithAnnotations(map[string]string{OCIv1.AnnotationRefName: r.Tag})}
+	)
+	if r.Tag == "" {
+		digest, err := digestOf(imageIndex, image)
+		if err != nil {
+			return err
+		}
+		matcher = match.Digests(digest)
+		options = nil
+	}
+
+	if imageIndex != nil {
+		err = path.ReplaceIndex(imageIndex, matcher, options...)
+	} else {
+		err = path.ReplaceImage(image, matcher, options...)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %q: %w", r.String(), err)
+	}
+	return nil
+}
+
+func digestOf(imageIndex ImageIndex, image Image) (Hash, error) {
+	if imageIndex != nil {
+		return imageIndex.Digest()
+	}
+	return image.Digest()
+}
+
+func (c *Client) listRelatedInLayout(ref, tagPrefix string) ([]Metadata, error) {
+	layoutRef, err := ParseLayoutRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := layoutRef.Tags()
+	if err != nil {
+		return nil, err
+	}
+	related := []Metadata{}
+	for tag, descriptor := range tags {
+		if !strings.HasPrefix(tag, tagPrefix) {
+			continue
+		}
+		related = append(related, Metadata{
+			URL:         layoutRef.WithTag(tag).String(),
+			Digest:      descriptor.Digest.String(),
+			Annotations: descriptor.Annotations,
+		})
+	}
+	slices.SortFunc(related, func(a, b Metadata) int { return cmp.Compare(a.URL, b.URL) })
+	return related, nil
+}
+
+// listReferrersInLayout finds manifests with the given subject, as there is no index
+// of referrers in a layout, all manifests in the top-level index are checked
+func (c *Client) listReferrersInLayout(ref, digest string) ([]Descriptor, error) {
+	layoutRef, err := ParseLayoutRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	_, indexManifest, err := layoutRef.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	path := layout.Path(layoutRef.Path)
+	referrers := []Descriptor{}
+	seen := map[Hash]struct{}{}
+	for _, descriptor := range indexManifest.Manifests {
+		if _, ok := seen[descriptor.Digest]; ok {
+			continue
+		}
+		seen[descriptor.Digest] = struct{}{}
+
+		data, err := path.Bytes(descriptor.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest %s from OCI layout %q: %w", descriptor.Digest, layoutRef.Path, err)
+		}
+		manifest := struct {
+			ArtifactType string `json:"artifactType"`
+			Config       struct {
+				MediaType string `json:"mediaType"`
+			} `json:"config"`
+			Subject     *Descriptor       `json:"subject"`
+			Annotations map[string]string `json:"annotations"`
+		}{}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal manifest %s from OCI layout %q: %w", descriptor.Digest, layoutRef.Path, err)
+		}
+		if manifest.Subject == nil || manifest.Subject.Digest.String() != digest {
+			continue
+		}
+		artifactType := manifest.ArtifactType
+		if artifactType == "" {
+			artifactType = manifest.Config.MediaType
+		}
+		referrers = append(referrers, Descriptor{
+			MediaType:    descriptor.MediaType,
+			Digest:       descriptor.Digest,
+			Size:         descriptor.Size,
+			ArtifactType: artifactType,
+			Annotations:  manifest.Annotations,
+		})
+	}
+	slices.SortFunc(referrers, func(a, b Descriptor) int { return cmp.Compare(a.Digest.String(), b.Digest.String()) })
+	return referrers, nil
+}
+
+// copyWithLayout is used instead of crane.Copy when source or destination is an OCI layout
+func (c *Client) copyWithLayout(ctx context.Context, srcRef, dstRef string) error {
+	imageIndex, _, image, err := c.GetIndexOrImage(ctx, srcRef)
+	if err != nil {
+		return err
+	}
+
+	if IsLayoutRef(dstRef) {
+		layoutRef, err := ParseLayoutRef(dstRef)
+		if err != nil {
+			return err
+		}
+		return layoutRef.write(imageIndex, image)
+	}
+
+	parsedRef, err := name.ParseReference(dstRef)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", dstRef, err)
+	}
+	if imageIndex != nil {
+		return remote.WriteIndex(parsedRef, imageIndex, c.remoteWithContext(ctx)...)
+	}
+	return remote.Write(parsedRef, image, c.remoteWithContext(ctx)...)
+}
+
+// PushLayout copies all tagged manifests from an OCI layout to the destination repository
+// and returns references to each of the artefacts found in the layout, the same as what
+// PushArtefact returns; artefacts are pushed last, so that these only appear in the
+// destination repository once all app images have been pushed
+func (c *Client) PushLayout(ctx context.Context, layoutPath, destinationRef string) ([]string, error) {
+	repo, err := name.NewRepository(destinationRef)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+
+	layoutRef := LayoutRef{Path: layoutPath}
+	tags, err := layoutRef.Tags()
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) == 0 {
+		return nil, fmt.Errorf("no tagged manifests found in OCI layout %q", layoutPath)
+	}
+
+	configTags, otherTags := []string{}, []string{}
+	for tag := range tags {
+		if strings.HasPrefix(tag, manifestTypes.ConfigImageTagPrefix) {
+			configTags = append(configTags, tag)
+		} else {
+			otherTags = append(otherTags, tag)
+		}
+	}
+	if len(configTags) == 0 {
+		return nil, fmt.Errorf("no artefacts found in OCI layout %q", layoutPath)
+	}
+	slices.Sort(configTags)
+	slices.Sort(otherTags)
+
+	// untagged manifests are referrers, these are pushed by digest before everything else
+	_, indexManifest, err := layoutRef.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	untagged := []string{}
+	for _, descriptor := range indexManifest.Manifests {
+		if _, ok := descriptor.Annotations[OCIv1.AnnotationRefName]; !ok && !slices.Contains(untagged, descriptor.Digest.String()) {
+			untagged = append(untagged, descriptor.Digest.String())
+		}
+	}
+	for _, digest := range untagged {
+		source := LayoutRef{Path: layoutPath, Digest: digest}.String()
+		if err := c.Copy(ctx, source, repo.Digest(digest).String(), digest); err != nil {
+			return nil, fmt.Errorf("failed to push %q: %w", digest, err)
+		}
+	}
+
+	for _, tag := range append(otherTags, configTags...) {
+		digest := tags[tag].Digest.String()
+		if err := c.Copy(ctx, layoutRef.WithTag(tag).String(), repo.Tag(tag).String(), digest); err != nil {
+			return nil, fmt.Errorf("failed to push %q: %w", tag, err)
+		}
+	}
+
+	// the shortest tag is the alias that PushArtefact returns
+	aliases := map[string]string{}
+	for _, tag := range configTags {
+		digest := tags[tag].Digest.String()
+		if alias, ok := aliases[digest]; !ok || len(tag) < len(alias) {
+			aliases[digest] = tag
+		}
+	}
+	refs := make([]string, 0, len(aliases))
+	for digest, alias := range aliases {
+		refs = append(refs, repo.Tag(alias).String()+"@"+digest)
+	}
+	slices.Sort(refs)
+	return refs, nil
+}
@@ -0,0 +1,236 @@
+package oci_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+
+	. "github.com/docker/labs-brown-tape/oci"
+	"github.com/docker/labs-brown-tape/trex"
+)
+
+func TestParseLayoutRef(t *testing.T) {
+	digest := "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	valid := map[string]LayoutRef{
+		"oci-layout://dir":                              {Path: "dir"},
+		"oci-layout://./some/dir:app.123":               {Path: "./some/dir", Tag: "app.123"},
+		"oci-layout:///tmp/dir:config.abc@" + digest:    {Path: "/tmp/dir", Tag: "config.abc", Digest: digest},
+		"oci-layout://dir@" + digest:                    {Path: "dir", Digest: digest},
+		"oci-layout://host:1234/dir":                    {Path: "host:1234/dir"},
+		"oci-layout://dir:sha256-e3b0c44298fc1c149.sig": {Path: "dir", Tag: "sha256-e3b0c44298fc1c149.sig"},
+	}
+	for ref, expected := range valid {
+		g := NewWithT(t)
+		layoutRef, err := ParseLayoutRef(ref)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(*layoutRef).To(Equal(expected))
+		g.Expect(layoutRef.String()).To(Equal(ref))
+	}
+
+	invalid := []string{
+		"dir:tag",
+		"oci-layout://",
+		"oci-layout://:tag",
+		"oci-layout://dir@sha256:foo",
+		"oci-layout://dir:invalid/tag:",
+	}
+	for _, ref := range invalid {
+		g := NewWithT(t)
+		_, err := ParseLayoutRef(ref)
+		g.Expect(err).To(HaveOccurred(), ref)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	trex.RunShared()
+	craneOptions := trex.Shared.CraneOptions()
+	makeDestination := trex.Shared.NewUniqueRepoNamer("bpt-layout-test")
+
+	g := NewWithT(t)
+	ctx := context.Background()
+	client := NewClient(craneOptions)
+
+	index, err := random.Index(256, 1, 2)
+	g.Expect(err).ToNot(HaveOccurred())
+	indexDigest, err := index.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	signature, err := random.Image(128, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	signatureDigest, err := signature.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	srcRef := makeDestination("src") + ":v1"
+	parsedSrcRef, err := name.ParseReference(srcRef)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.WriteIndex(parsedSrcRef, index, crane.GetOptions(craneOptions...).Remote...)).To(Succeed())
+
+	signatureTag := strings.Replace(indexDigest.String(), ":", "-", 1) + ".sig"
+	g.Expect(crane.Push(signature, makeDestination("src")+":"+signatureTag, craneOptions...)).To(Succeed())
+
+	layoutPath := filepath.Join(t.TempDir(), "layout")
+	appRef := LayoutRef{Path: layoutPath, Tag: "app.1"}
+
+	g.Expect(client.Copy(ctx, srcRef, appRef.String(), indexDigest.String())).To(Succeed())
+	// copying again should replace the tag rather than add a duplicate
+	g.Expect(client.Copy(ctx, srcRef, appRef.String(), indexDigest.String())).To(Succeed())
+	g.Expect(client.Copy(ctx, makeDestination("src")+":"+signatureTag,
+		LayoutRef{Path: layoutPath, Tag: signatureTag}.String(), signatureDigest.String())).To(Succeed())
+
+	tags, err := appRef.Tags()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(HaveLen(2))
+	g.Expect(tags).To(HaveKey("app.1"))
+	g.Expect(tags).To(HaveKey(signatureTag))
+
+	digest, err := client.Digest(ctx, appRef.String())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(digest).To(Equal(indexDigest.String()))
+
+	imageIndex, indexManifest, image, err := client.GetIndexOrImage(ctx, appRef.String())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(imageIndex).ToNot(BeNil())
+	g.Expect(indexManifest.Manifests).To(HaveLen(2))
+	g.Expect(image).To(BeNil())
+
+	_, err = client.Pull(ctx, LayoutRef{Path: layoutPath, Tag: signatureTag}.String())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	related, err := client.ListRelated(ctx, LayoutRef{Path: layoutPath}.String(), indexDigest.String())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(related).To(HaveLen(1))
+	g.Expect(related[0].URL).To(Equal(LayoutRef{Path: layoutPath, Tag: signatureTag}.String()))
+	g.Expect(related[0].Digest).To(Equal(signatureDigest.String()))
+
+	_, err = client.Digest(ctx, LayoutRef{Path: layoutPath}.String())
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = client.Digest(ctx, LayoutRef{Path: layoutPath, Tag: "missing"}.String())
+	g.Expect(err).To(HaveOccurred())
+
+	dstRef := makeDestination("dst") + ":v1"
+	g.Expect(client.Copy(ctx, appRef.String(), dstRef, indexDigest.String())).To(Succeed())
+}
+
+func TestPushArtefactToLayout(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	client := NewClient(nil)
+
+	sourceDir := t.TempDir()
+	g.Expect(os.WriteFile(filepath.Join(sourceDir, "cm.yaml"), []byte("kind: ConfigMap\n"), 0o644)).To(Succeed())
+
+	layoutPath := filepath.Join(t.TempDir(), "layout")
+
+	ref, err := client.PushArtefact(ctx, LayoutRef{Path: layoutPath}.String(), sourceDir, nil, nil, false, false, AttestationsEmbedded)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ref).To(HavePrefix(LayoutRef{Path: layoutPath}.String() + ":config."))
+
+	layoutRef, err := ParseLayoutRef(ref)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	tags, err := layoutRef.Tags()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(HaveLen(2))
+	for tag := range tags {
+		g.Expect(tag).To(HavePrefix("config."))
+		g.Expect(tags[tag].Digest.String()).To(Equal(layoutRef.Digest))
+	}
+
+	artefacts, err := client.Fetch(ctx, ref, ContentMediaType)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(artefacts).To(HaveLen(1))
+	g.Expect(artefacts[0].Close()).To(Succeed())
+
+	// pushing the same contents again returns existing artefact, unless forced
+	timestamp := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	existingRef, err := client.PushArtefact(ctx, LayoutRef{Path: layoutPath}.String(), sourceDir, &timestamp, nil, false, false, AttestationsEmbedded)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(existingRef).To(Equal(ref))
+
+	forcedRef, err := client.PushArtefact(ctx, LayoutRef{Path: layoutPath}.String(), sourceDir, &timestamp, nil, true, false, AttestationsEmbedded)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(forcedRef).ToNot(Equal(ref))
+	forcedLayoutRef, err := ParseLayoutRef(forcedRef)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(forcedLayoutRef.Tag).To(Equal(layoutRef.Tag))
+
+	// different contents are always pushed
+	g.Expect(os.WriteFile(filepath.Join(sourceDir, "cm.yaml"), []byte("kind: Secret\n"), 0o644)).To(Succeed())
+	changedRef, err := client.PushArtefact(ctx, LayoutRef{Path: layoutPath}.String(), sourceDir, &timestamp, nil, false, false, AttestationsEmbedded)
+	g.Expect(err).ToNot(HaveOccurred())
+	changedLayoutRef, err := ParseLayoutRef(changedRef)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changedLayoutRef.Tag).ToNot(Equal(layoutRef.Tag))
+}
+
+func TestPushLayout(t *testing.T) {
+	trex.RunShared()
+	craneOptions := trex.Shared.CraneOptions()
+	makeDestination := trex.Shared.NewUniqueRepoNamer("bpt-push-layout-test")
+
+	g := NewWithT(t)
+	ctx := context.Background()
+	client := NewClient(craneOptions)
+
+	layoutPath := filepath.Join(t.TempDir(), "layout")
+
+	image, err := random.Index(256, 1, 2)
+	g.Expect(err).ToNot(HaveOccurred())
+	srcRef := makeDestination("src") + ":v1"
+	parsedSrcRef, err := name.ParseReference(srcRef)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.WriteIndex(parsedSrcRef, image, crane.GetOptions(craneOptions...).Remote...)).To(Succeed())
+	imageDigest, err := image.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	signatureTag := strings.Replace(imageDigest.String(), ":", "-", 1) + ".sig"
+
+	for _, tag := range []string{"app.1", signatureTag} {
+		g.Expect(client.Copy(ctx, srcRef, LayoutRef{Path: layoutPath, Tag: tag}.String(), imageDigest.String())).To(Succeed())
+	}
+
+	sourceDir := t.TempDir()
+	g.Expect(os.WriteFile(filepath.Join(sourceDir, "cm.yaml"), []byte("kind: ConfigMap\n"), 0o644)).To(Succeed())
+
+	layoutArtefactRef, err := client.PushArtefact(ctx, LayoutRef{Path: layoutPath}.String(), sourceDir, nil, nil, false, false, AttestationsEmbedded)
+	g.Expect(err).ToNot(HaveOccurred())
+	parsedLayoutArtefactRef, err := ParseLayoutRef(layoutArtefactRef)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	destination := makeDestination("dst")
+
+	refs, err := client.PushLayout(ctx, layoutPath, destination)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(refs).To(ConsistOf(destination + ":" + parsedLayoutArtefactRef.Tag + "@" + parsedLayoutArtefactRef.Digest))
+
+	tags, err := LayoutRef{Path: layoutPath}.Tags()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(HaveLen(4))
+	for tag, descriptor := range tags {
+		digest, err := client.Digest(ctx, destination+":"+tag)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(digest).To(Equal(descriptor.Digest.String()))
+	}
+
+	artefacts, err := client.Fetch(ctx, refs[0], ContentMediaType)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(artefacts).To(HaveLen(1))
+	g.Expect(artefacts[0].Close()).To(Succeed())
+
+	existingRef, err := client.PushArtefact(ctx, destination, sourceDir, nil, nil, false, false, AttestationsEmbedded)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(existingRef).To(Equal(refs[0]))
+
+	_, err = client.PushLayout(ctx, filepath.Join(t.TempDir(), "missing"), destination)
+	g.Expect(err).To(HaveOccurred())
+}
@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"slices"
 	"strings"
 
 	ociclient "github.com/fluxcd/pkg/oci/client"
 	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	"github.com/google/go-containerregistry/pkg/v1/types"
@@ -24,6 +26,11 @@ import (
 
 const (
 	UserAgent = "tape/v1"
+
+	// attestation manifests that BuildKit adds to image indexes
+	attestationReferenceTypeAnnotation   = "vnd.docker.reference.type"
+	attestationReferenceDigestAnnotation = "vnd.docker.reference.digest"
+	attestationManifestReferenceType     = "attestation-manifest"
 )
 
 type (
@@ -76,14 +83,29 @@ func (c *Client) remoteWithContext(ctx context.Context) []remote.Option {
 }
 
 func (c *Client) Digest(ctx context.Context, ref string) (string, error) {
+	if IsLayoutRef(ref) {
+		layoutRef, err := ParseLayoutRef(ref)
+		if err != nil {
+			return "", err
+		}
+		_, descriptor, err := layoutRef.descriptor()
+		if err != nil {
+			return "", err
+		}
+		return descriptor.Digest.String(), nil
+	}
 	return crane.Digest(ref, c.withContext(ctx)...)
 }
 
 func (c *Client) Copy(ctx context.Context, srcRef, dstRef, digest string) error {
-	if err := crane.Copy(srcRef, dstRef, c.withContext(ctx)...); err != nil {
+	if IsLayoutRef(srcRef) || IsLayoutRef(dstRef) {
+		if err := c.copyWithLayout(ctx, srcRef, dstRef); err != nil {
+			return err
+		}
+	} else if err := crane.Copy(srcRef, dstRef, c.withContext(ctx)...); err != nil {
 		return err
 	}
-	newDigest, err := crane.Digest(dstRef, c.withContext(ctx)...)
+	newDigest, err := c.Digest(ctx, dstRef)
 	if err != nil {
 		return err
 	}
@@ -94,6 +116,14 @@ func (c *Client) Copy(ctx context.Context, srcRef, dstRef, digest string) error
 }
 
 func (c *Client) GetIndexOrImage(ctx context.Context, ref string) (v1.ImageIndex, *v1.IndexManifest, v1.Image, error) {
+	if IsLayoutRef(ref) {
+		layoutRef, err := ParseLayoutRef(ref)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return layoutRef.getIndexOrImage()
+	}
+
 	parsedRef, err := name.ParseReference(ref)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("invalid URL %q: %w", ref, err)
@@ -138,11 +168,24 @@ func (c *Client) GetIndexOrImage(ctx context.Context, ref string) (v1.ImageIndex
 }
 
 func (c *Client) Pull(ctx context.Context, ref string) (v1.Image, error) {
+	if IsLayoutRef(ref) {
+		_, _, image, err := c.GetIndexOrImage(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if image == nil {
+			return nil, fmt.Errorf("%q is an index, not an image", ref)
+		}
+		return image, nil
+	}
 	return crane.Pull(ref, c.withContext(ctx)...)
 }
 
 func (c *Client) ListRelated(ctx context.Context, ref, digest string) ([]Metadata, error) {
 	tagPrefix := strings.Join(strings.Split(digest, ":"), "-")
+	if IsLayoutRef(ref) {
+		return c.listRelatedInLayout(ref, tagPrefix)
+	}
 	listOptions := ociclient.ListOptions{
 		RegexFilter:            fmt.Sprintf("^%s.*", tagPrefix),
 		IncludeCosignArtifacts: true,
@@ -154,6 +197,105 @@ func (c *Client) ListRelated(ctx context.Context, ref, digest string) ([]Metadat
 	return tags, nil
 }
 
+// ListReferrers returns descriptors of manifests that have the given digest as the subject,
+// registries that don't support referrers API are checked using the referrers tag schema
+func (c *Client) ListReferrers(ctx context.Context, ref, digest string) ([]Descriptor, error) {
+	if IsLayoutRef(ref) {
+		return c.listReferrersInLayout(ref, digest)
+	}
+	repo, err := name.NewRepository(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", ref, err)
+	}
+	index, err := remote.Referrers(repo.Digest(digest), c.remoteWithContext(ctx)...)
+	if err != nil {
+		return nil, err
+	}
+	indexManifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	return indexManifest.Manifests, nil
+}
+
+// FilterIndex returns an index that only has manifests for the given platforms and attestation
+// manifests that refer to these; nil is returned when ref points to an image and not an index,
+// as well as when all manifests of the index match
+func (c *Client) FilterIndex(ctx context.Context, ref string, platforms ...Platform) (ImageIndex, error) {
+	imageIndex, indexManifest, _, err := c.GetIndexOrImage(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if imageIndex == nil {
+		return nil, nil
+	}
+
+	keep := map[Hash]struct{}{}
+	for _, descriptor := range indexManifest.Manifests {
+		if descriptor.Platform == nil {
+			continue
+		}
+		for _, platform := range platforms {
+			if descriptor.Platform.Satisfies(platform) {
+				keep[descriptor.Digest] = struct{}{}
+				break
+			}
+		}
+	}
+	if len(keep) == 0 {
+		return nil, fmt.Errorf("no manifests for platforms %v found in %q", platforms, ref)
+	}
+	remove := func(descriptor Descriptor) bool {
+		if _, ok := keep[descriptor.Digest]; ok {
+			return false
+		}
+		if descriptor.Annotations[attestationReferenceTypeAnnotation] == attestationManifestReferenceType {
+			subject, err := v1.NewHash(descriptor.Annotations[attestationReferenceDigestAnnotation])
+			if _, ok := keep[subject]; err == nil && ok {
+				return false
+			}
+		}
+		return true
+	}
+	if !slices.ContainsFunc(indexManifest.Manifests, remove) {
+		return nil, nil
+	}
+	return mutate.RemoveManifests(imageIndex, remove), nil
+}
+
+// WriteIndex writes the index to the registry or OCI layout
+func (c *Client) WriteIndex(ctx context.Context, ref string, imageIndex ImageIndex) error {
+	digest, err := imageIndex.Digest()
+	if err != nil {
+		return err
+	}
+	if IsLayoutRef(ref) {
+		layoutRef, err := ParseLayoutRef(ref)
+		if err != nil {
+			return err
+		}
+		if err := layoutRef.write(imageIndex, nil); err != nil {
+			return err
+		}
+	} else {
+		parsedRef, err := name.ParseReference(ref)
+		if err != nil {
+			return fmt.Errorf("invalid URL %q: %w", ref, err)
+		}
+		if err := remote.WriteIndex(parsedRef, imageIndex, c.remoteWithContext(ctx)...); err != nil {
+			return err
+		}
+	}
+	newDigest, err := c.Digest(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if digest.String() != newDigest {
+		return fmt.Errorf("unexpected digest mismatch after writing: %s (from destination registry) != %s (written)", newDigest, digest)
+	}
+	return nil
+}
+
 func IsCosignArtifact(ref string) bool {
 	return ociclient.IsCosignArtifact(ref)
 }
@@ -0,0 +1,101 @@
+package oci_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+
+	. "github.com/docker/labs-brown-tape/oci"
+	"github.com/docker/labs-brown-tape/trex"
+)
+
+func TestListReferrers(t *testing.T) {
+	trex.RunShared()
+	withReferrers := trex.New(0).WithReferrers()
+	withReferrers.RunInBackground(context.Background())
+
+	for description, registry := range map[string]*trex.Trex{
+		"tag-schema":    trex.Shared.Trex,
+		"referrers-api": withReferrers,
+	} {
+		registry := registry
+		usesTagSchema := registry == trex.Shared.Trex
+		t.Run(description, func(t *testing.T) {
+			craneOptions := registry.CraneOptions()
+			makeDestination := registry.NewUniqueRepoNamer("bpt-referrers-test")
+
+			g := NewWithT(t)
+			ctx := context.Background()
+			client := NewClient(craneOptions)
+
+			subject, err := random.Image(128, 1)
+			g.Expect(err).ToNot(HaveOccurred())
+			subjectDescriptor, err := partial.Descriptor(subject)
+			g.Expect(err).ToNot(HaveOccurred())
+			subjectDigest := subjectDescriptor.Digest.String()
+
+			src := makeDestination("src")
+			g.Expect(crane.Push(subject, src+":v1", craneOptions...)).To(Succeed())
+
+			expectedReferrers := map[string]string{}
+			for _, artifactType := range []string{"application/vnd.example.sig", "application/vnd.example.sbom"} {
+				referrer, err := random.Image(64, 1)
+				g.Expect(err).ToNot(HaveOccurred())
+				referrer = mutate.ConfigMediaType(referrer, types.MediaType(artifactType))
+				referrer = mutate.Subject(referrer, *subjectDescriptor).(v1.Image)
+				referrerDigest, err := referrer.Digest()
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(crane.Push(referrer, src+"@"+referrerDigest.String(), craneOptions...)).To(Succeed())
+				expectedReferrers[referrerDigest.String()] = artifactType
+			}
+
+			expectReferrers := func(ref string) {
+				referrers, err := client.ListReferrers(ctx, ref, subjectDigest)
+				g.Expect(err).ToNot(HaveOccurred())
+				found := map[string]string{}
+				for _, referrer := range referrers {
+					found[referrer.Digest.String()] = referrer.ArtifactType
+				}
+				g.Expect(found).To(Equal(expectedReferrers))
+			}
+
+			expectReferrers(src)
+
+			tags, err := crane.ListTags(src, craneOptions...)
+			g.Expect(err).ToNot(HaveOccurred())
+			if usesTagSchema {
+				g.Expect(tags).To(ContainElement(strings.Replace(subjectDigest, ":", "-", 1)))
+			} else {
+				g.Expect(tags).To(ConsistOf("v1"))
+			}
+
+			referrers, err := client.ListReferrers(ctx, makeDestination("empty"), subjectDigest)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(referrers).To(BeEmpty())
+
+			// referrers are stored untagged in a layout and pushed by digest
+			layoutPath := filepath.Join(t.TempDir(), "layout")
+			g.Expect(client.Copy(ctx, src+":v1", LayoutRef{Path: layoutPath, Tag: "app.1"}.String(), subjectDigest)).To(Succeed())
+			for digest := range expectedReferrers {
+				g.Expect(client.Copy(ctx, src+"@"+digest, LayoutRef{Path: layoutPath, Digest: digest}.String(), digest)).To(Succeed())
+			}
+			expectReferrers(LayoutRef{Path: layoutPath}.String())
+
+			// PushLayout requires an artefact
+			g.Expect(client.Copy(ctx, src+":v1", LayoutRef{Path: layoutPath, Tag: "config.1"}.String(), subjectDigest)).To(Succeed())
+			dst := makeDestination("dst")
+			_, err = client.PushLayout(ctx, layoutPath, dst)
+			g.Expect(err).ToNot(HaveOccurred())
+			expectReferrers(dst)
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"sigs.k8s.io/yaml"
+
+	"github.com/docker/labs-brown-tape/manifest/types"
+)
+
+type Scope string
+
+const (
+	// ScopeImage rules are evaluated once for each source of every image,
+	// with `image`, `source` and `input` variables
+	ScopeImage Scope = "image"
+	// ScopeStatement rules are evaluated once for each attestation statement,
+	// with `statement` and `input` variables
+	ScopeStatement Scope = "statement"
+)
+
+type Rule struct {
+	Name  string `json:"name"`
+	Scope Scope  `json:"scope"`
+	// Expression is written in CEL, it must evaluate to true, otherwise
+	// the rule is violated
+	Expression string `json:"expression"`
+	Message    string `json:"message,omitempty"`
+
+	program cel.Program
+}
+
+type Policy struct {
+	Rules []*Rule `json:"rules"`
+}
+
+// Input is what the rules are evaluated against, each of the images must have
+// `sources` field, and each of the statements must have `subject` field
+type Input struct {
+	Images     any `json:"images"`
+	Statements any `json:"statements"`
+}
+
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	// Source is where the violation was found, for statement rules
+	// it's made of subject names, and line and column are only set when
+	// the predicate has a location of an image reference
+	Source types.Source `json:"source"`
+}
+
+func (v Violation) String() string {
+	location := v.Source.Manifest
+	if v.Source.Line != 0 {
+		location = fmt.Sprintf("%s:%d:%d", location, v.Source.Line, v.Source.Column)
+	}
+	if location == "" {
+		return fmt.Sprintf("%s: %s", v.Rule, v.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", location, v.Rule, v.Message)
+}
+
+// Load reads rules from all of the given files and compiles them into a single policy
+func Load(paths ...string) (*Policy, error) {
+	policy := &Policy{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read policy file: %w", err)
+		}
+		fromFile := &Policy{}
+		if err := yaml.UnmarshalStrict(data, fromFile); err != nil {
+			return nil, fmt.Errorf("unable to parse policy file %q: %w", path, err)
+		}
+		policy.Rules = append(policy.Rules, fromFile.Rules...)
+	}
+	if err := policy.Compile(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Scoped returns a policy with only the rules of the given scope, image rules
+// can be evaluated before any of the statements are made
+func (p *Policy) Scoped(scope Scope) *Policy {
+	scoped := &Policy{}
+	for _, rule := range p.Rules {
+		if rule.Scope == scope {
+			scoped.Rules = append(scoped.Rules, rule)
+		}
+	}
+	return scoped
+}
+
+func (p *Policy) Compile() error {
+	names := map[string]struct{}{}
+	for _, rule := range p.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("rule name must not be empty")
+		}
+		if _, ok := names[rule.Name]; ok {
+			return fmt.Errorf("duplicate rule %q", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+		if err := rule.compile(); err != nil {
+			return fmt.Errorf("invalid rule %q: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) compile() error {
+	variables := []cel.EnvOption{cel.Variable("input", cel.DynType)}
+	switch r.Scope {
+	case ScopeImage:
+		variables = append(variables, cel.Variable("image", cel.DynType), cel.Variable("source", cel.DynType))
+	case ScopeStatement:
+		variables = append(variables, cel.Variable("statement", cel.DynType))
+	default:
+		return fmt.Errorf("unsupported scope %q", r.Scope)
+	}
+	env, err := cel.NewEnv(variables...)
+	if err != nil {
+		return err
+	}
+	ast, issues := env.Compile(r.Expression)
+	if issues != nil && issues.Err() != nil {
+		return issues.Err()
+	}
+	if outputType := ast.OutputType(); outputType != cel.BoolType && outputType != cel.DynType {
+		return fmt.Errorf("expression must evaluate to bool, not %s", outputType)
+	}
+	r.program, err = env.Program(ast)
+	return err
+}
+
+// Evaluate returns all violations of the rules, an error is only returned when
+// the input is invalid or a rule cannot be evaluated
+func (p *Policy) Evaluate(input *Input) ([]Violation, error) {
+	document := struct {
+		Images     map[string]map[string]any `json:"images"`
+		Statements []map[string]any          `json:"statements"`
+	}{}
+	// rules operate on the same representation that is used for JSON output
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("unable to make policy input: %w", err)
+	}
+	inputVar := map[string]any{}
+	if err := json.Unmarshal(data, &inputVar); err != nil {
+		return nil, fmt.Errorf("unable to make policy input: %w", err)
+	}
+
+	violations := []Violation{}
+	for _, rule := range p.Rules {
+		switch rule.Scope {
+		case ScopeImage:
+			for _, ref := range sortedKeys(document.Images) {
+				image := document.Images[ref]
+				sources, _ := image["sources"].([]any)
+				for i := range sources {
+					ok, err := rule.eval(map[string]any{"input": inputVar, "image": image, "source": sources[i]})
+					if err != nil {
+						return nil, fmt.Errorf("failed to evaluate rule %q for %s: %w", rule.Name, ref, err)
+					}
+					if ok {
+						continue
+					}
+					// only the location is decoded, as manifest digest is not needed
+					location := struct {
+						Manifest    string `json:"manifest"`
+						Line        int    `json:"line"`
+						Column      int    `json:"column"`
+						OriginalRef string `json:"originalRef"`
+					}{}
+					if err := convert(sources[i], &location); err != nil {
+						return nil, err
+					}
+					violations = append(violations, rule.violation(types.Source{
+						ImageSourceLocation: types.ImageSourceLocation{
+							Manifest: location.Manifest,
+							Line:     location.Line,
+							Column:   location.Column,
+						},
+						OriginalRef: location.OriginalRef,
+					}))
+				}
+			}
+		case ScopeStatement:
+			for _, statement := range document.Statements {
+				ok, err := rule.eval(map[string]any{"input": inputVar, "statement": statement})
+				if err != nil {
+					return nil, fmt.Errorf("failed to evaluate rule %q for statement of type %q: %w", rule.Name, statement["predicateType"], err)
+				}
+				if ok {
+					continue
+				}
+				location, err := statementLocation(statement)
+				if err != nil {
+					return nil, err
+				}
+				violations = append(violations, rule.violation(types.Source{ImageSourceLocation: location}))
+			}
+		}
+	}
+	return violations, nil
+}
+
+// statementLocation returns names of the subjects, when there is only one subject and
+// the predicate has an image reference with a location (e.g. foundImageReference or
+// replacedImageReference), line and column of the reference are also returned
+func statementLocation(statement map[string]any) (types.ImageSourceLocation, error) {
+	subjects := []struct {
+		Name string `json:"name"`
+	}{}
+	if err := convert(statement["subject"], &subjects); err != nil {
+		return types.ImageSourceLocation{}, err
+	}
+	names := make([]string, 0, len(subjects))
+	for _, subject := range subjects {
+		names = append(names, subject.Name)
+	}
+	location := types.ImageSourceLocation{Manifest: strings.Join(names, ", ")}
+	predicate, ok := statement["predicate"].(map[string]any)
+	if len(subjects) != 1 || !ok {
+		return location, nil
+	}
+	for _, key := range sortedKeys(predicate) {
+		ref := struct {
+			Reference string `json:"reference"`
+			Line      int    `json:"line"`
+			Column    int    `json:"column"`
+		}{}
+		if _, ok := predicate[key].(map[string]any); !ok {
+			continue
+		}
+		if err := convert(predicate[key], &ref); err != nil {
+			continue
+		}
+		if ref.Reference != "" && ref.Line != 0 {
+			location.Line = ref.Line
+			location.Column = ref.Column
+			break
+		}
+	}
+	return location, nil
+}
+
+func (r *Rule) eval(vars map[string]any) (bool, error) {
+	val, _, err := r.program.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	ok, isBool := val.Value().(bool)
+	if !isBool {
+		return false, fmt.Errorf("expression evaluated to %v instead of bool", val.Value())
+	}
+	return ok, nil
+}
+
+func (r *Rule) violation(source types.Source) Violation {
+	message := r.Message
+	if message == "" {
+		message = fmt.Sprintf("expression %q is false", r.Expression)
+	}
+	return Violation{
+		Rule:    r.Name,
+		Message: message,
+		Source:  source,
+	}
+}
+
+func convert(from, to any) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, to)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,171 @@
+package policy_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	toto "github.com/in-toto/in-toto-golang/in_toto"
+	. "github.com/onsi/gomega"
+
+	"github.com/docker/labs-brown-tape/manifest/types"
+	. "github.com/docker/labs-brown-tape/policy"
+)
+
+const rules = `
+rules:
+- name: no-latest-tag
+  scope: image
+  expression: '!source.originalRef.endsWith(":latest")'
+  message: image must not use latest tag
+- name: allowed-registries
+  scope: image
+  expression: 'image.ref.startsWith("ghcr.io/example/")'
+- name: signed-images
+  scope: image
+  expression: 'has(image.externalSignatures) && size(image.externalSignatures) > 0'
+  message: image must be signed
+- name: unmodified-manifests
+  scope: statement
+  expression: |
+    statement.predicateType != "docker.com/tape/ManifestDir/v0.1" ||
+      statement.predicate.containedInDirectory.vcsEntries.entryGroups.all(g, g.all(e, e.unmodified))
+  message: manifests must be committed
+`
+
+func TestPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.yaml")
+	g.Expect(os.WriteFile(path, []byte(rules), 0o644)).To(Succeed())
+
+	policy, err := Load(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(policy.Rules).To(HaveLen(4))
+
+	source := func(ref, manifest string, line, column int) types.Source {
+		return types.Source{
+			OriginalRef: ref,
+			ImageSourceLocation: types.ImageSourceLocation{
+				Manifest: manifest,
+				Line:     line,
+				Column:   column,
+			},
+		}
+	}
+
+	images := map[string]any{
+		"ghcr.io/example/app:latest@sha256:abc": map[string]any{
+			"ref":                "ghcr.io/example/app:latest@sha256:abc",
+			"sources":            []types.Source{source("ghcr.io/example/app:latest", "deployment.yaml", 10, 16)},
+			"externalSignatures": map[string]any{"ghcr.io/example/app:sha256-abc.sig": map[string]any{}},
+		},
+		"docker.io/library/nginx:1.25@sha256:def": map[string]any{
+			"ref": "docker.io/library/nginx:1.25@sha256:def",
+			"sources": []types.Source{
+				source("nginx:1.25", "deployment.yaml", 20, 16),
+				source("nginx:1.25", "job.yaml", 5, 10),
+			},
+		},
+	}
+	statements := []toto.Statement{{
+		StatementHeader: toto.StatementHeader{
+			PredicateType: "docker.com/tape/ManifestDir/v0.1",
+			Subject:       []toto.Subject{{Name: "deployment.yaml"}, {Name: "job.yaml"}},
+		},
+		Predicate: map[string]any{
+			"containedInDirectory": map[string]any{
+				"vcsEntries": map[string]any{
+					"entryGroups": [][]map[string]any{{{"unmodified": true}, {"unmodified": false}}},
+				},
+			},
+		},
+	}}
+
+	violations, err := policy.Evaluate(&Input{Images: images, Statements: statements})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	messages := []string{}
+	for _, violation := range violations {
+		messages = append(messages, violation.String())
+	}
+	g.Expect(messages).To(Equal([]string{
+		"deployment.yaml:10:16: no-latest-tag: image must not use latest tag",
+		`deployment.yaml:20:16: allowed-registries: expression "image.ref.startsWith(\"ghcr.io/example/\")" is false`,
+		`job.yaml:5:10: allowed-registries: expression "image.ref.startsWith(\"ghcr.io/example/\")" is false`,
+		"deployment.yaml:20:16: signed-images: image must be signed",
+		"job.yaml:5:10: signed-images: image must be signed",
+		"deployment.yaml, job.yaml: unmodified-manifests: manifests must be committed",
+	}))
+
+	violations, err = policy.Evaluate(&Input{Images: map[string]any{}, Statements: []toto.Statement{}})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(violations).To(BeEmpty())
+}
+
+func TestPolicyStatementLocation(t *testing.T) {
+	g := NewWithT(t)
+
+	policy := &Policy{Rules: []*Rule{
+		{
+			Name:       "no-example-images",
+			Scope:      ScopeStatement,
+			Expression: `!has(statement.predicate.replacedImageReference) || !statement.predicate.replacedImageReference.reference.startsWith("example.com/")`,
+			Message:    "images must not be copied to example.com",
+		},
+		{
+			Name:       "no-latest-tag",
+			Scope:      ScopeImage,
+			Expression: `!source.originalRef.endsWith(":latest")`,
+		},
+	}}
+	g.Expect(policy.Compile()).To(Succeed())
+
+	replacedImageRef := func(manifest, ref string, line, column int) toto.Statement {
+		return toto.Statement{
+			StatementHeader: toto.StatementHeader{
+				PredicateType: "docker.com/tape/ReplacedImageRef/v0.1",
+				Subject:       []toto.Subject{{Name: manifest}},
+			},
+			Predicate: map[string]any{
+				"replacedImageReference": map[string]any{"reference": ref, "line": line, "column": column},
+			},
+		}
+	}
+	statements := []toto.Statement{
+		replacedImageRef("deployment.yaml", "example.com/app@sha256:abc", 10, 16),
+		replacedImageRef("job.yaml", "ghcr.io/example/app@sha256:abc", 5, 10),
+	}
+
+	violations, err := policy.Evaluate(&Input{Images: map[string]any{}, Statements: statements})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(violations).To(HaveLen(1))
+	g.Expect(violations[0].String()).To(Equal("deployment.yaml:10:16: no-example-images: images must not be copied to example.com"))
+
+	scoped := policy.Scoped(ScopeImage)
+	g.Expect(scoped.Rules).To(HaveLen(1))
+	g.Expect(scoped.Rules[0].Name).To(Equal("no-latest-tag"))
+	violations, err = scoped.Evaluate(&Input{Images: map[string]any{}, Statements: statements})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(violations).To(BeEmpty())
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"scope.yaml":     "rules:\n- name: a\n  scope: manifest\n  expression: 'true'\n",
+		"syntax.yaml":    "rules:\n- name: a\n  scope: image\n  expression: 'image.ref =='\n",
+		"type.yaml":      "rules:\n- name: a\n  scope: image\n  expression: '1 + 1'\n",
+		"variable.yaml":  "rules:\n- name: a\n  scope: image\n  expression: 'statement.subject == []'\n",
+		"duplicate.yaml": "rules:\n- name: a\n  scope: image\n  expression: 'true'\n- name: a\n  scope: image\n  expression: 'true'\n",
+		"field.yaml":     "rules:\n- name: a\n  scope: image\n  expr: 'true'\n",
+	} {
+		path := filepath.Join(dir, name)
+		g.Expect(os.WriteFile(path, []byte(contents), 0o644)).To(Succeed())
+		_, err := Load(path)
+		g.Expect(err).To(HaveOccurred(), name)
+	}
+}
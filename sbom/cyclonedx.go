@@ -0,0 +1,178 @@
+package sbom
+
+import (
+	"fmt"
+	"time"
+)
+
+const cycloneDXSpecVersion = "1.5"
+
+// only the subset of CycloneDX that is needed to describe an application is defined here
+
+type cycloneDXDocument struct {
+	BOMFormat    string                `json:"bomFormat"`
+	SpecVersion  string                `json:"specVersion"`
+	Version      int                   `json:"version"`
+	Metadata     cycloneDXMetadata     `json:"metadata"`
+	Components   []cycloneDXComponent  `json:"components"`
+	Dependencies []cycloneDXDependency `json:"dependencies"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string             `json:"timestamp"`
+	Tools     cycloneDXTools     `json:"tools"`
+	Component cycloneDXComponent `json:"component"`
+}
+
+type cycloneDXTools struct {
+	Components []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXComponent struct {
+	Type               string                       `json:"type"`
+	BOMRef             string                       `json:"bom-ref,omitempty"`
+	Name               string                       `json:"name"`
+	Version            string                       `json:"version,omitempty"`
+	PURL               string                       `json:"purl,omitempty"`
+	Hashes             []cycloneDXHash              `json:"hashes,omitempty"`
+	Properties         []cycloneDXProperty          `json:"properties,omitempty"`
+	ExternalReferences []cycloneDXExternalReference `json:"externalReferences,omitempty"`
+	Components         []cycloneDXComponent         `json:"components,omitempty"`
+}
+
+// cycloneDXPackage is what is read from per-image documents, other fields are
+// not needed and may be of different shape in older versions of the spec
+type cycloneDXPackage struct {
+	Type       string             `json:"type"`
+	Name       string             `json:"name"`
+	Version    string             `json:"version"`
+	PURL       string             `json:"purl"`
+	Components []cycloneDXPackage `json:"components"`
+}
+
+type cycloneDXHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cycloneDXExternalReference struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type cycloneDXDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+func makeCycloneDX(app *Application) *cycloneDXDocument {
+	appRef := "app:" + app.Name
+	doc := &cycloneDXDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: cycloneDXSpecVersion,
+		Version:     1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: app.Created.UTC().Format(time.RFC3339),
+			Tools: cycloneDXTools{
+				Components: []cycloneDXComponent{{Type: "application", Name: toolName}},
+			},
+			Component: cycloneDXComponent{
+				Type:   "application",
+				BOMRef: appRef,
+				Name:   app.Name,
+			},
+		},
+		Components:   []cycloneDXComponent{},
+		Dependencies: []cycloneDXDependency{},
+	}
+
+	fileRefs := make([]string, 0, len(app.Manifests))
+	imagesByManifest := map[string][]string{}
+	for _, image := range app.Images {
+		for _, manifest := range image.Manifests {
+			imagesByManifest[manifest] = append(imagesByManifest[manifest], image.Ref)
+		}
+	}
+	for _, manifest := range app.Manifests {
+		fileRef := "file:" + manifest.Path
+		fileRefs = append(fileRefs, fileRef)
+		doc.Components = append(doc.Components, cycloneDXComponent{
+			Type:   "file",
+			BOMRef: fileRef,
+			Name:   manifest.Path,
+			Hashes: []cycloneDXHash{{Algorithm: "SHA-256", Content: manifest.Digest.String()}},
+		})
+		doc.Dependencies = append(doc.Dependencies, cycloneDXDependency{
+			Ref:       fileRef,
+			DependsOn: imagesByManifest[manifest.Path],
+		})
+	}
+	doc.Dependencies = append([]cycloneDXDependency{{Ref: appRef, DependsOn: fileRefs}}, doc.Dependencies...)
+
+	for _, image := range app.Images {
+		name, imageDigest := imageName(image.Ref)
+		component := cycloneDXContainer(image.Ref, name, imageDigest, image.SBOMs)
+		platformRefs := make([]string, 0, len(image.Platforms))
+		for _, platform := range image.Platforms {
+			// the same platform digest may appear in more than one image, e.g. when two tags
+			// refer to the same index, so the reference is made unique with the image
+			platformRef := image.Ref + "#" + platform.Digest
+			platformComponent := cycloneDXContainer(platformRef, name, platform.Digest, platform.SBOMs)
+			platformComponent.Properties = append(platformComponent.Properties, cycloneDXProperty{
+				Name:  "oci:platform",
+				Value: platform.String(),
+			})
+			platformRefs = append(platformRefs, platformComponent.BOMRef)
+			component.Components = append(component.Components, platformComponent)
+		}
+		doc.Components = append(doc.Components, component)
+		doc.Dependencies = append(doc.Dependencies, cycloneDXDependency{Ref: image.Ref, DependsOn: platformRefs})
+	}
+	return doc
+}
+
+// cycloneDXContainer returns component for image or platform-specific image, packages
+// from SBOMs become nested components and SBOMs in separate tags are also referenced
+func cycloneDXContainer(ref, name, imageDigest string, sboms []SBOM) cycloneDXComponent {
+	component := cycloneDXComponent{
+		Type:    "container",
+		BOMRef:  ref,
+		Name:    name,
+		Version: imageDigest,
+		PURL:    imagePURL(name, imageDigest),
+		Hashes:  []cycloneDXHash{{Algorithm: "SHA-256", Content: hexDigest(imageDigest)}},
+	}
+	for i, sbom := range sboms {
+		if sbom.Ref != "" {
+			component.ExternalReferences = append(component.ExternalReferences, cycloneDXExternalReference{
+				Type: "bom",
+				URL:  sbom.Ref,
+			})
+		}
+		for j, pkg := range sbom.Packages {
+			component.Components = append(component.Components, cycloneDXComponent{
+				Type:    "library",
+				BOMRef:  fmt.Sprintf("%s#%d.%d", ref, i, j),
+				Name:    pkg.Name,
+				Version: pkg.Version,
+				PURL:    pkg.PURL,
+			})
+		}
+	}
+	return component
+}
+
+func appendCycloneDXPackages(packages []Package, components []cycloneDXPackage) []Package {
+	for _, component := range components {
+		if component.Type != "file" {
+			packages = append(packages, Package{Name: component.Name, Version: component.Version, PURL: component.PURL})
+		}
+		packages = appendCycloneDXPackages(packages, component.Components)
+	}
+	return packages
+}
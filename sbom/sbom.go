@@ -0,0 +1,204 @@
+package sbom
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	kimage "sigs.k8s.io/kustomize/api/image"
+
+	"github.com/docker/labs-brown-tape/attest/digest"
+)
+
+type Format string
+
+const (
+	FormatCycloneDX Format = "cyclonedx"
+	FormatSPDX      Format = "spdx"
+
+	CycloneDXPredicateType = "https://cyclonedx.org/bom"
+	SPDXPredicateType      = "https://spdx.dev/Document"
+
+	toolName = "tape"
+)
+
+// Application is what the bill of materials is made for, it consists
+// of all manifests and images that these reference
+type Application struct {
+	Name      string
+	Created   time.Time
+	Manifests []Manifest
+	Images    []Image
+}
+
+type Manifest struct {
+	Path   string
+	Digest digest.SHA256
+}
+
+type Image struct {
+	// Ref must have a digest
+	Ref string
+	// Manifests are paths of all manifests where the image is referenced
+	Manifests []string
+	Platforms []Platform
+	// SBOMs are documents that describe the image as a whole
+	SBOMs []SBOM
+}
+
+type Platform struct {
+	Digest       string
+	OS           string
+	Architecture string
+	Variant      string
+	// SBOMs are documents that describe the image for this platform
+	SBOMs []SBOM
+}
+
+// SBOM is a per-image document, its packages are merged into the application document
+// and reference is added when it was obtained from a separate tag
+type SBOM struct {
+	Ref      string
+	Packages []Package
+}
+
+type Package struct {
+	Name    string
+	Version string
+	PURL    string
+}
+
+// Make returns the document in the given format along with in-toto predicate type
+func Make(format Format, app *Application) (string, any, error) {
+	app.sort()
+	switch format {
+	case FormatCycloneDX:
+		return CycloneDXPredicateType, makeCycloneDX(app), nil
+	case FormatSPDX:
+		return SPDXPredicateType, makeSPDX(app), nil
+	default:
+		return "", nil, fmt.Errorf("unsupported SBOM format %q", format)
+	}
+}
+
+// PackagesFromDocument returns packages listed in SPDX or CycloneDX document, the format
+// is detected from the contents, which can be given as any value that has JSON representation
+// of the document
+func PackagesFromDocument(document any) ([]Package, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	header := struct {
+		BOMFormat   string `json:"bomFormat"`
+		SPDXVersion string `json:"spdxVersion"`
+	}{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("unable to parse SBOM: %w", err)
+	}
+	switch {
+	case header.BOMFormat == "CycloneDX":
+		return PackagesFromCycloneDX(document)
+	case header.SPDXVersion != "":
+		return PackagesFromSPDX(document)
+	default:
+		return nil, fmt.Errorf("unable to parse SBOM, only SPDX and CycloneDX documents are supported")
+	}
+}
+
+// PackagesFromCycloneDX returns components listed in CycloneDX document, including nested
+// ones, files are not packages, so these are omitted
+func PackagesFromCycloneDX(document any) ([]Package, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	bom := &struct {
+		Components []cycloneDXPackage `json:"components"`
+	}{}
+	if err := json.Unmarshal(data, bom); err != nil {
+		return nil, fmt.Errorf("unable to parse CycloneDX document: %w", err)
+	}
+	return appendCycloneDXPackages([]Package{}, bom.Components), nil
+}
+
+// PackagesFromSPDX returns packages listed in SPDX document, which can be given
+// as any value that has JSON representation of the document
+func PackagesFromSPDX(document any) ([]Package, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	spdx := &spdxDocument{}
+	if err := json.Unmarshal(data, spdx); err != nil {
+		return nil, fmt.Errorf("unable to parse SPDX document: %w", err)
+	}
+	packages := make([]Package, 0, len(spdx.Packages))
+	for _, pkg := range spdx.Packages {
+		p := Package{Name: pkg.Name, Version: pkg.VersionInfo}
+		for _, ref := range pkg.ExternalRefs {
+			if ref.ReferenceType == "purl" {
+				p.PURL = ref.ReferenceLocator
+				break
+			}
+		}
+		packages = append(packages, p)
+	}
+	return packages, nil
+}
+
+func (a *Application) sort() {
+	slices.SortFunc(a.Manifests, func(a, b Manifest) int { return cmp.Compare(a.Path, b.Path) })
+	slices.SortFunc(a.Images, func(a, b Image) int { return cmp.Compare(a.Ref, b.Ref) })
+	for i := range a.Images {
+		image := &a.Images[i]
+		slices.Sort(image.Manifests)
+		image.Manifests = slices.Compact(image.Manifests)
+		slices.SortFunc(image.Platforms, func(a, b Platform) int { return cmp.Compare(a.Digest, b.Digest) })
+		sortSBOMs(image.SBOMs)
+		for j := range image.Platforms {
+			sortSBOMs(image.Platforms[j].SBOMs)
+		}
+	}
+}
+
+func sortSBOMs(sboms []SBOM) {
+	slices.SortFunc(sboms, func(a, b SBOM) int { return cmp.Compare(a.Ref, b.Ref) })
+	for i := range sboms {
+		slices.SortFunc(sboms[i].Packages, func(a, b Package) int {
+			if c := cmp.Compare(a.PURL, b.PURL); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.Name+"@"+a.Version, b.Name+"@"+b.Version)
+		})
+	}
+}
+
+// imagePURL returns package URL of the image as defined for OCI artifacts,
+// e.g. pkg:oci/app@sha256%3Aabc?repository_url=example.com/org/app
+func imagePURL(ref, imageDigest string) string {
+	name, _, _ := kimage.Split(ref)
+	return "pkg:oci/" + strings.ToLower(name[strings.LastIndex(name, "/")+1:]) +
+		"@" + strings.ReplaceAll(imageDigest, ":", "%3A") + "?repository_url=" + name
+}
+
+func imageName(ref string) (string, string) {
+	name, _, imageDigest := kimage.Split(ref)
+	return name, imageDigest
+}
+
+func hexDigest(imageDigest string) string {
+	_, hex, _ := strings.Cut(imageDigest, ":")
+	return hex
+}
+
+func (p Platform) String() string {
+	platform := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		platform += "/" + p.Variant
+	}
+	return platform
+}
@@ -0,0 +1,206 @@
+package sbom_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	. "github.com/docker/labs-brown-tape/sbom"
+)
+
+const (
+	appDigest      = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+	amd64Digest    = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	arm64Digest    = "sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	nginxDigest    = "sha256:4444444444444444444444444444444444444444444444444444444444444444"
+	deploymentHash = "5555555555555555555555555555555555555555555555555555555555555555"
+	jobHash        = "6666666666666666666666666666666666666666666666666666666666666666"
+)
+
+const imageSPDX = `{
+  "spdxVersion": "SPDX-2.3",
+  "packages": [
+    {"name": "musl", "versionInfo": "1.2.4", "externalRefs": [{"referenceCategory": "PACKAGE-MANAGER", "referenceType": "purl", "referenceLocator": "pkg:apk/alpine/musl@1.2.4"}]},
+    {"name": "busybox", "versionInfo": "1.36.1"}
+  ]
+}`
+
+func makeApplication(g *WithT) *Application {
+	spdx := map[string]any{}
+	g.Expect(json.Unmarshal([]byte(imageSPDX), &spdx)).To(Succeed())
+	packages, err := PackagesFromSPDX(spdx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(packages).To(ConsistOf(
+		Package{Name: "musl", Version: "1.2.4", PURL: "pkg:apk/alpine/musl@1.2.4"},
+		Package{Name: "busybox", Version: "1.36.1"},
+	))
+
+	return &Application{
+		Name:    "ghcr.io/example/app-config",
+		Created: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Manifests: []Manifest{
+			{Path: "job.yaml", Digest: jobHash},
+			{Path: "deployment.yaml", Digest: deploymentHash},
+		},
+		Images: []Image{
+			{
+				Ref:       "docker.io/library/nginx:1.25@" + nginxDigest,
+				Manifests: []string{"deployment.yaml"},
+			},
+			{
+				Ref:       "ghcr.io/example/app:v1@" + appDigest,
+				Manifests: []string{"job.yaml", "deployment.yaml", "job.yaml"},
+				Platforms: []Platform{
+					{Digest: arm64Digest, OS: "linux", Architecture: "arm64", Variant: "v8"},
+					{Digest: amd64Digest, OS: "linux", Architecture: "amd64", SBOMs: []SBOM{{Packages: packages}}},
+				},
+				SBOMs: []SBOM{{Ref: "ghcr.io/example/app:sha256-1111.sbom"}},
+			},
+		},
+	}
+}
+
+func TestCycloneDX(t *testing.T) {
+	g := NewWithT(t)
+
+	predicateType, doc, err := Make(FormatCycloneDX, makeApplication(g))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(predicateType).To(Equal(CycloneDXPredicateType))
+
+	bom := decode(g, doc)
+	g.Expect(bom).To(HaveKeyWithValue("bomFormat", "CycloneDX"))
+	g.Expect(bom).To(HaveKeyWithValue("metadata", HaveKeyWithValue("timestamp", "2024-01-01T00:00:00Z")))
+
+	components := bom["components"].([]any)
+	g.Expect(components).To(HaveLen(4))
+	g.Expect(components[0]).To(HaveKeyWithValue("name", "deployment.yaml"))
+	g.Expect(components[1]).To(HaveKeyWithValue("name", "job.yaml"))
+
+	app := components[3].(map[string]any)
+	g.Expect(app).To(HaveKeyWithValue("purl", "pkg:oci/app@sha256%3A"+appDigest[7:]+"?repository_url=ghcr.io/example/app"))
+	g.Expect(app).To(HaveKeyWithValue("externalReferences", ConsistOf(map[string]any{
+		"type": "bom",
+		"url":  "ghcr.io/example/app:sha256-1111.sbom",
+	})))
+	platforms := app["components"].([]any)
+	g.Expect(platforms).To(HaveLen(2))
+	g.Expect(platforms[0]).To(HaveKeyWithValue("version", amd64Digest))
+	g.Expect(platforms[0]).To(HaveKeyWithValue("components", HaveLen(2)))
+	g.Expect(platforms[1]).To(HaveKeyWithValue("properties", ConsistOf(map[string]any{
+		"name":  "oci:platform",
+		"value": "linux/arm64/v8",
+	})))
+
+	g.Expect(bom["dependencies"]).To(ContainElements(
+		map[string]any{"ref": "file:deployment.yaml", "dependsOn": []any{"docker.io/library/nginx:1.25@" + nginxDigest, "ghcr.io/example/app:v1@" + appDigest}},
+		map[string]any{"ref": "file:job.yaml", "dependsOn": []any{"ghcr.io/example/app:v1@" + appDigest}},
+	))
+}
+
+func TestCycloneDXUniqueRefs(t *testing.T) {
+	g := NewWithT(t)
+
+	// both tags refer to the same index, so platforms have the same digests
+	app := makeApplication(g)
+	latest := app.Images[1]
+	latest.Ref = "ghcr.io/example/app:latest@" + appDigest
+	app.Images = append(app.Images, latest)
+
+	_, doc, err := Make(FormatCycloneDX, app)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	refs := map[string]int{}
+	var collect func(components []any)
+	collect = func(components []any) {
+		for _, component := range components {
+			component := component.(map[string]any)
+			refs[component["bom-ref"].(string)]++
+			if nested, ok := component["components"].([]any); ok {
+				collect(nested)
+			}
+		}
+	}
+	collect(decode(g, doc)["components"].([]any))
+	g.Expect(refs).To(HaveKey("ghcr.io/example/app:latest@" + appDigest + "#" + amd64Digest))
+	for ref, count := range refs {
+		g.Expect(count).To(Equal(1), ref)
+	}
+}
+
+func TestPackagesFromDocument(t *testing.T) {
+	g := NewWithT(t)
+
+	cycloneDX := map[string]any{}
+	g.Expect(json.Unmarshal([]byte(`{
+  "bomFormat": "CycloneDX",
+  "specVersion": "1.4",
+  "metadata": {"tools": [{"name": "syft"}]},
+  "components": [
+    {"type": "library", "name": "musl", "version": "1.2.4", "purl": "pkg:apk/alpine/musl@1.2.4"},
+    {"type": "operating-system", "name": "alpine", "version": "3.19.0", "components": [
+      {"type": "library", "name": "busybox", "version": "1.36.1"}
+    ]},
+    {"type": "file", "name": "/etc/os-release"}
+  ]
+}`), &cycloneDX)).To(Succeed())
+	packages, err := PackagesFromDocument(cycloneDX)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(packages).To(Equal([]Package{
+		{Name: "musl", Version: "1.2.4", PURL: "pkg:apk/alpine/musl@1.2.4"},
+		{Name: "alpine", Version: "3.19.0"},
+		{Name: "busybox", Version: "1.36.1"},
+	}))
+
+	spdx := map[string]any{}
+	g.Expect(json.Unmarshal([]byte(imageSPDX), &spdx)).To(Succeed())
+	packages, err = PackagesFromDocument(spdx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(packages).To(HaveLen(2))
+
+	_, err = PackagesFromDocument(map[string]any{"packages": []any{}})
+	g.Expect(err).To(MatchError(ContainSubstring("only SPDX and CycloneDX documents are supported")))
+}
+
+func TestSPDX(t *testing.T) {
+	g := NewWithT(t)
+
+	predicateType, doc, err := Make(FormatSPDX, makeApplication(g))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(predicateType).To(Equal(SPDXPredicateType))
+
+	spdx := decode(g, doc)
+	g.Expect(spdx).To(HaveKeyWithValue("spdxVersion", "SPDX-2.3"))
+	g.Expect(spdx["files"]).To(HaveLen(2))
+	// application, 2 images, 2 platforms and 2 packages from per-image SBOM
+	g.Expect(spdx["packages"]).To(HaveLen(7))
+	g.Expect(spdx["relationships"]).To(ContainElements(
+		map[string]any{"spdxElementId": "SPDXRef-DOCUMENT", "relationshipType": "DESCRIBES", "relatedSpdxElement": "SPDXRef-Application"},
+		map[string]any{"spdxElementId": "SPDXRef-File-0", "relationshipType": "DEPENDS_ON", "relatedSpdxElement": "SPDXRef-Image-1"},
+		map[string]any{"spdxElementId": "SPDXRef-File-1", "relationshipType": "DEPENDS_ON", "relatedSpdxElement": "SPDXRef-Image-1"},
+		map[string]any{"spdxElementId": "SPDXRef-Image-1", "relationshipType": "CONTAINS", "relatedSpdxElement": "SPDXRef-Image-1-Platform-0"},
+		map[string]any{"spdxElementId": "SPDXRef-Image-1-Platform-0", "relationshipType": "CONTAINS", "relatedSpdxElement": "SPDXRef-Image-1-Platform-0-Package-0-0"},
+	))
+
+	// merged packages can be read back
+	packages, err := PackagesFromSPDX(doc)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(packages).To(ContainElement(Package{Name: "musl", Version: "1.2.4", PURL: "pkg:apk/alpine/musl@1.2.4"}))
+
+	// same application results in the same document
+	_, again, err := Make(FormatSPDX, makeApplication(g))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(decode(g, again)).To(Equal(spdx))
+
+	_, _, err = Make(Format("swid"), makeApplication(g))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func decode(g *WithT, doc any) map[string]any {
+	data, err := json.Marshal(doc)
+	g.Expect(err).ToNot(HaveOccurred())
+	decoded := map[string]any{}
+	g.Expect(json.Unmarshal(data, &decoded)).To(Succeed())
+	return decoded
+}
@@ -0,0 +1,199 @@
+package sbom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const (
+	spdxVersion         = "SPDX-2.3"
+	spdxNamespacePrefix = "https://github.com/docker/labs-brown-tape/spdx/"
+	spdxNoAssertion     = "NOASSERTION"
+)
+
+// only the subset of SPDX that is needed to describe an application is defined here,
+// it is also used for parsing of per-image SBOMs
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Files             []spdxFile         `json:"files,omitempty"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID                string            `json:"SPDXID"`
+	Name                  string            `json:"name"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+	Comment               string            `json:"comment,omitempty"`
+}
+
+type spdxFile struct {
+	SPDXID    string         `json:"SPDXID"`
+	FileName  string         `json:"fileName"`
+	Checksums []spdxChecksum `json:"checksums"`
+}
+
+type spdxChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	Element        string `json:"spdxElementId"`
+	Type           string `json:"relationshipType"`
+	RelatedElement string `json:"relatedSpdxElement"`
+}
+
+func makeSPDX(app *Application) *spdxDocument {
+	const appID = "SPDXRef-Application"
+
+	// namespace must be unique for each document, it's derived from the contents
+	// so that the same application always results in the same document
+	namespace := sha256.New()
+	fmt.Fprintln(namespace, app.Name)
+
+	doc := &spdxDocument{
+		SPDXVersion: spdxVersion,
+		DataLicense: "CC0-1.0",
+		SPDXID:      "SPDXRef-DOCUMENT",
+		Name:        app.Name,
+		CreationInfo: spdxCreationInfo{
+			Created:  app.Created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + toolName},
+		},
+		Packages: []spdxPackage{{
+			SPDXID:                appID,
+			Name:                  app.Name,
+			DownloadLocation:      spdxNoAssertion,
+			PrimaryPackagePurpose: "APPLICATION",
+		}},
+		Files: []spdxFile{},
+		Relationships: []spdxRelationship{{
+			Element:        "SPDXRef-DOCUMENT",
+			Type:           "DESCRIBES",
+			RelatedElement: appID,
+		}},
+	}
+
+	fileIDs := make(map[string]string, len(app.Manifests))
+	for i, manifest := range app.Manifests {
+		fmt.Fprintln(namespace, manifest.Path, manifest.Digest)
+		fileID := fmt.Sprintf("SPDXRef-File-%d", i)
+		fileIDs[manifest.Path] = fileID
+		doc.Files = append(doc.Files, spdxFile{
+			SPDXID:    fileID,
+			FileName:  manifest.Path,
+			Checksums: []spdxChecksum{{Algorithm: "SHA256", Value: manifest.Digest.String()}},
+		})
+		doc.relate(appID, "CONTAINS", fileID)
+	}
+
+	for i, image := range app.Images {
+		fmt.Fprintln(namespace, image.Ref)
+		name, imageDigest := imageName(image.Ref)
+		imageID := fmt.Sprintf("SPDXRef-Image-%d", i)
+		doc.Packages = append(doc.Packages, spdxContainer(imageID, image.Ref, name, imageDigest, image.SBOMs))
+		for _, manifest := range image.Manifests {
+			if fileID, ok := fileIDs[manifest]; ok {
+				doc.relate(fileID, "DEPENDS_ON", imageID)
+			}
+		}
+		doc.appendPackages(imageID, image.SBOMs)
+
+		for j, platform := range image.Platforms {
+			platformID := fmt.Sprintf("%s-Platform-%d", imageID, j)
+			platformPackage := spdxContainer(platformID, name+"@"+platform.Digest, name, platform.Digest, platform.SBOMs)
+			platformPackage.Comment = "platform: " + platform.String()
+			doc.Packages = append(doc.Packages, platformPackage)
+			doc.relate(imageID, "CONTAINS", platformID)
+			doc.appendPackages(platformID, platform.SBOMs)
+		}
+	}
+
+	doc.DocumentNamespace = spdxNamespacePrefix + hex.EncodeToString(namespace.Sum(nil))
+	return doc
+}
+
+// spdxContainer returns package for image or platform-specific image, SBOMs
+// in separate tags are referenced
+func spdxContainer(id, ref, name, imageDigest string, sboms []SBOM) spdxPackage {
+	pkg := spdxPackage{
+		SPDXID:                id,
+		Name:                  name,
+		VersionInfo:           imageDigest,
+		DownloadLocation:      spdxNoAssertion,
+		PrimaryPackagePurpose: "CONTAINER",
+		Checksums:             []spdxChecksum{{Algorithm: "SHA256", Value: hexDigest(imageDigest)}},
+		ExternalRefs: []spdxExternalRef{{
+			ReferenceCategory: "PACKAGE-MANAGER",
+			ReferenceType:     "purl",
+			ReferenceLocator:  imagePURL(ref, imageDigest),
+		}},
+	}
+	for _, sbom := range sboms {
+		if sbom.Ref != "" {
+			pkg.ExternalRefs = append(pkg.ExternalRefs, spdxExternalRef{
+				ReferenceCategory: "OTHER",
+				ReferenceType:     "sbom",
+				ReferenceLocator:  sbom.Ref,
+			})
+		}
+	}
+	return pkg
+}
+
+func (d *spdxDocument) relate(element, relationshipType, relatedElement string) {
+	d.Relationships = append(d.Relationships, spdxRelationship{
+		Element:        element,
+		Type:           relationshipType,
+		RelatedElement: relatedElement,
+	})
+}
+
+// appendPackages merges packages from SBOMs, these are contained in the container package
+func (d *spdxDocument) appendPackages(containerID string, sboms []SBOM) {
+	for i, sbom := range sboms {
+		for j, p := range sbom.Packages {
+			pkg := spdxPackage{
+				SPDXID:                fmt.Sprintf("%s-Package-%d-%d", containerID, i, j),
+				Name:                  p.Name,
+				VersionInfo:           p.Version,
+				DownloadLocation:      spdxNoAssertion,
+				PrimaryPackagePurpose: "LIBRARY",
+			}
+			if p.PURL != "" {
+				pkg.ExternalRefs = []spdxExternalRef{{
+					ReferenceCategory: "PACKAGE-MANAGER",
+					ReferenceType:     "purl",
+					ReferenceLocator:  p.PURL,
+				}}
+			}
+			d.Packages = append(d.Packages, pkg)
+			d.relate(containerID, "CONTAINS", pkg.SPDXID)
+		}
+	}
+}
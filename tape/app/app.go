@@ -2,13 +2,28 @@ package app
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 	flags "github.com/thought-machine/go-flags"
+	kustomize "sigs.k8s.io/kustomize/api/types"
 
+	"github.com/docker/labs-brown-tape/attest/cosign"
+	"github.com/docker/labs-brown-tape/attest/signer"
 	"github.com/docker/labs-brown-tape/logger"
+	"github.com/docker/labs-brown-tape/manifest/imageresolver"
+	"github.com/docker/labs-brown-tape/manifest/loader"
+	manifestTypes "github.com/docker/labs-brown-tape/manifest/types"
+	"github.com/docker/labs-brown-tape/oci"
 )
 
 type OutputFormat string
@@ -22,8 +37,54 @@ const (
 type TapeCommand struct {
 	LogLevel string `short:"l" long:"log-level" description:"Log level" default:"info"`
 
-	log *logger.Logger
-	ctx context.Context
+	RegistryAuthOptions
+	RegistryCacheOptions
+
+	log   *logger.Logger
+	ctx   context.Context
+	cache *oci.Cache
+	// transport is used for registry requests instead of the default one, it's only set in tests
+	transport http.RoundTripper
+}
+
+type RegistryAuthOptions struct {
+	Registry      string `long:"registry" description:"Registry to use explicit credentials for (defaults to registry of the image given to the command)"`
+	Username      string `long:"username" description:"Username for registry authentication, other credentials are read from Docker config"`
+	PasswordStdin bool   `long:"password-stdin" description:"Read password for registry authentication from stdin"`
+
+	// password is read from stdin once by Init, as stdin can only be consumed once
+	// while NewClient is called for each of the registries that a command uses
+	password *string
+}
+
+// readPassword checks that --username and --password-stdin are given together,
+// and reads the password from stdin unless it has been read already
+func (o *RegistryAuthOptions) readPassword() error {
+	if o.Username == "" {
+		if o.PasswordStdin {
+			return fmt.Errorf("--password-stdin requires --username")
+		}
+		return nil
+	}
+	if !o.PasswordStdin {
+		return fmt.Errorf("--username requires --password-stdin")
+	}
+	if o.password != nil {
+		return nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read password from stdin: %w", err)
+	}
+	password := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	o.password = &password
+	return nil
+}
+
+type RegistryCacheOptions struct {
+	NoCache     bool          `long:"no-cache" description:"Disable caching of registry lookups"`
+	DiskCache   bool          `long:"disk-cache" description:"Keep cached registry lookups on disk under user cache dir, so that these are reused by subsequent commands"`
+	CacheTagTTL time.Duration `long:"cache-tag-ttl" description:"How long lookups by tag are cached for, lookups by digest are cached indefinitely" default:"5m"`
 }
 
 type OutputFormatOptions struct {
@@ -31,7 +92,133 @@ type OutputFormatOptions struct {
 }
 
 type InputManifestDirOptions struct {
-	ManifestDir string `short:"D" long:"manifest-dir" description:"Intput directory to read manifests from" required:"true"`
+	ManifestDir string `short:"D" long:"manifest-dir" description:"Intput directory to read manifests from, when it contains a kustomization only the output of kustomize build is used and other files are excluded"`
+
+	HelmChartOptions
+	ImageFieldSpecOptions
+}
+
+type ImageFieldSpecOptions struct {
+	ImageFieldSpecs     []string `long:"image-field-spec" description:"Additional path to look for images in, in the form of '[[<group>/]<version>/]<kind>:<path>', e.g. 'tekton.dev/v1/Task:spec/steps[]/image', can be given multiple times"`
+	ImageFieldSpecsFile string   `long:"image-field-specs-file" description:"Path to file with additional paths to look for images in, using the format of 'images' section of kustomize transformer configuration"`
+}
+
+// FieldSpecs returns additional image paths given with either of the flags
+func (o *ImageFieldSpecOptions) FieldSpecs() ([]kustomize.FieldSpec, error) {
+	fieldSpecs := []kustomize.FieldSpec{}
+	if o.ImageFieldSpecsFile != "" {
+		fromFile, err := manifestTypes.LoadImageFieldSpecs(o.ImageFieldSpecsFile)
+		if err != nil {
+			return nil, err
+		}
+		fieldSpecs = append(fieldSpecs, fromFile...)
+	}
+	for _, spec := range o.ImageFieldSpecs {
+		fieldSpec, err := manifestTypes.ParseImageFieldSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		fieldSpecs = append(fieldSpecs, fieldSpec)
+	}
+	return fieldSpecs, nil
+}
+
+type KnownDigestOptions struct {
+	KnownDigests     []string `long:"known-digest" description:"Digest to use for an image instead of looking it up in the registry, in the form of '<name>:<tag>=<digest>', can be given multiple times"`
+	KnownDigestsFile []string `long:"known-digests-file" description:"Path to metadata file written by 'docker buildx build --metadata-file' or 'docker buildx bake --metadata-file' to take known digests from, can be given multiple times"`
+}
+
+// LoadKnownDigests returns known digests given with either of the flags
+func (o *KnownDigestOptions) LoadKnownDigests() (imageresolver.KnownDigests, error) {
+	knownDigests := imageresolver.KnownDigests{}
+	for _, path := range o.KnownDigestsFile {
+		if err := knownDigests.LoadKnownDigests(path); err != nil {
+			return nil, err
+		}
+	}
+	for _, knownDigest := range o.KnownDigests {
+		if err := knownDigests.ParseKnownDigest(knownDigest); err != nil {
+			return nil, err
+		}
+	}
+	return knownDigests, nil
+}
+
+type SignatureVerificationOptions struct {
+	SignatureKey          string `long:"signature-key" description:"Path to PEM-encoded public key to verify cosign signatures of app images with"`
+	CertificateIdentity   string `long:"certificate-identity" description:"Identity that keyless signatures of app images must be made by, e.g. email or workflow URI"`
+	CertificateOIDCIssuer string `long:"certificate-oidc-issuer" description:"OIDC issuer of the identity that keyless signatures of app images must be made by"`
+	TrustedRoot           string `long:"trusted-root" description:"Path to sigstore trusted root file with certificate authorities and transparency logs to verify keyless signatures against"`
+}
+
+// NewSignatureVerifier returns verifier for either of the modes, or nil when
+// none of the flags are given
+func (o *SignatureVerificationOptions) NewSignatureVerifier() (*cosign.Verifier, error) {
+	keyless := o.CertificateIdentity != "" || o.CertificateOIDCIssuer != "" || o.TrustedRoot != ""
+	switch {
+	case o.SignatureKey != "" && keyless:
+		return nil, fmt.Errorf("--signature-key cannot be used together with --certificate-identity, --certificate-oidc-issuer or --trusted-root")
+	case o.SignatureKey != "":
+		return cosign.NewKeyVerifier(o.SignatureKey)
+	case keyless:
+		if o.CertificateIdentity == "" || o.CertificateOIDCIssuer == "" || o.TrustedRoot == "" {
+			return nil, fmt.Errorf("--certificate-identity, --certificate-oidc-issuer and --trusted-root must be given together")
+		}
+		return cosign.NewKeylessVerifier(o.TrustedRoot, o.CertificateIdentity, o.CertificateOIDCIssuer)
+	default:
+		return nil, nil
+	}
+}
+
+type AttestationsVerificationOptions struct {
+	VerifyKey string `long:"verify-key" description:"Path to PEM-encoded public key to verify signatures of attestations with, fails unless every statement is signed with this key"`
+}
+
+// NewEnvelopeVerifier returns verifier of attestation envelopes, or nil when
+// --verify-key is not given
+func (o *AttestationsVerificationOptions) NewEnvelopeVerifier() (*dsse.EnvelopeVerifier, error) {
+	if o.VerifyKey == "" {
+		return nil, nil
+	}
+	verifier, err := signer.LoadPublicKeyFromFile(o.VerifyKey)
+	if err != nil {
+		return nil, err
+	}
+	return dsse.NewEnvelopeVerifier(verifier)
+}
+
+type HelmChartOptions struct {
+	HelmChart       string   `long:"helm-chart" description:"Path to Helm chart directory or archive to render manifests from, instead of reading these from --manifest-dir"`
+	HelmValues      []string `long:"helm-values" description:"Path to values file to use when rendering Helm chart, can be given multiple times"`
+	HelmReleaseName string   `long:"helm-release-name" description:"Release name to use when rendering Helm chart" default:"release-name"`
+	HelmNamespace   string   `long:"helm-namespace" description:"Namespace to use when rendering Helm chart" default:"default"`
+}
+
+// NewLoader returns the loader for the given options, along with the path to
+// the directory that the manifests come from, which is used for VCS detection
+func (o *InputManifestDirOptions) NewLoader() (loader.Loader, string, error) {
+	switch {
+	case o.ManifestDir != "" && o.HelmChart != "":
+		return nil, "", fmt.Errorf("--manifest-dir and --helm-chart cannot be used together")
+	case o.ManifestDir != "":
+		if len(o.HelmValues) != 0 {
+			return nil, "", fmt.Errorf("--helm-values can only be used with --helm-chart")
+		}
+		return loader.NewRecursiveManifestDirectoryLoader(o.ManifestDir), o.ManifestDir, nil
+	case o.HelmChart != "":
+		fi, err := os.Stat(o.HelmChart)
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to access Helm chart: %w", err)
+		}
+		sourceDir := o.HelmChart
+		if !fi.IsDir() {
+			sourceDir = filepath.Dir(o.HelmChart)
+		}
+		return loader.NewHelmChartLoader(o.HelmChart, o.HelmValues...).
+			WithRelease(o.HelmReleaseName, o.HelmNamespace), sourceDir, nil
+	default:
+		return nil, "", fmt.Errorf("either --manifest-dir or --helm-chart must be specified")
+	}
 }
 
 type OutputManifestDirOptions struct {
@@ -47,6 +234,31 @@ func Run() int {
 		ctx: ctx,
 	}
 
+	fp, err := newParser(tape)
+	if err != nil {
+		tape.log.Errorf("%s", err)
+		return 1
+	}
+
+	if _, err := fp.Parse(); err != nil {
+		switch flagsErr := err.(type) {
+		case flags.ErrorType:
+			if flagsErr == flags.ErrHelp {
+				return 0
+			}
+			tape.log.Errorf("failed to parse flags: %s", err)
+			return 1
+		default:
+			tape.log.Errorf("command failed: %s", err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// newParser returns parser with all of the commands added
+func newParser(tape *TapeCommand) (*flags.Parser, error) {
 	fp := flags.NewParser(tape, flags.HelpFlag)
 
 	commands := []struct {
@@ -59,7 +271,7 @@ func Run() int {
 			name:  "images",
 			short: "List app image",
 			long: []string{
-				"This command load manifests from the given dir and prints info about",
+				"This command loads manifests from the given dir and prints info about",
 				"all app images referenced in these manifests",
 			},
 			options: &TapeImagesCommand{
@@ -67,6 +279,19 @@ func Run() int {
 				InputManifestDirOptions: InputManifestDirOptions{},
 			},
 		},
+		{
+			name:  "sbom",
+			short: "Make bill of materials for the app",
+			long: []string{
+				"This command loads manifests from the given dir and prints CycloneDX or SPDX",
+				"bill of materials that lists all app images, the manifests these are referenced",
+				"in and packages from per-image SBOMs",
+			},
+			options: &TapeSBOMCommand{
+				tape:                    tape,
+				InputManifestDirOptions: InputManifestDirOptions{},
+			},
+		},
 		{
 			name:  "package",
 			short: "Package an artefact",
@@ -77,6 +302,17 @@ func Run() int {
 				tape:                    tape,
 				InputManifestDirOptions: InputManifestDirOptions{}},
 		},
+		{
+			name:  "push-layout",
+			short: "Push an artefact from OCI layout",
+			long: []string{
+				"This command pushes an artefact and all app images from an OCI layout directory",
+				"created with 'tape package --output-layout' to a registry",
+			},
+			options: &TapePushLayoutCommand{
+				tape: tape,
+			},
+		},
 		{
 			name:  "pull",
 			short: "Pull an artefact",
@@ -92,31 +328,109 @@ func Run() int {
 				tape: tape,
 			},
 		},
+		{
+			name:  "diff",
+			short: "Compare two artefacts",
+			long: []string{
+				"This command compares contents of two artefacts, and prints differences in",
+				"resources, app images and VCS info of the manifests",
+			},
+			options: &TapeDiffCommand{
+				tape: tape,
+			},
+		},
+		{
+			name:  "apply",
+			short: "Apply an artefact",
+			long: []string{
+				"This command applies contents of an artefact to a cluster, using the",
+				"content interpreter given by the artefact annotation",
+			},
+			options: &TapeApplyCommand{
+				tape: tape,
+			},
+		},
+		{
+			name:  "verify",
+			short: "Verify an artefact",
+			long: []string{
+				"This command checks that contents of an artefact match its attestations",
+				"and that all app images referenced in the manifests are present in the",
+				"package repository",
+			},
+			options: &TapeVerifyCommand{
+				tape: tape,
+			},
+		},
 	}
 
 	for _, c := range commands {
 		_, err := fp.AddCommand(c.name, c.short, strings.Join(c.long, "\n"), c.options)
 		if err != nil {
-			tape.log.Errorf("failed to add %s command: %s", c.name, err)
-			return 1
+			return nil, fmt.Errorf("failed to add %s command: %w", c.name, err)
 		}
 	}
+	return fp, nil
+}
 
-	if _, err := fp.Parse(); err != nil {
-		switch flagsErr := err.(type) {
-		case flags.ErrorType:
-			if flagsErr == flags.ErrHelp {
-				return 0
-			}
-			tape.log.Errorf("failed to parse flags: %s", err)
-			return 1
-		default:
-			tape.log.Errorf("command failed: %s", err)
-			return 1
+// NewClient returns a client that authenticates using Docker config and credential helpers,
+// explicit credentials are used for the registry of the given image unless --registry is set
+func (c *TapeCommand) NewClient(image string) (*oci.Client, error) {
+	options, err := c.cacheOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Username == "" {
+		return oci.NewClient(options), nil
+	}
+	if c.password == nil {
+		return nil, fmt.Errorf("password for %q has not been read from stdin", c.Username)
+	}
+
+	registry := c.Registry
+	if registry == "" {
+		if image == "" || oci.IsLayoutRef(image) {
+			return nil, fmt.Errorf("--registry must be set when using --username")
+		}
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine registry of %q: %w", image, err)
 		}
+		registry = ref.Context().RegistryStr()
 	}
 
-	return 0
+	keychain, err := oci.NewKeychain(registry, c.Username, *c.password)
+	if err != nil {
+		return nil, err
+	}
+	c.log.Debugf("using explicit credentials for %q", registry)
+
+	return oci.NewClient(append(options, crane.WithAuthFromKeychain(keychain))), nil
+}
+
+// cacheOptions returns options for using the cache, the same cache is shared
+// by all clients created by the command
+func (c *TapeCommand) cacheOptions() ([]crane.Option, error) {
+	if c.NoCache {
+		if c.transport != nil {
+			return []crane.Option{crane.WithTransport(c.transport)}, nil
+		}
+		return nil, nil
+	}
+	if c.cache == nil {
+		cacheDir := ""
+		if c.DiskCache {
+			var err error
+			cacheDir, err = oci.DefaultCacheDir()
+			if err != nil {
+				return nil, err
+			}
+			c.log.Debugf("caching registry lookups in %q", cacheDir)
+		}
+		c.cache = oci.NewCache(cacheDir, c.CacheTagTTL)
+	}
+	return []crane.Option{oci.WithCache(c.cache, c.transport)}, nil
 }
 
 func (c *TapeCommand) Init() error {
@@ -126,5 +440,5 @@ func (c *TapeCommand) Init() error {
 	if err := c.log.SetLevel(c.LogLevel); err != nil {
 		return err
 	}
-	return nil
+	return c.readPassword()
 }
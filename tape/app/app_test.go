@@ -0,0 +1,161 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+
+	"github.com/docker/labs-brown-tape/logger"
+	"github.com/docker/labs-brown-tape/trex"
+)
+
+// runCommand parses args the same way as Run does and executes the command,
+// registry requests are made with a transport that trusts the test registry
+func runCommand(args ...string) error {
+	tape := &TapeCommand{
+		log:       logger.New(),
+		ctx:       context.Background(),
+		transport: trex.Shared.Transport(),
+	}
+	fp, err := newParser(tape)
+	if err != nil {
+		return err
+	}
+	_, err = fp.ParseArgs(args)
+	return err
+}
+
+// runCommandWithOutput runs the command and returns what it wrote to stdout
+func runCommandWithOutput(t *testing.T, args ...string) ([]byte, error) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan []byte)
+	go func() {
+		buf := &bytes.Buffer{}
+		_, _ = io.Copy(buf, r)
+		output <- buf.Bytes()
+	}()
+
+	err = runCommand(args...)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return <-output, err
+}
+
+// makeTestRepo creates a git repository with manifests in the "manifests" directory,
+// which refer to an image pushed to the test registry, and returns path to the repository
+// relative to the working directory, as the loader doesn't accept absolute paths
+func makeTestRepo(t *testing.T, makeRepoName func(string) string) string {
+	t.Helper()
+	g := NewWithT(t)
+
+	index, err := random.Index(256, 1, 2)
+	g.Expect(err).ToNot(HaveOccurred())
+	imageRef := makeRepoName("src/app") + ":v1"
+	parsedImageRef, err := name.ParseReference(imageRef)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.WriteIndex(parsedImageRef, index, crane.GetOptions(trex.Shared.CraneOptions()...).Remote...)).To(Succeed())
+
+	repoDir := t.TempDir()
+	manifestDir := filepath.Join(repoDir, "manifests")
+	g.Expect(os.Mkdir(manifestDir, 0o755)).To(Succeed())
+
+	manifests := map[string]string{
+		"deployment.yaml": "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\nspec:\n  template:\n    spec:\n      containers:\n      - name: app\n        image: " + imageRef + "\n",
+		"service.yaml":    "apiVersion: v1\nkind: Service\nmetadata:\n  name: app\n",
+	}
+	for name, contents := range manifests {
+		g.Expect(os.WriteFile(filepath.Join(manifestDir, name), []byte(contents), 0o644)).To(Succeed())
+	}
+
+	repo, err := gogit.PlainInit(repoDir, false)
+	g.Expect(err).ToNot(HaveOccurred())
+	worktree, err := repo.Worktree()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(worktree.AddGlob("manifests")).To(Succeed())
+	_, err = worktree.Commit("add manifests", &gogit.CommitOptions{
+		Author: &object.Signature{
+			Name:  "test",
+			Email: "test@example.com",
+			When:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	return relativePath(t, repoDir)
+}
+
+func relativePath(t *testing.T, path string) string {
+	t.Helper()
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relPath, err := filepath.Rel(workDir, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return relPath
+}
+
+func TestPasswordStdinIsReadOnce(t *testing.T) {
+	g := NewWithT(t)
+
+	r, w, err := os.Pipe()
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = w.WriteString("secret\n")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(w.Close()).To(Succeed())
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	tape := &TapeCommand{
+		LogLevel: "info",
+		ctx:      context.Background(),
+		RegistryAuthOptions: RegistryAuthOptions{
+			Username:      "user",
+			PasswordStdin: true,
+		},
+	}
+	g.Expect(tape.Init()).To(Succeed())
+	g.Expect(tape.Init()).To(Succeed())
+
+	// stdin is already drained, so clients after the first one only work
+	// when the password is kept from the first read
+	for _, image := range []string{"example.com/app", "example.org/app"} {
+		_, err := tape.NewClient(image)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(tape.password).ToNot(BeNil())
+	g.Expect(*tape.password).To(Equal("secret"))
+
+	for _, options := range []RegistryAuthOptions{
+		{Username: "user"},
+		{PasswordStdin: true},
+	} {
+		tape := &TapeCommand{LogLevel: "info", RegistryAuthOptions: options}
+		g.Expect(tape.Init()).ToNot(Succeed())
+	}
+}
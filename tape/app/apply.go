@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/fluxcd/pkg/tar"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/docker/labs-brown-tape/interpreter"
+	"github.com/docker/labs-brown-tape/oci"
+)
+
+type TapeApplyCommand struct {
+	tape *TapeCommand
+
+	Image string `short:"I" long:"image" description:"Name of the image to apply" required:"true"`
+
+	Kubeconfig  string `long:"kubeconfig" description:"Path to kubeconfig file, defaults to KUBECONFIG or ~/.kube/config"`
+	KubeContext string `long:"context" description:"Name of the kubeconfig context to use"`
+	Namespace   string `short:"n" long:"namespace" description:"Namespace to use for resources that don't specify one, defaults to namespace of the kubeconfig context"`
+
+	FieldManager       string `long:"field-manager" description:"Name of the field manager to use for server-side apply" default:"tape"`
+	ForceConflicts     bool   `long:"force-conflicts" description:"Take ownership of fields that are managed by other field managers"`
+	InventoryName      string `long:"inventory-name" description:"Name of the config map to keep inventory of applied resources in, required for pruning"`
+	InventoryNamespace string `long:"inventory-namespace" description:"Namespace of the inventory config map, defaults to namespace for resources"`
+	Prune              bool   `long:"prune" description:"Delete resources that are in the inventory but are no longer in the artefact"`
+}
+
+func (c *TapeApplyCommand) Execute(args []string) error {
+	ctx := context.WithValue(c.tape.ctx, "command", "apply")
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	if err := c.tape.Init(); err != nil {
+		return err
+	}
+
+	client, err := c.tape.NewClient(c.Image)
+	if err != nil {
+		return err
+	}
+
+	registry, err := c.NewRegistry()
+	if err != nil {
+		return err
+	}
+
+	result, err := c.Apply(ctx, client, registry)
+	if result != nil {
+		for _, ref := range result.Applied {
+			c.tape.log.Infof("applied %s", ref)
+		}
+		for _, ref := range result.Pruned {
+			c.tape.log.Infof("pruned %s", ref)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply %q: %w", c.Image, err)
+	}
+	return nil
+}
+
+// NewRegistry returns registry of all supported content interpreters
+func (c *TapeApplyCommand) NewRegistry() (*interpreter.Registry, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = c.Kubeconfig
+	kubeconfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: c.KubeContext})
+
+	namespace := c.Namespace
+	if namespace == "" {
+		var err error
+		namespace, _, err = kubeconfig.Namespace()
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine namespace: %w", err)
+		}
+	}
+
+	restConfig, err := kubeconfig.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig: %w", err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+
+	kubectlApply, err := interpreter.NewKubectlApply(client, mapper, interpreter.KubectlApplyOptions{
+		FieldManager:       c.FieldManager,
+		ForceConflicts:     c.ForceConflicts,
+		Namespace:          namespace,
+		InventoryName:      c.InventoryName,
+		InventoryNamespace: c.InventoryNamespace,
+		Prune:              c.Prune,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	registry := interpreter.NewRegistry()
+	if err := registry.Register(oci.ContentInterpreterKubectlApply, kubectlApply); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Apply extracts contents of the artefact to a temporary directory and passes these
+// to the interpreter given by the artefact annotation
+func (c *TapeApplyCommand) Apply(ctx context.Context, client *oci.Client, registry *interpreter.Registry) (*interpreter.Result, error) {
+	artefacts, err := client.Fetch(ctx, c.Image, oci.ContentMediaType)
+	if err != nil {
+		return nil, err
+	}
+	if len(artefacts) != 1 {
+		return nil, fmt.Errorf("expected one content artefact, found %d", len(artefacts))
+	}
+	artefact := artefacts[0]
+	defer artefact.Close()
+
+	contentInterpreter, err := registry.Lookup(artefact.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "bpt-apply-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := tar.Untar(artefact, tempDir, tar.WithMaxUntarSize(-1)); err != nil {
+		return nil, fmt.Errorf("failed to extract manifests: %w", err)
+	}
+
+	return contentInterpreter.Apply(ctx, &interpreter.Contents{
+		Image:       c.Image,
+		Dir:         tempDir,
+		Annotations: artefact.Annotations,
+	})
+}
@@ -0,0 +1,362 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/fluxcd/pkg/tar"
+	toto "github.com/in-toto/in-toto-golang/in_toto"
+	kimage "sigs.k8s.io/kustomize/api/image"
+
+	"github.com/docker/labs-brown-tape/attest/manifest"
+	"github.com/docker/labs-brown-tape/attest/vcs/git"
+	"github.com/docker/labs-brown-tape/manifest/diff"
+	"github.com/docker/labs-brown-tape/oci"
+)
+
+type TapeDiffCommand struct {
+	tape *TapeCommand
+	OutputFormatOptions
+
+	OldImage string `long:"old-image" description:"Name of the image to compare against" required:"true"`
+	NewImage string `long:"new-image" description:"Name of the image to compare" required:"true"`
+}
+
+type diffInfo struct {
+	OldImage  string              `json:"oldImage"`
+	NewImage  string              `json:"newImage"`
+	Resources []diff.ResourceDiff `json:"resources"`
+	AppImages []appImageChange    `json:"appImages"`
+	VCS       *vcsChange          `json:"vcs,omitempty"`
+}
+
+// appImageChange is keyed by the name of the image as it was found in the manifests
+type appImageChange struct {
+	Name       string   `json:"name"`
+	OldDigests []string `json:"oldDigests,omitempty"`
+	NewDigests []string `json:"newDigests,omitempty"`
+}
+
+type vcsSummary struct {
+	Commit     string   `json:"commit,omitempty"`
+	Remotes    []string `json:"remotes,omitempty"`
+	Unmodified bool     `json:"unmodified"`
+}
+
+type vcsChange struct {
+	Old *vcsSummary `json:"old,omitempty"`
+	New *vcsSummary `json:"new,omitempty"`
+}
+
+type artefactContents struct {
+	resources  diff.Resources
+	statements []toto.Statement
+}
+
+func (c *TapeDiffCommand) Execute(args []string) error {
+	ctx := context.WithValue(c.tape.ctx, "command", "diff")
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	if err := c.tape.Init(); err != nil {
+		return err
+	}
+
+	client, err := c.tape.NewClient(c.NewImage)
+	if err != nil {
+		return err
+	}
+
+	outputInfo, err := c.CollectInfo(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to compare artifacts: %w", err)
+	}
+
+	if err := c.PrintInfo(ctx, outputInfo); err != nil {
+		return fmt.Errorf("failed to print differences between artifacts: %w", err)
+	}
+	return nil
+}
+
+func (c *TapeDiffCommand) CollectInfo(ctx context.Context, client *oci.Client) (*diffInfo, error) {
+	oldContents, err := c.fetch(ctx, client, c.OldImage)
+	if err != nil {
+		return nil, err
+	}
+	newContents, err := c.fetch(ctx, client, c.NewImage)
+	if err != nil {
+		return nil, err
+	}
+
+	outputInfo := &diffInfo{
+		OldImage:  c.OldImage,
+		NewImage:  c.NewImage,
+		Resources: diff.Compare(oldContents.resources, newContents.resources),
+		AppImages: []appImageChange{},
+	}
+
+	oldImages, err := appImageDigests(oldContents.statements)
+	if err != nil {
+		return nil, err
+	}
+	newImages, err := appImageDigests(newContents.statements)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for name := range oldImages {
+		names = append(names, name)
+	}
+	for name := range newImages {
+		if _, ok := oldImages[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if !slices.Equal(oldImages[name], newImages[name]) {
+			outputInfo.AppImages = append(outputInfo.AppImages, appImageChange{
+				Name:       name,
+				OldDigests: oldImages[name],
+				NewDigests: newImages[name],
+			})
+		}
+	}
+
+	oldVCS, err := makeVCSSummary(oldContents.statements)
+	if err != nil {
+		return nil, err
+	}
+	newVCS, err := makeVCSSummary(newContents.statements)
+	if err != nil {
+		return nil, err
+	}
+	if !vcsSummariesEqual(oldVCS, newVCS) {
+		outputInfo.VCS = &vcsChange{Old: oldVCS, New: newVCS}
+	}
+	return outputInfo, nil
+}
+
+// fetch extracts contents of the artefact to a temporary directory and loads resources
+// from it, along with the attestations
+func (c *TapeDiffCommand) fetch(ctx context.Context, client *oci.Client, image string) (*artefactContents, error) {
+	// NB: Fetch fails when there is more than one attestations layer, so statements
+	// are never taken from both embedded and referrer attestations
+	artefacts, err := client.Fetch(ctx, image, oci.ContentMediaType, oci.AttestMediaType, oci.SignedAttestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, artefact := range artefacts {
+			_ = artefact.Close()
+		}
+	}()
+
+	contents := &artefactContents{}
+	for i := range artefacts {
+		artefact := artefacts[i]
+		switch artefact.MediaType {
+		case oci.ContentMediaType:
+			tempDir, err := os.MkdirTemp("", "bpt-diff-")
+			if err != nil {
+				return nil, err
+			}
+			defer os.RemoveAll(tempDir)
+			if err := tar.Untar(artefact, tempDir, tar.WithMaxUntarSize(-1)); err != nil {
+				return nil, fmt.Errorf("failed to extract manifests from %q: %w", image, err)
+			}
+			contents.resources, err = diff.LoadResources(tempDir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load manifests from %q: %w", image, err)
+			}
+		case oci.AttestMediaType, oci.SignedAttestMediaType:
+			contents.statements, _, err = decodeAttestations(ctx, artefact, artefact.MediaType, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode attestations from %q: %w", image, err)
+			}
+		}
+	}
+	if contents.resources == nil {
+		return nil, fmt.Errorf("no manifests found in %q", image)
+	}
+	return contents, nil
+}
+
+type imageRefLocation struct {
+	manifest     string
+	line, column int
+}
+
+// appImageDigests returns digests of app images by original image name, these are matched
+// using the location of the reference in the manifest, as the new name is not unique
+func appImageDigests(statements []toto.Statement) (map[string][]string, error) {
+	originalNames := map[imageRefLocation]string{}
+	for _, statement := range statements {
+		if statement.PredicateType != manifest.OriginalImageRefPredicateType || len(statement.Subject) == 0 {
+			continue
+		}
+		predicate := &struct {
+			manifest.ImageRefenceWithLocation `json:"foundImageReference"`
+		}{}
+		if err := decodePredicate(statement, predicate); err != nil {
+			return nil, err
+		}
+		name, _, _ := kimage.Split(predicate.Reference)
+		originalNames[imageRefLocation{statement.Subject[0].Name, predicate.Line, predicate.Column}] = name
+	}
+
+	images := map[string][]string{}
+	for _, statement := range statements {
+		if statement.PredicateType != manifest.ReplacedImageRefPredicateType || len(statement.Subject) == 0 {
+			continue
+		}
+		predicate := &struct {
+			manifest.ImageRefenceWithLocation `json:"replacedImageReference"`
+		}{}
+		if err := decodePredicate(statement, predicate); err != nil {
+			return nil, err
+		}
+		name, _, digest := kimage.Split(predicate.Reference)
+		if originalName, ok := originalNames[imageRefLocation{statement.Subject[0].Name, predicate.Line, predicate.Column}]; ok {
+			name = originalName
+		}
+		if !slices.Contains(images[name], digest) {
+			images[name] = append(images[name], digest)
+		}
+	}
+	for name := range images {
+		slices.Sort(images[name])
+	}
+	return images, nil
+}
+
+// makeVCSSummary returns VCS info of the manifest directory, it's nil when
+// the directory is not in a repository
+func makeVCSSummary(statements []toto.Statement) (*vcsSummary, error) {
+	for _, statement := range statements {
+		if statement.PredicateType != manifest.ManifestDirPredicateType {
+			continue
+		}
+		predicate := &struct {
+			SourceDirectory struct {
+				VCSEntries *struct {
+					EntryGroups [][]git.Summary `json:"entryGroups"`
+				} `json:"vcsEntries"`
+			} `json:"containedInDirectory"`
+		}{}
+		if err := decodePredicate(statement, predicate); err != nil {
+			return nil, err
+		}
+		entries := predicate.SourceDirectory.VCSEntries
+		if entries == nil || len(entries.EntryGroups) == 0 || len(entries.EntryGroups[0]) == 0 {
+			return nil, nil
+		}
+		summary := &vcsSummary{Unmodified: true}
+		for _, group := range entries.EntryGroups {
+			for _, entry := range group {
+				summary.Unmodified = summary.Unmodified && entry.Unmodified
+			}
+		}
+		if entry := entries.EntryGroups[0][0]; entry.Git != nil {
+			summary.Commit = entry.Git.Reference.Hash
+			for remote, urls := range entry.Git.Remotes {
+				for _, url := range urls {
+					summary.Remotes = append(summary.Remotes, remote+" "+url)
+				}
+			}
+			slices.Sort(summary.Remotes)
+		}
+		return summary, nil
+	}
+	return nil, nil
+}
+
+func vcsSummariesEqual(a, b *vcsSummary) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Commit == b.Commit && a.Unmodified == b.Unmodified && slices.Equal(a.Remotes, b.Remotes)
+}
+
+func (c *TapeDiffCommand) PrintInfo(ctx context.Context, outputInfo *diffInfo) error {
+	switch c.OutputFormat {
+	case OutputFormatDirectJSON:
+		stdj := json.NewEncoder(os.Stdout)
+		stdj.SetIndent("", "  ")
+		if err := stdj.Encode(outputInfo); err != nil {
+			return fmt.Errorf("failed to marshal output: %w", err)
+		}
+	case OutputFormatText, OutputFormatDetailedText:
+		fmt.Printf("%s -> %s\n", outputInfo.OldImage, outputInfo.NewImage)
+		if len(outputInfo.Resources) == 0 {
+			fmt.Printf("  Resources: <unchanged>\n")
+		} else {
+			fmt.Printf("  Resources:\n")
+		}
+		for _, resource := range outputInfo.Resources {
+			switch resource.Change {
+			case diff.ChangeTypeAdded:
+				fmt.Printf("    %s %s (%s)\n", resource.Change, resource.ResourceID, resource.NewPath)
+			case diff.ChangeTypeRemoved:
+				fmt.Printf("    %s %s (%s)\n", resource.Change, resource.ResourceID, resource.OldPath)
+			default:
+				if resource.OldPath == resource.NewPath {
+					fmt.Printf("    %s %s (%s)\n", resource.Change, resource.ResourceID, resource.NewPath)
+				} else {
+					fmt.Printf("    %s %s (%s -> %s)\n", resource.Change, resource.ResourceID, resource.OldPath, resource.NewPath)
+				}
+			}
+			if c.OutputFormat == OutputFormatDetailedText {
+				for _, line := range strings.SplitAfter(resource.Diff, "\n") {
+					if line != "" {
+						fmt.Printf("      %s", line)
+					}
+				}
+			}
+		}
+		if len(outputInfo.AppImages) == 0 {
+			fmt.Printf("  App Images: <unchanged>\n")
+		} else {
+			fmt.Printf("  App Images:\n")
+		}
+		for _, image := range outputInfo.AppImages {
+			fmt.Printf("    %s: %s -> %s\n", image.Name, formatDigests(image.OldDigests), formatDigests(image.NewDigests))
+		}
+		if outputInfo.VCS == nil {
+			fmt.Printf("  VCS: <unchanged>\n")
+		} else {
+			old, new := outputInfo.VCS.Old, outputInfo.VCS.New
+			if old == nil {
+				old = &vcsSummary{}
+			}
+			if new == nil {
+				new = &vcsSummary{}
+			}
+			fmt.Printf("  VCS:\n")
+			fmt.Printf("    Commit: %s -> %s\n", formatValue(old.Commit), formatValue(new.Commit))
+			fmt.Printf("    Unmodified: %v -> %v\n", old.Unmodified, new.Unmodified)
+			if !slices.Equal(old.Remotes, new.Remotes) {
+				fmt.Printf("    Remotes: %s -> %s\n", formatValue(strings.Join(old.Remotes, ", ")), formatValue(strings.Join(new.Remotes, ", ")))
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported output format: %s", c.OutputFormat)
+	}
+	return nil
+}
+
+func formatDigests(digests []string) string {
+	return formatValue(strings.Join(digests, ", "))
+}
+
+func formatValue(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
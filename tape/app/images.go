@@ -1,7 +1,6 @@
 package app
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -13,11 +12,11 @@ import (
 	"github.com/in-toto/in-toto-golang/in_toto"
 
 	"github.com/secure-systems-lab/go-securesystemslib/dsse"
-	"github.com/sigstore/sigstore/pkg/cryptoutils"
 
+	"github.com/docker/labs-brown-tape/attest/cosign"
+	"github.com/docker/labs-brown-tape/logger"
 	"github.com/docker/labs-brown-tape/manifest/imageresolver"
 	"github.com/docker/labs-brown-tape/manifest/imagescanner"
-	"github.com/docker/labs-brown-tape/manifest/loader"
 	"github.com/docker/labs-brown-tape/manifest/types"
 	"github.com/docker/labs-brown-tape/oci"
 )
@@ -27,6 +26,8 @@ type TapeImagesCommand struct {
 
 	OutputFormatOptions
 	InputManifestDirOptions
+	KnownDigestOptions
+	SignatureVerificationOptions
 }
 
 type imageManifest struct {
@@ -45,10 +46,35 @@ type document struct {
 
 type documents map[string]document
 
+const (
+	signatureInfoMediaType = "application/vnd.com.docker.signinfo.v1alpha1"
+	spdxMediaType          = "application/spdx+json"
+)
+
+type signatureInfo struct {
+	SubjectAlternativeNames [][]string
+	Issuers                 []string `json:"issuers,omitempty"`
+	// Verified is set when at least one of the signatures is valid
+	Verified   bool     `json:"verified"`
+	VerifiedBy []string `json:"verifiedBy,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+}
+
+type signatureStatus string
+
+const (
+	signatureStatusUnsigned = signatureStatus("unsigned")
+	// signatureStatusUnverified means that there are signatures, but no key or identity to verify these with
+	signatureStatusUnverified = signatureStatus("unverified")
+	signatureStatusVerified   = signatureStatus("verified")
+	signatureStatusInvalid    = signatureStatus("invalid")
+)
+
 type imageInfo struct {
 	Ref                  string                      `json:"ref"`
 	Alias                *string                     `json:"alias,omitempty"`
 	DigestProvided       bool                        `json:"digestProvided"`
+	DigestSource         types.DigestSource          `json:"digestSource,omitempty"`
 	Sources              []types.Source              `json:"sources"`
 	InlineAttestations   documents                   `json:"inlineAttestations"`
 	ExternalAttestations documents                   `json:"externalAttestations"`
@@ -56,6 +82,7 @@ type imageInfo struct {
 	ExternalSBOMs        documents                   `json:"externalSBOMs,omitempty"`
 	InlineSignatures     documents                   `json:"inlineSignatures,omitempty"` // TODO: implement
 	ExternalSignatures   documents                   `json:"externalSignatures,omitempty"`
+	SignatureStatus      signatureStatus             `json:"signatureStatus"`
 	Related              map[string]*types.ImageList `json:"related,omitempty"`
 	RelatedUnclassified  []string                    `json:"relatedUnclassified,omitempty"`
 	Manifests            []imageManifest             `json:"manifests,omitempty"`
@@ -71,13 +98,29 @@ func (c *TapeImagesCommand) Execute(args []string) error {
 		return err
 	}
 
-	loader := loader.NewRecursiveManifestDirectoryLoader(c.ManifestDir)
+	loader, _, err := c.NewLoader()
+	if err != nil {
+		return err
+	}
+	fieldSpecs, err := c.FieldSpecs()
+	if err != nil {
+		return err
+	}
+	knownDigests, err := c.LoadKnownDigests()
+	if err != nil {
+		return err
+	}
+	verifier, err := c.NewSignatureVerifier()
+	if err != nil {
+		return err
+	}
 	if err := loader.Load(); err != nil {
 		return fmt.Errorf("failed to load manifests: %w", err)
 	}
 	c.tape.log.Debugf("loaded manifests: %v", loader.Paths())
 
 	scanner := imagescanner.NewDefaultImageScanner()
+	scanner.WithFieldSpecs(fieldSpecs...)
 
 	if err := scanner.Scan(loader.RelPaths()); err != nil {
 		return fmt.Errorf("failed to scan images: %w", err)
@@ -86,13 +129,15 @@ func (c *TapeImagesCommand) Execute(args []string) error {
 	images := scanner.GetImages()
 	c.tape.log.Debugf("found images: %#v", images.Items())
 
-	client := oci.NewClient(nil) // oci.NewDebugClient(os.Stdout, nil)
-	// TODO: use client.LoginWithCredentials() and/or other options
-	// TODO: integrate with docker-credential-helpers
+	client, err := c.tape.NewClient(registryImageRef(images)) // oci.NewDebugClient(os.Stdout, nil)
+	if err != nil {
+		return err
+	}
 
 	resolver := imageresolver.NewRegistryResolver(client)
+	resolver.WithKnownDigests(knownDigests)
 
-	outputInfo, err := c.CollectInfo(ctx, images, client, resolver)
+	outputInfo, err := c.CollectInfo(ctx, images, client, resolver, verifier)
 	if err != nil {
 		return fmt.Errorf("failed to collect info about images: %w", err)
 	}
@@ -104,15 +149,8 @@ func (c *TapeImagesCommand) Execute(args []string) error {
 	return nil
 }
 
-func (c *TapeImagesCommand) CollectInfo(ctx context.Context, images *types.ImageList, client *oci.Client, resolver imageresolver.Resolver) (map[string]imageInfo, error) {
-	outputInfo := make(map[string]imageInfo, len(images.Items()))
-
-	withDigests := map[string]struct{}{}
-	for _, image := range images.Items() {
-		if image.Digest != "" {
-			withDigests[image.Digest] = struct{}{}
-		}
-	}
+func (c *TapeImagesCommand) CollectInfo(ctx context.Context, images *types.ImageList, client *oci.Client, resolver imageresolver.Resolver, verifier *cosign.Verifier) (map[string]imageInfo, error) {
+	withDigests := imagesWithDigests(images)
 
 	c.tape.log.Info("resolving image digests")
 	if err := resolver.ResolveDigests(ctx, images); err != nil {
@@ -123,10 +161,40 @@ func (c *TapeImagesCommand) CollectInfo(ctx context.Context, images *types.Image
 		return nil, fmt.Errorf("failed to dedup images: %w", err)
 	}
 
+	return collectImageInfo(ctx, c.tape.log, images, withDigests, client, resolver, verifier)
+}
+
+// registryImageRef returns reference of the first image that is not in an OCI layout,
+// so that the registry of explicit credentials can be determined from it
+func registryImageRef(images *types.ImageList) string {
+	for _, image := range images.Items() {
+		if ref := image.Ref(true); !oci.IsLayoutRef(ref) {
+			return ref
+		}
+	}
+	return ""
+}
+
+// imagesWithDigests returns digests that were given in the manifests, it has to be
+// called before digests are resolved
+func imagesWithDigests(images *types.ImageList) map[string]struct{} {
+	withDigests := map[string]struct{}{}
+	for _, image := range images.Items() {
+		if image.Digest != "" {
+			withDigests[image.Digest] = struct{}{}
+		}
+	}
+	return withDigests
+}
+
+// collectImageInfo returns info about images with resolved digests, including
+// related attestations, SBOMs and signatures
+func collectImageInfo(ctx context.Context, log *logger.Logger, images *types.ImageList, withDigests map[string]struct{}, client *oci.Client, resolver imageresolver.Resolver, verifier *cosign.Verifier) (map[string]imageInfo, error) {
+	outputInfo := make(map[string]imageInfo, len(images.Items()))
+
 	// TODO: improve JSON formatter
 	// TODO: attestation formatter
 	// TODO: add test for the the CLI functionality, as fetching related tags is not covered by package tests
-	// TODO: include info about signatures
 	// TODO: include references to manifests where image is used
 
 	for _, image := range images.Items() {
@@ -135,6 +203,7 @@ func (c *TapeImagesCommand) CollectInfo(ctx context.Context, images *types.Image
 			Ref:                  image.Ref(true),
 			Alias:                image.Alias,
 			DigestProvided:       digestProvided,
+			DigestSource:         image.DigestSource,
 			Sources:              image.Sources,
 			InlineAttestations:   map[string]document{},
 			ExternalAttestations: map[string]document{},
@@ -146,14 +215,14 @@ func (c *TapeImagesCommand) CollectInfo(ctx context.Context, images *types.Image
 		}
 	}
 
-	c.tape.log.Info("resolving related images")
+	log.Info("resolving related images")
 
 	related, err := resolver.FindRelatedTags(ctx, images)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find related tags: %w", err)
 	}
 
-	c.tape.log.Debugf("related images: %#v", related.Items())
+	log.Debugf("related images: %#v", related.Items())
 
 	inspectManifest := func(image *types.Image, imageIndex oci.ImageIndex, indexManifest *oci.IndexManifest) error {
 		info := outputInfo[image.Ref(true)]
@@ -246,8 +315,8 @@ func (c *TapeImagesCommand) CollectInfo(ctx context.Context, images *types.Image
 		for _, related := range info.Related {
 			for _, relatedImage := range related.Items() {
 				ref := relatedImage.Ref(true)
-				switch {
-				case strings.HasSuffix(relatedImage.OriginalTag, ".att"):
+				switch relatedKind(relatedImage) {
+				case "att":
 					artefact, err := client.GetSingleArtefact(ctx, ref)
 					if err != nil {
 						return nil, fmt.Errorf("failed to fetch external attestation: %w", err)
@@ -267,7 +336,7 @@ func (c *TapeImagesCommand) CollectInfo(ctx context.Context, images *types.Image
 					}
 
 					info.ExternalAttestations[ref] = doc
-				case strings.HasSuffix(relatedImage.OriginalTag, ".sbom"):
+				case "sbom":
 					artefact, err := client.GetSingleArtefact(ctx, ref)
 					if err != nil {
 						return nil, fmt.Errorf("failed to fetch external attestation: %w", err)
@@ -278,7 +347,7 @@ func (c *TapeImagesCommand) CollectInfo(ctx context.Context, images *types.Image
 					}(nil)
 
 					switch artefact.MediaType {
-					case "spdx+json":
+					case "spdx+json", spdxMediaType:
 						decoder = json.NewDecoder(artefact)
 					default:
 						return nil, fmt.Errorf("unexpected media type of SBOM in %q: %s", ref, artefact.MediaType)
@@ -294,90 +363,19 @@ func (c *TapeImagesCommand) CollectInfo(ctx context.Context, images *types.Image
 					}
 
 					info.ExternalSBOMs[ref] = doc
-				case strings.HasSuffix(relatedImage.OriginalTag, ".sig"):
-					artefact, err := client.GetSingleArtefact(ctx, ref)
+				case "sig":
+					artefacts, err := client.GetArtefacts(ctx, ref)
 					if err != nil {
 						return nil, fmt.Errorf("failed to fetch external signature: %w", err)
 					}
-
-					if artefact.MediaType != "application/vnd.dev.cosign.simplesigning.v1+json" {
-						return nil, fmt.Errorf("unexpected media type of signature in %q: %s", ref, artefact.MediaType)
-					}
-
-					cosignBundleData, hasCosignBundle := artefact.Annotations["dev.sigstore.cosign/bundle"]
-					if !hasCosignBundle {
-						c.tape.log.Debugf("signature %q doesn't have bundle annotation", ref)
-						c.tape.log.Debugf("signature %q annotations %#v", ref, artefact.Annotations)
-						info.ExternalSignatures[ref] = document{
-							MediaType: "application/vnd.com.docker.signinfo.v1alpha1", // TODO: define this as a constant
-							Object:    nil,
-						}
-						break
-					}
-
-					bundleObj := &struct {
-						SignedEntryTimestamp string `json:"SignedEntryTimestamp"`
-						Payload              struct {
-							Body           string `json:"body"`
-							IntegratedTime int    `json:"integratedTime"`
-							LogIndex       int    `json:"logIndex"`
-							LogID          string `json:"logID"`
-						} `json:"Payload"`
-					}{}
-
-					if err := json.NewDecoder(strings.NewReader(cosignBundleData)).Decode(bundleObj); err != nil {
-						return nil, fmt.Errorf("failed to unmarshal signature bundle: %w", err)
-					}
-
-					hashedRekord := &struct {
-						APIVersion string `json:"apiVersion"`
-						Kind       string `json:"kind"`
-						Spec       struct {
-							Data struct {
-								Hash struct {
-									Algorithm string `json:"algorithm"`
-									Value     string `json:"value"`
-								} `json:"hash"`
-							} `json:"data"`
-							Signature struct {
-								Content   string `json:"content"`
-								PublicKey struct {
-									Content string `json:"content"`
-								} `json:"publicKey"`
-							} `json:"signature"`
-						} `json:"spec"`
-					}{}
-
-					if err := json.NewDecoder(newBase64Decoder(bundleObj.Payload.Body)).Decode(hashedRekord); err != nil {
-						return nil, fmt.Errorf("failed to unmarshal signature bundle: %w", err)
-					}
-					if hashedRekord.Kind != "hashedrekord" &&
-						hashedRekord.APIVersion != "0.0.1" {
-						return nil, fmt.Errorf("unexpected signature bundle version and kind: %s/%s", hashedRekord.Kind, hashedRekord.APIVersion)
+					// signature tag is named after the digest of the image it was made for
+					signedDigest := strings.Replace(strings.TrimSuffix(relatedImage.OriginalTag, ".sig"), "-", ":", 1)
+					if relatedImage.Subject != "" {
+						signedDigest = relatedImage.Subject
 					}
-
-					publicKeyPEM := bytes.NewBuffer(nil)
-					_, err = io.Copy(publicKeyPEM, newBase64Decoder(hashedRekord.Spec.Signature.PublicKey.Content))
-					if err != nil {
-						return nil, fmt.Errorf("failed to decode PEM signature bundle: %w", err)
-					}
-					certificates, err := cryptoutils.LoadCertificatesFromPEM(publicKeyPEM)
-					if err != nil {
-						return nil, fmt.Errorf("failed to load certificates from PEM signature bundle: %w", err)
-					}
-
-					certificiatesInfo := struct {
-						// TODO: add more info
-						SubjectAlternativeNames [][]string
-					}{}
-
-					for i := range certificates {
-						certificiatesInfo.SubjectAlternativeNames = append(certificiatesInfo.SubjectAlternativeNames, cryptoutils.GetSubjectAlternateNames(certificates[i]))
-					}
-
 					info.ExternalSignatures[ref] = document{
-						MediaType: "application/vnd.com.docker.signinfo.v1alpha1", // TODO: define this as a constant
-						Object:    certificiatesInfo,
+						MediaType: signatureInfoMediaType,
+						Object:    inspectSignatures(ctx, log, verifier, ref, signedDigest, artefacts),
 					}
 				default:
 					info.RelatedUnclassified = append(info.RelatedUnclassified, ref)
@@ -385,11 +383,85 @@ func (c *TapeImagesCommand) CollectInfo(ctx context.Context, images *types.Image
 			}
 		}
 
+		info.SignatureStatus = makeSignatureStatus(verifier, info.ExternalSignatures)
 		outputInfo[imageRef] = info
 	}
 	return outputInfo, nil
 }
 
+// relatedKind returns "att", "sbom" or "sig" for images that cosign stores either under
+// tags with these suffixes or as referrers with matching artifact type
+func relatedKind(image types.Image) string {
+	switch image.ArtifactType {
+	case cosign.AttestationArtifactType:
+		return "att"
+	case cosign.SBOMArtifactType, spdxMediaType:
+		return "sbom"
+	case cosign.SignatureArtifactType:
+		return "sig"
+	}
+	if i := strings.LastIndex(image.OriginalTag, "."); i >= 0 {
+		return image.OriginalTag[i+1:]
+	}
+	return ""
+}
+
+// inspectSignatures checks all signatures from a signature tag, any problems are recorded
+// as errors, as these only make the signatures invalid
+func inspectSignatures(ctx context.Context, log *logger.Logger, verifier *cosign.Verifier, ref, signedDigest string, artefacts []*oci.ArtefactInfo) *signatureInfo {
+	info := &signatureInfo{}
+	for _, artefact := range artefacts {
+		payload, err := io.ReadAll(artefact)
+		_ = artefact.Close()
+		if err != nil {
+			info.Errors = append(info.Errors, fmt.Sprintf("failed to read signature payload: %s", err))
+			continue
+		}
+		if artefact.MediaType != cosign.SimpleSigningMediaType {
+			info.Errors = append(info.Errors, fmt.Sprintf("unexpected media type of signature: %s", artefact.MediaType))
+			continue
+		}
+		signature, err := cosign.ParseSignature(payload, artefact.Annotations)
+		if err != nil {
+			info.Errors = append(info.Errors, fmt.Sprintf("invalid signature: %s", err))
+			continue
+		}
+		if names := signature.SubjectAlternativeNames(); len(names) > 0 {
+			info.SubjectAlternativeNames = append(info.SubjectAlternativeNames, names)
+		}
+		if issuer := signature.Issuer(); issuer != "" {
+			info.Issuers = append(info.Issuers, issuer)
+		}
+		if verifier == nil {
+			continue
+		}
+		verifiedBy, err := verifier.Verify(ctx, signature, signedDigest)
+		if err != nil {
+			log.Debugf("signature in %q is not valid: %s", ref, err)
+			info.Errors = append(info.Errors, err.Error())
+			continue
+		}
+		info.Verified = true
+		info.VerifiedBy = append(info.VerifiedBy, verifiedBy)
+	}
+	return info
+}
+
+func makeSignatureStatus(verifier *cosign.Verifier, signatures documents) signatureStatus {
+	switch {
+	case len(signatures) == 0:
+		return signatureStatusUnsigned
+	case verifier == nil:
+		return signatureStatusUnverified
+	}
+	for _, doc := range signatures {
+		if info, ok := doc.Object.(*signatureInfo); ok && info.Verified {
+			return signatureStatusVerified
+		}
+	}
+	return signatureStatusInvalid
+}
+
 func (c *TapeImagesCommand) PrintInfo(ctx context.Context, outputInfo map[string]imageInfo) error {
 	stdj := json.NewEncoder(os.Stdout)
 
@@ -412,11 +484,19 @@ func (c *TapeImagesCommand) PrintInfo(ctx context.Context, outputInfo map[string
 				fmt.Printf("    %s %s:%d:%d@sha256:%s\n", source.OriginalRef, source.Manifest, source.Line, source.Column, source.ManifestDigest)
 			}
 			fmt.Printf("  Digest provided: %v\n", info.DigestProvided)
+			if info.DigestSource != "" {
+				fmt.Printf("  Digest source: %s\n", info.DigestSource)
+			}
+			fmt.Printf("  Signature status: %s\n", info.SignatureStatus)
 
 			if len(info.Manifests) > 0 {
 				fmt.Printf("  OCI manifests:\n")
 				for _, manifest := range info.Manifests {
-					fmt.Printf("    %s  %s  %s  %d\n", manifest.Digest, manifest.MediaType, manifest.Platform.String(), manifest.Size)
+					platform := "<none>"
+					if manifest.Platform != nil {
+						platform = manifest.Platform.String()
+					}
+					fmt.Printf("    %s  %s  %s  %d\n", manifest.Digest, manifest.MediaType, platform, manifest.Size)
 				}
 			}
 
@@ -478,7 +558,3 @@ func (c *TapeImagesCommand) PrintInfo(ctx context.Context, outputInfo map[string
 	}
 	return nil
 }
-
-func newBase64Decoder(data string) io.Reader {
-	return base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))
-}
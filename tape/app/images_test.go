@@ -0,0 +1,59 @@
+package app
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/docker/labs-brown-tape/oci"
+	"github.com/docker/labs-brown-tape/trex"
+)
+
+func TestImagesWithLayoutRef(t *testing.T) {
+	trex.RunShared()
+	makeRepoName := trex.Shared.NewUniqueRepoNamer("bpt-images-test")
+
+	g := NewWithT(t)
+
+	repoDir := makeTestRepo(t, makeRepoName)
+	layoutPath := filepath.Join(t.TempDir(), "layout")
+
+	g.Expect(runCommand("package",
+		"--manifest-dir", filepath.Join(repoDir, "manifests"),
+		"--output-image", makeRepoName("dst/app"),
+		"--output-layout", layoutPath,
+	)).To(Succeed())
+
+	tags, err := oci.LayoutRef{Path: layoutPath}.Tags()
+	g.Expect(err).ToNot(HaveOccurred())
+	appImageRef, appImageDigest := "", ""
+	for tag, descriptor := range tags {
+		if !strings.HasPrefix(tag, "config.") {
+			appImageRef = oci.LayoutRef{Path: layoutPath, Tag: tag}.String()
+			appImageDigest = descriptor.Digest.String()
+		}
+	}
+	g.Expect(appImageRef).ToNot(BeEmpty())
+
+	manifestDir := filepath.Join(repoDir, "layout-manifests")
+	g.Expect(os.Mkdir(manifestDir, 0o755)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(manifestDir, "pod.yaml"),
+		[]byte("apiVersion: v1\nkind: Pod\nmetadata:\n  name: app\nspec:\n  containers:\n  - name: app\n    image: "+appImageRef+"\n"), 0o644)).To(Succeed())
+
+	output, err := runCommandWithOutput(t, "images", "--manifest-dir", manifestDir, "--output-format", "direct-json")
+	g.Expect(err).ToNot(HaveOccurred())
+	info := imageInfo{}
+	g.Expect(json.Unmarshal(output, &info)).To(Succeed())
+	g.Expect(info.Ref).To(Equal(appImageRef + "@" + appImageDigest))
+	g.Expect(info.Manifests).To(HaveLen(2))
+
+	// manifests of the random test index don't have a platform set
+	output, err = runCommandWithOutput(t, "images", "--manifest-dir", manifestDir, "--output-format", "text")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(output)).To(HavePrefix(appImageRef + "@" + appImageDigest + "\n"))
+	g.Expect(string(output)).To(ContainSubstring("  OCI manifests:\n"))
+}
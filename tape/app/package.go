@@ -2,48 +2,124 @@ package app
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/uuid"
+	toto "github.com/in-toto/in-toto-golang/in_toto"
+	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v1"
 	kimage "sigs.k8s.io/kustomize/api/image"
 
 	"github.com/docker/labs-brown-tape/attest"
+	"github.com/docker/labs-brown-tape/attest/digest"
 	"github.com/docker/labs-brown-tape/attest/manifest"
+	"github.com/docker/labs-brown-tape/attest/signer"
+	attestTypes "github.com/docker/labs-brown-tape/attest/types"
+	"github.com/docker/labs-brown-tape/attest/vcs/git"
+	"github.com/docker/labs-brown-tape/manifest/diff"
 	"github.com/docker/labs-brown-tape/manifest/imagecopier"
 	"github.com/docker/labs-brown-tape/manifest/imageresolver"
 	"github.com/docker/labs-brown-tape/manifest/imagescanner"
 	"github.com/docker/labs-brown-tape/manifest/loader"
 	"github.com/docker/labs-brown-tape/manifest/packager"
+	manifestTypes "github.com/docker/labs-brown-tape/manifest/types"
 	"github.com/docker/labs-brown-tape/manifest/updater"
 	"github.com/docker/labs-brown-tape/oci"
+	"github.com/docker/labs-brown-tape/policy"
+	"github.com/docker/labs-brown-tape/sbom"
 )
 
 type TapePackageCommand struct {
 	tape *TapeCommand
 	InputManifestDirOptions
+	KnownDigestOptions
+	SignatureVerificationOptions
+	OutputFormatOptions
 
 	// WithImages  map[string]string `short:"I" long:"with-images" required:"false" description:"Names of new images to use instead of what specified in the manifests"`
 	OutputImage string `short:"O" long:"output-image" required:"true" description:"Name of the image to push"`
 
+	OutputLayout string `long:"output-layout" description:"Path to OCI image layout directory to write the artefact and app images to instead of pushing them to the registry, the name given with --output-image is still used for image references"`
+
+	SignKey string `long:"sign-key" description:"Path to PEM-encoded private key (ECDSA, ED25519 or RSA) to sign attestations with"`
+
+	Reproducible bool `long:"reproducible" description:"Build the artefact in strict reproducible mode, with normalised file modes and creation time taken from SOURCE_DATE_EPOCH or the time of the last commit"`
+
+	Force bool `long:"force" description:"Push the artefact even when an artefact with the same content and attestations already exists"`
+
+	AttestationsLayout oci.AttestationsLayout `long:"attestations-layout" description:"Whether attestations are embedded in the artefact index or pushed as a separate manifest that refers to it" choice:"embedded" choice:"referrer" default:"embedded"`
+
+	SBOMFormat sbom.Format `long:"sbom" description:"Format of application bill of materials to include in the attestations" choice:"cyclonedx" choice:"spdx"`
+
+	RequireSignatures bool `long:"require-signatures" description:"Fail unless every app image has a signature verified with --signature-key or keyless verification flags"`
+
+	PolicyFiles []string `long:"policy" description:"Path to file with CEL rules that the artefact must comply with before it is pushed, can be given multiple times"`
+
+	Naming imagecopier.Naming `long:"naming" description:"How app images are named in the destination: single-repo copies all images to the repository given with --output-image, per-image-repo uses a repository named after the alias of each image under it, and mirror-path keeps the original path of each repository" choice:"single-repo" choice:"per-image-repo" choice:"mirror-path" default:"single-repo"`
+
+	Platforms []string `long:"platform" description:"Platform to copy from multi-platform app images, e.g. linux/amd64, can be given multiple times; other platforms are left out of the copied image index"`
+
+	DryRun bool `long:"dry-run" description:"Print the plan of what would be copied and pushed, including changes to manifests and attestations, without writing to the registry or the output layout; --output-format applies to the plan"`
+
+	Jobs    int `short:"j" long:"jobs" description:"Number of images to copy concurrently, copying to --output-layout is always done one at a time" default:"4"`
+	Retries int `long:"retries" description:"Number of times to retry copying an image after a transient failure" default:"3"`
+
 	// TODO: implement
 	// Push bool `short:"P" long:"push" description:"Push the resulting image to the registry"`
 }
 
 func (c *TapePackageCommand) ValidateFlags() error {
-	name, tag, digest := kimage.Split(c.OutputImage)
+	if c.Jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1")
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("--retries must not be negative")
+	}
+	if _, err := c.parsePlatforms(); err != nil {
+		return err
+	}
+	if c.OutputLayout != "" && c.Naming != "" && c.Naming != imagecopier.NamingSingleRepo {
+		return fmt.Errorf("--naming=%s cannot be used with --output-layout", c.Naming)
+	}
+	return validateOutputImage(c.OutputImage)
+}
+
+// parsePlatforms returns platforms given with --platform in normalised form
+func (c *TapePackageCommand) parsePlatforms() ([]oci.Platform, error) {
+	platforms := make([]oci.Platform, 0, len(c.Platforms))
+	for _, value := range c.Platforms {
+		platform, err := v1.ParsePlatform(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform %q: %w", value, err)
+		}
+		platforms = append(platforms, *platform)
+	}
+	return platforms, nil
+}
+
+func validateOutputImage(outputImage string) error {
+	name, tag, digest := kimage.Split(outputImage)
 
 	invalidOutputImageErr := func(reason string, values ...interface{}) error {
 		return fmt.Errorf("invalid output image name %q: "+reason, values...)
 	}
 
 	if tag != "" {
-		return invalidOutputImageErr("tag shouldn't be specified", c.OutputImage)
+		return invalidOutputImageErr("tag shouldn't be specified", outputImage)
 	}
 	if digest != "" {
-		return invalidOutputImageErr("digest shouldn't be specified", c.OutputImage)
+		return invalidOutputImageErr("digest shouldn't be specified", outputImage)
 	}
 	if name == "" {
 		return invalidOutputImageErr("name must not be empty", name)
@@ -56,6 +132,7 @@ func (c *TapePackageCommand) ValidateFlags() error {
 }
 
 func (c *TapePackageCommand) Execute(args []string) error {
+	startedOn := time.Now().UTC()
 	ctx := context.WithValue(c.tape.ctx, "command", "package")
 	if len(args) != 0 {
 		return fmt.Errorf("unexpected arguments: %v", args)
@@ -69,32 +146,96 @@ func (c *TapePackageCommand) Execute(args []string) error {
 		return err
 	}
 
-	loader := loader.NewRecursiveManifestDirectoryLoader(c.ManifestDir)
-	if err := loader.Load(); err != nil {
+	var attestationSigner *signer.SignerVerifier
+	if c.SignKey != "" {
+		var err error
+		attestationSigner, err = signer.LoadPrivateKeyFromFile(c.SignKey)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key: %w", err)
+		}
+		keyID, _ := attestationSigner.KeyID()
+		c.tape.log.Infof("attestations will be signed with key %s", keyID)
+	}
+
+	verifier, err := c.NewSignatureVerifier()
+	if err != nil {
+		return err
+	}
+	if c.RequireSignatures && verifier == nil {
+		return fmt.Errorf("--require-signatures needs either --signature-key or keyless verification flags")
+	}
+
+	var policyRules *policy.Policy
+	if len(c.PolicyFiles) != 0 {
+		policyRules, err = policy.Load(c.PolicyFiles...)
+		if err != nil {
+			return fmt.Errorf("failed to load policy: %w", err)
+		}
+		c.tape.log.Infof("loaded %d policy rules", len(policyRules.Rules))
+	}
+
+	manifests, sourceDir, err := c.NewLoader()
+	if err != nil {
+		return err
+	}
+	fieldSpecs, err := c.FieldSpecs()
+	if err != nil {
+		return err
+	}
+	knownDigests, err := c.LoadKnownDigests()
+	if err != nil {
+		return err
+	}
+	if err := manifests.Load(); err != nil {
 		return fmt.Errorf("failed to load manifests: %w", err)
 	}
-	c.tape.log.Debugf("loaded manifests: %v", loader.Paths())
+	c.tape.log.Debugf("loaded manifests: %v", manifests.Paths())
 
-	repoDetected, attreg, err := attest.DetectVCS(c.ManifestDir)
+	repoDetected, attreg, err := attest.DetectVCS(sourceDir)
 	if err != nil {
 		return err
 	}
-	/// baseDir := c.ManifestDir
+	/// baseDir := sourceDir
 	if vcsSummary := attreg.BaseDirSummary(); repoDetected && vcsSummary != nil {
 		// baseDir = vcsSummary.Common().Path
 		summaryJSON, err := json.Marshal(vcsSummary.Full())
 		if err != nil {
 			return err
 		}
-		c.tape.log.Infof("VCS info for %q: %s", c.ManifestDir, summaryJSON)
+		c.tape.log.Infof("VCS info for %q: %s", sourceDir, summaryJSON)
 	} else {
-		c.tape.log.Warnf("path %q is not in VCS", c.ManifestDir)
+		c.tape.log.Warnf("path %q is not in VCS", sourceDir)
+	}
+
+	var kustomizeBuild *loader.KustomizeBuild
+	if dirLoader, ok := manifests.(*loader.RecursiveManifestDirectoryLoader); ok {
+		kustomizeBuild = dirLoader.KustomizeBuild()
+	}
+	if kustomizeBuild != nil {
+		c.tape.log.Infof("using output of kustomize build for %q (overlays: %v)", kustomizeBuild.Kustomization, kustomizeBuild.Overlays)
+		if len(kustomizeBuild.Excluded) > 0 {
+			c.tape.log.Warnf("files not used by %q are excluded from the artefact: %v", kustomizeBuild.Kustomization, kustomizeBuild.Excluded)
+		}
+		attreg.RegisterDerived(kustomizeBuild.Output, kustomizeBuild.Sources()...)
+	}
+
+	var helmChart *loader.HelmChart
+	if helmLoader, ok := manifests.(*loader.HelmChartLoader); ok {
+		helmChart = helmLoader.HelmChart()
+	}
+	if helmChart != nil {
+		c.tape.log.Infof("rendered Helm chart %s %s (release %q in namespace %q)",
+			helmChart.Name, helmChart.Version, helmChart.ReleaseName, helmChart.Namespace)
+		for output := range helmChart.Outputs {
+			attreg.RegisterDerived(output, helmChart.Sources()...)
+		}
 	}
 
 	scanner := imagescanner.NewDefaultImageScanner()
 	scanner.WithProvinanceAttestor(attreg)
+	scanner.WithFieldSpecs(fieldSpecs...)
 
-	if err := scanner.Scan(loader.RelPaths()); err != nil {
+	if err := scanner.Scan(manifests.RelPaths()); err != nil {
 		return fmt.Errorf("failed to scan images: %w", err)
 	}
 
@@ -105,17 +246,69 @@ func (c *TapePackageCommand) Execute(args []string) error {
 		return err
 	}
 
+	if kustomizeBuild != nil {
+		if err := attreg.AssociateStatements(manifest.MakeKustomizationStatement(
+			attestTypes.MakeSubject(kustomizeBuild.Output, kustomizeBuild.OutputDigest),
+			kustomizeBuild.Kustomization, kustomizeBuild.Overlays,
+		)); err != nil {
+			return err
+		}
+	}
+
+	if helmChart != nil {
+		subjects := make([]attestTypes.Subject, 0, len(helmChart.Outputs))
+		for output, digest := range helmChart.Outputs {
+			subjects = append(subjects, attestTypes.MakeSubject(output, digest))
+		}
+		slices.SortFunc(subjects, func(a, b attestTypes.Subject) int { return cmp.Compare(a.Name, b.Name) })
+		if err := attreg.AssociateStatements(manifest.MakeHelmChartStatement(manifest.HelmChartRelease{
+			Name:         helmChart.Name,
+			Version:      helmChart.Version,
+			AppVersion:   helmChart.AppVersion,
+			ReleaseName:  helmChart.ReleaseName,
+			Namespace:    helmChart.Namespace,
+			ValuesFiles:  helmChart.ValuesFiles,
+			ValuesDigest: helmChart.ValuesDigest,
+		}, subjects...)); err != nil {
+			return err
+		}
+	}
+
 	if err := attreg.AssociateStatements(manifest.MakeOriginalImageRefStatements(images)...); err != nil {
 		return err
 	}
 
-	client := oci.NewClient(nil)
-	// TODO: use client.LoginWithCredentials() and/or other options
-	// TODO: integrate with docker-credential-helpers
+	client, err := c.tape.NewClient(c.OutputImage)
+	if err != nil {
+		return err
+	}
+
+	platforms, err := c.parsePlatforms()
+	if err != nil {
+		return err
+	}
 
 	resolver := imageresolver.NewRegistryResolver(client)
+	resolver.WithKnownDigests(knownDigests)
+	resolver.WithPlatforms(platforms...)
 
 	copier := imagecopier.NewRegistryCopier(client, c.OutputImage)
+	destinationRef := c.OutputImage
+	if c.OutputLayout != "" {
+		copier = imagecopier.NewLayoutCopier(client, c.OutputImage, c.OutputLayout)
+		destinationRef = oci.LayoutRef{Path: c.OutputLayout}.String()
+	}
+	copier.WithJobs(c.Jobs)
+	copier.WithRetries(c.Retries)
+	copier.WithProgress(c.logCopyProgress)
+	copier.WithPlatforms(platforms...)
+	naming, err := imagecopier.NewNamingStrategy(c.Naming)
+	if err != nil {
+		return err
+	}
+	copier.WithNaming(naming)
+
+	withDigests := imagesWithDigests(images)
 
 	c.tape.log.Info("resolving image digests")
 	if err := resolver.ResolveDigests(ctx, images); err != nil {
@@ -126,6 +319,36 @@ func (c *TapePackageCommand) Execute(args []string) error {
 		return fmt.Errorf("failed to dedup images: %w", err)
 	}
 
+	var imagesInfo map[string]imageInfo
+	if policyRules != nil || c.SBOMFormat != "" || verifier != nil {
+		// info is collected before images are copied, so that it refers to original images
+		imagesInfo, err = collectImageInfo(ctx, c.tape.log, images, withDigests, client, resolver, verifier)
+		if err != nil {
+			return fmt.Errorf("failed to collect info about images: %w", err)
+		}
+	}
+
+	if c.RequireSignatures {
+		unverified := []string{}
+		for ref, info := range imagesInfo {
+			if info.SignatureStatus != signatureStatusVerified {
+				unverified = append(unverified, fmt.Sprintf("%s (%s)", ref, info.SignatureStatus))
+			}
+		}
+		if len(unverified) != 0 {
+			slices.Sort(unverified)
+			return fmt.Errorf("images without verified signatures: %s", strings.Join(unverified, ", "))
+		}
+	}
+
+	// image rules only depend on the info about original images, so these are checked
+	// before anything is copied
+	if policyRules != nil {
+		if err := c.checkPolicy(policyRules.Scoped(policy.ScopeImage), "images", imagesInfo, nil); err != nil {
+			return err
+		}
+	}
+
 	if err := attreg.AssociateStatements(manifest.MakeResovedImageRefStatements(images)...); err != nil {
 		return err
 	}
@@ -141,23 +364,62 @@ func (c *TapePackageCommand) Execute(args []string) error {
 		return fmt.Errorf("failed to find images related to manifests: %w", err)
 	}
 
-	c.tape.log.Info("copying images")
+	var plan *packagePlan
+	if c.DryRun {
+		c.tape.log.Info("planning image copies")
 
-	imageRefs, err := copier.CopyImages(ctx, images, related, relatedToManifests)
-	if err != nil {
-		return fmt.Errorf("failed to copy images: %w", err)
+		copies, err := copier.PlanCopy(ctx, images, related, relatedToManifests)
+		if err != nil {
+			return fmt.Errorf("failed to plan image copies: %w", err)
+		}
+		plan = &packagePlan{Copies: copies}
+	} else {
+		c.tape.log.Info("copying images")
+
+		imageRefs, err := copier.CopyImages(ctx, images, related, relatedToManifests)
+		if err != nil {
+			return fmt.Errorf("failed to copy images: %w", err)
+		}
+		c.tape.log.Infof("copied images: %s", strings.Join(imageRefs, ", "))
+	}
+
+	if len(platforms) != 0 {
+		platformNames := make([]string, 0, len(platforms))
+		for _, platform := range platforms {
+			platformNames = append(platformNames, platform.String())
+		}
+		if err := attreg.AssociateStatements(manifest.MakeFilteredImageRefStatements(images, platformNames, related, relatedToManifests)...); err != nil {
+			return err
+		}
 	}
-	c.tape.log.Infof("copied images: %s", strings.Join(imageRefs, ", "))
 
 	c.tape.log.Info("updating manifest files")
 
+	// NB: loader works on a copy of the manifests, so these can be updated in dry-run mode
+	var originalResources diff.Resources
+	if plan != nil {
+		originalResources, err = diff.LoadResources(images.Dir())
+		if err != nil {
+			return err
+		}
+	}
+
 	updater := updater.NewFileUpdater()
+	updater.WithFieldSpecs(fieldSpecs...)
 	if err := updater.Update(images); err != nil {
 		return fmt.Errorf("failed to update manifest files: %w", err)
 	}
+
+	if plan != nil {
+		updatedResources, err := diff.LoadResources(images.Dir())
+		if err != nil {
+			return err
+		}
+		plan.Manifests = diff.Compare(originalResources, updatedResources)
+	}
 	attreg.RegisterMutated(updater.Mutations())
 	scanner.Reset()
-	if err := scanner.Scan(loader.RelPaths()); err != nil {
+	if err := scanner.Scan(manifests.RelPaths()); err != nil {
 		return fmt.Errorf("failed to scan updated manifest files: %w", err)
 	}
 	replacedImages := scanner.GetImages()
@@ -167,6 +429,36 @@ func (c *TapePackageCommand) Execute(args []string) error {
 		return err
 	}
 
+	sourceEpochTimestamp, err := c.sourceEpochTimestamp(manifests, sourceDir)
+	if err != nil {
+		return err
+	}
+
+	contentDigest, err := client.ContentDigest(images.Dir(), c.Reproducible)
+	if err != nil {
+		return fmt.Errorf("failed to make content digest: %w", err)
+	}
+	artefactSubject := attestTypes.MakeSubject(c.OutputImage+":"+manifestTypes.ConfigImageTagPrefix+contentDigest, digest.SHA256(contentDigest))
+	attreg.RegisterArtefact(artefactSubject.Name, artefactSubject.Digest)
+
+	if err := c.associateProvenance(attreg, artefactSubject, images, startedOn); err != nil {
+		return fmt.Errorf("failed to make provenance attestation: %w", err)
+	}
+
+	if c.SBOMFormat != "" {
+		app, err := makeSBOMApplication(c.OutputImage, sourceEpochTimestamp, scanner.GetManifestDigests(), images, imagesInfo, true)
+		if err != nil {
+			return err
+		}
+		predicateType, document, err := sbom.Make(c.SBOMFormat, app)
+		if err != nil {
+			return fmt.Errorf("failed to make SBOM: %w", err)
+		}
+		if err := attreg.AssociateStatements(manifest.MakeSBOMStatement(artefactSubject, predicateType, document)); err != nil {
+			return err
+		}
+	}
+
 	c.tape.log.DebugFn(func() []interface{} {
 		buf := bytes.NewBuffer(make([]byte, 0, 1024))
 		base64 := base64.NewEncoder(base64.StdEncoding, buf)
@@ -179,9 +471,25 @@ func (c *TapePackageCommand) Execute(args []string) error {
 		return []interface{}{"attestations: ", buf.String()}
 	})
 
-	path, sourceEpochTimestamp := loader.MostRecentlyModified()
-	c.tape.log.Debugf("using source epoch timestamp %s from most recently modified manifest file %q", sourceEpochTimestamp, path)
-	packager := packager.NewDefaultPackager(client, c.OutputImage, &sourceEpochTimestamp, attreg.GetStatements()...)
+	if policyRules != nil {
+		if err := c.checkPolicy(policyRules.Scoped(policy.ScopeStatement), "attestations", imagesInfo, attreg.GetStatements()); err != nil {
+			return err
+		}
+	}
+
+	if plan != nil {
+		plan.Artefact = artefactSubject.Name
+		plan.Attestations = attreg.GetStatements().Export()
+		return c.printPlan(plan)
+	}
+
+	packager := packager.NewDefaultPackager(client, destinationRef, &sourceEpochTimestamp, attreg.GetStatements()...)
+	if attestationSigner != nil {
+		packager.WithAttestationSigner(attestationSigner)
+	}
+	packager.WithForce(c.Force)
+	packager.WithReproducible(c.Reproducible)
+	packager.WithAttestationsLayout(c.AttestationsLayout)
 	packageRef, err := packager.Push(ctx, images.Dir())
 	if err != nil {
 		return fmt.Errorf("failed to create package: %w", err)
@@ -190,3 +498,208 @@ func (c *TapePackageCommand) Execute(args []string) error {
 	c.tape.log.Infof("created package %q", packageRef)
 	return nil
 }
+
+// packagePlan is printed in dry-run mode instead of copying images and pushing the artefact
+type packagePlan struct {
+	Artefact     string                    `json:"artefact"`
+	Copies       []imagecopier.PlannedCopy `json:"copies"`
+	Manifests    []diff.ResourceDiff       `json:"manifests"`
+	Attestations []toto.Statement          `json:"attestations"`
+}
+
+func (c *TapePackageCommand) printPlan(plan *packagePlan) error {
+	switch c.OutputFormat {
+	case OutputFormatDirectJSON:
+		stdj := json.NewEncoder(os.Stdout)
+		stdj.SetIndent("", "  ")
+		if err := stdj.Encode(plan); err != nil {
+			return fmt.Errorf("failed to marshal output: %w", err)
+		}
+	case OutputFormatText, OutputFormatDetailedText:
+		fmt.Printf("Artefact: %s\n", plan.Artefact)
+		fmt.Printf("  Copies:\n")
+		for _, planned := range plan.Copies {
+			fmt.Printf("    %s -> %s@%s\n", planned.Source, strings.TrimSuffix(planned.Destination, "@"+planned.Digest), planned.Digest)
+		}
+		if len(plan.Manifests) == 0 {
+			fmt.Printf("  Manifests: <unchanged>\n")
+		} else {
+			fmt.Printf("  Manifests:\n")
+		}
+		for _, resource := range plan.Manifests {
+			fmt.Printf("    %s %s (%s)\n", resource.Change, resource.ResourceID, resource.NewPath)
+			if c.OutputFormat == OutputFormatDetailedText {
+				for _, line := range strings.SplitAfter(resource.Diff, "\n") {
+					if line != "" {
+						fmt.Printf("      %s", line)
+					}
+				}
+			}
+		}
+		fmt.Printf("  Attestations:\n")
+		for _, statement := range plan.Attestations {
+			subjects := make([]string, 0, len(statement.Subject))
+			for _, subject := range statement.Subject {
+				subjects = append(subjects, subject.Name)
+			}
+			fmt.Printf("    %s: %s\n", statement.PredicateType, strings.Join(subjects, ", "))
+		}
+	default:
+		return fmt.Errorf("unsupported output format: %s", c.OutputFormat)
+	}
+	return nil
+}
+
+// associateProvenance adds SLSA provenance statement, its subject is the content layer of
+// the artefact; invocation metadata is omitted in reproducible mode; only flags that affect
+// the contents are recorded, paths and the output image are left out, as these are specific
+// to where the command runs, files given with flags are recorded by digests of their contents
+func (c *TapePackageCommand) associateProvenance(attreg *attest.PathCheckerRegistry, subject attestTypes.Subject, images *manifestTypes.ImageList, startedOn time.Time) error {
+	externalParameters := map[string]any{
+		"command":            "package",
+		"reproducible":       c.Reproducible,
+		"attestationsLayout": c.AttestationsLayout,
+		"naming":             c.Naming,
+		"signed":             c.SignKey != "",
+	}
+	if len(c.Platforms) > 0 {
+		externalParameters["platforms"] = c.Platforms
+	}
+	if c.HelmChart != "" {
+		externalParameters["helmReleaseName"] = c.HelmReleaseName
+		externalParameters["helmNamespace"] = c.HelmNamespace
+	}
+	if len(c.KnownDigests) > 0 {
+		externalParameters["knownDigests"] = c.KnownDigests
+	}
+	if len(c.ImageFieldSpecs) > 0 {
+		externalParameters["imageFieldSpecs"] = c.ImageFieldSpecs
+	}
+	if c.SBOMFormat != "" {
+		externalParameters["sbom"] = c.SBOMFormat
+	}
+	if c.RequireSignatures {
+		externalParameters["requireSignatures"] = true
+	}
+	for key, paths := range map[string][]string{
+		"helmValues":        c.HelmValues,
+		"knownDigestsFiles": c.KnownDigestsFile,
+		"policies":          c.PolicyFiles,
+	} {
+		if len(paths) == 0 {
+			continue
+		}
+		digests, err := fileDigests(paths...)
+		if err != nil {
+			return err
+		}
+		externalParameters[key] = digests
+	}
+	if c.ImageFieldSpecsFile != "" {
+		digests, err := fileDigests(c.ImageFieldSpecsFile)
+		if err != nil {
+			return err
+		}
+		externalParameters["imageFieldSpecsFile"] = digests[0]
+	}
+	invocation := manifest.ProvenanceInvocation{
+		ExternalParameters: externalParameters,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		invocation.BuilderVersion = map[string]string{"tape": info.Main.Version}
+	}
+	if !c.Reproducible {
+		invocation.Metadata = slsa.BuildMetadata{
+			InvocationID: uuid.NewString(),
+			StartedOn:    &startedOn,
+		}
+	}
+	return attreg.AssociateStatements(manifest.MakeProvenanceStatement(subject, invocation, images, attreg.BaseDirSummary()))
+}
+
+// fileDigests returns digests of the contents of the files in the given order
+func fileDigests(paths ...string) ([]digest.SHA256, error) {
+	hash := sha256.New()
+	digests := make([]digest.SHA256, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		hash.Reset()
+		_, _ = hash.Write(data)
+		digests = append(digests, digest.MakeSHA256(hash))
+	}
+	return digests, nil
+}
+
+// sourceEpochTimestamp returns creation time of the artefact, in reproducible mode it's taken from
+// SOURCE_DATE_EPOCH or the time of HEAD commit instead of modification time of the manifest files
+func (c *TapePackageCommand) sourceEpochTimestamp(manifests loader.Loader, sourceDir string) (time.Time, error) {
+	if !c.Reproducible {
+		path, timestamp := manifests.MostRecentlyModified()
+		c.tape.log.Debugf("using source epoch timestamp %s from most recently modified manifest file %q", timestamp, path)
+		return timestamp, nil
+	}
+
+	if value, ok := os.LookupEnv("SOURCE_DATE_EPOCH"); ok {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", value, err)
+		}
+		timestamp := time.Unix(seconds, 0).UTC()
+		c.tape.log.Debugf("using source epoch timestamp %s from SOURCE_DATE_EPOCH", timestamp)
+		return timestamp, nil
+	}
+
+	timestamp, ok, err := git.HeadCommitTime(sourceDir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("--reproducible requires SOURCE_DATE_EPOCH to be set when %q is not in a git repository", sourceDir)
+	}
+	c.tape.log.Debugf("using source epoch timestamp %s from HEAD commit of repository containing %q", timestamp, sourceDir)
+	return timestamp, nil
+}
+
+func (c *TapePackageCommand) checkPolicy(policyRules *policy.Policy, subject string, imagesInfo map[string]imageInfo, statements attestTypes.Statements) error {
+	if len(policyRules.Rules) == 0 {
+		return nil
+	}
+	exported := make([]toto.Statement, 0, len(statements))
+	for _, statement := range statements {
+		exported = append(exported, statement.Export())
+	}
+	violations, err := policyRules.Evaluate(&policy.Input{
+		Images:     imagesInfo,
+		Statements: exported,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to evaluate policy: %w", err)
+	}
+	if len(violations) == 0 {
+		c.tape.log.Infof("%s comply with policy", subject)
+		return nil
+	}
+	for _, violation := range violations {
+		c.tape.log.Errorf("policy violation: %s", violation)
+	}
+	return fmt.Errorf("%s violate policy, found %d violations", subject, len(violations))
+}
+
+func (c *TapePackageCommand) logCopyProgress(progress imagecopier.Progress) {
+	switch progress.Status {
+	case imagecopier.ProgressStatusCopying:
+		c.tape.log.Debugf("copying %q to %q (attempt %d)", progress.Source, progress.Destination, progress.Attempt)
+	case imagecopier.ProgressStatusCopied:
+		c.tape.log.Infof("[%d/%d] copied %q to %q", progress.Completed, progress.Total, progress.Source, progress.Destination)
+	case imagecopier.ProgressStatusSkipped:
+		c.tape.log.Infof("[%d/%d] skipped %q, %q already exists", progress.Completed, progress.Total, progress.Source, progress.Destination)
+	case imagecopier.ProgressStatusRetrying:
+		c.tape.log.Warnf("failed to copy %q (attempt %d), will retry: %s", progress.Source, progress.Attempt, progress.Err)
+	case imagecopier.ProgressStatusFailed:
+		// the error is returned, so only log it at debug level
+		c.tape.log.Debugf("failed to copy %q to %q (attempt %d): %s", progress.Source, progress.Destination, progress.Attempt, progress.Err)
+	}
+}
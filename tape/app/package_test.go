@@ -0,0 +1,236 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/google/go-containerregistry/pkg/crane"
+	. "github.com/onsi/gomega"
+
+	"github.com/docker/labs-brown-tape/attest/manifest"
+	"github.com/docker/labs-brown-tape/manifest/diff"
+	"github.com/docker/labs-brown-tape/oci"
+	"github.com/docker/labs-brown-tape/trex"
+)
+
+func TestPackageSkipsExistingArtefact(t *testing.T) {
+	trex.RunShared()
+	makeRepoName := trex.Shared.NewUniqueRepoNamer("bpt-package-test")
+
+	g := NewWithT(t)
+
+	repoDir := makeTestRepo(t, makeRepoName)
+	layoutPath := filepath.Join(t.TempDir(), "layout")
+
+	// provenance has a new invocation ID on every run without --reproducible, it's not
+	// taken into account, so the artefact from the first run is used as is
+	pkg := func() string {
+		g.Expect(runCommand("package",
+			"--manifest-dir", filepath.Join(repoDir, "manifests"),
+			"--output-image", makeRepoName("dst/app"),
+			"--output-layout", layoutPath,
+		)).To(Succeed())
+		return findArtefactInLayout(t, layoutPath)
+	}
+
+	ref := pkg()
+	g.Expect(pkg()).To(Equal(ref))
+
+	tags, err := oci.LayoutRef{Path: layoutPath}.Tags()
+	g.Expect(err).ToNot(HaveOccurred())
+	layoutRef, err := oci.ParseLayoutRef(ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(HaveKey(layoutRef.Tag))
+	g.Expect(tags[layoutRef.Tag].Digest.String()).To(Equal(layoutRef.Digest))
+}
+
+func TestPackageChecksImagePolicyBeforeCopying(t *testing.T) {
+	trex.RunShared()
+	makeRepoName := trex.Shared.NewUniqueRepoNamer("bpt-package-test")
+
+	g := NewWithT(t)
+
+	repoDir := makeTestRepo(t, makeRepoName)
+	policyPath := filepath.Join(t.TempDir(), "policy.yaml")
+	g.Expect(os.WriteFile(policyPath, []byte(`
+rules:
+- name: allowed-registries
+  scope: image
+  expression: 'image.ref.startsWith("ghcr.io/example/")'
+`), 0o644)).To(Succeed())
+
+	outputImage := makeRepoName("dst/app")
+	err := runCommand("package",
+		"--manifest-dir", filepath.Join(repoDir, "manifests"),
+		"--output-image", outputImage,
+		"--policy", policyPath,
+	)
+	g.Expect(err).To(MatchError("images violate policy, found 1 violations"))
+
+	// nothing was copied, so the repository doesn't exist
+	tags, err := crane.ListTags(outputImage, trex.Shared.CraneOptions()...)
+	if err == nil {
+		g.Expect(tags).To(BeEmpty())
+	}
+}
+
+func TestPackageReproducible(t *testing.T) {
+	trex.RunShared()
+	makeRepoName := trex.Shared.NewUniqueRepoNamer("bpt-package-test")
+
+	g := NewWithT(t)
+
+	repoDir := makeTestRepo(t, makeRepoName)
+	outputImage := makeRepoName("dst/app")
+
+	// clones have the same commit, but files are written at different times and
+	// the modes of the files differ
+	clone := func(mode os.FileMode, modTime time.Time) string {
+		cloneDir := t.TempDir()
+		_, err := gogit.PlainClone(cloneDir, false, &gogit.CloneOptions{URL: repoDir})
+		g.Expect(err).ToNot(HaveOccurred())
+		for _, name := range []string{"deployment.yaml", "service.yaml"} {
+			path := filepath.Join(cloneDir, "manifests", name)
+			g.Expect(os.Chmod(path, mode)).To(Succeed())
+			g.Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+		}
+		return relativePath(t, cloneDir)
+	}
+
+	pkg := func(cloneDir string) string {
+		layoutPath := filepath.Join(t.TempDir(), "layout")
+		g.Expect(runCommand("package",
+			"--manifest-dir", filepath.Join(cloneDir, "manifests"),
+			"--output-image", outputImage,
+			"--output-layout", layoutPath,
+			"--reproducible",
+		)).To(Succeed())
+		layoutRef, err := oci.ParseLayoutRef(findArtefactInLayout(t, layoutPath))
+		g.Expect(err).ToNot(HaveOccurred())
+		return layoutRef.Tag + "@" + layoutRef.Digest
+	}
+
+	g.Expect(pkg(clone(0o600, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)))).To(
+		Equal(pkg(clone(0o664, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)))))
+}
+
+func TestPackageDryRun(t *testing.T) {
+	trex.RunShared()
+	makeRepoName := trex.Shared.NewUniqueRepoNamer("bpt-package-test")
+
+	g := NewWithT(t)
+
+	repoDir := makeTestRepo(t, makeRepoName)
+	layoutPath := filepath.Join(t.TempDir(), "layout")
+	outputImage := makeRepoName("dst/app")
+
+	output, err := runCommandWithOutput(t, "package",
+		"--manifest-dir", filepath.Join(repoDir, "manifests"),
+		"--output-image", outputImage,
+		"--output-layout", layoutPath,
+		"--dry-run",
+		"--output-format", "direct-json",
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	plan := &packagePlan{}
+	g.Expect(json.Unmarshal(output, plan)).To(Succeed())
+	g.Expect(plan.Artefact).To(HavePrefix(outputImage + ":config."))
+
+	g.Expect(plan.Copies).To(HaveLen(1))
+	g.Expect(plan.Copies[0].Source).To(HavePrefix(makeRepoName("src/app") + ":v1@"))
+	g.Expect(plan.Copies[0].Destination).To(HavePrefix(oci.LayoutRef{Path: layoutPath}.String()))
+
+	g.Expect(plan.Manifests).To(HaveLen(1))
+	g.Expect(plan.Manifests[0].ResourceID).To(Equal(diff.ResourceID{Group: "apps", Kind: "Deployment", Name: "app"}))
+	g.Expect(plan.Manifests[0].Change).To(Equal(diff.ChangeTypeModified))
+	g.Expect(plan.Manifests[0].Diff).To(ContainSubstring("+      - image: " + outputImage + ":app."))
+
+	predicateTypes := []string{}
+	for _, statement := range plan.Attestations {
+		predicateTypes = append(predicateTypes, statement.PredicateType)
+	}
+	g.Expect(predicateTypes).To(ContainElements(
+		manifest.OriginalImageRefPredicateType,
+		manifest.ResolvedImageRefPredicateType,
+		manifest.ReplacedImageRefPredicateType,
+	))
+
+	// nothing was written to the registry or the layout
+	tags, err := crane.ListTags(outputImage, trex.Shared.CraneOptions()...)
+	if err == nil {
+		g.Expect(tags).To(BeEmpty())
+	}
+	if _, err := os.Stat(layoutPath); err == nil {
+		tags, err := oci.LayoutRef{Path: layoutPath}.Tags()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(tags).To(BeEmpty())
+		entries, err := os.ReadDir(filepath.Join(layoutPath, "blobs", "sha256"))
+		if err == nil {
+			g.Expect(entries).To(BeEmpty())
+		}
+	} else {
+		g.Expect(os.IsNotExist(err)).To(BeTrue())
+	}
+}
+
+func TestPackageProvenanceParameters(t *testing.T) {
+	trex.RunShared()
+	makeRepoName := trex.Shared.NewUniqueRepoNamer("bpt-package-test")
+
+	g := NewWithT(t)
+
+	repoDir := makeTestRepo(t, makeRepoName)
+	policy := []byte(`
+rules:
+- name: any-image
+  scope: image
+  expression: 'image.ref != ""'
+`)
+	policyPath := filepath.Join(t.TempDir(), "policy.yaml")
+	g.Expect(os.WriteFile(policyPath, policy, 0o644)).To(Succeed())
+
+	output, err := runCommandWithOutput(t, "package",
+		"--manifest-dir", filepath.Join(repoDir, "manifests"),
+		"--output-image", makeRepoName("dst/app"),
+		"--policy", policyPath,
+		"--sbom", "spdx",
+		"--image-field-spec", "example.com/v1/Task:spec/steps[]/image",
+		"--dry-run",
+		"--output-format", "direct-json",
+	)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	plan := &packagePlan{}
+	g.Expect(json.Unmarshal(output, plan)).To(Succeed())
+	externalParameters := map[string]any{}
+	for _, statement := range plan.Attestations {
+		if statement.PredicateType != manifest.ProvenancePredicateType {
+			continue
+		}
+		data, err := json.Marshal(statement.Predicate)
+		g.Expect(err).ToNot(HaveOccurred())
+		predicate := struct {
+			BuildDefinition struct {
+				ExternalParameters map[string]any `json:"externalParameters"`
+			} `json:"buildDefinition"`
+		}{}
+		g.Expect(json.Unmarshal(data, &predicate)).To(Succeed())
+		externalParameters = predicate.BuildDefinition.ExternalParameters
+	}
+
+	// policy file is recorded by digest of its contents rather than the path
+	g.Expect(externalParameters).To(HaveKeyWithValue("policies", []any{
+		map[string]any{"sha256": fmt.Sprintf("%x", sha256.Sum256(policy))},
+	}))
+	g.Expect(externalParameters).To(HaveKeyWithValue("sbom", "spdx"))
+	g.Expect(externalParameters).To(HaveKeyWithValue("imageFieldSpecs", []any{"example.com/v1/Task:spec/steps[]/image"}))
+	g.Expect(externalParameters).To(HaveKeyWithValue("signed", false))
+	g.Expect(externalParameters).ToNot(HaveKey("helmReleaseName"))
+}
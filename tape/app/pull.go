@@ -1,20 +1,26 @@
 package app
 
 import (
+	"bytes"
+	"cmp"
 	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/docker/labs-brown-tape/oci"
 	"github.com/fluxcd/pkg/tar"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 )
 
 type TapePullCommand struct {
 	tape *TapeCommand
 	OutputManifestDirOptions
+	AttestationsVerificationOptions
 
 	Image        string `short:"I" long:"image" description:"Name of the image to pull" required:"true"`
 	Attestations string `short:"a" long:"attestations" description:"Path to wrtie attestations file"`
@@ -32,12 +38,25 @@ func (c *TapePullCommand) Execute(args []string) error {
 		return err
 	}
 
-	client := oci.NewClient(nil)
+	verifier, err := c.NewEnvelopeVerifier()
+	if err != nil {
+		return err
+	}
+
+	client, err := c.tape.NewClient(c.Image)
+	if err != nil {
+		return err
+	}
 
-	artefacts, err := client.Fetch(ctx, c.Image, oci.ContentMediaType, oci.AttestMediaType)
+	artefacts, err := client.Fetch(ctx, c.Image, oci.ContentMediaType, oci.AttestMediaType, oci.SignedAttestMediaType)
 	if err != nil {
 		return err
 	}
+	// attestations are checked before manifests are extracted, so that nothing
+	// is written when signatures cannot be verified
+	slices.SortStableFunc(artefacts, func(a, b *oci.ArtefactInfo) int {
+		return cmp.Compare(pullOrder(a.MediaType), pullOrder(b.MediaType))
+	})
 
 	for i := range artefacts {
 		artefact := artefacts[i]
@@ -48,17 +67,24 @@ func (c *TapePullCommand) Execute(args []string) error {
 			}
 			c.tape.log.Infof("extracted manifest to %q", c.OutputManifestDirOptions.ManifestDir)
 			// TODO: add mode to just dump the tarball
-		case oci.AttestMediaType:
+		case oci.AttestMediaType, oci.SignedAttestMediaType:
+			data, err := io.ReadAll(artefact)
+			if err != nil {
+				return fmt.Errorf("failed to read attestations: %w", err)
+			}
+
+			if err := c.reportSigners(ctx, data, artefact.MediaType, verifier); err != nil {
+				return err
+			}
+
 			if c.Attestations == "" {
 				break
 			}
 
-			r, w := io.ReadCloser(nil), io.WriteCloser(nil)
+			r, w := io.ReadCloser(io.NopCloser(bytes.NewReader(data))), io.WriteCloser(nil)
 
-			if filepath.Ext(c.Attestations) == ".gz" {
-				r = artefact
-			} else {
-				r, err = gzip.NewReader(artefact)
+			if filepath.Ext(c.Attestations) != ".gz" {
+				r, err = gzip.NewReader(r)
 				if err != nil {
 					return fmt.Errorf("failed to decompress attestations file: %w", err)
 				}
@@ -84,3 +110,33 @@ func (c *TapePullCommand) Execute(args []string) error {
 	}
 	return nil
 }
+
+func pullOrder(mediaType oci.MediaType) int {
+	if mediaType == oci.ContentMediaType {
+		return 1
+	}
+	return 0
+}
+
+// reportSigners logs key IDs of signatures of each statement, these are only
+// verified when verifier is given
+func (c *TapePullCommand) reportSigners(ctx context.Context, data []byte, mediaType oci.MediaType, verifier *dsse.EnvelopeVerifier) error {
+	if mediaType != oci.SignedAttestMediaType && verifier == nil {
+		c.tape.log.Warnf("attestations in %q are not signed", c.Image)
+		return nil
+	}
+	statements, keyIDs, err := decodeAttestations(ctx, bytes.NewReader(data), mediaType, verifier)
+	if err != nil {
+		return fmt.Errorf("failed to decode attestations: %w", err)
+	}
+	for i := range statements {
+		if verifier != nil {
+			c.tape.log.Infof("statement %d of type %q signed by %s", i,
+				statements[i].PredicateType, strings.Join(keyIDs[i], ", "))
+			continue
+		}
+		c.tape.log.Infof("statement %d of type %q has signatures with unverified key IDs %s, use --verify-key to verify", i,
+			statements[i].PredicateType, strings.Join(keyIDs[i], ", "))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	toto "github.com/in-toto/in-toto-golang/in_toto"
+
+	"github.com/docker/labs-brown-tape/attest/manifest"
+	manifestTypes "github.com/docker/labs-brown-tape/manifest/types"
+	"github.com/docker/labs-brown-tape/oci"
+)
+
+type TapePushLayoutCommand struct {
+	tape *TapeCommand
+
+	Layout      string `short:"L" long:"layout" description:"Path to OCI image layout directory created with 'tape package --output-layout'" required:"true"`
+	OutputImage string `short:"O" long:"output-image" description:"Name of the repository to push to" required:"true"`
+}
+
+func (c *TapePushLayoutCommand) Execute(args []string) error {
+	ctx := context.WithValue(c.tape.ctx, "command", "push-layout")
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	if err := c.tape.Init(); err != nil {
+		return err
+	}
+
+	if err := validateOutputImage(c.OutputImage); err != nil {
+		return err
+	}
+
+	layoutPath := c.Layout
+	if oci.IsLayoutRef(layoutPath) {
+		layoutRef, err := oci.ParseLayoutRef(layoutPath)
+		if err != nil {
+			return err
+		}
+		if layoutRef.Tag != "" || layoutRef.Digest != "" {
+			return fmt.Errorf("layout reference %q must not include a tag or a digest", c.Layout)
+		}
+		layoutPath = layoutRef.Path
+	}
+
+	client, err := c.tape.NewClient(c.OutputImage)
+	if err != nil {
+		return err
+	}
+
+	if err := c.checkAppImageRefs(ctx, client, layoutPath); err != nil {
+		return err
+	}
+
+	c.tape.log.Infof("pushing contents of %q to %q", layoutPath, c.OutputImage)
+
+	refs, err := client.PushLayout(ctx, layoutPath, c.OutputImage)
+	if err != nil {
+		return fmt.Errorf("failed to push layout: %w", err)
+	}
+
+	for _, ref := range refs {
+		c.tape.log.Infof("pushed package %q", ref)
+	}
+	return nil
+}
+
+// checkAppImageRefs warns when app images in the manifests point to a different repository,
+// as references in the manifests are set at packaging time and cannot be changed at this point
+func (c *TapePushLayoutCommand) checkAppImageRefs(ctx context.Context, client *oci.Client, layoutPath string) error {
+	destination, err := name.NewRepository(c.OutputImage)
+	if err != nil {
+		return fmt.Errorf("invalid output image name %q: %w", c.OutputImage, err)
+	}
+
+	layoutRef := oci.LayoutRef{Path: layoutPath}
+	tags, err := layoutRef.Tags()
+	if err != nil {
+		return err
+	}
+
+	checked := map[oci.Hash]struct{}{}
+	for tag, descriptor := range tags {
+		if !strings.HasPrefix(tag, manifestTypes.ConfigImageTagPrefix) {
+			continue
+		}
+		// the same artefact has two tags
+		if _, ok := checked[descriptor.Digest]; ok {
+			continue
+		}
+		checked[descriptor.Digest] = struct{}{}
+		statements, err := fetchAttestations(ctx, client, layoutRef.WithTag(tag).String())
+		if err != nil {
+			return err
+		}
+		for _, statement := range statements {
+			if statement.PredicateType != manifest.ReplacedImageRefPredicateType {
+				continue
+			}
+			predicate := &struct {
+				manifest.ImageRefenceWithLocation `json:"replacedImageReference"`
+			}{}
+			if err := decodePredicate(statement, predicate); err != nil {
+				return err
+			}
+			ref, err := name.ParseReference(predicate.Reference)
+			if err != nil {
+				return fmt.Errorf("invalid app image reference %q: %w", predicate.Reference, err)
+			}
+			if ref.Context().Name() != destination.Name() {
+				c.tape.log.Warnf("manifests in %q refer to app image %q, which is not in %q",
+					tag, predicate.Reference, c.OutputImage)
+			}
+		}
+	}
+	return nil
+}
+
+// fetchAttestations returns statements from all attestations layers of the artefact
+func fetchAttestations(ctx context.Context, client *oci.Client, ref string) ([]toto.Statement, error) {
+	artefacts, err := client.Fetch(ctx, ref, oci.AttestMediaType, oci.SignedAttestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, artefact := range artefacts {
+			_ = artefact.Close()
+		}
+	}()
+
+	statements := []toto.Statement{}
+	for _, artefact := range artefacts {
+		decoded, _, err := decodeAttestations(ctx, artefact, artefact.MediaType, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode attestations: %w", err)
+		}
+		statements = append(statements, decoded...)
+	}
+	return statements, nil
+}
@@ -0,0 +1,203 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto"
+	kimage "sigs.k8s.io/kustomize/api/image"
+
+	"github.com/docker/labs-brown-tape/attest/digest"
+	"github.com/docker/labs-brown-tape/manifest/imageresolver"
+	"github.com/docker/labs-brown-tape/manifest/imagescanner"
+	"github.com/docker/labs-brown-tape/manifest/types"
+	"github.com/docker/labs-brown-tape/sbom"
+)
+
+type TapeSBOMCommand struct {
+	tape *TapeCommand
+
+	InputManifestDirOptions
+	KnownDigestOptions
+
+	Format sbom.Format `short:"f" long:"format" description:"Format of the bill of materials" choice:"cyclonedx" choice:"spdx" default:"cyclonedx"`
+	Name   string      `long:"name" description:"Name of the application, defaults to the name of the manifest directory"`
+}
+
+func (c *TapeSBOMCommand) Execute(args []string) error {
+	ctx := context.WithValue(c.tape.ctx, "command", "sbom")
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	if err := c.tape.Init(); err != nil {
+		return err
+	}
+
+	loader, sourceDir, err := c.NewLoader()
+	if err != nil {
+		return err
+	}
+	fieldSpecs, err := c.FieldSpecs()
+	if err != nil {
+		return err
+	}
+	knownDigests, err := c.LoadKnownDigests()
+	if err != nil {
+		return err
+	}
+	if err := loader.Load(); err != nil {
+		return fmt.Errorf("failed to load manifests: %w", err)
+	}
+	c.tape.log.Debugf("loaded manifests: %v", loader.Paths())
+
+	scanner := imagescanner.NewDefaultImageScanner()
+	scanner.WithFieldSpecs(fieldSpecs...)
+
+	if err := scanner.Scan(loader.RelPaths()); err != nil {
+		return fmt.Errorf("failed to scan images: %w", err)
+	}
+
+	images := scanner.GetImages()
+	c.tape.log.Debugf("found images: %#v", images.Items())
+
+	client, err := c.tape.NewClient(registryImageRef(images))
+	if err != nil {
+		return err
+	}
+
+	resolver := imageresolver.NewRegistryResolver(client)
+	resolver.WithKnownDigests(knownDigests)
+
+	withDigests := imagesWithDigests(images)
+
+	c.tape.log.Info("resolving image digests")
+	if err := resolver.ResolveDigests(ctx, images); err != nil {
+		return fmt.Errorf("failed to resolve image digests: %w", err)
+	}
+
+	if err := images.Dedup(); err != nil {
+		return fmt.Errorf("failed to dedup images: %w", err)
+	}
+
+	imagesInfo, err := collectImageInfo(ctx, c.tape.log, images, withDigests, client, resolver, nil)
+	if err != nil {
+		return fmt.Errorf("failed to collect info about images: %w", err)
+	}
+
+	name := c.Name
+	if name == "" {
+		name = filepath.Base(filepath.Clean(sourceDir))
+	}
+	_, created := loader.MostRecentlyModified()
+
+	app, err := makeSBOMApplication(name, created, scanner.GetManifestDigests(), images, imagesInfo, false)
+	if err != nil {
+		return err
+	}
+	_, document, err := sbom.Make(c.Format, app)
+	if err != nil {
+		return err
+	}
+
+	stdj := json.NewEncoder(os.Stdout)
+	stdj.SetIndent("", "  ")
+	if err := stdj.Encode(document); err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+	return nil
+}
+
+// makeSBOMApplication returns application with all images and per-image SBOMs found
+// in imagesInfo, when copied is set images are referred to by their new names
+func makeSBOMApplication(name string, created time.Time, manifestDigests map[string]digest.SHA256, images *types.ImageList, imagesInfo map[string]imageInfo, copied bool) (*sbom.Application, error) {
+	app := &sbom.Application{
+		Name:      name,
+		Created:   created,
+		Manifests: make([]sbom.Manifest, 0, len(manifestDigests)),
+		Images:    make([]sbom.Image, 0, images.Len()),
+	}
+	for path, manifestDigest := range manifestDigests {
+		app.Manifests = append(app.Manifests, sbom.Manifest{Path: path, Digest: manifestDigest})
+	}
+
+	for _, image := range images.Items() {
+		originalRef := image.Ref(true)
+		info := imagesInfo[originalRef]
+
+		appImage := sbom.Image{Ref: originalRef}
+		if copied && image.NewName != "" {
+			appImage.Ref = image.Ref(false)
+		}
+		for _, source := range image.Sources {
+			appImage.Manifests = append(appImage.Manifests, source.Manifest)
+		}
+
+		platforms := map[string]*sbom.Platform{}
+		for _, manifest := range info.Manifests {
+			// attestation manifests are not images
+			if manifest.Platform == nil || manifest.Annotations["vnd.docker.reference.type"] != "" {
+				continue
+			}
+			platform := &sbom.Platform{
+				Digest:       manifest.Digest.String(),
+				OS:           manifest.Platform.OS,
+				Architecture: manifest.Platform.Architecture,
+				Variant:      manifest.Platform.Variant,
+			}
+			if doc, ok := inlineSBOM(info.InlineSBOMs, manifest.Digest.String()); ok {
+				statement, ok := doc.Object.(*in_toto.Statement)
+				if !ok {
+					return nil, fmt.Errorf("unexpected inline SBOM for %s", platform.Digest)
+				}
+				packages, err := sbom.PackagesFromDocument(statement.Predicate)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read inline SBOM for %s: %w", platform.Digest, err)
+				}
+				platform.SBOMs = append(platform.SBOMs, sbom.SBOM{Packages: packages})
+			}
+			platforms[platform.Digest] = platform
+		}
+
+		for relatedTo, related := range info.Related {
+			for _, relatedImage := range related.Items() {
+				ref := relatedImage.Ref(true)
+				doc, ok := info.ExternalSBOMs[ref]
+				if !ok {
+					continue
+				}
+				packages, err := sbom.PackagesFromDocument(doc.Object)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read SBOM %q: %w", ref, err)
+				}
+				externalSBOM := sbom.SBOM{Ref: ref, Packages: packages}
+				if _, _, platformDigest := kimage.Split(relatedTo); relatedTo != originalRef && platforms[platformDigest] != nil {
+					platforms[platformDigest].SBOMs = append(platforms[platformDigest].SBOMs, externalSBOM)
+				} else {
+					appImage.SBOMs = append(appImage.SBOMs, externalSBOM)
+				}
+			}
+		}
+
+		for _, platform := range platforms {
+			appImage.Platforms = append(appImage.Platforms, *platform)
+		}
+		app.Images = append(app.Images, appImage)
+	}
+	return app, nil
+}
+
+// inlineSBOM looks up SBOM by subject, which may be given with or without algorithm
+func inlineSBOM(sboms documents, subject string) (document, bool) {
+	if doc, ok := sboms[subject]; ok {
+		return doc, true
+	}
+	_, hex, _ := strings.Cut(subject, ":")
+	doc, ok := sboms[hex]
+	return doc, ok
+}
@@ -0,0 +1,508 @@
+package app
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fluxcd/pkg/tar"
+	"github.com/google/go-containerregistry/pkg/name"
+	toto "github.com/in-toto/in-toto-golang/in_toto"
+	kimage "sigs.k8s.io/kustomize/api/image"
+
+	"github.com/docker/labs-brown-tape/attest/digest"
+	"github.com/docker/labs-brown-tape/attest/manifest"
+	"github.com/docker/labs-brown-tape/manifest/imagescanner"
+	"github.com/docker/labs-brown-tape/oci"
+)
+
+type TapeVerifyCommand struct {
+	tape *TapeCommand
+	OutputFormatOptions
+	ImageFieldSpecOptions
+
+	Image string `short:"I" long:"image" description:"Name of the image to verify" required:"true"`
+}
+
+const (
+	verifyCheckManifestDigest   = "manifest-digest"
+	verifyCheckReplacedImageRef = "replaced-image-ref"
+	verifyCheckAppImage         = "app-image"
+)
+
+type verifyResult struct {
+	Check    string `json:"check"`
+	Subject  string `json:"subject"`
+	OK       bool   `json:"ok"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type verifyReport struct {
+	Image    string         `json:"image"`
+	Digest   string         `json:"digest"`
+	Verified bool           `json:"verified"`
+	Results  []verifyResult `json:"results"`
+}
+
+type imageLocation struct {
+	manifest     string
+	line, column int
+}
+
+func (r *verifyReport) add(result verifyResult) {
+	r.Results = append(r.Results, result)
+}
+
+func (r *verifyReport) numFailed() int {
+	failed := 0
+	for i := range r.Results {
+		if !r.Results[i].OK {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (c *TapeVerifyCommand) Execute(args []string) error {
+	ctx := context.WithValue(c.tape.ctx, "command", "verify")
+	if len(args) != 0 {
+		return fmt.Errorf("unexpected arguments: %v", args)
+	}
+
+	if err := c.tape.Init(); err != nil {
+		return err
+	}
+
+	client, err := c.tape.NewClient(c.Image)
+	if err != nil {
+		return err
+	}
+
+	report, err := c.CollectReport(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to verify artifact: %w", err)
+	}
+
+	if err := c.PrintReport(ctx, report); err != nil {
+		return fmt.Errorf("failed to print verification report: %w", err)
+	}
+
+	if !report.Verified {
+		return fmt.Errorf("verification of %q failed: %d check(s) did not pass", c.Image, report.numFailed())
+	}
+	return nil
+}
+
+func (c *TapeVerifyCommand) CollectReport(ctx context.Context, client *oci.Client) (*verifyReport, error) {
+	imageIndex, indexManifest, _, err := client.GetIndexOrImage(ctx, c.Image)
+	if err != nil {
+		return nil, err
+	}
+	if indexManifest == nil {
+		return nil, fmt.Errorf("no index manifest found for %q", c.Image)
+	}
+
+	imageIndexDigest, err := imageIndex.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &verifyReport{
+		Image:   c.Image,
+		Digest:  imageIndexDigest.String(),
+		Results: []verifyResult{},
+	}
+
+	artefacts, _, err := client.FetchFromIndexOrImage(ctx, imageIndex, indexManifest, nil, oci.ContentMediaType, oci.AttestMediaType, oci.SignedAttestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	// all of the artefacts are closed on return, including referrers appended
+	// below and those that are left unread when any of the earlier ones fails
+	defer func() {
+		for _, artefact := range artefacts {
+			_ = artefact.Close()
+		}
+	}()
+	referrers, _, err := client.FetchReferrers(ctx, c.Image, imageIndexDigest.String(), oci.AttestMediaType, oci.SignedAttestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	artefacts = append(artefacts, referrers...)
+	if err := oci.CheckAttestationsLayers(c.Image, artefacts); err != nil {
+		return nil, err
+	}
+
+	contentDir, err := os.MkdirTemp("", "tape-verify-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(contentDir)
+
+	var (
+		hasContent     bool
+		statements     []toto.Statement
+		hasAttestLayer bool
+	)
+
+	for i := range artefacts {
+		artefact := artefacts[i]
+		switch artefact.MediaType {
+		case oci.ContentMediaType:
+			if err := tar.Untar(artefact, contentDir, tar.WithMaxUntarSize(-1)); err != nil {
+				return nil, fmt.Errorf("failed to exatract manifests: %w", err)
+			}
+			hasContent = true
+		case oci.AttestMediaType, oci.SignedAttestMediaType:
+			statements, _, err = decodeAttestations(ctx, artefact, artefact.MediaType, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode attestations: %w", err)
+			}
+			hasAttestLayer = true
+		}
+	}
+
+	if !hasContent {
+		return nil, fmt.Errorf("no content layer found in %q", c.Image)
+	}
+	if !hasAttestLayer {
+		return nil, fmt.Errorf("no attestations layer found in %q", c.Image)
+	}
+
+	// manifest paths in statements are relative to repo root, while the paths
+	// in content layer are relative to the manifest directory
+	baseDir := ""
+	attestedDigests := map[string]digest.SHA256{}
+	replacedDigests := map[string]digest.SHA256{}
+	replacedRefs := map[imageLocation]string{}
+
+	for _, statement := range statements {
+		switch statement.PredicateType {
+		case manifest.ManifestDirPredicateType:
+			predicate := &struct {
+				SourceDirectory struct {
+					Path string `json:"path"`
+				} `json:"containedInDirectory"`
+			}{}
+			if err := decodePredicate(statement, predicate); err != nil {
+				return nil, err
+			}
+			baseDir = predicate.SourceDirectory.Path
+			for _, subject := range statement.Subject {
+				attestedDigests[subject.Name] = digest.SHA256(subject.Digest["sha256"])
+			}
+		case manifest.KustomizationPredicateType, manifest.HelmChartPredicateType:
+			// rendered manifests are not in VCS, so these are not subjects of the
+			// manifest directory statement
+			for _, subject := range statement.Subject {
+				attestedDigests[subject.Name] = digest.SHA256(subject.Digest["sha256"])
+			}
+		case manifest.ReplacedImageRefPredicateType:
+			predicate := &struct {
+				manifest.ImageRefenceWithLocation `json:"replacedImageReference"`
+			}{}
+			if err := decodePredicate(statement, predicate); err != nil {
+				return nil, err
+			}
+			for _, subject := range statement.Subject {
+				subjectDigest := digest.SHA256(subject.Digest["sha256"])
+				if existing, ok := replacedDigests[subject.Name]; ok && existing != subjectDigest {
+					report.add(verifyResult{
+						Check:    verifyCheckReplacedImageRef,
+						Subject:  subject.Name,
+						Expected: existing.String(),
+						Actual:   subjectDigest.String(),
+						Message:  "conflicting digests in replaced image reference statements",
+					})
+				}
+				replacedDigests[subject.Name] = subjectDigest
+				replacedRefs[imageLocation{subject.Name, predicate.Line, predicate.Column}] = predicate.Reference
+			}
+		}
+	}
+
+	if len(attestedDigests) == 0 {
+		return nil, fmt.Errorf("no manifest directory statement found in %q", c.Image)
+	}
+
+	manifests, err := c.verifyManifestDigests(report, contentDir, baseDir, attestedDigests, replacedDigests)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.verifyReplacedImageRefs(report, contentDir, baseDir, manifests, replacedRefs); err != nil {
+		return nil, err
+	}
+
+	if err := c.verifyAppImages(ctx, client, report, replacedRefs); err != nil {
+		return nil, err
+	}
+
+	report.Verified = report.numFailed() == 0
+	return report, nil
+}
+
+func (c *TapeVerifyCommand) verifyManifestDigests(report *verifyReport, contentDir, baseDir string, attestedDigests, replacedDigests map[string]digest.SHA256) ([]string, error) {
+	manifests := []string{}
+	found := map[string]struct{}{}
+
+	hash := sha256.New()
+	if err := filepath.WalkDir(contentDir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !e.Type().IsRegular() {
+			return nil
+		}
+		relPath, err := filepath.Rel(contentDir, p)
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, relPath)
+
+		subject := filepath.Join(baseDir, relPath)
+		found[subject] = struct{}{}
+
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		hash.Reset()
+		if _, err := io.Copy(hash, file); err != nil {
+			return err
+		}
+		actual := digest.MakeSHA256(hash)
+
+		// files that had image references updated have different digests,
+		// and these are recorded in replaced image reference statements
+		expected, ok := replacedDigests[subject]
+		if !ok {
+			expected, ok = attestedDigests[subject]
+		}
+		result := verifyResult{
+			Check:   verifyCheckManifestDigest,
+			Subject: subject,
+			Actual:  actual.String(),
+		}
+		switch {
+		case !ok:
+			result.Message = "file is not attested"
+		case expected != actual:
+			result.Expected = expected.String()
+			result.Message = "digest mismatch"
+		default:
+			result.Expected = expected.String()
+			result.OK = true
+		}
+		report.add(result)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to check manifest digests: %w", err)
+	}
+
+	missing := []string{}
+	for subject := range attestedDigests {
+		if _, ok := found[subject]; !ok {
+			missing = append(missing, subject)
+		}
+	}
+	slices.Sort(missing)
+	for _, subject := range missing {
+		report.add(verifyResult{
+			Check:    verifyCheckManifestDigest,
+			Subject:  subject,
+			Expected: attestedDigests[subject].String(),
+			Message:  "attested file is missing from content",
+		})
+	}
+	return manifests, nil
+}
+
+func (c *TapeVerifyCommand) verifyReplacedImageRefs(report *verifyReport, contentDir, baseDir string, manifests []string, replacedRefs map[imageLocation]string) error {
+	fieldSpecs, err := c.FieldSpecs()
+	if err != nil {
+		return err
+	}
+	scanner := imagescanner.NewDefaultImageScanner()
+	scanner.WithFieldSpecs(fieldSpecs...)
+	if err := scanner.Scan(contentDir, manifests); err != nil {
+		return fmt.Errorf("failed to scan images: %w", err)
+	}
+
+	found := map[imageLocation]struct{}{}
+	for _, image := range scanner.GetImages().Items() {
+		for _, source := range image.Sources {
+			location := imageLocation{filepath.Join(baseDir, source.Manifest), source.Line, source.Column}
+			found[location] = struct{}{}
+			result := verifyResult{
+				Check:   verifyCheckReplacedImageRef,
+				Subject: fmt.Sprintf("%s:%d:%d", location.manifest, location.line, location.column),
+				Actual:  source.OriginalRef,
+			}
+			expected, ok := replacedRefs[location]
+			switch {
+			case !ok:
+				result.Message = "image reference is not attested"
+			case expected != source.OriginalRef:
+				result.Expected = expected
+				result.Message = "image reference mismatch"
+			default:
+				result.Expected = expected
+				result.OK = true
+			}
+			report.add(result)
+		}
+	}
+
+	missing := []imageLocation{}
+	for location := range replacedRefs {
+		if _, ok := found[location]; !ok {
+			missing = append(missing, location)
+		}
+	}
+	slices.SortFunc(missing, compareImageLocations)
+	for _, location := range missing {
+		report.add(verifyResult{
+			Check:    verifyCheckReplacedImageRef,
+			Subject:  fmt.Sprintf("%s:%d:%d", location.manifest, location.line, location.column),
+			Expected: replacedRefs[location],
+			Message:  "attested image reference is missing from content",
+		})
+	}
+	return nil
+}
+
+func (c *TapeVerifyCommand) verifyAppImages(ctx context.Context, client *oci.Client, report *verifyReport, replacedRefs map[imageLocation]string) error {
+	// images in a layout are named after the repository that the layout is pushed to,
+	// so these are looked up by tag or digest in the layout itself
+	var (
+		packageRepo string
+		layoutRef   *oci.LayoutRef
+		err         error
+	)
+	if oci.IsLayoutRef(c.Image) {
+		layoutRef, err = oci.ParseLayoutRef(c.Image)
+		if err != nil {
+			return err
+		}
+	} else {
+		artefactRef, err := name.ParseReference(c.Image)
+		if err != nil {
+			return fmt.Errorf("invalid image reference %q: %w", c.Image, err)
+		}
+		packageRepo = artefactRef.Context().Name()
+	}
+
+	refs := []string{}
+	for _, ref := range replacedRefs {
+		if !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	slices.Sort(refs)
+
+	for _, ref := range refs {
+		result := verifyResult{
+			Check:   verifyCheckAppImage,
+			Subject: ref,
+		}
+		imageName, tag, imageDigest := kimage.Split(ref)
+		result.Expected = imageDigest
+
+		repo, err := name.NewRepository(imageName)
+		switch {
+		case err != nil:
+			result.Message = fmt.Sprintf("invalid image name: %s", err)
+		// with naming other than single-repo, images are in repositories under the package repository
+		case layoutRef == nil && repo.Name() != packageRepo && !strings.HasPrefix(repo.Name(), packageRepo+"/"):
+			result.Message = fmt.Sprintf("image is not in package repository %q or any repository under it", packageRepo)
+		case imageDigest == "":
+			result.Message = "image reference has no digest"
+		default:
+			lookupRef := imageName + "@" + imageDigest
+			if tag != "" {
+				lookupRef = imageName + ":" + tag
+			}
+			if layoutRef != nil {
+				lookupRef = oci.LayoutRef{Path: layoutRef.Path, Digest: imageDigest}.String()
+				if tag != "" {
+					lookupRef = oci.LayoutRef{Path: layoutRef.Path, Tag: tag}.String()
+				}
+			}
+			actual, err := client.Digest(ctx, lookupRef)
+			switch {
+			case err != nil:
+				result.Message = fmt.Sprintf("failed to find image in registry: %s", err)
+			case actual != imageDigest:
+				result.Actual = actual
+				result.Message = "digest mismatch"
+			default:
+				result.Actual = actual
+				result.OK = true
+			}
+		}
+		report.add(result)
+	}
+	return nil
+}
+
+func compareImageLocations(a, b imageLocation) int {
+	if cmp := cmp.Compare(a.manifest, b.manifest); cmp != 0 {
+		return cmp
+	}
+	if cmp := cmp.Compare(a.line, b.line); cmp != 0 {
+		return cmp
+	}
+	return cmp.Compare(a.column, b.column)
+}
+
+func (c *TapeVerifyCommand) PrintReport(ctx context.Context, report *verifyReport) error {
+	stdj := json.NewEncoder(os.Stdout)
+	switch c.OutputFormat {
+	case OutputFormatDirectJSON:
+		stdj.SetIndent("", "  ")
+		if err := stdj.Encode(report); err != nil {
+			return fmt.Errorf("failed to marshal output: %w", err)
+		}
+	case OutputFormatText, OutputFormatDetailedText:
+		fmt.Printf("%s\n", report.Image)
+		fmt.Printf("  Digest: %s\n", report.Digest)
+		fmt.Printf("  Verified: %v\n", report.Verified)
+		fmt.Printf("  Results:\n")
+		for _, result := range report.Results {
+			if result.OK && c.OutputFormat == OutputFormatText {
+				continue
+			}
+			status := "ok"
+			if !result.OK {
+				status = "FAIL"
+			}
+			fmt.Printf("    %-4s  %s  %s\n", status, result.Check, result.Subject)
+			if result.Message != "" {
+				fmt.Printf("      Message: %s\n", result.Message)
+			}
+			if c.OutputFormat == OutputFormatDetailedText || !result.OK {
+				if result.Expected != "" {
+					fmt.Printf("      Expected: %s\n", result.Expected)
+				}
+				if result.Actual != "" {
+					fmt.Printf("      Actual: %s\n", result.Actual)
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported output format: %s", c.OutputFormat)
+	}
+	return nil
+}
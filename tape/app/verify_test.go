@@ -0,0 +1,245 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/pkg/tar"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	. "github.com/onsi/gomega"
+	OCIv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/docker/labs-brown-tape/oci"
+	"github.com/docker/labs-brown-tape/trex"
+)
+
+func TestVerify(t *testing.T) {
+	trex.RunShared()
+	makeRepoName := trex.Shared.NewUniqueRepoNamer("bpt-verify-test")
+
+	g := NewWithT(t)
+
+	repoDir := makeTestRepo(t, makeRepoName)
+	layoutPath := filepath.Join(t.TempDir(), "layout")
+
+	g.Expect(runCommand("package",
+		"--manifest-dir", filepath.Join(repoDir, "manifests"),
+		"--output-image", makeRepoName("dst/app"),
+		"--output-layout", layoutPath,
+	)).To(Succeed())
+
+	artefactRef := findArtefactInLayout(t, layoutPath)
+
+	verify := func(ref string) (*verifyReport, error) {
+		output, err := runCommandWithOutput(t, "verify", "--image", ref, "--output-format", "direct-json")
+		if len(output) == 0 {
+			return nil, err
+		}
+		report := &verifyReport{}
+		g.Expect(json.Unmarshal(output, report)).To(Succeed())
+		return report, err
+	}
+
+	failedResults := func(report *verifyReport) []verifyResult {
+		failed := []verifyResult{}
+		for _, result := range report.Results {
+			if !result.OK {
+				failed = append(failed, result)
+			}
+		}
+		return failed
+	}
+
+	t.Run("matching artefact", func(t *testing.T) {
+		g := NewWithT(t)
+
+		report, err := verify(artefactRef)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(report.Verified).To(BeTrue())
+		checks := map[string]int{}
+		for _, result := range report.Results {
+			checks[result.Check]++
+		}
+		g.Expect(checks).To(Equal(map[string]int{
+			verifyCheckManifestDigest:   2,
+			verifyCheckReplacedImageRef: 1,
+			verifyCheckAppImage:         1,
+		}))
+	})
+
+	t.Run("tampered content", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ref := writeTamperedArtefact(t, layoutPath, artefactRef, "tampered-content", true, func(dir string) {
+			g.Expect(os.WriteFile(filepath.Join(dir, "service.yaml"), []byte("apiVersion: v1\nkind: Service\nmetadata:\n  name: other\n"), 0o644)).To(Succeed())
+		})
+
+		report, err := verify(ref)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(report.Verified).To(BeFalse())
+		failed := failedResults(report)
+		g.Expect(failed).To(HaveLen(1))
+		g.Expect(failed[0].Check).To(Equal(verifyCheckManifestDigest))
+		g.Expect(failed[0].Subject).To(Equal("manifests/service.yaml"))
+		g.Expect(failed[0].Message).To(Equal("digest mismatch"))
+	})
+
+	t.Run("replaced image ref mismatch", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ref := writeTamperedArtefact(t, layoutPath, artefactRef, "tampered-image-ref", true, func(dir string) {
+			path := filepath.Join(dir, "deployment.yaml")
+			data, err := os.ReadFile(path)
+			g.Expect(err).ToNot(HaveOccurred())
+			data = []byte(strings.Replace(string(data), "image: ", "image: example.com/other@", 1))
+			g.Expect(os.WriteFile(path, data, 0o644)).To(Succeed())
+		})
+
+		report, err := verify(ref)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(report.Verified).To(BeFalse())
+		mismatches := []verifyResult{}
+		for _, result := range failedResults(report) {
+			if result.Check == verifyCheckReplacedImageRef {
+				mismatches = append(mismatches, result)
+			}
+		}
+		g.Expect(mismatches).To(HaveLen(1))
+		g.Expect(mismatches[0].Subject).To(HavePrefix("manifests/deployment.yaml:"))
+		g.Expect(mismatches[0].Message).To(Equal("image reference mismatch"))
+		g.Expect(mismatches[0].Actual).To(HavePrefix("example.com/other@"))
+	})
+
+	t.Run("missing attestation layer", func(t *testing.T) {
+		g := NewWithT(t)
+
+		ref := writeTamperedArtefact(t, layoutPath, artefactRef, "no-attestations", false, func(string) {})
+
+		report, err := verify(ref)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("no attestations layer found"))
+		g.Expect(report).To(BeNil())
+	})
+}
+
+func TestVerifyWithNaming(t *testing.T) {
+	trex.RunShared()
+	makeRepoName := trex.Shared.NewUniqueRepoNamer("bpt-verify-test")
+
+	for _, naming := range []string{"per-image-repo", "mirror-path"} {
+		naming := naming
+		t.Run(naming, func(t *testing.T) {
+			g := NewWithT(t)
+
+			repoDir := makeTestRepo(t, makeRepoName)
+			outputImage := makeRepoName("dst/" + naming)
+			g.Expect(runCommand("package",
+				"--manifest-dir", filepath.Join(repoDir, "manifests"),
+				"--output-image", outputImage,
+				"--naming", naming,
+			)).To(Succeed())
+
+			tags, err := crane.ListTags(outputImage, trex.Shared.CraneOptions()...)
+			g.Expect(err).ToNot(HaveOccurred())
+			artefactRef := ""
+			for _, tag := range tags {
+				if strings.HasPrefix(tag, "config.") && len(tag) > len("config.")+7 {
+					artefactRef = outputImage + ":" + tag
+				}
+			}
+			g.Expect(artefactRef).ToNot(BeEmpty())
+
+			output, err := runCommandWithOutput(t, "verify", "--image", artefactRef, "--output-format", "direct-json")
+			g.Expect(err).ToNot(HaveOccurred())
+			report := &verifyReport{}
+			g.Expect(json.Unmarshal(output, report)).To(Succeed())
+			g.Expect(report.Verified).To(BeTrue())
+			appImages := []verifyResult{}
+			for _, result := range report.Results {
+				if result.Check == verifyCheckAppImage {
+					appImages = append(appImages, result)
+				}
+			}
+			g.Expect(appImages).To(HaveLen(1))
+			g.Expect(appImages[0].Subject).To(HavePrefix(outputImage + "/"))
+		})
+	}
+}
+
+// findArtefactInLayout returns reference to the only artefact in the layout
+func findArtefactInLayout(t *testing.T, layoutPath string) string {
+	t.Helper()
+	g := NewWithT(t)
+
+	tags, err := oci.LayoutRef{Path: layoutPath}.Tags()
+	g.Expect(err).ToNot(HaveOccurred())
+	refs := []string{}
+	for tag, descriptor := range tags {
+		// full tag has the whole content hash, while alias has only a short prefix
+		if strings.HasPrefix(tag, "config.") && len(tag) > len("config.")+7 {
+			refs = append(refs, oci.LayoutRef{Path: layoutPath, Tag: tag, Digest: descriptor.Digest.String()}.String())
+		}
+	}
+	g.Expect(refs).To(HaveLen(1))
+	return refs[0]
+}
+
+// writeTamperedArtefact writes an index with content of the artefact modified by tamper,
+// and unless withAttestations is false, the original attestations, it returns reference
+// to the new index
+func writeTamperedArtefact(t *testing.T, layoutPath, artefactRef, tag string, withAttestations bool, tamper func(string)) string {
+	t.Helper()
+	g := NewWithT(t)
+	ctx := context.Background()
+	client := oci.NewClient(nil)
+
+	contentDir := t.TempDir()
+	artefacts, err := client.Fetch(ctx, artefactRef, oci.ContentMediaType)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(artefacts).To(HaveLen(1))
+	g.Expect(tar.Untar(artefacts[0], contentDir, tar.WithMaxUntarSize(-1))).To(Succeed())
+	g.Expect(artefacts[0].Close()).To(Succeed())
+
+	tamper(contentDir)
+
+	// content-only artefact is pushed to a separate layout, so that its content
+	// manifest can be combined with the original attestations
+	contentOnlyRef, err := client.PushArtefact(ctx, oci.LayoutRef{Path: filepath.Join(t.TempDir(), "layout")}.String(), contentDir, nil, nil, false, false, oci.AttestationsEmbedded)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var index oci.ImageIndex = empty.Index
+	for _, source := range []struct {
+		ref       string
+		mediaType oci.MediaType
+	}{
+		{contentOnlyRef, oci.ContentMediaType},
+		{artefactRef, oci.AttestMediaType},
+	} {
+		if !withAttestations && source.mediaType == oci.AttestMediaType {
+			continue
+		}
+		imageIndex, indexManifest, _, err := client.GetIndexOrImage(ctx, source.ref)
+		g.Expect(err).ToNot(HaveOccurred())
+		for _, descriptor := range indexManifest.Manifests {
+			if descriptor.ArtifactType != string(source.mediaType) {
+				continue
+			}
+			image, err := imageIndex.Image(descriptor.Digest)
+			g.Expect(err).ToNot(HaveOccurred())
+			index = mutate.AppendManifests(index, mutate.IndexAddendum{Add: image, Descriptor: descriptor})
+		}
+	}
+
+	path, err := layout.FromPath(layoutPath)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(path.AppendIndex(index, layout.WithAnnotations(map[string]string{OCIv1.AnnotationRefName: tag}))).To(Succeed())
+
+	return oci.LayoutRef{Path: layoutPath, Tag: tag}.String()
+}
@@ -1,15 +1,18 @@
 package app
 
 import (
-	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"strings"
 
 	toto "github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 
 	"github.com/docker/labs-brown-tape/attest/manifest"
 	attestTypes "github.com/docker/labs-brown-tape/attest/types"
@@ -20,6 +23,7 @@ import (
 type TapeViewCommand struct {
 	tape *TapeCommand
 	OutputFormatOptions
+	AttestationsVerificationOptions
 
 	Image string `short:"I" long:"image" description:"Name of the image to view" required:"true"`
 }
@@ -31,8 +35,12 @@ type artefactInfo struct {
 		Content rawManifest[oci.Manifest]      `json:"content"`
 		Attest  rawManifest[oci.Manifest]      `json:"attest"`
 	} `json:"rawManifests"`
-	Attestations        []toto.Statement               `json:"attestations"`
-	AttestationsSummary *attestTypes.SummaryAnnotation `json:"attestationsSummary,omitempty"`
+	Attestations         []toto.Statement               `json:"attestations"`
+	AttestationsSignedBy [][]string                     `json:"attestationsSignedBy,omitempty"`
+	AttestationsSummary  *attestTypes.SummaryAnnotation `json:"attestationsSummary,omitempty"`
+	// SignaturesVerified is only set when key IDs in AttestationsSignedBy were
+	// verified with --verify-key, otherwise these are as found in the envelopes
+	SignaturesVerified bool `json:"signaturesVerified"`
 }
 
 type rawManifest[T oci.Manifest | oci.IndexManifest] struct {
@@ -50,7 +58,10 @@ func (c *TapeViewCommand) Execute(args []string) error {
 		return err
 	}
 
-	client := oci.NewClient(nil)
+	client, err := c.tape.NewClient(c.Image)
+	if err != nil {
+		return err
+	}
 
 	outputInfo, err := c.CollectInfo(ctx, client)
 	if err != nil {
@@ -67,6 +78,11 @@ func (c *TapeViewCommand) Execute(args []string) error {
 func (c *TapeViewCommand) CollectInfo(ctx context.Context, client *oci.Client) (*artefactInfo, error) {
 	artefactInfo := &artefactInfo{}
 
+	verifier, err := c.NewEnvelopeVerifier()
+	if err != nil {
+		return nil, err
+	}
+
 	imageIndex, indexManifest, _, err := client.GetIndexOrImage(ctx, c.Image)
 	if err != nil {
 		return nil, err
@@ -90,6 +106,20 @@ func (c *TapeViewCommand) CollectInfo(ctx context.Context, client *oci.Client) (
 		return nil, err
 	}
 
+	// attestations may be pushed as a referrer instead of being part of the index
+	referrersInfo, referrersManifests, err := client.FetchReferrers(ctx, c.Image, imageIndexDigest.String(), oci.AttestMediaType, oci.SignedAttestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	imageInfo = append(imageInfo, referrersInfo...)
+	maps.Copy(manifests, referrersManifests)
+	if err := oci.CheckAttestationsLayers(c.Image, imageInfo); err != nil {
+		for _, info := range imageInfo {
+			_ = info.Close()
+		}
+		return nil, err
+	}
+
 	if len(imageInfo) == 0 {
 		return nil, fmt.Errorf("no images found in index %q", c.Image)
 	}
@@ -98,7 +128,7 @@ func (c *TapeViewCommand) CollectInfo(ctx context.Context, client *oci.Client) (
 		info := imageInfo[i]
 		switch info.MediaType {
 		case oci.ContentMediaType:
-		case oci.AttestMediaType:
+		case oci.AttestMediaType, oci.SignedAttestMediaType:
 			if annotation, ok := info.Annotations[oci.AttestationsSummaryAnnotation]; ok {
 				summary, err := attestTypes.UnmarshalSummaryAnnotation(annotation)
 				if err != nil {
@@ -107,37 +137,24 @@ func (c *TapeViewCommand) CollectInfo(ctx context.Context, client *oci.Client) (
 				artefactInfo.AttestationsSummary = summary
 			}
 
-			gr, err := gzip.NewReader(info)
+			statements, keyIDs, err := decodeAttestations(ctx, info, info.MediaType, verifier)
 			if err != nil {
 				return nil, err
 			}
-			scanner := bufio.NewScanner(gr)
-			for scanner.Scan() {
-				statement := toto.Statement{} // attestTypes.GenericStatement[any]{}
-				if err := json.NewDecoder(bytes.NewBuffer(scanner.Bytes())).Decode(&statement); err != nil {
-					return nil, err
-				}
-				artefactInfo.Attestations = append(artefactInfo.Attestations, statement)
-			}
-			if err := scanner.Err(); err != nil {
-				return nil, err
-			}
-			if err := gr.Close(); err != nil {
-				return nil, err
+			artefactInfo.Attestations = append(artefactInfo.Attestations, statements...)
+			if info.MediaType == oci.SignedAttestMediaType {
+				artefactInfo.AttestationsSignedBy = append(artefactInfo.AttestationsSignedBy, keyIDs...)
+				artefactInfo.SignaturesVerified = verifier != nil
 			}
 		}
 	}
 
 	for _, statement := range artefactInfo.Attestations {
 		if statement.PredicateType == manifest.ReplacedImageRefPredicateType {
-			buf := bytes.NewBuffer(nil)
-			if err := json.NewEncoder(buf).Encode(statement.Predicate); err != nil {
-				return nil, err
-			}
 			predicate := &struct {
 				manifest.ImageRefenceWithLocation `json:"replacedImageReference"`
 			}{}
-			if err := json.NewDecoder(buf).Decode(predicate); err != nil {
+			if err := decodePredicate(statement, predicate); err != nil {
 				return nil, err
 			}
 
@@ -153,7 +170,7 @@ func (c *TapeViewCommand) CollectInfo(ctx context.Context, client *oci.Client) (
 		switch m.Manifest.Config.MediaType {
 		case oci.ContentMediaType:
 			artefactInfo.RawManifests.Content = m
-		case oci.AttestMediaType:
+		case oci.AttestMediaType, oci.SignedAttestMediaType:
 			artefactInfo.RawManifests.Attest = m
 		}
 	}
@@ -196,6 +213,92 @@ func (c *TapeViewCommand) PrintInfo(ctx context.Context, outputInfo *artefactInf
 				}
 			}
 		}
+		if len(outputInfo.AttestationsSignedBy) > 0 {
+			if outputInfo.SignaturesVerified {
+				fmt.Printf("  Attestations Signed By:\n")
+			} else {
+				fmt.Printf("  Attestations Signed By (unverified key IDs, use --verify-key to verify):\n")
+			}
+			for i := range outputInfo.AttestationsSignedBy {
+				fmt.Printf("    %s: %s\n", outputInfo.Attestations[i].PredicateType,
+					strings.Join(outputInfo.AttestationsSignedBy[i], ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// decodeAttestations reads statements from the attestations layer, for signed
+// layers it also returns key IDs of signatures found in each of the envelopes;
+// when verifier is given, every envelope must have a valid signature and only
+// key IDs of verified signatures are returned
+func decodeAttestations(ctx context.Context, r io.Reader, mediaType oci.MediaType, verifier *dsse.EnvelopeVerifier) ([]toto.Statement, [][]string, error) {
+	if verifier != nil && mediaType != oci.SignedAttestMediaType {
+		return nil, nil, fmt.Errorf("attestations are not signed")
+	}
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	statements := []toto.Statement{}
+	keyIDs := [][]string{}
+	// statements are decoded from the stream, as these can be larger than any
+	// reasonable line length limit, e.g. with SBOMs or signed envelopes
+	decoder := json.NewDecoder(gr)
+	for decoder.More() {
+		statement := toto.Statement{} // attestTypes.GenericStatement[any]{}
+		switch mediaType {
+		case oci.SignedAttestMediaType:
+			envelope := dsse.Envelope{}
+			if err := decoder.Decode(&envelope); err != nil {
+				return nil, nil, err
+			}
+			if envelope.PayloadType != toto.PayloadType {
+				return nil, nil, fmt.Errorf("unexpected payload type %q", envelope.PayloadType)
+			}
+			payload, err := envelope.DecodeB64Payload()
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to decode envelope payload: %w", err)
+			}
+			if err := json.Unmarshal(payload, &statement); err != nil {
+				return nil, nil, err
+			}
+			signedBy := make([]string, 0, len(envelope.Signatures))
+			if verifier != nil {
+				acceptedKeys, err := verifier.Verify(ctx, &envelope)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to verify signature of statement %d of type %q: %w", len(statements), statement.PredicateType, err)
+				}
+				for i := range acceptedKeys {
+					signedBy = append(signedBy, acceptedKeys[i].KeyID)
+				}
+			} else {
+				for i := range envelope.Signatures {
+					signedBy = append(signedBy, envelope.Signatures[i].KeyID)
+				}
+			}
+			keyIDs = append(keyIDs, signedBy)
+		default:
+			if err := decoder.Decode(&statement); err != nil {
+				return nil, nil, err
+			}
+			keyIDs = append(keyIDs, nil)
+		}
+		statements = append(statements, statement)
+	}
+	if err := gr.Close(); err != nil {
+		return nil, nil, err
+	}
+	return statements, keyIDs, nil
+}
+
+func decodePredicate(statement toto.Statement, predicate any) error {
+	buf := bytes.NewBuffer(nil)
+	if err := json.NewEncoder(buf).Encode(statement.Predicate); err != nil {
+		return err
+	}
+	if err := json.NewDecoder(buf).Decode(predicate); err != nil {
+		return fmt.Errorf("failed to decode predicate of type %q: %w", statement.PredicateType, err)
 	}
 	return nil
 }
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+
+	"github.com/docker/labs-brown-tape/attest/manifest"
+	"github.com/docker/labs-brown-tape/attest/signer"
+	attestTypes "github.com/docker/labs-brown-tape/attest/types"
+	"github.com/docker/labs-brown-tape/oci"
+)
+
+func TestDecodeLargeAttestations(t *testing.T) {
+	g := NewWithT(t)
+
+	// each statement is encoded as a single line, which is longer than 64KiB
+	statements := attestTypes.Statements{
+		manifest.MakeSBOMStatement(
+			attestTypes.MakeSubject("manifests/deployment.yaml", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+			"https://cyclonedx.org/bom",
+			map[string]string{"comment": strings.Repeat("x", 128*1024)},
+		),
+		manifest.MakeSBOMStatement(
+			attestTypes.MakeSubject("manifests/service.yaml", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+			"https://cyclonedx.org/bom",
+			map[string]string{"comment": "small"},
+		),
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	signerVerifier, err := signer.NewSignerVerifier(key)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	for mediaType, encode := range map[oci.MediaType]func(*gzip.Writer) error{
+		oci.AttestMediaType: func(w *gzip.Writer) error { return statements.Encode(w) },
+		oci.SignedAttestMediaType: func(w *gzip.Writer) error {
+			return statements.EncodeSigned(context.Background(), w, signerVerifier)
+		},
+	} {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		g.Expect(encode(gw)).To(Succeed())
+		g.Expect(gw.Close()).To(Succeed())
+
+		decoded, keyIDs, err := decodeAttestations(context.Background(), buf, mediaType, nil)
+		g.Expect(err).ToNot(HaveOccurred(), string(mediaType))
+		g.Expect(decoded).To(HaveLen(2))
+		g.Expect(keyIDs).To(HaveLen(2))
+		g.Expect(decoded[0].Subject[0].Name).To(Equal("manifests/deployment.yaml"))
+		g.Expect(decoded[0].Predicate).To(HaveKeyWithValue("comment", HaveLen(128*1024)))
+		g.Expect(decoded[1].Subject[0].Name).To(Equal("manifests/service.yaml"))
+	}
+}
+
+func TestDecodeAttestationsWithVerifier(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	statements := attestTypes.Statements{
+		manifest.MakeSBOMStatement(
+			attestTypes.MakeSubject("manifests/deployment.yaml", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+			"https://cyclonedx.org/bom",
+			map[string]string{"comment": "small"},
+		),
+	}
+
+	makeSignerVerifier := func() *signer.SignerVerifier {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		g.Expect(err).ToNot(HaveOccurred())
+		signerVerifier, err := signer.NewSignerVerifier(key)
+		g.Expect(err).ToNot(HaveOccurred())
+		return signerVerifier
+	}
+	signerVerifier, otherSignerVerifier := makeSignerVerifier(), makeSignerVerifier()
+
+	encode := func(signed bool) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		if signed {
+			g.Expect(statements.EncodeSigned(ctx, gw, signerVerifier)).To(Succeed())
+		} else {
+			g.Expect(statements.Encode(gw)).To(Succeed())
+		}
+		g.Expect(gw.Close()).To(Succeed())
+		return buf
+	}
+
+	verifier, err := dsse.NewEnvelopeVerifier(signerVerifier.Verifier)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, keyIDs, err := decodeAttestations(ctx, encode(true), oci.SignedAttestMediaType, verifier)
+	g.Expect(err).ToNot(HaveOccurred())
+	keyID, err := signerVerifier.KeyID()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(keyIDs).To(Equal([][]string{{keyID}}))
+
+	otherVerifier, err := dsse.NewEnvelopeVerifier(otherSignerVerifier.Verifier)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, _, err = decodeAttestations(ctx, encode(true), oci.SignedAttestMediaType, otherVerifier)
+	g.Expect(err).To(MatchError(ContainSubstring("failed to verify signature of statement 0")))
+
+	_, _, err = decodeAttestations(ctx, encode(false), oci.AttestMediaType, verifier)
+	g.Expect(err).To(MatchError("attestations are not signed"))
+}
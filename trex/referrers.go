@@ -0,0 +1,173 @@
+package trex
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+var (
+	referrersPath = regexp.MustCompile(`^/v2/(.+)/referrers/(sha256:[a-f0-9]{64})$`)
+	manifestsPath = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+)
+
+// referrersHandler implements OCI referrers API on top of the registry, which doesn't
+// support it; subjects are recorded as manifests get pushed, so the index of referrers
+// only lives as long as the registry does
+type referrersHandler struct {
+	http.Handler
+	users map[string]string
+
+	lock sync.Mutex
+	// referrers are held by repository and subject digest
+	referrers map[string]map[string][]v1.Descriptor
+}
+
+func newReferrersHandler(handler http.Handler, users map[string]string) *referrersHandler {
+	return &referrersHandler{
+		Handler:   handler,
+		users:     users,
+		referrers: map[string]map[string][]v1.Descriptor{},
+	}
+}
+
+func (h *referrersHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if match := referrersPath.FindStringSubmatch(req.URL.Path); match != nil && req.Method == http.MethodGet {
+		h.serveReferrers(w, req, match[1], match[2])
+		return
+	}
+	if match := manifestsPath.FindStringSubmatch(req.URL.Path); match != nil && req.Method == http.MethodPut {
+		h.servePutManifest(w, req, match[1])
+		return
+	}
+	h.Handler.ServeHTTP(w, req)
+}
+
+func (h *referrersHandler) authorized(req *http.Request) bool {
+	if len(h.users) == 0 {
+		return true
+	}
+	username, password, ok := req.BasicAuth()
+	return ok && h.users[username] == password
+}
+
+func (h *referrersHandler) serveReferrers(w http.ResponseWriter, req *http.Request, repo, subject string) {
+	if !h.authorized(req) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="trex"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	h.lock.Lock()
+	manifests := []v1.Descriptor{}
+	for _, descriptor := range h.referrers[repo][subject] {
+		if artifactType := req.URL.Query().Get("artifactType"); artifactType != "" && descriptor.ArtifactType != artifactType {
+			continue
+		}
+		manifests = append(manifests, descriptor)
+	}
+	h.lock.Unlock()
+
+	if req.URL.Query().Has("artifactType") {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", string(types.OCIImageIndex))
+	_ = json.NewEncoder(w).Encode(v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests:     manifests,
+	})
+}
+
+// servePutManifest passes the manifest to the registry and records it as a referrer
+// when it has a subject and the registry accepted it
+func (h *referrersHandler) servePutManifest(w http.ResponseWriter, req *http.Request, repo string) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	manifest := struct {
+		MediaType    types.MediaType `json:"mediaType"`
+		ArtifactType string          `json:"artifactType"`
+		Config       struct {
+			MediaType string `json:"mediaType"`
+		} `json:"config"`
+		Subject     *v1.Descriptor    `json:"subject"`
+		Annotations map[string]string `json:"annotations"`
+	}{}
+	if err := json.Unmarshal(body, &manifest); err != nil || manifest.Subject == nil {
+		h.Handler.ServeHTTP(w, req)
+		return
+	}
+
+	recorder := &statusRecorder{ResponseWriter: w, subject: manifest.Subject.Digest.String()}
+	h.Handler.ServeHTTP(recorder, req)
+	if recorder.status != http.StatusCreated {
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	descriptor := v1.Descriptor{
+		MediaType:    manifest.MediaType,
+		Digest:       v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])},
+		Size:         int64(len(body)),
+		ArtifactType: manifest.ArtifactType,
+		Annotations:  manifest.Annotations,
+	}
+	if descriptor.MediaType == "" {
+		descriptor.MediaType = types.MediaType(req.Header.Get("Content-Type"))
+	}
+	if descriptor.ArtifactType == "" {
+		descriptor.ArtifactType = manifest.Config.MediaType
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.referrers[repo]; !ok {
+		h.referrers[repo] = map[string][]v1.Descriptor{}
+	}
+	referrers := h.referrers[repo][recorder.subject]
+	for _, existing := range referrers {
+		if existing.Digest == descriptor.Digest {
+			return
+		}
+	}
+	referrers = append(referrers, descriptor)
+	sort.Slice(referrers, func(i, j int) bool { return referrers[i].Digest.String() < referrers[j].Digest.String() })
+	h.referrers[repo][recorder.subject] = referrers
+}
+
+// statusRecorder sets OCI-Subject header, so that clients know that referrers API
+// is supported, and keeps the status for the handler to check
+type statusRecorder struct {
+	http.ResponseWriter
+	subject string
+	status  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	if status == http.StatusCreated {
+		r.Header().Set("OCI-Subject", r.subject)
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(data)
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
+	"golang.org/x/crypto/bcrypt"
 
 	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
 	_ "github.com/distribution/distribution/v3/registry/auth/silly"
@@ -28,22 +29,42 @@ import (
 
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/registry"
+	"github.com/distribution/distribution/v3/registry/handlers"
 )
 
 type Trex struct {
 	port                    int
+	pkiDir                  string
 	caCert, tlsKey, tlsCert string
 
 	caPool *x509.CertPool
+
+	users map[string]string
+
+	referrers bool
 }
 
 func New(port int) *Trex {
 	return &Trex{
 		port:   port,
 		caPool: x509.NewCertPool(),
+		users:  map[string]string{},
 	}
 }
 
+// WithBasicAuth enables htpasswd authentication, it must be called before Run
+func (r *Trex) WithBasicAuth(username, password string) *Trex {
+	r.users[username] = password
+	return r
+}
+
+// WithReferrers enables OCI referrers API, without it the registry behaves like the ones
+// that only support the referrers tag schema; it must be called before Run
+func (r *Trex) WithReferrers() *Trex {
+	r.referrers = true
+	return r
+}
+
 var Shared = struct {
 	*Trex
 	*sync.Once
@@ -54,23 +75,45 @@ var Shared = struct {
 
 func RunShared() {
 	Shared.Once.Do(func() {
-		go func() {
-			err := Shared.Run(context.Background())
-			if err != nil {
-				panic(err)
-			}
-		}()
+		Shared.RunInBackground(context.Background())
 	})
+	Shared.waitUntilReady()
+}
+
+// RunInBackground starts the registry in a goroutine and waits until it accepts connections,
+// port and certificates are set up beforehand, so that these are not written concurrently
+func (r *Trex) RunInBackground(ctx context.Context) {
+	if err := r.setup(ctx); err != nil {
+		panic(err)
+	}
+	go func() {
+		if err := r.serve(ctx); err != nil {
+			panic(err)
+		}
+	}()
+	r.waitUntilReady()
+}
+
+func (r *Trex) waitUntilReady() {
 	for {
 		_, err := (&net.Dialer{Timeout: 2 * time.Second}).
-			DialContext(context.Background(), "tcp", Shared.Addr())
+			DialContext(context.Background(), "tcp", r.Addr())
 		if err == nil {
 			break
 		}
+		time.Sleep(10 * time.Millisecond)
 	}
 }
 
 func (r *Trex) Run(ctx context.Context) error {
+	if err := r.setup(ctx); err != nil {
+		return err
+	}
+	return r.serve(ctx)
+}
+
+// setup allocates the port, unless it was given, and generates certificates
+func (r *Trex) setup(ctx context.Context) error {
 	if r.port == 0 {
 		// automatically allocate the port, and use it for the registry;
 		// albeit this can be racy, since registry cannot take a listener
@@ -84,14 +127,12 @@ func (r *Trex) Run(ctx context.Context) error {
 			return err
 		}
 	}
-	// TODO: return a channel to indicate when the server is ready, or perhpas
-	// just copy what RunShared does and start a goroutine?
+
 	pkiDir, err := os.MkdirTemp("", "trex-pki-*")
 	if err != nil {
 		return err
 	}
-
-	defer os.RemoveAll(pkiDir)
+	r.pkiDir = pkiDir
 
 	r.caCert = filepath.Join(pkiDir, "ca.crt")
 	r.tlsKey = filepath.Join(pkiDir, "tls.key")
@@ -115,6 +156,11 @@ func (r *Trex) Run(ctx context.Context) error {
 	if !r.caPool.AppendCertsFromPEM(caCert) {
 		return fmt.Errorf("failed to setup CA certificate pool")
 	}
+	return nil
+}
+
+func (r *Trex) serve(ctx context.Context) error {
+	defer os.RemoveAll(r.pkiDir)
 
 	config := &configuration.Configuration{
 		Storage: configuration.Storage{
@@ -129,10 +175,32 @@ func (r *Trex) Run(ctx context.Context) error {
 			MaxEntries: 100,
 		},
 	}
+	if len(r.users) > 0 {
+		htpasswdPath := filepath.Join(r.pkiDir, "htpasswd")
+		if err := r.writeHtpasswd(htpasswdPath); err != nil {
+			return err
+		}
+		config.Auth = configuration.Auth{
+			"htpasswd": configuration.Parameters{
+				"realm": "trex",
+				"path":  htpasswdPath,
+			},
+		}
+	}
 	config.HTTP.Addr = r.Addr()
 	config.HTTP.TLS.Certificate = r.tlsCert
 	config.HTTP.TLS.Key = r.tlsKey
 
+	if r.referrers {
+		// registry has to be wrapped, so the app is served directly, which also
+		// avoids global health checks that only allow one registry per process
+		server := &http.Server{
+			Addr:    r.Addr(),
+			Handler: newReferrersHandler(handlers.NewApp(ctx, config), r.users),
+		}
+		return server.ListenAndServeTLS(r.tlsCert, r.tlsKey)
+	}
+
 	registry, err := registry.NewRegistry(ctx, config)
 	if err != nil {
 		return err
@@ -144,11 +212,30 @@ func (r *Trex) Run(ctx context.Context) error {
 	return nil
 }
 
+func (r *Trex) writeHtpasswd(path string) error {
+	htpasswd := []byte{}
+	for username, password := range r.users {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		htpasswd = fmt.Appendf(htpasswd, "%s:%s\n", username, hash)
+	}
+	return os.WriteFile(path, htpasswd, 0o600)
+}
+
 func (r *Trex) Addr() string {
 	return fmt.Sprintf("127.0.0.1:%d", r.port)
 }
 
 func (r *Trex) CraneOptions() []crane.Option {
+	return []crane.Option{
+		crane.WithTransport(r.Transport()),
+	}
+}
+
+// Transport trusts the CA of the registry, it can be used directly for wrapping
+func (r *Trex) Transport() http.RoundTripper {
 	transport := remote.DefaultTransport.(*http.Transport).Clone()
 
 	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -169,9 +256,7 @@ func (r *Trex) CraneOptions() []crane.Option {
 		return dialer.DialContext(ctx, network, addr)
 	}
 
-	return []crane.Option{
-		crane.WithTransport(transport),
-	}
+	return transport
 }
 
 func (r *Trex) NewUniqueRepoNamer(knownInfix string) func(string) string {